@@ -0,0 +1,320 @@
+// Package bacs writes BACS Standard 18 files of direct credits.
+package bacs
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	data "github.com/form3/data"
+	"github.com/form3/iso20022"
+)
+
+// Scheme is the payment scheme of the payments written to Standard 18 files.
+const Scheme = "BACS"
+
+// MaxReference is the length of the reference of a detail record.
+const MaxReference = 18
+
+const (
+	// maxAmount is the largest amount in pence of a credit or contra, 11
+	// digits
+	maxAmount = 99999999999
+	// maxTotal is the largest total in pence of the credits or debits of a
+	// file, 13 digits
+	maxTotal = 9999999999999
+	// maxCount is the largest number of credits or debits of a file, 7
+	// digits
+	maxCount = 9999999
+)
+
+const (
+	// creditCode is the transaction code of a direct credit
+	creditCode = "99"
+	// contraCode is the transaction code of the contra debiting the
+	// originating account with the total of its credits
+	contraCode = "17"
+)
+
+var (
+	// sortCodePattern also matches service user numbers
+	sortCodePattern      = regexp.MustCompile(`^[0-9]{6}$`)
+	accountNumberPattern = regexp.MustCompile(`^[0-9]{8}$`)
+	// bacsCharacters is the character set of names and references
+	bacsCharacters   = regexp.MustCompile(`^[A-Z0-9.&/\- ]*$`)
+	notBacsCharacter = regexp.MustCompile(`[^A-Z0-9.&/\- ]`)
+)
+
+// Options identifies the service user submitting the files.
+type Options struct {
+	// ServiceUserNumber is the 6 digit number allocated by BACS
+	ServiceUserNumber string
+	// ServiceUserName is written on every credit, in 18 characters
+	ServiceUserName string
+	// VolumeSerial identifies the submission, 6 characters, defaults to
+	// 000001
+	VolumeSerial string
+	// CreatedAt defaults to now
+	CreatedAt time.Time
+}
+
+// Submission is a volume of Standard 18 files, one per processing date, each
+// with the credits of every originating account followed by their contra.
+type Submission struct {
+	opts  Options
+	files []*file
+}
+
+type file struct {
+	date   time.Time
+	groups []*group
+}
+
+// account is an originating or destination account.
+type account struct {
+	sortCode, number, name string
+}
+
+type group struct {
+	originator account
+	credits    []credit
+	// total is the amount of the contra in pence
+	total int64
+}
+
+type credit struct {
+	destination account
+	pence       int64
+	reference   string
+}
+
+// New groups BACS payments by processing date and originating account into a
+// submission, with a new contra whenever the total of an account would not
+// fit one. It returns iso20022.Errors listing every payment that cannot be
+// written, and an error when the totals of a file do not fit its trailer.
+func New(payments []data.Payment, opts Options) (*Submission, error) {
+	if !sortCodePattern.MatchString(opts.ServiceUserNumber) {
+		return nil, fmt.Errorf("service user number %q must be 6 digits", opts.ServiceUserNumber)
+	}
+	if opts.VolumeSerial == "" {
+		opts.VolumeSerial = "000001"
+	}
+	if len(opts.VolumeSerial) > 6 || !bacsCharacters.MatchString(opts.VolumeSerial) {
+		return nil, fmt.Errorf("volume serial %q must be at most 6 characters of A-Z and 0-9", opts.VolumeSerial)
+	}
+	if opts.CreatedAt.IsZero() {
+		opts.CreatedAt = time.Now()
+	}
+	if len(payments) == 0 {
+		return nil, fmt.Errorf("a submission needs at least one payment")
+	}
+
+	s := &Submission{opts: opts}
+	files := map[string]*file{}
+	groups := map[string]*group{}
+	var errs iso20022.Errors
+	for i, payment := range payments {
+		date, originator, c, err := newCredit(payment)
+		if err != nil {
+			errs = append(errs, &iso20022.TransactionError{Index: i, ID: payment.ID, Err: err})
+			continue
+		}
+		day := date.Format("2006-01-02")
+		f, ok := files[day]
+		if !ok {
+			f = &file{date: date}
+			files[day] = f
+			s.files = append(s.files, f)
+		}
+		key := day + originator.sortCode + originator.number
+		g, ok := groups[key]
+		if !ok || g.total+c.pence > maxAmount {
+			g = &group{originator: originator}
+			groups[key] = g
+			f.groups = append(f.groups, g)
+		}
+		g.credits = append(g.credits, c)
+		g.total += c.pence
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	for _, f := range s.files {
+		var total int64
+		credits := 0
+		for _, g := range f.groups {
+			total += g.total
+			credits += len(g.credits)
+		}
+		if total > maxTotal || credits > maxCount || len(f.groups) > maxCount {
+			return nil, fmt.Errorf("the payments of %v total %v pence in %d credits, more than a file holds", f.date.Format("2006-01-02"), total, credits)
+		}
+	}
+	sort.SliceStable(s.files, func(i, j int) bool { return s.files[i].date.Before(s.files[j].date) })
+	return s, nil
+}
+
+// newCredit validates a payment and returns its processing date, originating
+// account and credit.
+func newCredit(payment data.Payment) (time.Time, account, credit, error) {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	a := payment.Attributes
+	if a.PaymentScheme != Scheme {
+		add("payment scheme %q is not %v", a.PaymentScheme, Scheme)
+	}
+	date, err := time.Parse("2006-01-02", a.ProcessingDate)
+	if err != nil {
+		add("processing date %q is not a date as YYYY-MM-DD", a.ProcessingDate)
+	}
+	if a.Currency != "GBP" {
+		add("currency %q is not GBP", a.Currency)
+	}
+	pence := int64(math.Round(a.Amount * 100))
+	if pence <= 0 || pence > maxAmount {
+		add("amount %v is not between 0.01 and 999999999.99", a.Amount)
+	}
+	reference := strings.ToUpper(a.Reference)
+	switch {
+	case strings.TrimSpace(reference) == "":
+		add("reference is required")
+	case len(reference) > MaxReference:
+		add("reference %q is longer than %d characters", a.Reference, MaxReference)
+	case !bacsCharacters.MatchString(reference):
+		add("reference %q may only contain A-Z, 0-9, space and . & / -", a.Reference)
+	}
+	originator := newAccount(a.DebtorParty, "debtor", add)
+	destination := newAccount(a.BeneficiaryParty, "beneficiary", add)
+	if len(problems) > 0 {
+		return date, originator, credit{}, fmt.Errorf("%v", strings.Join(problems, ", "))
+	}
+	return date, originator, credit{destination: destination, pence: pence, reference: reference}, nil
+}
+
+// newAccount reads the sort code from the bank id and the account number of
+// a party.
+func newAccount(party data.Account, role string, add func(string, ...interface{})) account {
+	if !sortCodePattern.MatchString(party.BankID) {
+		add("%v bank id %q is not a 6 digit sort code", role, party.BankID)
+	}
+	if !accountNumberPattern.MatchString(party.AccountNumber) {
+		add("%v account number %q is not 8 digits", role, party.AccountNumber)
+	}
+	name := party.AccountName
+	if name == "" {
+		name = party.Name
+	}
+	return account{sortCode: party.BankID, number: party.AccountNumber, name: name}
+}
+
+// name returns s in the BACS character set, other characters replaced by
+// spaces, cut to 18 characters.
+func name(s string) string {
+	s = notBacsCharacter.ReplaceAllString(strings.ToUpper(s), " ")
+	if len(s) > 18 {
+		s = s[:18]
+	}
+	return s
+}
+
+// VolumeSerial returns the serial number identifying the submission.
+func (s *Submission) VolumeSerial() string {
+	return s.opts.VolumeSerial
+}
+
+// julian formats a date as " yyddd".
+func julian(t time.Time) string {
+	return fmt.Sprintf(" %02d%03d", t.Year()%100, t.YearDay())
+}
+
+// pad left justifies s in n characters.
+func pad(s string, n int) string {
+	return fmt.Sprintf("%-*s", n, s)
+}
+
+// digits right justifies n in width zeros. New checks the amounts, totals and
+// counts fit their fields.
+func digits(n int64, width int) string {
+	return fmt.Sprintf("%0*d", width, n)
+}
+
+// Write writes the submission, one record per CRLF terminated line: VOL1, then
+// for every file HDR1, HDR2 and UHL1, the credits and contras, and EOF1, EOF2
+// and UTL1.
+func (s *Submission) Write(w io.Writer) error {
+	records := []string{s.vol1()}
+	for i, f := range s.files {
+		hdr1, hdr2 := s.hdr1(i), hdr2()
+		records = append(records, "HDR1"+hdr1, "HDR2"+hdr2, s.uhl1(i, f))
+		var credits, debits int64
+		var creditCount, debitCount int
+		for _, g := range f.groups {
+			for _, c := range g.credits {
+				records = append(records, s.detail(g.originator, c))
+			}
+			records = append(records, contra(g.originator, g.total))
+			credits += g.total
+			debits += g.total
+			creditCount += len(g.credits)
+			debitCount++
+		}
+		records = append(records, "EOF1"+hdr1, "EOF2"+hdr2, utl1(debits, credits, debitCount, creditCount))
+	}
+	for _, record := range records {
+		if _, err := io.WriteString(w, record+"\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// vol1 returns the volume header label.
+func (s *Submission) vol1() string {
+	return "VOL1" + pad(s.opts.VolumeSerial, 6) + "0" + pad("", 20) + pad("", 6) + pad("", 4) + s.opts.ServiceUserNumber + pad("", 4) + pad("", 28) + "1"
+}
+
+// hdr1 returns the HDR1 label of the i-th file without its label identifier,
+// which is repeated by EOF1.
+func (s *Submission) hdr1(i int) string {
+	fileID := "A" + s.opts.ServiceUserNumber + "S  " + strconv.Itoa((i+1)%10) + s.opts.ServiceUserNumber
+	created := julian(s.opts.CreatedAt)
+	return fileID + pad(s.opts.VolumeSerial, 6) + "0001" + digits(int64(i+1), 4) + pad("", 4) + pad("", 2) +
+		created + created + " " + "000000" + pad("", 13) + pad("", 7)
+}
+
+// hdr2 returns the HDR2 label without its label identifier: fixed 100
+// character records in 2000 character blocks.
+func hdr2() string {
+	return "F" + "02000" + "00100" + pad("", 35) + "00" + pad("", 28)
+}
+
+// uhl1 returns the user header label of the i-th file, processed on the date
+// of f.
+func (s *Submission) uhl1(i int, f *file) string {
+	return "UHL1" + julian(f.date) + pad("999999", 10) + "00" + "000000" + pad("1 DAILY", 9) + digits(int64(i+1), 3) + pad("", 7) + pad("", 7) + pad("", 26)
+}
+
+// detail returns the record crediting the destination of c from originator.
+func (s *Submission) detail(originator account, c credit) string {
+	return c.destination.sortCode + c.destination.number + "0" + creditCode + originator.sortCode + originator.number +
+		pad("", 4) + digits(c.pence, 11) + pad(name(s.opts.ServiceUserName), 18) + pad(c.reference, 18) + pad(name(c.destination.name), 18)
+}
+
+// contra returns the record debiting originator with the total of its
+// credits.
+func contra(originator account, total int64) string {
+	return originator.sortCode + originator.number + "0" + contraCode + originator.sortCode + originator.number +
+		pad("", 4) + digits(total, 11) + pad("CONTRA", 18) + pad("CONTRA", 18) + pad(name(originator.name), 18)
+}
+
+// utl1 returns the user trailer label with the totals and counts of a file.
+func utl1(debits, credits int64, debitCount, creditCount int) string {
+	return "UTL1" + digits(debits, 13) + digits(credits, 13) + digits(int64(debitCount), 7) + digits(int64(creditCount), 7) + pad("", 8) + pad("", 28)
+}
@@ -0,0 +1,164 @@
+package bacs
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	data "github.com/form3/data"
+	"github.com/form3/iso20022"
+)
+
+func testPayment() data.Payment {
+	return data.Payment{
+		ID: "4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43",
+		Attributes: data.Attributes{
+			Amount:           100.21,
+			BeneficiaryParty: data.Account{AccountName: "W Owens", AccountNumber: "31926819", BankID: "403000", BankIDCode: "GBDSC"},
+			Currency:         "GBP",
+			DebtorParty:      data.Account{AccountName: "EJ Brown Black", AccountNumber: "71268996", BankID: "203301", BankIDCode: "GBDSC"},
+			PaymentScheme:    "BACS",
+			ProcessingDate:   "2017-01-18",
+			Reference:        "Em piano Jan",
+		},
+	}
+}
+
+var testOptions = Options{
+	ServiceUserNumber: "123456",
+	ServiceUserName:   "Form3 Ltd",
+	VolumeSerial:      "VOL001",
+	CreatedAt:         time.Date(2017, 1, 17, 10, 30, 0, 0, time.UTC),
+}
+
+func TestWrite(t *testing.T) {
+	second := testPayment()
+	second.Attributes.Amount = 50.5
+	second.Attributes.Reference = "INV/2017-01"
+	second.Attributes.BeneficiaryParty = data.Account{Name: "Alexander Smith-Jones & Sons", AccountNumber: "12345678", BankID: "601613"}
+	otherDebtor := testPayment()
+	otherDebtor.Attributes.Amount = 1
+	otherDebtor.Attributes.DebtorParty = data.Account{AccountName: "Form3 Payroll", AccountNumber: "87654321", BankID: "203301"}
+	later := testPayment()
+	later.Attributes.ProcessingDate = "2017-01-19"
+
+	submission, err := New([]data.Payment{later, testPayment(), otherDebtor, second}, testOptions)
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	var buf bytes.Buffer
+	if err := submission.Write(&buf); err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	expected := []string{
+		"VOL1VOL0010                              123456                                1",
+		"HDR1A123456S  1123456VOL00100010001       17017 17017 000000                    ",
+		"HDR2F0200000100                                   00                            ",
+		"UHL1 17018999999    000000001 DAILY  001                                        ",
+		"4030003192681909920330171268996    00000010021FORM3 LTD         EM PIANO JAN      W OWENS           ",
+		"6016131234567809920330171268996    00000005050FORM3 LTD         INV/2017-01       ALEXANDER SMITH-JO",
+		"2033017126899601720330171268996    00000015071CONTRA            CONTRA            EJ BROWN BLACK    ",
+		"4030003192681909920330187654321    00000000100FORM3 LTD         EM PIANO JAN      W OWENS           ",
+		"2033018765432101720330187654321    00000000100CONTRA            CONTRA            FORM3 PAYROLL     ",
+		"EOF1A123456S  1123456VOL00100010001       17017 17017 000000                    ",
+		"EOF2F0200000100                                   00                            ",
+		"UTL10000000015171000000001517100000020000003                                    ",
+		"HDR1A123456S  2123456VOL00100010002       17017 17017 000000                    ",
+		"HDR2F0200000100                                   00                            ",
+		"UHL1 17019999999    000000001 DAILY  002                                        ",
+		"4030003192681909920330171268996    00000010021FORM3 LTD         EM PIANO JAN      W OWENS           ",
+		"2033017126899601720330171268996    00000010021CONTRA            CONTRA            EJ BROWN BLACK    ",
+		"EOF1A123456S  2123456VOL00100010002       17017 17017 000000                    ",
+		"EOF2F0200000100                                   00                            ",
+		"UTL10000000010021000000001002100000010000001                                    ",
+	}
+	obtained := strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n")
+	if len(expected) != len(obtained) {
+		t.Fatalf("expected %d records got %d:\n%v", len(expected), len(obtained), buf.String())
+	}
+	for i := range expected {
+		if expected[i] != obtained[i] {
+			t.Errorf("record %d\n...expected = %q\n...obtained = %q", i, expected[i], obtained[i])
+		}
+		// labels are 80 characters and the records of a file 100
+		if length := len(obtained[i]); length != 80 && length != 100 {
+			t.Errorf("record %d has %d characters", i, length)
+		}
+	}
+}
+
+func TestNewLargeTotals(t *testing.T) {
+	largest := testPayment()
+	largest.Attributes.Amount = 999999999.99
+
+	// the credits of an account are split between contras of 11 digits
+	submission, err := New([]data.Payment{largest, largest, testPayment()}, testOptions)
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	var buf bytes.Buffer
+	if err := submission.Write(&buf); err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	var contras []string
+	for _, record := range strings.Split(buf.String(), "\r\n") {
+		if strings.HasPrefix(record, "203301712689960172033017126899") {
+			contras = append(contras, record[35:46])
+		}
+	}
+	expected := "[99999999999 99999999999 00000010021]"
+	if obtained := fmt.Sprint(contras); expected != obtained {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, obtained)
+	}
+	if !strings.Contains(buf.String(), "UTL10200000010019020000001001900000030000003") {
+		t.Errorf("expected the totals of the contras in %v", buf.String())
+	}
+
+	// the totals of a file are 13 digits
+	payments := make([]data.Payment, 101)
+	for i := range payments {
+		payments[i] = largest
+	}
+	if _, err := New(payments, testOptions); err == nil || !strings.Contains(err.Error(), "more than a file holds") {
+		t.Errorf("expected an error for the total of the file, got %v", err)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	long := testPayment()
+	long.Attributes.Reference = "Payment for piano lessons"
+	invalid := testPayment()
+	invalid.Attributes.PaymentScheme = "FPS"
+	invalid.Attributes.Currency = "EUR"
+	invalid.Attributes.Reference = "Em's piano"
+	invalid.Attributes.BeneficiaryParty.BankID = "40-30-00"
+	invalid.Attributes.DebtorParty.AccountNumber = "GB29XABC10161234567801"
+
+	_, err := New([]data.Payment{testPayment(), long, invalid}, testOptions)
+	errs, ok := err.(iso20022.Errors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("expected errors for 2 payments, got %v", err)
+	}
+	expected := `reference "Payment for piano lessons" is longer than 18 characters`
+	if errs[0].Index != 1 || errs[0].Err.Error() != expected {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, errs[0])
+	}
+	for _, problem := range []string{`payment scheme "FPS" is not BACS`, `currency "EUR" is not GBP`, `reference "Em's piano" may only contain`, `beneficiary bank id "40-30-00"`, `debtor account number "GB29XABC10161234567801"`} {
+		if !strings.Contains(errs[1].Err.Error(), problem) {
+			t.Errorf("expected %v in %v", problem, errs[1].Err)
+		}
+	}
+}
+
+func TestNewOptions(t *testing.T) {
+	for _, opts := range []Options{{}, {ServiceUserNumber: "12345"}, {ServiceUserNumber: "123456", VolumeSerial: "VOLUME1"}} {
+		if _, err := New([]data.Payment{testPayment()}, opts); err == nil {
+			t.Errorf("expected an error for %+v", opts)
+		}
+	}
+	if _, err := New(nil, testOptions); err == nil {
+		t.Errorf("expected an error without payments")
+	}
+}
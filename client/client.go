@@ -0,0 +1,319 @@
+// Package client is a Go client of the payments API.
+//
+// Payments are exchanged as JSON:API documents, so that every failure comes
+// with its HTTP status, and the other resources as plain JSON. Failures are
+// returned as *Error. Idempotent requests are retried with backoff after
+// network errors and server errors, creates included as they are sent with an
+// idempotency key.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	data "github.com/form3/data"
+	"github.com/form3/openapi"
+)
+
+const (
+	jsonType    = "application/json"
+	jsonAPIType = "application/vnd.api+json"
+
+	IdempotencyKeyHeader = "Idempotency-Key"
+	RequestIDHeader      = "X-Request-ID"
+)
+
+// DefaultRetry bounds the retries of idempotent requests.
+var DefaultRetry = data.Backoff{
+	Initial:     200 * time.Millisecond,
+	Max:         5 * time.Second,
+	Multiplier:  2,
+	Jitter:      0.2,
+	MaxAttempts: 4,
+}
+
+// Options configures a Client.
+type Options struct {
+	// BaseURL is the URL of the service, e.g. https://payments.example.com
+	BaseURL string
+	// CertFile and KeyFile are the PEM certificate and key authenticating the
+	// client with mutual TLS. CAFile is the PEM bundle verifying the server,
+	// the system roots by default.
+	CertFile string
+	KeyFile  string
+	CAFile   string
+	// Token is sent as a bearer token, for services behind a gateway
+	// authenticating clients with tokens.
+	Token string
+	// Retry bounds the retries of idempotent requests, DefaultRetry when
+	// zero. MaxAttempts 1 disables the retries.
+	Retry data.Backoff
+	// HTTPClient sends the requests instead of a client built from the TLS
+	// options.
+	HTTPClient *http.Client
+}
+
+// Client calls the payments API. Requests are bounded by the deadline of
+// their context.
+type Client struct {
+	baseURL *url.URL
+	http    *http.Client
+	token   string
+	retry   data.Backoff
+	// newID generates the request ids and idempotency keys
+	newID func() string
+}
+
+func New(opts Options) (*Client, error) {
+	baseURL, err := url.Parse(strings.TrimSuffix(opts.BaseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if baseURL.Scheme != "http" && baseURL.Scheme != "https" {
+		return nil, fmt.Errorf("base URL %q must be an http or https URL", opts.BaseURL)
+	}
+	c := &Client{baseURL: baseURL, http: opts.HTTPClient, token: opts.Token, retry: opts.Retry, newID: newID}
+	if c.retry == (data.Backoff{}) {
+		c.retry = DefaultRetry
+	}
+	if c.http == nil {
+		config, err := tlsConfig(opts)
+		if err != nil {
+			return nil, err
+		}
+		c.http = &http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: config}}
+	}
+	return c, nil
+}
+
+func tlsConfig(opts Options) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if opts.CAFile != "" {
+		pem, err := ioutil.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %v", opts.CAFile)
+		}
+	}
+	return config, nil
+}
+
+// newID returns a random version 4 UUID.
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// request is a call to the API, sent again as it is when retried.
+type request struct {
+	method      string
+	path        string
+	query       url.Values
+	body        []byte
+	contentType string
+	accept      string
+	// idempotencyKey makes a POST safe to retry
+	idempotencyKey string
+	noRetry        bool
+}
+
+func (r request) idempotent() bool {
+	if r.noRetry {
+		return false
+	}
+	switch r.method {
+	case "GET", "HEAD", "PUT", "DELETE":
+		return true
+	}
+	return r.idempotencyKey != ""
+}
+
+// retryStatus reports if a response with status may succeed when retried.
+func (r request) retryStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	case http.StatusConflict:
+		// the first request with the key is still in progress
+		return r.idempotencyKey != ""
+	}
+	return false
+}
+
+func (c *Client) url(r request) string {
+	u := *c.baseURL
+	u.Path += r.path
+	u.RawQuery = r.query.Encode()
+	return u.String()
+}
+
+// send sends r, retrying it when idempotent, and returns the successful
+// response or the *Error of the last one.
+func (c *Client) send(ctx context.Context, r request) (*http.Response, error) {
+	requestID := c.newID()
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequest(r.method, c.url(r), bytes.NewReader(r.body))
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set(RequestIDHeader, requestID)
+		if r.contentType != "" {
+			req.Header.Set("Content-Type", r.contentType)
+		}
+		if r.accept != "" {
+			req.Header.Set("Accept", r.accept)
+		}
+		if r.idempotencyKey != "" {
+			req.Header.Set(IdempotencyKeyHeader, r.idempotencyKey)
+		}
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+
+		resp, err := c.http.Do(req)
+		if err == nil && resp.StatusCode < 300 {
+			return resp, nil
+		}
+		if err == nil {
+			err = decodeError(resp)
+			resp.Body.Close()
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		apiErr, isAPIErr := err.(*Error)
+		retry := r.idempotent() && !c.retry.Exhausted(attempt) && (!isAPIErr || r.retryStatus(apiErr.StatusCode))
+		if !retry {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(c.retry.Delay(attempt)):
+		}
+	}
+}
+
+// do sends r and decodes the JSON response into out. With legacy set, a
+// successful status carrying an error message of the service is returned as
+// an *Error.
+func (c *Client) do(ctx context.Context, r request, out interface{}, legacy bool) error {
+	resp, err := c.send(ctx, r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if legacy {
+		if err := legacyError(resp, body); err != nil {
+			return err
+		}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(body, out)
+}
+
+// download sends r and copies the response body to w. A JSON response sent
+// instead of the file is the error message of the service.
+func (c *Client) download(ctx context.Context, r request, w io.Writer) error {
+	resp, err := c.send(ctx, r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == jsonType {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		if err := legacyError(resp, body); err != nil {
+			return err
+		}
+		_, err = w.Write(body)
+		return err
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// HealthReport is the outcome of the readiness checks of the service.
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+type CheckResult struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Alive checks the service is running.
+func (c *Client) Alive(ctx context.Context) error {
+	return c.do(ctx, request{method: "GET", path: "/healthz"}, nil, false)
+}
+
+// Ready returns the readiness checks of the service, with an *Error of status
+// 503 when it is not ready.
+func (c *Client) Ready(ctx context.Context) (*HealthReport, error) {
+	var report HealthReport
+	err := c.do(ctx, request{method: "GET", path: "/readyz", noRetry: true}, &report, false)
+	if apiErr, ok := err.(*Error); ok && apiErr.StatusCode == http.StatusServiceUnavailable {
+		json.Unmarshal(apiErr.body, &report)
+		return &report, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// Metrics returns the Prometheus metrics of the service.
+func (c *Client) Metrics(ctx context.Context) (string, error) {
+	var buf bytes.Buffer
+	err := c.download(ctx, request{method: "GET", path: "/metrics"}, &buf)
+	return buf.String(), err
+}
+
+// OpenAPI returns the OpenAPI document of the service.
+func (c *Client) OpenAPI(ctx context.Context) (*openapi.Document, error) {
+	var doc openapi.Document
+	if err := c.do(ctx, request{method: "GET", path: "/openapi.json", accept: jsonType}, &doc, false); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
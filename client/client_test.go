@@ -0,0 +1,206 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	data "github.com/form3/data"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const paymentDocument = `{"data":{"type":"Payment","id":"4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43","version":0,` +
+	`"attributes":{"amount":100.21,"currency":"GBP"},"meta":{"status":"reconciled"},` +
+	`"links":{"self":"/payments/5b290f5b802b0f1479000002"}},"links":{"self":"/payments/5b290f5b802b0f1479000002"}}`
+
+// newTestClient returns a client of a server serving handler, retrying
+// without waiting.
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	c, err := New(Options{BaseURL: server.URL, Token: "secret", Retry: data.Backoff{Initial: time.Millisecond, MaxAttempts: 3}})
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	return c
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(Options{BaseURL: "payments.example.com"}); err == nil {
+		t.Errorf("expected a base URL without scheme to be refused")
+	}
+	if _, err := New(Options{BaseURL: "https://payments.example.com", CAFile: "missing.pem"}); err == nil {
+		t.Errorf("expected a missing CA file to be refused")
+	}
+}
+
+func TestGetPaymentRetried(t *testing.T) {
+	var attempts int
+	var requestIDs []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		requestIDs = append(requestIDs, r.Header.Get(RequestIDHeader))
+		if r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("Accept") != jsonAPIType {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", jsonAPIType)
+		fmt.Fprint(w, paymentDocument)
+	})
+
+	payment, err := c.GetPayment(context.Background(), bson.ObjectIdHex("5b290f5b802b0f1479000002"))
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	if payment.MongoID.Hex() != "5b290f5b802b0f1479000002" || payment.Status != "reconciled" || payment.Attributes.Amount != 100.21 {
+		t.Errorf("unexpected payment %+v", payment)
+	}
+	if attempts != 3 || requestIDs[0] == "" || requestIDs[0] != requestIDs[2] {
+		t.Errorf("expected 3 attempts with the same request id, got %v", requestIDs)
+	}
+}
+
+func TestCreatePaymentRetriedWithKey(t *testing.T) {
+	var keys []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get(IdempotencyKeyHeader))
+		body, _ := ioutil.ReadAll(r.Body)
+		if !strings.Contains(string(body), `"amount":100.21`) {
+			t.Errorf("unexpected body %s", body)
+		}
+		if len(keys) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		fmt.Fprint(w, paymentDocument)
+	})
+
+	_, err := c.CreatePayment(context.Background(), data.Payment{Attributes: data.Attributes{Amount: 100.21}})
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	if len(keys) != 2 || keys[0] == "" || keys[0] != keys[1] {
+		t.Errorf("expected 2 attempts with the same idempotency key, got %v", keys)
+	}
+}
+
+func TestNotRetried(t *testing.T) {
+	var attempts int
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, `{"status":"the upload queue is full","request_id":"abc"}`)
+	})
+
+	_, err := c.UploadPayments(context.Background(), "payments.csv", strings.NewReader("amount\n1\n"), nil)
+	if StatusCode(err) != http.StatusServiceUnavailable || attempts != 1 {
+		t.Errorf("expected a single attempt failing with 503, got %v after %v attempts", err, attempts)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, test := range []struct {
+		status      int
+		contentType string
+		body        string
+		expected    string
+	}{
+		{http.StatusNotFound, jsonAPIType,
+			`{"errors":[{"status":"404","title":"Not Found","detail":"not found","meta":{"request_id":"abc"}}]}`,
+			"Not Found: not found (request abc)"},
+		{http.StatusBadRequest, jsonType, `{"status":"limit must be a positive number","request_id":"abc"}`,
+			"Bad Request: limit must be a positive number (request abc)"},
+		{http.StatusBadGateway, "text/html", "<h1>Bad Gateway</h1>\n", "Bad Gateway: <h1>Bad Gateway</h1> (request header)"},
+		// the service sends database errors with 200
+		{http.StatusOK, jsonType, `{"status":"no reachable servers"}`, "OK: no reachable servers (request header)"},
+	} {
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", test.contentType)
+			w.Header().Set(RequestIDHeader, "header")
+			w.WriteHeader(test.status)
+			fmt.Fprint(w, test.body)
+		})
+		c.retry.MaxAttempts = 1
+		_, err := c.GetJob(context.Background(), bson.ObjectIdHex("5b290f5b802b0f1479000002"))
+		if err == nil || err.Error() != test.expected || StatusCode(err) != test.status {
+			t.Errorf("\n...expected = %v\n...obtained = %v", test.expected, err)
+		}
+	}
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", jsonAPIType)
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"errors":[{"status":"404","title":"Not Found","detail":"not found"}]}`)
+	})
+	if err := c.DeletePayment(context.Background(), bson.ObjectIdHex("5b290f5b802b0f1479000002")); !IsNotFound(err) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+}
+
+func TestPaymentIterator(t *testing.T) {
+	ids := []string{"5b290f5b802b0f1479000001", "5b290f5b802b0f1479000002", "5b290f5b802b0f1479000003"}
+	var queries []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		if r.URL.Path != "/reconciliation/payments" {
+			t.Errorf("unexpected path %v", r.URL.Path)
+		}
+		start := 0
+		for i, id := range ids {
+			if id == r.URL.Query().Get("after") {
+				start = i + 1
+			}
+		}
+		end := start + 2
+		if end > len(ids) {
+			end = len(ids)
+		}
+		var resources []string
+		for _, id := range ids[start:end] {
+			resources = append(resources, `{"type":"Payment","version":0,"attributes":{},"links":{"self":"/payments/`+id+`"}}`)
+		}
+		next := ""
+		if end-start == 2 {
+			query := r.URL.Query()
+			query.Set("after", ids[end-1])
+			next = `,"next":"/payments?` + query.Encode() + `"`
+		}
+		fmt.Fprintf(w, `{"data":[%v],"links":{"self":"/payments"%v},"meta":{"count":%v}}`, strings.Join(resources, ","), next, end-start)
+	})
+
+	iter := c.UnmatchedPayments(context.Background(), data.PaymentFilter{Currency: "GBP", Limit: 2})
+	var obtained []string
+	var payment data.Payment
+	for iter.Next(&payment) {
+		obtained = append(obtained, payment.MongoID.Hex())
+	}
+	if err := iter.Close(); err != nil {
+		t.Errorf("Didn't expect error %v", err)
+	}
+	if strings.Join(obtained, ",") != strings.Join(ids, ",") {
+		t.Errorf("\n...expected = %v\n...obtained = %v", ids, obtained)
+	}
+	expected := []string{"currency=GBP&limit=2", "after=5b290f5b802b0f1479000002&currency=GBP&limit=2"}
+	if strings.Join(queries, " ") != strings.Join(expected, " ") {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, queries)
+	}
+}
+
+func TestPaymentIteratorError(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"errors":[{"status":"400","title":"Bad Request","detail":"after must be a payment id"}]}`)
+	})
+	payments, err := c.ListPayments(context.Background(), data.PaymentFilter{})
+	if payments != nil || StatusCode(err) != http.StatusBadRequest {
+		t.Errorf("expected the error of the first page, got %v %v", payments, err)
+	}
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// maxErrorBody bounds the error responses read.
+const maxErrorBody = 1 << 20
+
+// Error is a failed response of the service.
+type Error struct {
+	// StatusCode is the HTTP status of the response. The service sends some
+	// errors of its database with 200.
+	StatusCode int
+	Message    string
+	// RequestID identifies the request in the logs of the service
+	RequestID string
+
+	body []byte
+}
+
+func (e *Error) Error() string {
+	msg := http.StatusText(e.StatusCode)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.RequestID != "" {
+		msg += " (request " + e.RequestID + ")"
+	}
+	return msg
+}
+
+// StatusCode returns the HTTP status of err when it is an *Error, else 0.
+func StatusCode(err error) int {
+	if apiErr, ok := err.(*Error); ok {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// IsNotFound reports if err is the response of the service to a request for
+// a missing resource.
+func IsNotFound(err error) bool {
+	return StatusCode(err) == http.StatusNotFound
+}
+
+// errorBody holds the fields of the JSON:API error documents and of the
+// plain error messages.
+type errorBody struct {
+	Errors []struct {
+		Detail string `json:"detail"`
+		Meta   struct {
+			RequestID string `json:"request_id"`
+		} `json:"meta"`
+	} `json:"errors"`
+	Status    *string `json:"status"`
+	RequestID string  `json:"request_id"`
+}
+
+// decodeError reads the error of a failed response, a JSON:API document, an
+// error message or else text.
+func decodeError(resp *http.Response) error {
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if err != nil {
+		return err
+	}
+	e := &Error{StatusCode: resp.StatusCode, RequestID: resp.Header.Get(RequestIDHeader), body: body}
+	var decoded errorBody
+	switch {
+	case json.Unmarshal(body, &decoded) != nil:
+		e.Message = strings.TrimSpace(string(body))
+	case len(decoded.Errors) > 0:
+		e.Message = decoded.Errors[0].Detail
+		if id := decoded.Errors[0].Meta.RequestID; id != "" {
+			e.RequestID = id
+		}
+	case decoded.Status != nil:
+		e.Message = *decoded.Status
+		if decoded.RequestID != "" {
+			e.RequestID = decoded.RequestID
+		}
+	}
+	return e
+}
+
+// legacyError returns the error message sent by the service with a
+// successful status, an object holding only a status and a request id.
+func legacyError(resp *http.Response, body []byte) error {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil {
+		return nil
+	}
+	status, ok := fields["status"]
+	_, hasID := fields["request_id"]
+	if !ok || len(fields) > 2 || len(fields) == 2 && !hasID {
+		return nil
+	}
+	e := &Error{StatusCode: resp.StatusCode, RequestID: resp.Header.Get(RequestIDHeader), body: body}
+	json.Unmarshal(status, &e.Message)
+	if hasID {
+		json.Unmarshal(fields["request_id"], &e.RequestID)
+	}
+	return e
+}
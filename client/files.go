@@ -0,0 +1,237 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/form3/bacs"
+	data "github.com/form3/data"
+	"github.com/form3/ingest"
+	"github.com/form3/iso20022"
+	"github.com/form3/reconcile"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const xmlType = "application/xml"
+
+// Export formats of ExportPayments.
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// ExportOptions selects the format of an export, CSV by default, and its
+// columns, every payment field by default.
+type ExportOptions struct {
+	Format  string
+	Columns []string
+}
+
+// ExportPayments writes the payments matching filter to w as CSV or NDJSON.
+func (c *Client) ExportPayments(ctx context.Context, filter data.PaymentFilter, opts ExportOptions, w io.Writer) error {
+	query := filter.Values()
+	if opts.Format != "" {
+		query.Set("format", opts.Format)
+	}
+	if len(opts.Columns) > 0 {
+		query.Set("columns", strings.Join(opts.Columns, ","))
+	}
+	return c.download(ctx, request{method: "GET", path: "/payments/export", query: query}, w)
+}
+
+func messageQuery(query url.Values, opts iso20022.MessageOptions) url.Values {
+	for name, value := range map[string]string{
+		"message_id":        opts.MessageID,
+		"initiating_party":  opts.InitiatingParty,
+		"settlement_method": opts.SettlementMethod,
+	} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	return query
+}
+
+// ExportPain001 writes the payments matching filter to w as a pain.001
+// customer credit transfer initiation.
+func (c *Client) ExportPain001(ctx context.Context, filter data.PaymentFilter, opts iso20022.MessageOptions, w io.Writer) error {
+	return c.download(ctx, request{method: "GET", path: "/payments/export/pain001", query: messageQuery(filter.Values(), opts)}, w)
+}
+
+// ExportPacs008 writes the payments matching filter to w as a pacs.008 FI to
+// FI customer credit transfer.
+func (c *Client) ExportPacs008(ctx context.Context, filter data.PaymentFilter, opts iso20022.MessageOptions, w io.Writer) error {
+	return c.download(ctx, request{method: "GET", path: "/payments/export/pacs008", query: messageQuery(filter.Values(), opts)}, w)
+}
+
+// ExportBacs writes the BACS payments matching filter to w as a Standard 18
+// submission.
+func (c *Client) ExportBacs(ctx context.Context, filter data.PaymentFilter, opts bacs.Options, w io.Writer) error {
+	query := filter.Values()
+	for name, value := range map[string]string{
+		"service_user_number": opts.ServiceUserNumber,
+		"service_user_name":   opts.ServiceUserName,
+		"volume_serial":       opts.VolumeSerial,
+	} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	return c.download(ctx, request{method: "GET", path: "/payments/export/bacs", query: query}, w)
+}
+
+// GetPaymentPain001 writes the payment with the mongo id id to w as a
+// pain.001 message.
+func (c *Client) GetPaymentPain001(ctx context.Context, id bson.ObjectId, opts iso20022.MessageOptions, w io.Writer) error {
+	return c.download(ctx, request{method: "GET", path: "/payments/" + id.Hex() + "/pain001", query: messageQuery(url.Values{}, opts)}, w)
+}
+
+// GetPaymentPacs008 writes the payment with the mongo id id to w as a
+// pacs.008 message.
+func (c *Client) GetPaymentPacs008(ctx context.Context, id bson.ObjectId, opts iso20022.MessageOptions, w io.Writer) error {
+	return c.download(ctx, request{method: "GET", path: "/payments/" + id.Hex() + "/pacs008", query: messageQuery(url.Values{}, opts)}, w)
+}
+
+// GetPaymentMT103 returns the payment with the mongo id id as the text block
+// of an MT103 message.
+func (c *Client) GetPaymentMT103(ctx context.Context, id bson.ObjectId) (string, error) {
+	var buf bytes.Buffer
+	err := c.download(ctx, request{method: "GET", path: "/payments/" + id.Hex() + "/mt103"}, &buf)
+	return buf.String(), err
+}
+
+// Job is an upload of payments processed in the background.
+type Job struct {
+	data.Job
+	Progress float64         `json:"progress"`
+	Errors   []data.RowError `json:"errors"`
+	// ErrorReport is the path of the CSV report of the failed rows
+	ErrorReport string `json:"error_report"`
+}
+
+// UploadPayments uploads a CSV file of payments named name, its columns
+// mapped with mapping on top of the mapping of the service. The payments are
+// created by a job, polled with GetJob.
+func (c *Client) UploadPayments(ctx context.Context, name string, file io.Reader, mapping ingest.Mapping) (*Job, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if len(mapping) > 0 {
+		b, err := json.Marshal(mapping)
+		if err != nil {
+			return nil, err
+		}
+		form.WriteField("mapping", string(b))
+	}
+	part, err := form.CreateFormFile("file", name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return nil, err
+	}
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+	var job Job
+	r := request{method: "POST", path: "/payments/upload", body: body.Bytes(), contentType: form.FormDataContentType()}
+	if err := c.do(ctx, r, &job, true); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetJob returns the upload job with id id.
+func (c *Client) GetJob(ctx context.Context, id bson.ObjectId) (*Job, error) {
+	var job Job
+	if err := c.do(ctx, request{method: "GET", path: "/jobs/" + id.Hex()}, &job, true); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetJobErrors writes the CSV report of the rows an upload job failed to
+// create to w.
+func (c *Client) GetJobErrors(ctx context.Context, id bson.ObjectId, w io.Writer) error {
+	return c.download(ctx, request{method: "GET", path: "/jobs/" + id.Hex() + "/errors"}, w)
+}
+
+// ImportResult is the outcome of every transaction of an imported message.
+type ImportResult struct {
+	MessageID   string                 `json:"message_id"`
+	Created     int                    `json:"created"`
+	Failed      int                    `json:"failed"`
+	Results     []BatchItem            `json:"results"`
+	Unsupported []iso20022.Unsupported `json:"unsupported"`
+}
+
+// ImportPain001 creates the payments of the credit transfers of a pain.001
+// message.
+func (c *Client) ImportPain001(ctx context.Context, message io.Reader) (*ImportResult, error) {
+	body, err := ioutil.ReadAll(message)
+	if err != nil {
+		return nil, err
+	}
+	var result ImportResult
+	r := request{method: "POST", path: "/payments/import/pain001", body: body, contentType: xmlType}
+	if err := c.do(ctx, r, &result, true); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// StatementImport is the outcome of importing a statement.
+type StatementImport struct {
+	Entries    int               `json:"entries"`
+	Imported   int               `json:"imported"`
+	Duplicates int               `json:"duplicates"`
+	Matched    []reconcile.Match `json:"matched"`
+	Unmatched  int               `json:"unmatched"`
+}
+
+// ImportCamt053 imports the entries of a camt.053 statement and reconciles
+// them with the payments. Entries imported before are skipped, so a
+// statement can be imported again.
+func (c *Client) ImportCamt053(ctx context.Context, statement io.Reader) (*StatementImport, error) {
+	return c.importStatement(ctx, "/statements/import/camt053", xmlType, statement)
+}
+
+// ImportMT940 imports the entries of MT940 statements and reconciles them
+// with the payments.
+func (c *Client) ImportMT940(ctx context.Context, statement io.Reader) (*StatementImport, error) {
+	return c.importStatement(ctx, "/statements/import/mt940", "text/plain", statement)
+}
+
+func (c *Client) importStatement(ctx context.Context, path, contentType string, statement io.Reader) (*StatementImport, error) {
+	body, err := ioutil.ReadAll(statement)
+	if err != nil {
+		return nil, err
+	}
+	var result StatementImport
+	r := request{method: "POST", path: path, body: body, contentType: contentType}
+	if err := c.do(ctx, r, &result, true); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// UnmatchedEntries returns the statement entries not matched to a payment,
+// oldest first, at most limit of them or the default of the service when
+// limit is 0.
+func (c *Client) UnmatchedEntries(ctx context.Context, limit int) ([]data.StatementEntry, error) {
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var entries []data.StatementEntry
+	if err := c.do(ctx, request{method: "GET", path: "/reconciliation/entries", query: query}, &entries, true); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,240 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"path"
+	"strconv"
+
+	data "github.com/form3/data"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// DefaultPageSize is the number of payments fetched per request when
+// iterating a list without a limit.
+const DefaultPageSize = 100
+
+var errNoData = errors.New("the response has no payment")
+
+// resource is a payment as a JSON:API resource object.
+type resource struct {
+	Type           string          `json:"type"`
+	ID             string          `json:"id,omitempty"`
+	Version        int             `json:"version"`
+	OrganisationID string          `json:"organisation_id,omitempty"`
+	Attributes     data.Attributes `json:"attributes"`
+	Meta           *struct {
+		Status string `json:"status,omitempty"`
+	} `json:"meta,omitempty"`
+	Links *struct {
+		Self string `json:"self"`
+	} `json:"links,omitempty"`
+}
+
+func toResource(payment *data.Payment) resource {
+	return resource{
+		Type:           payment.Type,
+		ID:             payment.ID,
+		Version:        payment.Version,
+		OrganisationID: payment.OrganisationID,
+		Attributes:     payment.Attributes,
+	}
+}
+
+// payment returns the payment of r, its mongo id read from the self link.
+func (r *resource) payment() data.Payment {
+	payment := data.Payment{
+		Type:           r.Type,
+		ID:             r.ID,
+		Version:        r.Version,
+		OrganisationID: r.OrganisationID,
+		Attributes:     r.Attributes,
+	}
+	if r.Meta != nil {
+		payment.Status = r.Meta.Status
+	}
+	if r.Links != nil {
+		if id := path.Base(r.Links.Self); bson.IsObjectIdHex(id) {
+			payment.MongoID = bson.ObjectIdHex(id)
+		}
+	}
+	return payment
+}
+
+type resourceDocument struct {
+	Data *resource `json:"data"`
+}
+
+type resourcesDocument struct {
+	Data  []resource `json:"data"`
+	Links struct {
+		Next string `json:"next"`
+	} `json:"links"`
+}
+
+// sendPayment sends r with payment, if any, as a resource and returns the
+// payment of the response.
+func (c *Client) sendPayment(ctx context.Context, r request, payment *data.Payment) (*data.Payment, error) {
+	if payment != nil {
+		res := toResource(payment)
+		body, err := json.Marshal(resourceDocument{Data: &res})
+		if err != nil {
+			return nil, err
+		}
+		r.body, r.contentType = body, jsonAPIType
+	}
+	r.accept = jsonAPIType
+	var doc resourceDocument
+	if err := c.do(ctx, r, &doc, false); err != nil {
+		return nil, err
+	}
+	if doc.Data == nil {
+		return nil, errNoData
+	}
+	p := doc.Data.payment()
+	return &p, nil
+}
+
+// GetPayment returns the payment with the mongo id id.
+func (c *Client) GetPayment(ctx context.Context, id bson.ObjectId) (*data.Payment, error) {
+	return c.sendPayment(ctx, request{method: "GET", path: "/payments/" + id.Hex()}, nil)
+}
+
+// CreatePayment creates payment, sent with a new idempotency key so that it
+// is created once however many times the request is retried.
+func (c *Client) CreatePayment(ctx context.Context, payment data.Payment) (*data.Payment, error) {
+	return c.sendPayment(ctx, request{method: "POST", path: "/payments", idempotencyKey: c.newID()}, &payment)
+}
+
+// UpdatePayment replaces the payment with the mongo id of payment.
+func (c *Client) UpdatePayment(ctx context.Context, payment data.Payment) (*data.Payment, error) {
+	return c.sendPayment(ctx, request{method: "PUT", path: "/payments/" + payment.MongoID.Hex()}, &payment)
+}
+
+// DeletePayment deletes the payment with the mongo id id.
+func (c *Client) DeletePayment(ctx context.Context, id bson.ObjectId) error {
+	return c.do(ctx, request{method: "DELETE", path: "/payments/" + id.Hex(), accept: jsonAPIType}, nil, false)
+}
+
+// BatchResult is the outcome of a batch create.
+type BatchResult struct {
+	Ordered bool        `json:"ordered"`
+	Created int         `json:"created"`
+	Failed  int         `json:"failed"`
+	Results []BatchItem `json:"results"`
+}
+
+// BatchItem is the outcome of the payment at Index in a batch, created,
+// invalid, failed, not_attempted or unknown when it may have been created.
+type BatchItem struct {
+	Index   int           `json:"index"`
+	Status  string        `json:"status"`
+	Payment *data.Payment `json:"payment,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// CreatePayments creates payments with a single request. An ordered batch
+// stops at the first payment that fails.
+func (c *Client) CreatePayments(ctx context.Context, payments []data.Payment, ordered bool) (*BatchResult, error) {
+	body, err := json.Marshal(struct {
+		Ordered  bool           `json:"ordered"`
+		Payments []data.Payment `json:"payments"`
+	}{ordered, payments})
+	if err != nil {
+		return nil, err
+	}
+	var result BatchResult
+	r := request{method: "POST", path: "/payments/batch", body: body, contentType: jsonType, idempotencyKey: c.newID()}
+	if err := c.do(ctx, r, &result, true); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// PaymentIterator iterates the payments of a list, fetching a page at a time.
+type PaymentIterator struct {
+	client *Client
+	ctx    context.Context
+	path   string
+	query  url.Values
+	page   []resource
+	// done is set once the last page is fetched
+	done bool
+	err  error
+}
+
+// Payments iterates the payments matching filter, in order of creation.
+// filter.Limit is the size of the pages, DefaultPageSize when not set.
+func (c *Client) Payments(ctx context.Context, filter data.PaymentFilter) *PaymentIterator {
+	return c.iterate(ctx, "/payments", filter)
+}
+
+// UnmatchedPayments iterates the payments no statement entry matched.
+func (c *Client) UnmatchedPayments(ctx context.Context, filter data.PaymentFilter) *PaymentIterator {
+	return c.iterate(ctx, "/reconciliation/payments", filter)
+}
+
+func (c *Client) iterate(ctx context.Context, path string, filter data.PaymentFilter) *PaymentIterator {
+	query := filter.Values()
+	if filter.Limit <= 0 {
+		query.Set("limit", strconv.Itoa(DefaultPageSize))
+	}
+	return &PaymentIterator{client: c, ctx: ctx, path: path, query: query}
+}
+
+// Next decodes the next payment into payment and reports if there was one.
+func (it *PaymentIterator) Next(payment *data.Payment) bool {
+	for len(it.page) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+		it.fetch()
+	}
+	*payment = it.page[0].payment()
+	it.page = it.page[1:]
+	return true
+}
+
+// fetch reads the next page, following the next link of the previous one.
+func (it *PaymentIterator) fetch() {
+	var doc resourcesDocument
+	r := request{method: "GET", path: it.path, query: it.query, accept: jsonAPIType}
+	if it.err = it.client.do(it.ctx, r, &doc, false); it.err != nil {
+		return
+	}
+	it.page = doc.Data
+	if doc.Links.Next == "" {
+		it.done = true
+		return
+	}
+	// the link is relative to the service, only its query is kept
+	next, err := url.Parse(doc.Links.Next)
+	if err != nil {
+		it.err = err
+		return
+	}
+	it.query = next.Query()
+}
+
+// Close returns the error that stopped the iteration, if any.
+func (it *PaymentIterator) Close() error {
+	it.page, it.done = nil, true
+	return it.err
+}
+
+// ListPayments returns every payment matching filter, fetched a page at a
+// time.
+func (c *Client) ListPayments(ctx context.Context, filter data.PaymentFilter) ([]data.Payment, error) {
+	iter := c.Payments(ctx, filter)
+	payments := []data.Payment{}
+	var payment data.Payment
+	for iter.Next(&payment) {
+		payments = append(payments, payment)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
@@ -0,0 +1,235 @@
+// Command form3ctl operates on the payments of the service through its REST
+// API, e.g.
+//
+//	form3ctl -profile prod list -currency GBP -o csv
+//	form3ctl get 5b290f5b802b0f1479000002
+//	form3ctl create -f payments.json
+//
+// The service is reached with a profile of the configuration file,
+// ~/.form3ctl.json by default, or the -url, -cert, -key, -ca and -token
+// flags. Failures exit with a code telling the kind of error, see the exit
+// constants.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/form3/client"
+)
+
+// Exit codes
+const (
+	exitOK = 0
+	// exitError is a failure to reach the service, read a file or decode a
+	// response
+	exitError = 1
+	// exitUsage is a command run with invalid arguments
+	exitUsage = 2
+	// exitNotFound is a payment or job that does not exist
+	exitNotFound = 3
+	// exitInvalid is a request rejected by the service, 400, 406, 413, 415
+	// or 422
+	exitInvalid = 4
+	// exitConflict is a request conflicting with another one, 409
+	exitConflict = 5
+	// exitDenied is a client not allowed to send the request, 401 or 403
+	exitDenied = 6
+	// exitUnavailable is a service failing to handle the request, 429, a 5xx
+	// status or a database error sent with 200
+	exitUnavailable = 7
+	// exitPartial is a create or import where some payments failed
+	exitPartial = 8
+)
+
+// usageError is an error of the arguments of a command.
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+var (
+	// errPartial is returned by the commands that printed their results but
+	// where some payments failed.
+	errPartial = errors.New("some payments failed")
+	// errFlags is returned for the flags of a command the flag package
+	// reported already.
+	errFlags = usageError("invalid flags")
+)
+
+// env is what a command operates with.
+type env struct {
+	ctx     context.Context
+	client  *client.Client
+	printer *printer
+	stdin   io.Reader
+	stdout  io.Writer
+	stderr  io.Writer
+}
+
+// flags returns the flag set of the command name, invoked with arguments.
+func (e *env) flags(name, arguments string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(e.stderr, "usage: form3ctl %v [flags] %v\n", name, arguments)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses the flags of a command.
+func parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil && err != flag.ErrHelp {
+		return errFlags
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// command runs a subcommand with the arguments after its name.
+type command func(e *env, args []string) error
+
+var commands = map[string]command{
+	"create": createCommand,
+	"delete": deleteCommand,
+	"export": exportCommand,
+	"get":    getCommand,
+	"import": importCommand,
+	"list":   listCommand,
+	"update": updateCommand,
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr, os.LookupEnv)
+	stop()
+	os.Exit(code)
+}
+
+// run runs form3ctl with args and returns its exit code.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer, lookupEnv func(string) (string, bool)) int {
+	fs := flag.NewFlagSet("form3ctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	configPath, explicit := lookupEnv("FORM3CTL_CONFIG")
+	if !explicit {
+		if home, err := os.UserHomeDir(); err == nil {
+			configPath = filepath.Join(home, configFile)
+		}
+	}
+	profileName, _ := lookupEnv("FORM3CTL_PROFILE")
+	fs.Func("config", "configuration file of the profiles, $FORM3CTL_CONFIG or ~/"+configFile+" by default", func(path string) error {
+		configPath, explicit = path, true
+		return nil
+	})
+	fs.StringVar(&profileName, "profile", profileName, "profile of the environment, $FORM3CTL_PROFILE or the default profile by default")
+	var flags Profile
+	fs.StringVar(&flags.BaseURL, "url", "", "URL of the service, overriding the profile")
+	fs.StringVar(&flags.CertFile, "cert", "", "PEM client certificate, overriding the profile")
+	fs.StringVar(&flags.KeyFile, "key", "", "PEM key of the client certificate, overriding the profile")
+	fs.StringVar(&flags.CAFile, "ca", "", "PEM CA bundle verifying the service, overriding the profile")
+	fs.StringVar(&flags.Token, "token", "", "bearer token, overriding the profile")
+	output := fs.String("o", outputTable, "output format: table, json or csv")
+	timeout := fs.Duration("timeout", 0, "deadline of the command, none by default")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: form3ctl [flags] %v [flags] [arguments]\n", strings.Join(commandNames(), "|"))
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		return exitOK
+	} else if err != nil {
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q, expected one of %v\n", fs.Arg(0), strings.Join(commandNames(), ", "))
+		return exitUsage
+	}
+
+	e := &env{stdin: stdin, stdout: stdout, stderr: stderr}
+	var err error
+	if e.printer, err = newPrinter(*output, stdout); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+	cfg, err := loadConfig(configPath, explicit)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+	profile, err := cfg.profile(profileName)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+	profile = profile.override(flags)
+	if profile.BaseURL == "" {
+		fmt.Fprintln(stderr, "no service URL, set -url or a profile")
+		return exitUsage
+	}
+	if e.client, err = client.New(profile.options()); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+	e.ctx = ctx
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		e.ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	err = cmd(e, fs.Args()[1:])
+	if err != nil && err != flag.ErrHelp && err != errPartial && err != errFlags {
+		fmt.Fprintln(stderr, err)
+	}
+	return exitCode(err)
+}
+
+func commandNames() []string {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// exitCode maps the error of a command to the exit code of the process.
+func exitCode(err error) int {
+	switch err {
+	case nil, flag.ErrHelp:
+		return exitOK
+	case errPartial:
+		return exitPartial
+	}
+	if _, ok := err.(usageError); ok {
+		return exitUsage
+	}
+	if _, ok := err.(*client.Error); !ok {
+		return exitError
+	}
+	switch status := client.StatusCode(err); {
+	case status == http.StatusNotFound:
+		return exitNotFound
+	case status == http.StatusConflict:
+		return exitConflict
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return exitDenied
+	case status == http.StatusTooManyRequests || status >= 500 || status < 300:
+		return exitUnavailable
+	}
+	return exitInvalid
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const paymentResource = `{"type":"Payment","id":"4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43","version":0,` +
+	`"attributes":{"amount":100.21,"currency":"GBP","payment_scheme":"FPS","processing_date":"2017-01-18","reference":"Payment for Em's piano lessons"},` +
+	`"links":{"self":"/payments/5b290f5b802b0f1479000002"}}`
+
+// runCtl runs form3ctl with args against handler, without configuration file.
+func runCtl(t *testing.T, handler http.HandlerFunc, stdin string, args ...string) (int, string, string) {
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	t.Setenv("HOME", t.TempDir())
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), append([]string{"-url", server.URL}, args...), strings.NewReader(stdin), &stdout, &stderr, func(string) (string, bool) {
+		return "", false
+	})
+	return code, stdout.String(), stderr.String()
+}
+
+func listHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/vnd.api+json")
+	fmt.Fprintf(w, `{"data":[%v],"links":{"self":"/payments"},"meta":{"count":1}}`, paymentResource)
+}
+
+func TestList(t *testing.T) {
+	for _, test := range []struct {
+		output   string
+		expected string
+	}{
+		{"table", "" +
+			"_ID                       ID                                    PAYMENT_SCHEME  AMOUNT  CURRENCY  PROCESSING_DATE  REFERENCE                       STATUS\n" +
+			"5b290f5b802b0f1479000002  4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43  FPS             100.21  GBP       2017-01-18       Payment for Em's piano lessons  \n"},
+		{"csv", "" +
+			"_id,id,payment_scheme,amount,currency,processing_date,reference,status\n" +
+			"5b290f5b802b0f1479000002,4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43,FPS,100.21,GBP,2017-01-18,Payment for Em's piano lessons,\n"},
+	} {
+		code, stdout, stderr := runCtl(t, listHandler, "", "-o", test.output, "list", "-currency", "GBP")
+		if code != exitOK || stdout != test.expected {
+			t.Errorf("%v\n...expected = %v\n...obtained = %v %v%v", test.output, test.expected, code, stdout, stderr)
+		}
+	}
+
+	code, stdout, _ := runCtl(t, listHandler, "", "-o", "json", "list")
+	if code != exitOK || !strings.HasPrefix(stdout, "[\n  {\n    \"_id\": \"5b290f5b802b0f1479000002\",") {
+		t.Errorf("expected a JSON array of payments, got %v %v", code, stdout)
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	for _, test := range []struct {
+		status   int
+		body     string
+		expected int
+	}{
+		{http.StatusNotFound, `{"errors":[{"status":"404","title":"Not Found","detail":"not found"}]}`, exitNotFound},
+		{http.StatusBadRequest, `{"status":"invalid path parameter"}`, exitInvalid},
+		{http.StatusForbidden, `{"errors":[{"status":"403","title":"Forbidden","detail":"denied"}]}`, exitDenied},
+		{http.StatusConflict, `{"status":"in progress"}`, exitConflict},
+		// database errors of the legacy endpoints are sent with 200
+		{http.StatusOK, `{"status":"no reachable servers"}`, exitUnavailable},
+	} {
+		code, _, stderr := runCtl(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(test.status)
+			fmt.Fprint(w, test.body)
+		}, "", "import", "-format", "csv", "-f", "-")
+		if code != test.expected || stderr == "" {
+			t.Errorf("%v\n...expected = %v\n...obtained = %v %v", test.status, test.expected, code, stderr)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"get", "not-an-id"},
+		{"list", "-reconciled", "maybe"},
+		{"list", "-unknown"},
+		{"refund"},
+		{"-o", "yaml", "list"},
+	} {
+		if code, _, _ := runCtl(t, listHandler, "", args...); code != exitUsage {
+			t.Errorf("%v\n...expected = %v\n...obtained = %v", args, exitUsage, code)
+		}
+	}
+}
+
+func TestCreate(t *testing.T) {
+	var keys []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		body, _ := ioutil.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/payments":
+			if !strings.Contains(string(body), `"amount":100.21`) {
+				t.Errorf("unexpected body %s", body)
+			}
+			fmt.Fprintf(w, `{"data":%v}`, paymentResource)
+		case "/payments/batch":
+			fmt.Fprint(w, `{"ordered":false,"created":1,"failed":1,"results":[`+
+				`{"index":0,"status":"created","payment":{"_id":"5b290f5b802b0f1479000002","id":"4ee3a8d8","version":0}},`+
+				`{"index":1,"status":"invalid","error":"the payment belongs to another organisation"}]}`)
+		}
+	}
+
+	code, stdout, stderr := runCtl(t, handler, `{"attributes":{"amount":100.21}}`, "create", "-f", "-")
+	if code != exitOK || !strings.Contains(stdout, "5b290f5b802b0f1479000002") || keys[0] == "" {
+		t.Errorf("expected the payment to be created with a key, got %v %v%v %v", code, stdout, stderr, keys)
+	}
+
+	code, stdout, _ = runCtl(t, handler, `[{},{}]`, "-o", "csv", "create", "-f", "-", "-ordered=false")
+	expected := "index,status,_id,id,error\n" +
+		"0,created,5b290f5b802b0f1479000002,4ee3a8d8,\n" +
+		"1,invalid,,,the payment belongs to another organisation\n"
+	if code != exitPartial || stdout != expected {
+		t.Errorf("\n...expected = %v %v\n...obtained = %v %v", exitPartial, expected, code, stdout)
+	}
+}
+
+func TestExport(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "payments.csv")
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("format") != "ndjson" || r.URL.Query().Get("columns") != "id,attributes.amount" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotAcceptable)
+			fmt.Fprint(w, `{"status":"export is available as text/csv or application/x-ndjson"}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		fmt.Fprintln(w, `{"id":"4ee3a8d8","attributes":{"amount":100.21}}`)
+	}
+
+	code, _, stderr := runCtl(t, handler, "", "export", "-format", "ndjson", "-columns", "id,attributes.amount", "-out", out)
+	if b, err := ioutil.ReadFile(out); code != exitOK || err != nil || string(b) != "{\"id\":\"4ee3a8d8\",\"attributes\":{\"amount\":100.21}}\n" {
+		t.Errorf("expected the export to be written, got %v %v %v %s", code, stderr, err, b)
+	}
+
+	code, _, _ = runCtl(t, handler, "", "export", "-out", out)
+	if _, err := os.Stat(out); code != exitInvalid || !os.IsNotExist(err) {
+		t.Errorf("expected the failed export to be removed, got %v %v", code, err)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		listHandler(w, r)
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	config := filepath.Join(dir, "form3ctl.json")
+	ioutil.WriteFile(config, []byte(`{"default_profile":"local","profiles":{`+
+		`"local":{"base_url":"`+server.URL+`","token":"local"},`+
+		`"staging":{"base_url":"`+server.URL+`","token":"staging"},`+
+		`"prod":{"base_url":"https://payments.example.com","ca_file":"ca.pem"}}}`), 0644)
+
+	cfg, err := loadConfig(config, true)
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	if ca := cfg.Profiles["prod"].CAFile; ca != filepath.Join(dir, "ca.pem") {
+		t.Errorf("expected the CA file relative to the configuration, got %v", ca)
+	}
+
+	for _, test := range []struct {
+		args     []string
+		env      map[string]string
+		expected string
+	}{
+		{[]string{"list"}, map[string]string{"FORM3CTL_CONFIG": config}, "Bearer local"},
+		{[]string{"-config", config, "-token", "flag", "list"}, nil, "Bearer flag"},
+		{[]string{"-config", config, "list"}, map[string]string{"FORM3CTL_PROFILE": "staging"}, "Bearer staging"},
+	} {
+		authorization = ""
+		var stdout, stderr bytes.Buffer
+		code := run(context.Background(), test.args, nil, &stdout, &stderr, func(name string) (string, bool) {
+			value, ok := test.env[name]
+			return value, ok
+		})
+		if code != exitOK || authorization != test.expected {
+			t.Errorf("%v\n...expected = %v\n...obtained = %v %v %v", test.args, test.expected, code, authorization, stderr.String())
+		}
+	}
+
+	var stderr bytes.Buffer
+	code := run(context.Background(), []string{"-config", config, "-profile", "production", "list"}, nil, ioutil.Discard, &stderr, func(string) (string, bool) { return "", false })
+	if code != exitUsage || strings.TrimSpace(stderr.String()) != `unknown profile "production", expected one of local, prod, staging` {
+		t.Errorf("expected the unknown profile to be reported, got %v %v", code, stderr.String())
+	}
+}
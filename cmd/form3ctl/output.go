@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	data "github.com/form3/data"
+)
+
+// Output formats
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputCSV   = "csv"
+)
+
+// table is a result as rows of cells, printed as a table or CSV.
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+// printer prints the results of the commands in the output format.
+type printer struct {
+	format string
+	out    io.Writer
+}
+
+func newPrinter(format string, out io.Writer) (*printer, error) {
+	switch format {
+	case outputTable, outputJSON, outputCSV:
+		return &printer{format: format, out: out}, nil
+	}
+	return nil, fmt.Errorf("output must be %v, %v or %v, got %q", outputTable, outputJSON, outputCSV, format)
+}
+
+// print prints v as JSON, or t as a table or CSV.
+func (p *printer) print(v interface{}, t table) error {
+	switch p.format {
+	case outputJSON:
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = p.out.Write(append(b, '\n'))
+		return err
+	case outputCSV:
+		w := csv.NewWriter(p.out)
+		w.Write(t.header)
+		w.WriteAll(t.rows)
+		return w.Error()
+	}
+	w := tabwriter.NewWriter(p.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.ToUpper(strings.Join(t.header, "\t")))
+	for _, row := range t.rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+var paymentHeader = []string{"_id", "id", "payment_scheme", "amount", "currency", "processing_date", "reference", "status"}
+
+func paymentRow(payment *data.Payment) []string {
+	return []string{
+		payment.MongoID.Hex(),
+		payment.ID,
+		payment.Attributes.PaymentScheme,
+		strconv.FormatFloat(payment.Attributes.Amount, 'f', -1, 64),
+		payment.Attributes.Currency,
+		payment.Attributes.ProcessingDate,
+		payment.Attributes.Reference,
+		payment.Status,
+	}
+}
+
+func paymentsTable(payments []data.Payment) table {
+	t := table{header: paymentHeader}
+	for i := range payments {
+		t.rows = append(t.rows, paymentRow(&payments[i]))
+	}
+	return t
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/form3/bacs"
+	"github.com/form3/client"
+	data "github.com/form3/data"
+	"github.com/form3/ingest"
+	"github.com/form3/iso20022"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// filterFlags declares the flags of the payment filter query parameters, e.g.
+// -processing-date-from for processing_date_from.
+func filterFlags(fs *flag.FlagSet) func() (data.PaymentFilter, error) {
+	params := []string{"organisation_id", "currency", "payment_scheme", "end_to_end_reference", "processing_date_from", "processing_date_to"}
+	values := make([]*string, len(params))
+	for i, param := range params {
+		values[i] = fs.String(strings.Replace(param, "_", "-", -1), "", "only payments with this "+strings.Replace(param, "_", " ", -1))
+	}
+	reconciled := fs.String("reconciled", "", "only reconciled payments when true, the others when false")
+	return func() (data.PaymentFilter, error) {
+		query := url.Values{}
+		for i, param := range params {
+			query.Set(param, *values[i])
+		}
+		query.Set("reconciled", *reconciled)
+		f, err := data.ParsePaymentFilter(query)
+		if err != nil {
+			return f, usageError(err.Error())
+		}
+		return f, nil
+	}
+}
+
+// paymentID reads the mongo id of a payment from an argument.
+func paymentID(arg string) (bson.ObjectId, error) {
+	if !bson.IsObjectIdHex(arg) {
+		return "", usageError(fmt.Sprintf("%q is not a payment id", arg))
+	}
+	return bson.ObjectIdHex(arg), nil
+}
+
+// readInput returns the content of the file at path, or of stdin for -.
+func (e *env) readInput(path string) ([]byte, error) {
+	if path == "" {
+		return nil, usageError("no file given with -f")
+	}
+	if path == "-" {
+		return ioutil.ReadAll(e.stdin)
+	}
+	return ioutil.ReadFile(path)
+}
+
+// listCommand prints the payments matching the filter flags.
+func listCommand(e *env, args []string) error {
+	fs := e.flags("list", "")
+	filter := filterFlags(fs)
+	unmatched := fs.Bool("unmatched", false, "only payments no statement entry matched")
+	limit := fs.Int("limit", 0, "maximum number of payments listed, all by default")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	f, err := filter()
+	if err != nil {
+		return err
+	}
+	if *limit > 0 && *limit < client.DefaultPageSize {
+		f.Limit = *limit
+	}
+	iter := e.client.Payments(e.ctx, f)
+	if *unmatched {
+		iter = e.client.UnmatchedPayments(e.ctx, f)
+	}
+	payments := []data.Payment{}
+	var payment data.Payment
+	for (*limit <= 0 || len(payments) < *limit) && iter.Next(&payment) {
+		payments = append(payments, payment)
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+	return e.printer.print(payments, paymentsTable(payments))
+}
+
+// getCommand prints the payments with the ids given as arguments.
+func getCommand(e *env, args []string) error {
+	fs := e.flags("get", "id...")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return usageError("no payment id given")
+	}
+	payments := []data.Payment{}
+	for _, arg := range fs.Args() {
+		id, err := paymentID(arg)
+		if err != nil {
+			return err
+		}
+		payment, err := e.client.GetPayment(e.ctx, id)
+		if err != nil {
+			return err
+		}
+		payments = append(payments, *payment)
+	}
+	if len(payments) == 1 {
+		return e.printer.print(payments[0], paymentsTable(payments))
+	}
+	return e.printer.print(payments, paymentsTable(payments))
+}
+
+// createCommand creates the payment, or the array of payments as a batch,
+// of a JSON file.
+func createCommand(e *env, args []string) error {
+	fs := e.flags("create", "")
+	file := fs.String("f", "", "JSON file of a payment or an array of payments, - for stdin")
+	ordered := fs.Bool("ordered", true, "stop a batch at the first payment that fails")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	b, err := e.readInput(*file)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(b), []byte("[")) {
+		var payment data.Payment
+		if err := json.Unmarshal(b, &payment); err != nil {
+			return fmt.Errorf("%v: %v", *file, err)
+		}
+		created, err := e.client.CreatePayment(e.ctx, payment)
+		if err != nil {
+			return err
+		}
+		return e.printer.print(created, paymentsTable([]data.Payment{*created}))
+	}
+	var payments []data.Payment
+	if err := json.Unmarshal(b, &payments); err != nil {
+		return fmt.Errorf("%v: %v", *file, err)
+	}
+	result, err := e.client.CreatePayments(e.ctx, payments, *ordered)
+	if err != nil {
+		return err
+	}
+	if err := e.printer.print(result, resultsTable(result.Results)); err != nil {
+		return err
+	}
+	if result.Failed > 0 {
+		return errPartial
+	}
+	return nil
+}
+
+func resultsTable(results []client.BatchItem) table {
+	t := table{header: []string{"index", "status", "_id", "id", "error"}}
+	for _, item := range results {
+		row := []string{strconv.Itoa(item.Index), item.Status, "", "", item.Error}
+		if item.Payment != nil {
+			row[2], row[3] = item.Payment.MongoID.Hex(), item.Payment.ID
+		}
+		t.rows = append(t.rows, row)
+	}
+	return t
+}
+
+// updateCommand replaces the payment with the id given as argument with the
+// payment of a JSON file.
+func updateCommand(e *env, args []string) error {
+	fs := e.flags("update", "id")
+	file := fs.String("f", "", "JSON file of the payment, - for stdin")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError("expected the id of the payment")
+	}
+	id, err := paymentID(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	b, err := e.readInput(*file)
+	if err != nil {
+		return err
+	}
+	var payment data.Payment
+	if err := json.Unmarshal(b, &payment); err != nil {
+		return fmt.Errorf("%v: %v", *file, err)
+	}
+	payment.MongoID = id
+	updated, err := e.client.UpdatePayment(e.ctx, payment)
+	if err != nil {
+		return err
+	}
+	return e.printer.print(updated, paymentsTable([]data.Payment{*updated}))
+}
+
+// deleteCommand deletes the payments with the ids given as arguments,
+// stopping at the first that cannot be deleted.
+func deleteCommand(e *env, args []string) error {
+	fs := e.flags("delete", "id...")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return usageError("no payment id given")
+	}
+	ids := make([]bson.ObjectId, fs.NArg())
+	for i, arg := range fs.Args() {
+		id, err := paymentID(arg)
+		if err != nil {
+			return err
+		}
+		ids[i] = id
+	}
+	type deleted struct {
+		ID     bson.ObjectId `json:"_id"`
+		Status string        `json:"status"`
+	}
+	results := []deleted{}
+	t := table{header: []string{"_id", "status"}}
+	var err error
+	for _, id := range ids {
+		if err = e.client.DeletePayment(e.ctx, id); err != nil {
+			break
+		}
+		results = append(results, deleted{id, "deleted"})
+		t.rows = append(t.rows, []string{id.Hex(), "deleted"})
+	}
+	if len(results) > 0 {
+		if err := e.printer.print(results, t); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// Export and import formats
+const (
+	formatCSV     = "csv"
+	formatNDJSON  = "ndjson"
+	formatPain001 = "pain001"
+	formatPacs008 = "pacs008"
+	formatBacs    = "bacs"
+	formatCamt053 = "camt053"
+	formatMT940   = "mt940"
+)
+
+// exportCommand writes the payments matching the filter flags as a file.
+func exportCommand(e *env, args []string) error {
+	fs := e.flags("export", "")
+	format := fs.String("format", formatCSV, "csv, ndjson, pain001, pacs008 or bacs")
+	out := fs.String("out", "-", "file written, - for stdout")
+	columns := fs.String("columns", "", "comma separated columns of a csv or ndjson export, every field by default")
+	messageID := fs.String("message-id", "", "identification of a pain001 or pacs008 message, generated by default")
+	initiatingParty := fs.String("initiating-party", "", "name of the party sending a pain001 or pacs008 message")
+	settlementMethod := fs.String("settlement-method", "", "settlement method of a pacs008 message")
+	serviceUserNumber := fs.String("service-user-number", "", "6 digit number of the BACS service user")
+	serviceUserName := fs.String("service-user-name", "", "name of the BACS service user written on the credits")
+	volumeSerial := fs.String("volume-serial", "", "serial number of the BACS submission, 000001 by default")
+	filter := filterFlags(fs)
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	f, err := filter()
+	if err != nil {
+		return err
+	}
+
+	var export func(w io.Writer) error
+	switch *format {
+	case formatCSV, formatNDJSON:
+		opts := client.ExportOptions{Format: *format}
+		if *columns != "" {
+			opts.Columns = strings.Split(*columns, ",")
+		}
+		export = func(w io.Writer) error { return e.client.ExportPayments(e.ctx, f, opts, w) }
+	case formatPain001, formatPacs008:
+		opts := iso20022.MessageOptions{MessageID: *messageID, InitiatingParty: *initiatingParty, SettlementMethod: *settlementMethod}
+		if *format == formatPain001 {
+			export = func(w io.Writer) error { return e.client.ExportPain001(e.ctx, f, opts, w) }
+		} else {
+			export = func(w io.Writer) error { return e.client.ExportPacs008(e.ctx, f, opts, w) }
+		}
+	case formatBacs:
+		opts := bacs.Options{ServiceUserNumber: *serviceUserNumber, ServiceUserName: *serviceUserName, VolumeSerial: *volumeSerial}
+		export = func(w io.Writer) error { return e.client.ExportBacs(e.ctx, f, opts, w) }
+	default:
+		return usageError(fmt.Sprintf("unknown export format %q", *format))
+	}
+
+	if *out == "-" {
+		return export(e.stdout)
+	}
+	w, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	err = export(w)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// a truncated export is not left behind
+		os.Remove(*out)
+	}
+	return err
+}
+
+// importCommand imports a file of payments or a statement.
+func importCommand(e *env, args []string) error {
+	fs := e.flags("import", "")
+	format := fs.String("format", "", "csv, pain001, camt053 or mt940")
+	file := fs.String("f", "", "file imported, - for stdin")
+	mapping := fs.String("mapping", "", "JSON file mapping the columns of a csv file to payment fields")
+	wait := fs.Bool("wait", false, "wait for the payments of a csv file to be created")
+	interval := fs.Duration("interval", defaultPollInterval, "how often the job of a csv file is polled with -wait")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if *format == "" {
+		return usageError("no format given with -format")
+	}
+	b, err := e.readInput(*file)
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case formatCSV:
+		return e.uploadPayments(*file, b, *mapping, *wait, *interval)
+	case formatPain001:
+		result, err := e.client.ImportPain001(e.ctx, bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		if err := e.printer.print(result, resultsTable(result.Results)); err != nil {
+			return err
+		}
+		if result.Failed > 0 {
+			return errPartial
+		}
+		return nil
+	case formatCamt053, formatMT940:
+		importStatement := e.client.ImportCamt053
+		if *format == formatMT940 {
+			importStatement = e.client.ImportMT940
+		}
+		result, err := importStatement(e.ctx, bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		t := table{header: []string{"entries", "imported", "duplicates", "matched", "unmatched"}}
+		t.rows = [][]string{{strconv.Itoa(result.Entries), strconv.Itoa(result.Imported), strconv.Itoa(result.Duplicates),
+			strconv.Itoa(len(result.Matched)), strconv.Itoa(result.Unmatched)}}
+		return e.printer.print(result, t)
+	}
+	return usageError(fmt.Sprintf("unknown import format %q", *format))
+}
+
+// defaultPollInterval is how often the job of an uploaded file is polled.
+const defaultPollInterval = time.Second
+
+// uploadPayments uploads a CSV file of payments and prints its job, once
+// finished with wait.
+func (e *env) uploadPayments(path string, file []byte, mappingPath string, wait bool, interval time.Duration) error {
+	var mapping ingest.Mapping
+	if mappingPath != "" {
+		b, err := ioutil.ReadFile(mappingPath)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(b, &mapping); err != nil {
+			return fmt.Errorf("%v: %v", mappingPath, err)
+		}
+	}
+	if path == "-" {
+		path = "stdin.csv"
+	}
+	job, err := e.client.UploadPayments(e.ctx, filepath.Base(path), bytes.NewReader(file), mapping)
+	if err != nil {
+		return err
+	}
+	for wait && !job.Finished() {
+		select {
+		case <-e.ctx.Done():
+			return e.ctx.Err()
+		case <-time.After(interval):
+		}
+		if job, err = e.client.GetJob(e.ctx, job.ID); err != nil {
+			return err
+		}
+	}
+	t := table{header: []string{"job", "status", "total_rows", "created_rows", "failed_rows", "error"}}
+	t.rows = [][]string{{job.ID.Hex(), job.Status, strconv.Itoa(job.TotalRows), strconv.Itoa(job.CreatedRows),
+		strconv.Itoa(job.FailedRows), job.Error}}
+	if err := e.printer.print(job, t); err != nil {
+		return err
+	}
+	if job.Status == data.JobFailed || job.Status == data.JobInterrupted {
+		return fmt.Errorf("job %v %v: %v", job.ID.Hex(), job.Status, job.Error)
+	}
+	if job.FailedRows > 0 {
+		return errPartial
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/form3/client"
+)
+
+// configFile is the default configuration file, in the home directory.
+const configFile = ".form3ctl.json"
+
+// Config holds the profiles of the environments operated, e.g.
+//
+//	{"default_profile": "local",
+//	 "profiles": {"local": {"base_url": "http://localhost:5000"},
+//	              "prod": {"base_url": "https://payments.example.com", "cert_file": "ops.pem", "key_file": "ops-key.pem"}}}
+type Config struct {
+	DefaultProfile string             `json:"default_profile"`
+	Profiles       map[string]Profile `json:"profiles"`
+}
+
+// Profile is how to reach the service of an environment. Relative file paths
+// are relative to the configuration file.
+type Profile struct {
+	BaseURL  string `json:"base_url"`
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+	CAFile   string `json:"ca_file,omitempty"`
+	Token    string `json:"token,omitempty"`
+}
+
+// loadConfig reads the configuration file at path. A missing file is an
+// empty configuration unless the path was given explicitly.
+func loadConfig(path string, explicit bool) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	dir := filepath.Dir(path)
+	for name, p := range cfg.Profiles {
+		for _, file := range []*string{&p.CertFile, &p.KeyFile, &p.CAFile} {
+			if *file != "" && !filepath.IsAbs(*file) {
+				*file = filepath.Join(dir, *file)
+			}
+		}
+		cfg.Profiles[name] = p
+	}
+	return &cfg, nil
+}
+
+// profile returns the profile named name, or the default profile when name
+// is empty. Without profiles, the empty profile is returned.
+func (c *Config) profile(name string) (Profile, error) {
+	if name == "" {
+		name = c.DefaultProfile
+	}
+	if name == "" {
+		return Profile{}, nil
+	}
+	p, ok := c.Profiles[name]
+	if !ok {
+		var names []string
+		for name := range c.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return p, fmt.Errorf("unknown profile %q, expected one of %v", name, strings.Join(names, ", "))
+	}
+	return p, nil
+}
+
+// override replaces the fields of p set in other.
+func (p Profile) override(other Profile) Profile {
+	for _, field := range []struct{ dst, src *string }{
+		{&p.BaseURL, &other.BaseURL},
+		{&p.CertFile, &other.CertFile},
+		{&p.KeyFile, &other.KeyFile},
+		{&p.CAFile, &other.CAFile},
+		{&p.Token, &other.Token},
+	} {
+		if *field.src != "" {
+			*field.dst = *field.src
+		}
+	}
+	return p
+}
+
+func (p Profile) options() client.Options {
+	return client.Options{BaseURL: p.BaseURL, CertFile: p.CertFile, KeyFile: p.KeyFile, CAFile: p.CAFile, Token: p.Token}
+}
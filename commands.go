@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/form3/bacs"
+	"github.com/form3/config"
+	data "github.com/form3/data"
+	"github.com/form3/iso20022"
+	"github.com/form3/migrate"
+)
+
+// command is a maintenance task run with the service configuration instead of
+// the server, e.g. `form3 -mongo-host db:27017 indexes -apply`.
+type command func(cfg *config.Config, dbConn *data.MongoDBConn, args []string, out io.Writer) error
+
+var commands = map[string]command{
+	"bacs":    bacsCommand,
+	"indexes": indexesCommand,
+	"migrate": migrateCommand,
+	"pacs008": messageCommand("pacs008", func(payments []data.Payment, opts iso20022.MessageOptions) (iso20022.Message, error) {
+		return iso20022.NewPacs008(payments, opts)
+	}),
+	"pain001": messageCommand("pain001", func(payments []data.Payment, opts iso20022.MessageOptions) (iso20022.Message, error) {
+		return iso20022.NewPain001(payments, opts)
+	}),
+}
+
+// runCommand runs the command named by args[0] and returns the process exit
+// code.
+func runCommand(cfg *config.Config, dbConn *data.MongoDBConn, args []string) int {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		var names []string
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(os.Stderr, "unknown command %q, expected one of %v\n", args[0], strings.Join(names, ", "))
+		return 2
+	}
+	if err := cmd(cfg, dbConn, args[1:], os.Stdout); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// indexSpecs returns every index the service declares.
+func indexSpecs(cfg *config.Config) []data.IndexSpec {
+	specs := append(data.PaymentIndexes(cfg.Mongo.Collection), data.JobIndexes()...)
+	specs = append(specs, data.StatementIndexes()...)
+	specs = append(specs, data.IdempotencyIndexes()...)
+	return append(specs, migrate.Indexes()...)
+}
+
+func newMigrator(cfg *config.Config, dbConn *data.MongoDBConn) *migrate.Migrator {
+	return migrate.New(dbConn, cfg.Mongo.Database, migrate.Payments(cfg.Mongo.Collection))
+}
+
+// indexesCommand prints how the indexes in mongo differ from the declared
+// ones, and builds the missing ones with -apply. It fails if a required index
+// is still missing.
+func indexesCommand(cfg *config.Config, dbConn *data.MongoDBConn, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("indexes", flag.ContinueOnError)
+	apply := fs.Bool("apply", false, "build the missing indexes")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	report, err := dbConn.ReconcileIndexes(cfg.Mongo.Database, indexSpecs(cfg), *apply)
+	if err != nil {
+		return err
+	}
+	if err := printJSON(out, report); err != nil {
+		return err
+	}
+	return report.Err()
+}
+
+// migrateCommand applies the pending migrations, printing their progress to
+// stderr, or lists the migrations with -status.
+func migrateCommand(cfg *config.Config, dbConn *data.MongoDBConn, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "count the documents the pending migrations would change without writing them")
+	status := fs.Bool("status", false, "list the migrations and when they were applied")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	migrator := newMigrator(cfg, dbConn)
+	if *status {
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		return printJSON(out, statuses)
+	}
+	migrator.Progress = func(p migrate.Progress) {
+		fmt.Fprintf(os.Stderr, "migration %d: %s %d/%d (%.1f%%), %d changed\n",
+			p.Version, p.Collection, p.Processed, p.Total, p.Percent(), p.Changed)
+	}
+	records, err := migrator.Up(*dryRun)
+	if records == nil {
+		records = []migrate.Record{}
+	}
+	if err := printJSON(out, records); err != nil {
+		return err
+	}
+	return err
+}
+
+// filterFlags declares the flags of the payment filter query parameters, e.g.
+// -processing-date-from for processing_date_from.
+func filterFlags(fs *flag.FlagSet) func() (data.PaymentFilter, error) {
+	params := []string{"organisation_id", "currency", "payment_scheme", "end_to_end_reference", "processing_date_from", "processing_date_to"}
+	values := make([]*string, len(params))
+	for i, param := range params {
+		values[i] = fs.String(strings.Replace(param, "_", "-", -1), "", "only payments with this "+strings.Replace(param, "_", " ", -1))
+	}
+	return func() (data.PaymentFilter, error) {
+		query := url.Values{}
+		for i, param := range params {
+			query.Set(param, *values[i])
+		}
+		return data.ParsePaymentFilter(query)
+	}
+}
+
+// readPayments returns the payments matching filter.
+func readPayments(dbConn *data.MongoDBConn, filter data.PaymentFilter) ([]data.Payment, error) {
+	db := &data.PaymentDataBase{MongoDBConn: dbConn}
+	iter, err := db.StreamPayments(context.Background(), filter)
+	if err != nil {
+		return nil, err
+	}
+	var payments []data.Payment
+	var payment data.Payment
+	for iter.Next(&payment) {
+		payments = append(payments, payment)
+	}
+	return payments, iter.Close()
+}
+
+// messageCommand returns the command writing the filtered payments as the
+// ISO 20022 message built by build.
+func messageCommand(name string, build func([]data.Payment, iso20022.MessageOptions) (iso20022.Message, error)) command {
+	return func(cfg *config.Config, dbConn *data.MongoDBConn, args []string, out io.Writer) error {
+		fs := flag.NewFlagSet(name, flag.ContinueOnError)
+		var opts iso20022.MessageOptions
+		fs.StringVar(&opts.MessageID, "message-id", "", "identification of the message, generated by default")
+		fs.StringVar(&opts.InitiatingParty, "initiating-party", "", "name of the party sending the message")
+		fs.StringVar(&opts.SettlementMethod, "settlement-method", "", "settlement method of a pacs.008, "+iso20022.DefaultSettlementMethod+" by default")
+		filter := filterFlags(fs)
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		f, err := filter()
+		if err != nil {
+			return err
+		}
+		payments, err := readPayments(dbConn, f)
+		if err != nil {
+			return err
+		}
+		doc, err := build(payments, opts)
+		if err != nil {
+			return err
+		}
+		return doc.Write(out)
+	}
+}
+
+// bacsCommand writes the filtered BACS payments as a Standard 18 submission.
+func bacsCommand(cfg *config.Config, dbConn *data.MongoDBConn, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("bacs", flag.ContinueOnError)
+	var opts bacs.Options
+	fs.StringVar(&opts.ServiceUserNumber, "service-user-number", "", "6 digit number of the service user")
+	fs.StringVar(&opts.ServiceUserName, "service-user-name", "", "name of the service user written on the credits")
+	fs.StringVar(&opts.VolumeSerial, "volume-serial", "", "serial number of the submission, 000001 by default")
+	filter := filterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	f, err := filter()
+	if err != nil {
+		return err
+	}
+	f.PaymentScheme = bacs.Scheme
+	payments, err := readPayments(dbConn, f)
+	if err != nil {
+		return err
+	}
+	submission, err := bacs.New(payments, opts)
+	if err != nil {
+		return err
+	}
+	return submission.Write(out)
+}
+
+func printJSON(out io.Writer, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(append(b, '\n'))
+	return err
+}
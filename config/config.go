@@ -0,0 +1,348 @@
+// Package config loads the service configuration.
+//
+// Values are resolved in this order, each source overriding the previous one:
+//
+//  1. defaults, see Default
+//  2. the JSON config file given with -config or CONFIG_FILE
+//  3. environment variables
+//  4. command-line flags
+//
+// Every setting marked as secret can also be read from a file by setting the
+// environment variable with a _FILE suffix, e.g. MONGO_PASSWORD_FILE, or by
+// giving a path prefixed with "file:" as its value in any source.
+package config
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	data "github.com/form3/data"
+	"github.com/form3/ingest"
+	"github.com/form3/logging"
+	"github.com/form3/tlsutil"
+)
+
+// Config is the effective configuration of the service.
+type Config struct {
+	Server    Server    `json:"server"`
+	Mongo     Mongo     `json:"mongo"`
+	Log       Log       `json:"log"`
+	Ingest    Ingest    `json:"ingest"`
+	Reconcile Reconcile `json:"reconcile"`
+	Shutdown  Shutdown  `json:"shutdown"`
+	Health    Health    `json:"health"`
+
+	// Command holds the arguments left after the flags, naming a maintenance
+	// command to run instead of the server.
+	Command []string `json:"-"`
+}
+
+type Server struct {
+	Addr string `json:"addr" env:"LISTEN_ADDR" flag:"addr" help:"address the REST API listens on"`
+	TLS  TLS    `json:"tls"`
+
+	MaxBatchSize int   `json:"max_batch_size" env:"MAX_BATCH_SIZE" flag:"max-batch-size" help:"maximum number of payments of a batch create"`
+	MaxBodySize  int64 `json:"max_body_size" env:"MAX_BODY_SIZE" flag:"max-body-size" help:"maximum size of a JSON request body in bytes"`
+	// MaxMessagePayments bounds the payments of an exported pain.001,
+	// pacs.008 or BACS file, all read before it is written
+	MaxMessagePayments int `json:"max_message_payments" env:"MAX_MESSAGE_PAYMENTS" flag:"max-message-payments" help:"maximum number of payments of an exported payment file"`
+}
+
+// TLS enables HTTPS when CertFile is set, and mutual TLS when ClientAuth is
+// request or require.
+type TLS struct {
+	CertFile            string            `json:"cert_file" env:"TLS_CERT_FILE" flag:"tls-cert" help:"PEM certificate served by the REST API, enables TLS"`
+	KeyFile             string            `json:"key_file" env:"TLS_KEY_FILE" flag:"tls-key" help:"PEM private key of the certificate"`
+	ClientCAFile        string            `json:"client_ca_file" env:"TLS_CLIENT_CA_FILE" flag:"tls-client-ca" help:"PEM CA bundle verifying client certificates"`
+	ClientAuth          string            `json:"client_auth" env:"TLS_CLIENT_AUTH" flag:"tls-client-auth" help:"client certificates: none, request or require"`
+	MinVersion          string            `json:"min_version" env:"TLS_MIN_VERSION" flag:"tls-min-version" help:"minimum TLS version: 1.0, 1.1, 1.2 or 1.3"`
+	CipherSuites        []string          `json:"cipher_suites" env:"TLS_CIPHER_SUITES" flag:"tls-cipher-suites" help:"comma separated cipher suites allowed below TLS 1.3"`
+	ClientOrganisations map[string]string `json:"client_organisations" env:"TLS_CLIENT_ORGANISATIONS" flag:"tls-client-organisations" help:"comma separated certificate common name=organisation id pairs"`
+	ReloadInterval      Duration          `json:"reload_interval" env:"TLS_RELOAD_INTERVAL" flag:"tls-reload-interval" help:"how often certificate files are checked for changes"`
+}
+
+// Enabled reports if the REST API is served over TLS.
+func (t TLS) Enabled() bool {
+	return t.CertFile != ""
+}
+
+// Options returns the TLS options of the server.
+func (t TLS) Options() tlsutil.Options {
+	return tlsutil.Options{
+		CertFile:     t.CertFile,
+		KeyFile:      t.KeyFile,
+		ClientCAFile: t.ClientCAFile,
+		ClientAuth:   t.ClientAuth,
+		MinVersion:   t.MinVersion,
+		CipherSuites: t.CipherSuites,
+	}
+}
+
+type Mongo struct {
+	Host           string   `json:"host" env:"MONGO_URI" flag:"mongo-host" help:"mongo host:port, comma separated for several servers"`
+	Database       string   `json:"database" env:"MONGO_DATABASE" flag:"mongo-database" help:"mongo database name"`
+	Collection     string   `json:"collection" env:"MONGO_COLLECTION" flag:"mongo-collection" help:"collection storing the payments"`
+	SocketTimeout  Duration `json:"socket_timeout" env:"MONGO_SOCKET_TIMEOUT" flag:"mongo-socket-timeout" help:"timeout of mongo socket operations"`
+	ConnectTimeout Duration `json:"connect_timeout" env:"MONGO_CONNECT_TIMEOUT" flag:"mongo-connect-timeout" help:"timeout of a single attempt to dial mongo"`
+	Username       string   `json:"username" env:"MONGO_USERNAME" flag:"mongo-username" help:"mongo user, enables authentication"`
+	Password       string   `json:"password" env:"MONGO_PASSWORD" flag:"mongo-password" help:"mongo password" secret:"true"`
+	AuthSource     string   `json:"auth_source" env:"MONGO_AUTH_SOURCE" flag:"mongo-auth-source" help:"database holding the mongo user, defaults to the database"`
+	AuthMechanism  string   `json:"auth_mechanism" env:"MONGO_AUTH_MECHANISM" flag:"mongo-auth-mechanism" help:"SCRAM-SHA-1 or MONGODB-CR"`
+	ReplicaSet     string   `json:"replica_set" env:"MONGO_REPLICA_SET" flag:"mongo-replica-set" help:"name of the replica set the servers must belong to"`
+	TLS            MongoTLS `json:"tls"`
+
+	ReconcileIndexes bool `json:"reconcile_indexes" env:"MONGO_RECONCILE_INDEXES" flag:"mongo-reconcile-indexes" help:"build missing indexes at startup"`
+	Migrate          bool `json:"migrate" env:"MONGO_MIGRATE" flag:"mongo-migrate" help:"apply pending migrations in the background at startup"`
+}
+
+// MongoTLS encrypts the connections to mongo when Enabled.
+type MongoTLS struct {
+	Enabled      bool     `json:"enabled" env:"MONGO_TLS" flag:"mongo-tls" help:"connect to mongo over TLS"`
+	CAFile       string   `json:"ca_file" env:"MONGO_TLS_CA_FILE" flag:"mongo-tls-ca" help:"PEM CA bundle trusted for the mongo servers instead of the system roots"`
+	CertFile     string   `json:"cert_file" env:"MONGO_TLS_CERT_FILE" flag:"mongo-tls-cert" help:"PEM client certificate presented to mongo"`
+	KeyFile      string   `json:"key_file" env:"MONGO_TLS_KEY_FILE" flag:"mongo-tls-key" help:"PEM private key of the client certificate"`
+	ServerName   string   `json:"server_name" env:"MONGO_TLS_SERVER_NAME" flag:"mongo-tls-server-name" help:"name verified in the mongo server certificates, defaults to the host"`
+	PinnedSHA256 []string `json:"pinned_sha256" env:"MONGO_TLS_PINNED_SHA256" flag:"mongo-tls-pinned-sha256" help:"comma separated SHA-256 fingerprints, one of which must be in the server chain"`
+}
+
+// ConnectOptions returns the options to dial mongo, loading the TLS files.
+func (m Mongo) ConnectOptions() (data.ConnectOptions, error) {
+	opts := data.ConnectOptions{
+		Database:      m.Database,
+		Collection:    m.Collection,
+		SocketTimeout: m.SocketTimeout.Duration(),
+		DialTimeout:   m.ConnectTimeout.Duration(),
+		Username:      m.Username,
+		Password:      m.Password,
+		AuthSource:    m.AuthSource,
+		ReplicaSet:    m.ReplicaSet,
+	}
+	for _, addr := range strings.Split(m.Host, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			opts.Addrs = append(opts.Addrs, addr)
+		}
+	}
+	if m.Username != "" {
+		opts.AuthMechanism = m.AuthMechanism
+	}
+	if m.TLS.Enabled {
+		config, err := tlsutil.ClientConfig(tlsutil.ClientOptions{
+			CAFile:       m.TLS.CAFile,
+			CertFile:     m.TLS.CertFile,
+			KeyFile:      m.TLS.KeyFile,
+			ServerName:   m.TLS.ServerName,
+			PinnedSHA256: m.TLS.PinnedSHA256,
+		})
+		if err != nil {
+			return opts, err
+		}
+		opts.TLS = config
+	}
+	return opts, nil
+}
+
+// Ingest configures the processing of uploaded CSV files.
+type Ingest struct {
+	Mapping       map[string]string `json:"mapping" env:"INGEST_MAPPING" flag:"ingest-mapping" help:"comma separated CSV column=payment field path pairs used by default"`
+	QueueSize     int               `json:"queue_size" env:"INGEST_QUEUE_SIZE" flag:"ingest-queue-size" help:"number of uploaded files waiting to be processed"`
+	MaxUploadSize int64             `json:"max_upload_size" env:"INGEST_MAX_UPLOAD_SIZE" flag:"ingest-max-upload-size" help:"maximum size of an uploaded file in bytes"`
+}
+
+// Reconcile sets how far statement entries may be from the payments they
+// match.
+type Reconcile struct {
+	AmountTolerance float64 `json:"amount_tolerance" env:"RECONCILE_AMOUNT_TOLERANCE" flag:"reconcile-amount-tolerance" help:"largest difference between the amounts of a statement entry and its payment"`
+	DateTolerance   int     `json:"date_tolerance" env:"RECONCILE_DATE_TOLERANCE" flag:"reconcile-date-tolerance" help:"largest number of days between the booking date of a statement entry and the processing date of its payment"`
+}
+
+type Log struct {
+	Level string `json:"level" env:"LOG_LEVEL" flag:"log-level" help:"one of debug, info, warn or error"`
+}
+
+type Shutdown struct {
+	DrainDelay  Duration `json:"drain_delay" env:"SHUTDOWN_DRAIN_DELAY" flag:"shutdown-drain-delay" help:"how long readiness fails before the server stops accepting connections"`
+	GracePeriod Duration `json:"grace_period" env:"SHUTDOWN_GRACE_PERIOD" flag:"shutdown-grace-period" help:"how long in-flight requests and workers have to finish on shutdown"`
+}
+
+type Health struct {
+	ProbeTimeout Duration `json:"probe_timeout" env:"HEALTH_PROBE_TIMEOUT" flag:"health-probe-timeout" help:"how long a readiness check may take before it is reported as timed out"`
+}
+
+// Default returns the configuration used when no source sets a value.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Addr:         ":5000",
+			MaxBatchSize: 1000,
+			MaxBodySize:  10 << 20,
+
+			MaxMessagePayments: 10000,
+
+			TLS: TLS{
+				ClientAuth:     "none",
+				MinVersion:     "1.2",
+				ReloadInterval: Duration(30 * time.Second),
+			},
+		},
+		Mongo: Mongo{
+			Host:           "localhost:27017",
+			Database:       "form3_db",
+			Collection:     "payments",
+			SocketTimeout:  Duration(10 * time.Minute),
+			ConnectTimeout: Duration(10 * time.Second),
+			AuthMechanism:  "SCRAM-SHA-1",
+
+			ReconcileIndexes: true,
+			Migrate:          true,
+		},
+		Log: Log{Level: "info"},
+		Ingest: Ingest{
+			QueueSize:     10,
+			MaxUploadSize: 50 << 20,
+		},
+		Reconcile: Reconcile{DateTolerance: 2},
+		Shutdown: Shutdown{
+			DrainDelay:  Duration(5 * time.Second),
+			GracePeriod: Duration(30 * time.Second),
+		},
+		Health: Health{ProbeTimeout: Duration(2 * time.Second)},
+	}
+}
+
+// Validate checks the configuration is usable, reporting every problem found.
+func (c *Config) Validate() error {
+	var problems []string
+	if c.Server.Addr == "" {
+		problems = append(problems, "server.addr is required")
+	}
+	if c.Server.MaxBatchSize <= 0 {
+		problems = append(problems, "server.max_batch_size must be positive")
+	}
+	if c.Server.MaxBodySize <= 0 {
+		problems = append(problems, "server.max_body_size must be positive")
+	}
+	if c.Server.MaxMessagePayments <= 0 {
+		problems = append(problems, "server.max_message_payments must be positive")
+	}
+	if tlsConfig := c.Server.TLS; tlsConfig.Enabled() {
+		if tlsConfig.KeyFile == "" {
+			problems = append(problems, "server.tls.key_file is required with server.tls.cert_file")
+		}
+		if _, err := tlsutil.ParseVersion(tlsConfig.MinVersion); err != nil {
+			problems = append(problems, "server.tls.min_version: "+err.Error())
+		}
+		if _, err := tlsutil.ParseCipherSuites(tlsConfig.CipherSuites); err != nil {
+			problems = append(problems, "server.tls.cipher_suites: "+err.Error())
+		}
+		if auth, err := tlsutil.ParseClientAuth(tlsConfig.ClientAuth); err != nil {
+			problems = append(problems, "server.tls.client_auth: "+err.Error())
+		} else if auth != tls.NoClientCert && tlsConfig.ClientCAFile == "" {
+			problems = append(problems, "server.tls.client_ca_file is required to verify client certificates")
+		}
+		if tlsConfig.ReloadInterval <= 0 {
+			problems = append(problems, "server.tls.reload_interval must be positive")
+		}
+	} else if c.Server.TLS.ClientCAFile != "" || c.Server.TLS.KeyFile != "" {
+		problems = append(problems, "server.tls.cert_file is required to enable TLS")
+	}
+	if c.Mongo.Host == "" {
+		problems = append(problems, "mongo.host is required")
+	} else if strings.Contains(c.Mongo.Host, "://") || strings.Contains(c.Mongo.Host, "@") {
+		problems = append(problems, "mongo.host must only list host:port addresses, use the mongo settings for credentials and options")
+	}
+	if c.Mongo.Password != "" && c.Mongo.Username == "" {
+		problems = append(problems, "mongo.username is required with mongo.password")
+	}
+	if c.Mongo.Username != "" && c.Mongo.AuthMechanism != "SCRAM-SHA-1" && c.Mongo.AuthMechanism != "MONGODB-CR" {
+		problems = append(problems, "mongo.auth_mechanism must be SCRAM-SHA-1 or MONGODB-CR")
+	}
+	if mongoTLS := c.Mongo.TLS; mongoTLS.Enabled {
+		if (mongoTLS.CertFile == "") != (mongoTLS.KeyFile == "") {
+			problems = append(problems, "mongo.tls.cert_file and mongo.tls.key_file must be set together")
+		}
+		if _, err := tlsutil.ParsePins(mongoTLS.PinnedSHA256); err != nil {
+			problems = append(problems, "mongo.tls.pinned_sha256: "+err.Error())
+		}
+	}
+	if c.Mongo.Database == "" {
+		problems = append(problems, "mongo.database is required")
+	}
+	if c.Mongo.Collection == "" {
+		problems = append(problems, "mongo.collection is required")
+	}
+	if c.Mongo.SocketTimeout <= 0 {
+		problems = append(problems, "mongo.socket_timeout must be positive")
+	}
+	if c.Mongo.ConnectTimeout <= 0 {
+		problems = append(problems, "mongo.connect_timeout must be positive")
+	}
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		problems = append(problems, "log.level: "+err.Error())
+	}
+	if c.Ingest.QueueSize <= 0 {
+		problems = append(problems, "ingest.queue_size must be positive")
+	}
+	if c.Ingest.MaxUploadSize <= 0 {
+		problems = append(problems, "ingest.max_upload_size must be positive")
+	}
+	if err := ingest.Mapping(c.Ingest.Mapping).Validate(); err != nil {
+		problems = append(problems, "ingest.mapping: "+err.Error())
+	}
+	if c.Reconcile.AmountTolerance < 0 {
+		problems = append(problems, "reconcile.amount_tolerance must not be negative")
+	}
+	if c.Reconcile.DateTolerance < 0 {
+		problems = append(problems, "reconcile.date_tolerance must not be negative")
+	}
+	if c.Shutdown.DrainDelay < 0 {
+		problems = append(problems, "shutdown.drain_delay must not be negative")
+	}
+	if c.Shutdown.GracePeriod <= 0 {
+		problems = append(problems, "shutdown.grace_period must be positive")
+	}
+	if c.Health.ProbeTimeout <= 0 {
+		problems = append(problems, "health.probe_timeout must be positive")
+	}
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Duration is a time.Duration written as a string such as "10s" in the
+// config file.
+type Duration time.Duration
+
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"10s\": %v", err)
+	}
+	return d.Set(s)
+}
+
+// Set parses a duration such as "1m30s", implementing flag.Value.
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
@@ -0,0 +1,250 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const filePrefix = "file:"
+
+// LookupEnv returns the value of an environment variable, see os.LookupEnv.
+type LookupEnv func(key string) (string, bool)
+
+// Load builds the configuration from the defaults, the config file, the
+// environment and the command-line arguments (without the program name) and
+// validates it. printConfig is true when -print-config was given. The
+// arguments left after the flags are returned in cfg.Command.
+func Load(name string, args []string, env LookupEnv) (cfg *Config, printConfig bool, err error) {
+	cfg = Default()
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configFile := fs.String("config", "", "path of a JSON config file (env CONFIG_FILE)")
+	fs.BoolVar(&printConfig, "print-config", false, "print the effective configuration with secrets redacted and exit")
+	flagValues := map[string]*flagValue{}
+	walk(reflect.ValueOf(cfg).Elem(), func(field reflect.StructField, v reflect.Value) {
+		if name := field.Tag.Get("flag"); name != "" {
+			fv := &flagValue{isBool: v.Kind() == reflect.Bool}
+			flagValues[name] = fv
+			usage := field.Tag.Get("help")
+			if envName := field.Tag.Get("env"); envName != "" {
+				usage += " (env " + envName + ")"
+			}
+			fs.Var(fv, name, usage)
+		}
+	})
+	if err = fs.Parse(args); err != nil {
+		return nil, false, err
+	}
+
+	if *configFile == "" {
+		*configFile, _ = env("CONFIG_FILE")
+	}
+	if *configFile != "" {
+		if err = loadFile(cfg, *configFile); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if err = resolve(cfg, env, flagValues); err != nil {
+		return nil, false, err
+	}
+	if err = cfg.Validate(); err != nil {
+		return nil, false, err
+	}
+	cfg.Command = fs.Args()
+	return cfg, printConfig, nil
+}
+
+// resolve applies the environment and the flags given on the command line to
+// every tagged setting of target, a pointer to a struct, and reads secrets
+// given as file paths.
+func resolve(target interface{}, env LookupEnv, flagValues map[string]*flagValue) error {
+	return walkErr(reflect.ValueOf(target).Elem(), func(field reflect.StructField, v reflect.Value) error {
+		path := fieldPath(field)
+		if envName := field.Tag.Get("env"); envName != "" {
+			if s, ok := env(envName); ok {
+				if err := set(v, s); err != nil {
+					return fmt.Errorf("%s: %v", envName, err)
+				}
+			} else if file, ok := env(envName + "_FILE"); ok && isSecret(field) {
+				if err := set(v, filePrefix+file); err != nil {
+					return fmt.Errorf("%s_FILE: %v", envName, err)
+				}
+			}
+		}
+		if fv, ok := flagValues[field.Tag.Get("flag")]; ok && fv.set {
+			if err := set(v, fv.value); err != nil {
+				return fmt.Errorf("-%s: %v", field.Tag.Get("flag"), err)
+			}
+		}
+		if isSecret(field) && v.Kind() == reflect.String && strings.HasPrefix(v.String(), filePrefix) {
+			secret, err := readSecret(strings.TrimPrefix(v.String(), filePrefix))
+			if err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+			v.SetString(secret)
+		}
+		return nil
+	})
+}
+
+// redact replaces every secret set in target, a pointer to a struct.
+func redact(target interface{}) {
+	walk(reflect.ValueOf(target).Elem(), func(field reflect.StructField, v reflect.Value) {
+		if isSecret(field) && v.Kind() == reflect.String && v.String() != "" {
+			v.SetString("[REDACTED]")
+		}
+	})
+}
+
+// Print writes the configuration as JSON with every secret redacted.
+func (c *Config) Print(w io.Writer) error {
+	redacted := *c
+	redact(&redacted)
+	out, err := json.MarshalIndent(redacted, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(out, '\n'))
+	return err
+}
+
+func loadFile(cfg *Config, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(cfg); err != nil {
+		return fmt.Errorf("config file %s: %v", path, err)
+	}
+	return nil
+}
+
+func readSecret(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+func isSecret(field reflect.StructField) bool {
+	return field.Tag.Get("secret") == "true"
+}
+
+func fieldPath(field reflect.StructField) string {
+	return strings.Split(field.Tag.Get("json"), ",")[0]
+}
+
+var durationType = reflect.TypeOf(Duration(0))
+
+// walk calls fn for every leaf setting of the struct v.
+func walk(v reflect.Value, fn func(field reflect.StructField, v reflect.Value)) {
+	walkErr(v, func(field reflect.StructField, v reflect.Value) error {
+		fn(field, v)
+		return nil
+	})
+}
+
+func walkErr(v reflect.Value, fn func(field reflect.StructField, v reflect.Value) error) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field, value := t.Field(i), v.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		var err error
+		if value.Kind() == reflect.Struct {
+			err = walkErr(value, fn)
+		} else {
+			err = fn(field, value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// set parses s into v according to its type.
+func set(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		return v.Addr().Interface().(*Duration).Set(s)
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	case reflect.Map:
+		pairs := map[string]string{}
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			kv := strings.SplitN(item, "=", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("expected key=value, got %q", item)
+			}
+			pairs[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+		v.Set(reflect.ValueOf(pairs))
+	default:
+		return fmt.Errorf("unsupported setting type %v", v.Type())
+	}
+	return nil
+}
+
+// flagValue records a flag value so it can be applied after the config file
+// and the environment.
+type flagValue struct {
+	value  string
+	set    bool
+	isBool bool
+}
+
+// IsBoolFlag lets boolean settings be given as -flag without a value.
+func (f *flagValue) IsBoolFlag() bool {
+	return f.isBool
+}
+
+func (f *flagValue) String() string {
+	return f.value
+}
+
+func (f *flagValue) Set(s string) error {
+	f.value, f.set = s, true
+	return nil
+}
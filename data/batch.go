@@ -0,0 +1,124 @@
+package data
+
+import (
+	"context"
+	"errors"
+
+	"github.com/form3/logging"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ErrNotAttempted is the error of the payments of an ordered batch following
+// the first one that failed.
+var ErrNotAttempted = errors.New("not attempted, an earlier payment of the ordered batch failed")
+
+// UnknownOutcomeError is the error of a payment of a batch that may or may not
+// have been inserted: the bulk write failed as a whole, e.g. the connection
+// was lost, rather than rejecting the payment.
+type UnknownOutcomeError struct {
+	Err error
+}
+
+func (e *UnknownOutcomeError) Error() string {
+	return "unknown outcome, the payment may have been created: " + e.Err.Error()
+}
+
+// IsUnknownOutcome reports if err is the error of a payment of a batch that
+// may have been inserted.
+func IsUnknownOutcome(err error) bool {
+	_, ok := err.(*UnknownOutcomeError)
+	return ok
+}
+
+// UnknownOutcomes returns n results of payments whose insertion failed with
+// err without telling which were inserted.
+func UnknownOutcomes(n int, err error) []BatchResult {
+	results := make([]BatchResult, n)
+	for i := range results {
+		results[i].Err = &UnknownOutcomeError{Err: err}
+	}
+	return results
+}
+
+// BatchResult is the outcome of inserting one payment of a batch.
+type BatchResult struct {
+	Payment *Payment
+	Err     error
+}
+
+// CreatePayments inserts payments with a single bulk write. In ordered mode
+// the insertion stops at the first failure, otherwise every payment is
+// attempted. The results are in the order of payments. When the bulk write
+// fails without telling the outcome of some payments, they have an
+// UnknownOutcomeError; the error is only returned by other implementations.
+func (p *PaymentDataBase) CreatePayments(ctx context.Context, payments []Payment, ordered bool) ([]BatchResult, error) {
+	logging.FromContext(ctx).Debug("DataBase Create Payments", logging.Fields{"count": len(payments), "ordered": ordered})
+	results := make([]BatchResult, len(payments))
+	docs := make([]interface{}, len(payments))
+	for i := range payments {
+		payments[i].MongoID = bson.NewObjectId()
+		docs[i] = payments[i]
+		results[i].Payment = &payments[i]
+	}
+	if len(payments) == 0 {
+		return results, nil
+	}
+
+	err := p.write(func(conn *mgo.Session) error {
+		bulk := conn.DB(p.db).C(p.collection).Bulk()
+		if !ordered {
+			bulk.Unordered()
+		}
+		bulk.Insert(docs...)
+		_, err := bulk.Run()
+		return err
+	})
+	if err == nil {
+		return results, nil
+	}
+	bulkErr, ok := err.(*mgo.BulkError)
+	if !ok {
+		return UnknownOutcomes(len(payments), err), nil
+	}
+	return batchResults(results, bulkErr.Cases(), ordered), nil
+}
+
+// batchResults sets the errors of the failed payments of a bulk insert. The
+// payments rejected by mongo failed. A failure of the whole write, reported
+// for every payment or without index, leaves the outcome of the payments not
+// rejected unknown.
+func batchResults(results []BatchResult, cases []mgo.BulkErrorCase, ordered bool) []BatchResult {
+	first := len(results)
+	var unknown error
+	for _, c := range cases {
+		if c.Index < 0 || c.Index >= len(results) {
+			unknown = c.Err
+			continue
+		}
+		if !writeError(c.Err) {
+			results[c.Index] = BatchResult{Err: &UnknownOutcomeError{Err: c.Err}}
+			continue
+		}
+		results[c.Index] = BatchResult{Err: c.Err}
+		if c.Index < first {
+			first = c.Index
+		}
+	}
+	for i := range results {
+		switch {
+		case ordered && i > first:
+			results[i] = BatchResult{Err: ErrNotAttempted}
+		case unknown != nil && results[i].Err == nil:
+			results[i] = BatchResult{Err: &UnknownOutcomeError{Err: unknown}}
+		}
+	}
+	return results
+}
+
+// writeError reports if err is the rejection of a single document of a bulk
+// write, rather than a failure of the whole write.
+func writeError(err error) bool {
+	_, ok := err.(*mgo.QueryError)
+	return ok || mgo.IsDup(err)
+}
@@ -0,0 +1,56 @@
+package data
+
+import (
+	"errors"
+	"testing"
+
+	"gopkg.in/mgo.v2"
+)
+
+var dup = &mgo.QueryError{Code: 11000, Message: "E11000 duplicate key error"}
+
+func TestBatchResultsOrdered(t *testing.T) {
+	results := batchResults(make([]BatchResult, 4), []mgo.BulkErrorCase{{Index: 1, Err: dup}}, true)
+	expected := []error{nil, dup, ErrNotAttempted, ErrNotAttempted}
+	for i, e := range expected {
+		if results[i].Err != e {
+			t.Errorf("payment %d: expected %v got %v", i, e, results[i].Err)
+		}
+	}
+}
+
+func TestBatchResultsUnordered(t *testing.T) {
+	results := batchResults(make([]BatchResult, 4), []mgo.BulkErrorCase{{Index: 0, Err: dup}, {Index: 2, Err: dup}}, false)
+	expected := []error{dup, nil, dup, nil}
+	for i, e := range expected {
+		if results[i].Err != e {
+			t.Errorf("payment %d: expected %v got %v", i, e, results[i].Err)
+		}
+	}
+}
+
+func TestBatchResultsUnknownIndex(t *testing.T) {
+	unknown := errors.New("write concern error")
+	for _, ordered := range []bool{true, false} {
+		results := batchResults(make([]BatchResult, 3), []mgo.BulkErrorCase{{Index: 1, Err: dup}, {Index: -1, Err: unknown}}, ordered)
+		if results[1].Err != dup || !IsUnknownOutcome(results[0].Err) {
+			t.Errorf("ordered %v: unexpected results %+v", ordered, results)
+		}
+		if last := results[2].Err; ordered && last != ErrNotAttempted || !ordered && !IsUnknownOutcome(last) {
+			t.Errorf("ordered %v: unexpected result %v", ordered, last)
+		}
+	}
+}
+
+func TestBatchResultsConnectionLost(t *testing.T) {
+	// a failure of the whole write is reported for every payment
+	lost := errors.New("EOF")
+	cases := []mgo.BulkErrorCase{{Index: 0, Err: lost}, {Index: 1, Err: lost}, {Index: 2, Err: lost}}
+	for _, ordered := range []bool{true, false} {
+		for i, result := range batchResults(make([]BatchResult, 3), cases, ordered) {
+			if !IsUnknownOutcome(result.Err) || result.Err.Error() != "unknown outcome, the payment may have been created: EOF" {
+				t.Errorf("ordered %v payment %d: expected an unknown outcome got %v", ordered, i, result.Err)
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package data
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var paymentType = reflect.TypeOf(Payment{})
+
+// PaymentFields returns the dotted JSON path of every field of a payment that
+// is not itself a struct, e.g. "attributes.beneficiary_party.account_number",
+// in declaration order.
+func PaymentFields() []string {
+	return leafFields(paymentType, "")
+}
+
+func leafFields(t reflect.Type, prefix string) []string {
+	var paths []string
+	for i := 0; i < t.NumField(); i++ {
+		path := prefix + jsonName(t.Field(i))
+		if ft := t.Field(i).Type; ft.Kind() == reflect.Struct {
+			paths = append(paths, leafFields(ft, path+".")...)
+		} else {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+func jsonName(f reflect.StructField) string {
+	return strings.Split(f.Tag.Get("json"), ",")[0]
+}
+
+// PaymentField returns the field of v, a Payment value, at the dotted JSON
+// path. The field is settable when v is.
+func PaymentField(v reflect.Value, path string) (reflect.Value, error) {
+	for _, name := range strings.Split(path, ".") {
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}, fmt.Errorf("%v is not a field", path)
+		}
+		found := false
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if jsonName(t.Field(i)) == name {
+				v, found = v.Field(i), true
+				break
+			}
+		}
+		if !found {
+			return reflect.Value{}, fmt.Errorf("unknown field %v", path)
+		}
+	}
+	return v, nil
+}
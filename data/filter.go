@@ -0,0 +1,140 @@
+package data
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// PaymentFilter selects the payments listed or exported. Empty fields match
+// every payment.
+type PaymentFilter struct {
+	OrganisationID    string
+	Currency          string
+	PaymentScheme     string
+	EndToEndReference string
+	// ProcessingDateFrom and ProcessingDateTo bound the processing date,
+	// both included, as YYYY-MM-DD.
+	ProcessingDateFrom string
+	ProcessingDateTo   string
+	// Reconciled selects the reconciled payments when true and the others
+	// when false
+	Reconciled *bool
+	// After and Limit select a page of the payments, in order of creation:
+	// at most Limit payments created after the payment After
+	After bson.ObjectId
+	Limit int
+}
+
+// ParsePaymentFilter reads a filter from the query parameters organisation_id,
+// currency, payment_scheme, end_to_end_reference, processing_date_from,
+// processing_date_to, reconciled, after and limit.
+func ParsePaymentFilter(query url.Values) (PaymentFilter, error) {
+	f := PaymentFilter{
+		OrganisationID:     strings.TrimSpace(query.Get("organisation_id")),
+		Currency:           strings.ToUpper(strings.TrimSpace(query.Get("currency"))),
+		PaymentScheme:      strings.TrimSpace(query.Get("payment_scheme")),
+		EndToEndReference:  strings.TrimSpace(query.Get("end_to_end_reference")),
+		ProcessingDateFrom: strings.TrimSpace(query.Get("processing_date_from")),
+		ProcessingDateTo:   strings.TrimSpace(query.Get("processing_date_to")),
+	}
+	for name, date := range map[string]string{"processing_date_from": f.ProcessingDateFrom, "processing_date_to": f.ProcessingDateTo} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return f, fmt.Errorf("%v must be a date as YYYY-MM-DD, got %q", name, date)
+		}
+	}
+	if reconciled := strings.TrimSpace(query.Get("reconciled")); reconciled != "" {
+		b, err := strconv.ParseBool(reconciled)
+		if err != nil {
+			return f, fmt.Errorf("reconciled must be true or false, got %q", reconciled)
+		}
+		f.Reconciled = &b
+	}
+	if after := strings.TrimSpace(query.Get("after")); after != "" {
+		if !bson.IsObjectIdHex(after) {
+			return f, fmt.Errorf("after must be a payment id, got %q", after)
+		}
+		f.After = bson.ObjectIdHex(after)
+	}
+	if limit := strings.TrimSpace(query.Get("limit")); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return f, fmt.Errorf("limit must be a positive number, got %q", limit)
+		}
+		f.Limit = n
+	}
+	return f, nil
+}
+
+// Values returns the query parameters read by ParsePaymentFilter.
+func (f PaymentFilter) Values() url.Values {
+	query := url.Values{}
+	for name, value := range map[string]string{
+		"organisation_id":      f.OrganisationID,
+		"currency":             f.Currency,
+		"payment_scheme":       f.PaymentScheme,
+		"end_to_end_reference": f.EndToEndReference,
+		"processing_date_from": f.ProcessingDateFrom,
+		"processing_date_to":   f.ProcessingDateTo,
+	} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	if f.Reconciled != nil {
+		query.Set("reconciled", strconv.FormatBool(*f.Reconciled))
+	}
+	if f.After != "" {
+		query.Set("after", f.After.Hex())
+	}
+	if f.Limit > 0 {
+		query.Set("limit", strconv.Itoa(f.Limit))
+	}
+	return query
+}
+
+// Query returns the mongo query of the filter.
+func (f PaymentFilter) Query() bson.M {
+	query := bson.M{}
+	if f.OrganisationID != "" {
+		query["organisation_id"] = f.OrganisationID
+	}
+	if f.Currency != "" {
+		query["attributes.currency"] = f.Currency
+	}
+	if f.PaymentScheme != "" {
+		query["attributes.payment_scheme"] = f.PaymentScheme
+	}
+	if f.EndToEndReference != "" {
+		query["attributes.end_to_end_reference"] = f.EndToEndReference
+	}
+	if f.ProcessingDateFrom != "" || f.ProcessingDateTo != "" {
+		// ISO dates sort as strings
+		date := bson.M{}
+		if f.ProcessingDateFrom != "" {
+			date["$gte"] = f.ProcessingDateFrom
+		}
+		if f.ProcessingDateTo != "" {
+			date["$lte"] = f.ProcessingDateTo
+		}
+		query["attributes.processing_date"] = date
+	}
+	if f.After != "" {
+		query["_id"] = bson.M{"$gt": f.After}
+	}
+	if f.Reconciled != nil {
+		if *f.Reconciled {
+			query["status"] = PaymentReconciled
+		} else {
+			query["status"] = bson.M{"$ne": PaymentReconciled}
+		}
+	}
+	return query
+}
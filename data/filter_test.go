@@ -0,0 +1,99 @@
+package data
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestParsePaymentFilter(t *testing.T) {
+	query, _ := url.ParseQuery("currency=gbp&payment_scheme=FPS&processing_date_from=2017-01-01&processing_date_to=2017-01-31")
+
+	f, err := ParsePaymentFilter(query)
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	expected := bson.M{
+		"attributes.currency":        "GBP",
+		"attributes.payment_scheme":  "FPS",
+		"attributes.processing_date": bson.M{"$gte": "2017-01-01", "$lte": "2017-01-31"},
+	}
+	if !reflect.DeepEqual(expected, f.Query()) {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, f.Query())
+	}
+}
+
+func TestParsePaymentFilterEmpty(t *testing.T) {
+	f, err := ParsePaymentFilter(url.Values{})
+	if err != nil || len(f.Query()) != 0 {
+		t.Errorf("expected an empty query, got %v %v", f.Query(), err)
+	}
+}
+
+func TestParsePaymentFilterInvalidDate(t *testing.T) {
+	query, _ := url.ParseQuery("processing_date_to=31/01/2017")
+	if _, err := ParsePaymentFilter(query); err == nil {
+		t.Errorf("expected an error for an invalid date")
+	}
+}
+
+func TestParsePaymentFilterReconciled(t *testing.T) {
+	for reconciled, expected := range map[string]interface{}{"true": PaymentReconciled, "false": bson.M{"$ne": PaymentReconciled}} {
+		f, err := ParsePaymentFilter(url.Values{"reconciled": {reconciled}})
+		if err != nil {
+			t.Fatalf("Didn't expect error %v", err)
+		}
+		if obtained := f.Query()["status"]; !reflect.DeepEqual(expected, obtained) {
+			t.Errorf("\n...expected = %v\n...obtained = %v", expected, obtained)
+		}
+	}
+	if _, err := ParsePaymentFilter(url.Values{"reconciled": {"maybe"}}); err == nil {
+		t.Errorf("expected an error for an invalid reconciled")
+	}
+}
+
+func TestParsePaymentFilterPage(t *testing.T) {
+	f, err := ParsePaymentFilter(url.Values{"after": {"5b290f5b802b0f1479000002"}, "limit": {"50"}})
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	expected := bson.M{"_id": bson.M{"$gt": bson.ObjectIdHex("5b290f5b802b0f1479000002")}}
+	if !reflect.DeepEqual(expected, f.Query()) || f.Limit != 50 {
+		t.Errorf("\n...expected = %v limit 50\n...obtained = %v limit %v", expected, f.Query(), f.Limit)
+	}
+	for _, query := range []url.Values{{"after": {"42"}}, {"limit": {"0"}}, {"limit": {"all"}}} {
+		if _, err := ParsePaymentFilter(query); err == nil {
+			t.Errorf("expected an error for %v", query)
+		}
+	}
+}
+
+func TestPaymentFilterValues(t *testing.T) {
+	query := "after=5b290f5b802b0f1479000002&currency=GBP&end_to_end_reference=Wil+piano+Jan&limit=10&organisation_id=743d5b63" +
+		"&payment_scheme=FPS&processing_date_from=2017-01-01&processing_date_to=2017-01-31&reconciled=false"
+	values, _ := url.ParseQuery(query)
+	f, err := ParsePaymentFilter(values)
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	if obtained := f.Values().Encode(); query != obtained {
+		t.Errorf("\n...expected = %v\n...obtained = %v", query, obtained)
+	}
+}
+
+func TestPaymentFields(t *testing.T) {
+	fields := PaymentFields()
+	if fields[0] != "_id" || fields[1] != "id" {
+		t.Errorf("unexpected fields %v", fields)
+	}
+	for _, path := range fields {
+		if _, err := PaymentField(reflect.ValueOf(Payment{}), path); err != nil {
+			t.Errorf("Didn't expect error %v", err)
+		}
+	}
+	if _, err := PaymentField(reflect.ValueOf(Payment{}), "attributes.amount.value"); err == nil {
+		t.Errorf("expected an error for a path below a leaf")
+	}
+}
@@ -0,0 +1,105 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/form3/logging"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// IDEMPOTENCY_COLLECTION is the collection storing the responses of the
+// requests sent with an idempotency key
+const IDEMPOTENCY_COLLECTION = "idempotency_keys"
+
+// IdempotencyKeyTTL is how long the response of a request is replayed to the
+// requests sent again with its key.
+const IdempotencyKeyTTL = 24 * time.Hour
+
+// IdempotencyReservationTTL is how long a key stays reserved by a request in
+// progress. A reservation left by a request that never completed, e.g. when
+// the instance stopped, can then be taken over by a request sent again.
+const IdempotencyReservationTTL = 5 * time.Minute
+
+// IdempotentRequest is a request sent with an idempotency key and, once
+// completed, its response.
+type IdempotentRequest struct {
+	Key string `bson:"_id"`
+	// Hash identifies the method, path and body of the request
+	Hash        string    `bson:"hash"`
+	Completed   bool      `bson:"completed"`
+	Status      int       `bson:"status,omitempty"`
+	ContentType string    `bson:"content_type,omitempty"`
+	Body        []byte    `bson:"body,omitempty"`
+	ExpiresAt   time.Time `bson:"expires_at"`
+}
+
+type IdempotencyProvider interface {
+	// ReserveKey records a request as in progress. When its key is reserved
+	// already it returns the request stored with the key instead, unless the
+	// reservation of a request in progress expired.
+	ReserveKey(ctx context.Context, request IdempotentRequest) (*IdempotentRequest, error)
+	// CompleteKey stores the response of the request of a reserved key.
+	CompleteKey(ctx context.Context, request IdempotentRequest) error
+	// ReleaseKey forgets a key whose request failed, so it can be retried.
+	ReleaseKey(ctx context.Context, key string) error
+}
+
+// IdempotencyIndexes are the indexes of the idempotency keys collection,
+// expiring the keys.
+func IdempotencyIndexes() []IndexSpec {
+	return []IndexSpec{
+		{Collection: IDEMPOTENCY_COLLECTION, Key: []string{"expires_at"}, ExpireAfter: time.Second},
+	}
+}
+
+type IdempotencyDataBase struct {
+	*MongoDBConn
+}
+
+func (d *IdempotencyDataBase) ReserveKey(ctx context.Context, request IdempotentRequest) (*IdempotentRequest, error) {
+	logging.FromContext(ctx).Debug("DataBase Reserve Idempotency Key")
+	now := time.Now().UTC()
+	request.Completed = false
+	request.ExpiresAt = now.Add(IdempotencyReservationTTL)
+	err := d.write(func(conn *mgo.Session) error {
+		return conn.DB(d.db).C(IDEMPOTENCY_COLLECTION).Insert(request)
+	})
+	if !mgo.IsDup(err) {
+		return nil, err
+	}
+	var existing IdempotentRequest
+	err = d.read(func(conn *mgo.Session) error {
+		return conn.DB(d.db).C(IDEMPOTENCY_COLLECTION).FindId(request.Key).One(&existing)
+	})
+	if err != nil || existing.Completed || existing.Hash != request.Hash || !existing.ExpiresAt.Before(now) {
+		return &existing, err
+	}
+	// the request reserving the key never completed, unless another request
+	// took the reservation over meanwhile
+	err = d.write(func(conn *mgo.Session) error {
+		return conn.DB(d.db).C(IDEMPOTENCY_COLLECTION).Update(
+			bson.M{"_id": request.Key, "completed": false, "expires_at": existing.ExpiresAt}, request)
+	})
+	if err == mgo.ErrNotFound {
+		return &existing, nil
+	}
+	return nil, err
+}
+
+func (d *IdempotencyDataBase) CompleteKey(ctx context.Context, request IdempotentRequest) error {
+	logging.FromContext(ctx).Debug("DataBase Complete Idempotency Key", logging.Fields{"status": request.Status})
+	request.Completed = true
+	request.ExpiresAt = time.Now().UTC().Add(IdempotencyKeyTTL)
+	return d.write(func(conn *mgo.Session) error {
+		return conn.DB(d.db).C(IDEMPOTENCY_COLLECTION).UpdateId(request.Key, request)
+	})
+}
+
+func (d *IdempotencyDataBase) ReleaseKey(ctx context.Context, key string) error {
+	logging.FromContext(ctx).Debug("DataBase Release Idempotency Key")
+	return d.write(func(conn *mgo.Session) error {
+		return conn.DB(d.db).C(IDEMPOTENCY_COLLECTION).RemoveId(key)
+	})
+}
@@ -0,0 +1,237 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/form3/logging"
+	"gopkg.in/mgo.v2"
+)
+
+// IndexSpec declares an index the service expects on a collection.
+type IndexSpec struct {
+	Collection  string
+	Key         []string // fields, prefixed with - for descending order
+	Unique      bool
+	Sparse      bool
+	ExpireAfter time.Duration // TTL, only for collections of expiring documents
+	// Required indexes must exist for the service to be ready, typically the
+	// unique indexes enforcing business keys.
+	Required bool
+}
+
+// Name returns the name mongo gives to an index on the spec key.
+func (s IndexSpec) Name() string {
+	return indexName(s.Key)
+}
+
+func (s IndexSpec) index() mgo.Index {
+	return mgo.Index{
+		Key:         s.Key,
+		Name:        s.Name(),
+		Unique:      s.Unique,
+		Sparse:      s.Sparse,
+		ExpireAfter: s.ExpireAfter,
+		Background:  true,
+	}
+}
+
+func (s IndexSpec) String() string {
+	options := []string{}
+	if s.Unique {
+		options = append(options, "unique")
+	}
+	if s.Sparse {
+		options = append(options, "sparse")
+	}
+	if s.ExpireAfter > 0 {
+		options = append(options, "ttl "+s.ExpireAfter.String())
+	}
+	return fmt.Sprintf("%v.%v %v", s.Collection, s.Name(), options)
+}
+
+func indexName(key []string) string {
+	parts := make([]string, 0, len(key))
+	for _, field := range key {
+		if strings.HasPrefix(field, "-") {
+			parts = append(parts, field[1:]+"_-1")
+		} else {
+			parts = append(parts, strings.TrimPrefix(field, "+")+"_1")
+		}
+	}
+	return strings.Join(parts, "_")
+}
+
+// PaymentIndexes are the indexes of the payments collection. The collection
+// name is filled in with the configured one.
+func PaymentIndexes(collection string) []IndexSpec {
+	return []IndexSpec{
+		{Collection: collection, Key: []string{"id"}, Unique: true, Sparse: true, Required: true},
+		{Collection: collection, Key: []string{"organisation_id", "attributes.processing_date"}},
+		{Collection: collection, Key: []string{"attributes.end_to_end_reference"}, Sparse: true},
+		{Collection: collection, Key: []string{"status"}, Sparse: true},
+	}
+}
+
+// IndexConflict is an existing index whose name matches a spec but whose
+// definition does not.
+type IndexConflict struct {
+	Spec     string `json:"spec"`
+	Existing string `json:"existing"`
+}
+
+// IndexReport describes the differences between the declared and existing
+// indexes of a database, and what reconciliation did about them.
+type IndexReport struct {
+	Missing     []string          `json:"missing,omitempty"`
+	Extra       []string          `json:"extra,omitempty"`
+	Conflicting []IndexConflict   `json:"conflicting,omitempty"`
+	Created     []string          `json:"created,omitempty"`
+	Failed      map[string]string `json:"failed,omitempty"`
+	// RequiredMissing lists required indexes that still do not exist.
+	RequiredMissing []string `json:"required_missing,omitempty"`
+}
+
+// Err returns an error if a required index is missing.
+func (r IndexReport) Err() error {
+	if len(r.RequiredMissing) == 0 {
+		return nil
+	}
+	return errors.New("missing required indexes: " + strings.Join(r.RequiredMissing, ", "))
+}
+
+func describeIndex(collection string, index mgo.Index) string {
+	return IndexSpec{
+		Collection:  collection,
+		Key:         index.Key,
+		Unique:      index.Unique,
+		Sparse:      index.Sparse,
+		ExpireAfter: index.ExpireAfter,
+	}.String()
+}
+
+// diffIndexes compares the specs of one collection to its existing indexes.
+func diffIndexes(collection string, specs []IndexSpec, existing []mgo.Index) (report IndexReport, missing []IndexSpec) {
+	byName := map[string]mgo.Index{}
+	for _, index := range existing {
+		if index.Name != "_id_" {
+			byName[index.Name] = index
+		}
+	}
+	declared := map[string]bool{}
+	for _, spec := range specs {
+		declared[spec.Name()] = true
+		index, ok := byName[spec.Name()]
+		if !ok {
+			report.Missing = append(report.Missing, spec.String())
+			missing = append(missing, spec)
+			if spec.Required {
+				report.RequiredMissing = append(report.RequiredMissing, spec.String())
+			}
+			continue
+		}
+		if index.Unique != spec.Unique || index.Sparse != spec.Sparse || index.ExpireAfter != spec.ExpireAfter {
+			report.Conflicting = append(report.Conflicting, IndexConflict{Spec: spec.String(), Existing: describeIndex(collection, index)})
+			if spec.Required {
+				report.RequiredMissing = append(report.RequiredMissing, spec.String())
+			}
+		}
+	}
+	for name, index := range byName {
+		if !declared[name] {
+			report.Extra = append(report.Extra, describeIndex(collection, index))
+		}
+	}
+	return report, missing
+}
+
+// ReconcileIndexes compares the declared indexes with the ones in db. When
+// apply is true the missing indexes are built in the background; existing
+// indexes are never dropped or changed and documents are never removed, so
+// extra and conflicting indexes are only reported.
+func (m *MongoDBConn) ReconcileIndexes(db string, specs []IndexSpec, apply bool) (IndexReport, error) {
+	var report IndexReport
+	for _, collection := range collections(specs) {
+		var existing []mgo.Index
+		err := m.read(func(conn *mgo.Session) (err error) {
+			existing, err = conn.DB(db).C(collection).Indexes()
+			if qe, ok := err.(*mgo.QueryError); ok && qe.Code == 26 {
+				// the collection does not exist yet
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			return report, err
+		}
+
+		r, missing := diffIndexes(collection, specsOf(specs, collection), existing)
+		report.Missing = append(report.Missing, r.Missing...)
+		report.Extra = append(report.Extra, r.Extra...)
+		report.Conflicting = append(report.Conflicting, r.Conflicting...)
+
+		if !apply {
+			report.RequiredMissing = append(report.RequiredMissing, r.RequiredMissing...)
+			continue
+		}
+		stillMissing := map[string]bool{}
+		for _, name := range r.RequiredMissing {
+			stillMissing[name] = true
+		}
+		for _, spec := range missing {
+			err := m.write(func(conn *mgo.Session) error {
+				return conn.DB(db).C(collection).EnsureIndex(spec.index())
+			})
+			if err != nil {
+				if report.Failed == nil {
+					report.Failed = map[string]string{}
+				}
+				report.Failed[spec.String()] = err.Error()
+				logger.Error("Could not build index", logging.Fields{"index": spec.String(), "error": err})
+				continue
+			}
+			report.Created = append(report.Created, spec.String())
+			delete(stillMissing, spec.String())
+		}
+		for _, name := range r.RequiredMissing {
+			if stillMissing[name] {
+				report.RequiredMissing = append(report.RequiredMissing, name)
+			}
+		}
+	}
+	return report, nil
+}
+
+// CheckIndexes returns an error if a required index is missing or conflicts
+// with its declaration.
+func (m *MongoDBConn) CheckIndexes(db string, specs []IndexSpec) error {
+	report, err := m.ReconcileIndexes(db, specs, false)
+	if err != nil {
+		return err
+	}
+	return report.Err()
+}
+
+func collections(specs []IndexSpec) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, spec := range specs {
+		if !seen[spec.Collection] {
+			seen[spec.Collection] = true
+			names = append(names, spec.Collection)
+		}
+	}
+	return names
+}
+
+func specsOf(specs []IndexSpec, collection string) []IndexSpec {
+	var result []IndexSpec
+	for _, spec := range specs {
+		if spec.Collection == collection {
+			result = append(result, spec)
+		}
+	}
+	return result
+}
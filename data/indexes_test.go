@@ -0,0 +1,65 @@
+package data
+
+import (
+	"testing"
+
+	"gopkg.in/mgo.v2"
+)
+
+func TestIndexName(t *testing.T) {
+	expected := map[string][]string{
+		"id_1": {"id"},
+		"organisation_id_1_attributes.processing_date_1": {"organisation_id", "attributes.processing_date"},
+		"status_-1": {"-status"},
+	}
+	for name, key := range expected {
+		if n := indexName(key); n != name {
+			t.Errorf("expected %v got %v", name, n)
+		}
+	}
+}
+
+func TestDiffIndexes(t *testing.T) {
+	specs := []IndexSpec{
+		{Collection: "payments", Key: []string{"id"}, Unique: true, Sparse: true, Required: true},
+		{Collection: "payments", Key: []string{"organisation_id", "attributes.processing_date"}},
+		{Collection: "payments", Key: []string{"status"}, Sparse: true},
+	}
+	existing := []mgo.Index{
+		{Name: "_id_", Key: []string{"_id"}},
+		{Name: "id_1", Key: []string{"id"}, Unique: true, Sparse: true},
+		{Name: "status_1", Key: []string{"status"}},
+		{Name: "version_1", Key: []string{"version"}},
+	}
+
+	report, missing := diffIndexes("payments", specs, existing)
+	if len(missing) != 1 || missing[0].Name() != "organisation_id_1_attributes.processing_date_1" {
+		t.Errorf("unexpected missing indexes %v", missing)
+	}
+	if len(report.Conflicting) != 1 || report.Conflicting[0].Existing != "payments.status_1 []" {
+		t.Errorf("unexpected conflicts %v", report.Conflicting)
+	}
+	if len(report.Extra) != 1 || report.Extra[0] != "payments.version_1 []" {
+		t.Errorf("unexpected extra indexes %v", report.Extra)
+	}
+	if err := report.Err(); err != nil {
+		t.Errorf("Didn't expect error %v", err)
+	}
+}
+
+func TestDiffIndexesRequired(t *testing.T) {
+	specs := PaymentIndexes("payments")
+
+	report, _ := diffIndexes("payments", specs, []mgo.Index{{Name: "id_1", Key: []string{"id"}}})
+	if len(report.Conflicting) != 1 {
+		t.Errorf("a non unique id index should conflict, got %v", report)
+	}
+	if report.Err() == nil {
+		t.Errorf("expected an error for the required unique index")
+	}
+
+	report, _ = diffIndexes("payments", specs, nil)
+	if len(report.Missing) != len(specs) || len(report.RequiredMissing) != 1 {
+		t.Errorf("unexpected report %v", report)
+	}
+}
@@ -0,0 +1,125 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/form3/logging"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// JOB_COLLECTION is the collection storing the background jobs
+const JOB_COLLECTION = "jobs"
+
+// JobRetention is how long finished and abandoned jobs are kept.
+const JobRetention = 7 * 24 * time.Hour
+
+// MaxJobErrors is the number of row errors kept in a job, further errors are
+// only counted.
+const MaxJobErrors = 1000
+
+// Job statuses
+const (
+	JobQueued      = "queued"
+	JobRunning     = "running"
+	JobCompleted   = "completed"
+	JobFailed      = "failed"
+	JobInterrupted = "interrupted"
+)
+
+// Job tracks the processing of an uploaded payments file.
+type Job struct {
+	ID             bson.ObjectId `json:"id" bson:"_id"`
+	Type           string        `json:"type" bson:"type"`
+	Status         string        `json:"status" bson:"status"`
+	OrganisationID string        `json:"organisation_id,omitempty" bson:"organisation_id,omitempty"`
+	FileName       string        `json:"file_name,omitempty" bson:"file_name,omitempty"`
+	TotalRows      int           `json:"total_rows" bson:"total_rows"`
+	ProcessedRows  int           `json:"processed_rows" bson:"processed_rows"`
+	CreatedRows    int           `json:"created_rows" bson:"created_rows"`
+	FailedRows     int           `json:"failed_rows" bson:"failed_rows"`
+	Error          string        `json:"error,omitempty" bson:"error,omitempty"`
+	Errors         []RowError    `json:"-" bson:"errors,omitempty"`
+	CreatedAt      time.Time     `json:"created_at" bson:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at" bson:"updated_at"`
+	FinishedAt     *time.Time    `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+	ExpiresAt      time.Time     `json:"-" bson:"expires_at"`
+}
+
+// RowError is the reason a row of a file was not imported.
+type RowError struct {
+	Line   int    `json:"line" bson:"line"`
+	Column string `json:"column,omitempty" bson:"column,omitempty"`
+	Error  string `json:"error" bson:"error"`
+}
+
+// AddError records the error of a row, keeping at most MaxJobErrors.
+func (j *Job) AddError(e RowError) {
+	j.FailedRows++
+	if len(j.Errors) < MaxJobErrors {
+		j.Errors = append(j.Errors, e)
+	}
+}
+
+// Finished reports if the job will not change anymore.
+func (j *Job) Finished() bool {
+	return j.Status != JobQueued && j.Status != JobRunning
+}
+
+// Progress returns the share of the rows processed, in percent.
+func (j *Job) Progress() float64 {
+	if j.Status == JobCompleted {
+		return 100
+	}
+	if j.TotalRows == 0 {
+		return 0
+	}
+	return float64(j.ProcessedRows) * 100 / float64(j.TotalRows)
+}
+
+type JobProvider interface {
+	CreateJob(ctx context.Context, job *Job) error
+	UpdateJob(ctx context.Context, job *Job) error
+	GetJob(ctx context.Context, id bson.ObjectId) (*Job, error)
+}
+
+// JobIndexes are the indexes of the jobs collection, expiring old jobs.
+func JobIndexes() []IndexSpec {
+	return []IndexSpec{
+		{Collection: JOB_COLLECTION, Key: []string{"expires_at"}, ExpireAfter: time.Second},
+	}
+}
+
+type JobDataBase struct {
+	*MongoDBConn
+}
+
+// CreateJob stores a new job, setting its id and timestamps.
+func (j *JobDataBase) CreateJob(ctx context.Context, job *Job) error {
+	logging.FromContext(ctx).Debug("DataBase Create Job")
+	now := time.Now().UTC()
+	job.ID = bson.NewObjectId()
+	job.CreatedAt, job.UpdatedAt, job.ExpiresAt = now, now, now.Add(JobRetention)
+	return j.write(func(conn *mgo.Session) error {
+		return conn.DB(j.db).C(JOB_COLLECTION).Insert(job)
+	})
+}
+
+// UpdateJob replaces the stored job with job.
+func (j *JobDataBase) UpdateJob(ctx context.Context, job *Job) error {
+	job.UpdatedAt = time.Now().UTC()
+	job.ExpiresAt = job.UpdatedAt.Add(JobRetention)
+	return j.write(func(conn *mgo.Session) error {
+		return conn.DB(j.db).C(JOB_COLLECTION).UpdateId(job.ID, job)
+	})
+}
+
+func (j *JobDataBase) GetJob(ctx context.Context, id bson.ObjectId) (job *Job, err error) {
+	logging.FromContext(ctx).Debug("DataBase Get Job", logging.Fields{"job": id.Hex()})
+	err = j.read(func(conn *mgo.Session) error {
+		c := conn.DB(j.db).C(JOB_COLLECTION)
+		return find(ctx, c, bson.M{"_id": id}).One(&job)
+	})
+	return
+}
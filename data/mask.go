@@ -0,0 +1,35 @@
+package data
+
+import (
+	"github.com/form3/logging"
+)
+
+// Masked returns a copy of the account safe to log, keeping only the last
+// digits of the account number and hiding names and address.
+func (a Account) Masked() interface{} {
+	a.AccountNumber = logging.Mask(a.AccountNumber)
+	a.AccountName = logging.Redact(a.AccountName)
+	a.Name = logging.Redact(a.Name)
+	a.Address = logging.Redact(a.Address)
+	return a
+}
+
+// Masked returns a copy of the sponsor safe to log.
+func (s Sponsor) Masked() interface{} {
+	s.AccountNumber = logging.Mask(s.AccountNumber)
+	return s
+}
+
+// Masked returns a copy of the attributes with every party masked.
+func (a Attributes) Masked() interface{} {
+	a.BeneficiaryParty = a.BeneficiaryParty.Masked().(Account)
+	a.DebtorParty = a.DebtorParty.Masked().(Account)
+	a.SponsorParty = a.SponsorParty.Masked().(Sponsor)
+	return a
+}
+
+// Masked returns a copy of the payment safe to log.
+func (p Payment) Masked() interface{} {
+	p.Attributes = p.Attributes.Masked().(Attributes)
+	return p
+}
@@ -0,0 +1,44 @@
+package data
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPaymentMasked(t *testing.T) {
+	payment := Payment{
+		ID: "4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43",
+		Attributes: Attributes{
+			Amount: 100.21,
+			BeneficiaryParty: Account{
+				AccountName:   "W Owens",
+				AccountNumber: "31926819",
+				Name:          "Wilfred Jeremiah Owens",
+				Address:       "1 The Beneficiary Localtown SE2",
+				BankID:        "403000",
+			},
+			DebtorParty: Account{
+				AccountName:   "EJ Brown Black",
+				AccountNumber: "GB29XABC10161234567801",
+				Name:          "Emelia Jane Brown",
+			},
+			SponsorParty: Sponsor{AccountNumber: "56781234", BankID: "123123"},
+		},
+	}
+
+	masked, _ := json.Marshal(payment.Masked())
+	for _, secret := range []string{"31926819", "GB29XABC10161234567801", "56781234", "Owens", "Emelia", "Beneficiary Localtown"} {
+		if strings.Contains(string(masked), secret) {
+			t.Errorf("masked payment leaks %q: %s", secret, masked)
+		}
+	}
+	for _, kept := range []string{"****6819", "7801", "403000", "4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43"} {
+		if !strings.Contains(string(masked), kept) {
+			t.Errorf("masked payment should keep %q: %s", kept, masked)
+		}
+	}
+	if payment.Attributes.BeneficiaryParty.AccountNumber != "31926819" {
+		t.Errorf("Masked must not modify the original payment")
+	}
+}
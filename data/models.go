@@ -1,11 +1,15 @@
 package data
 
 import (
-	"log"
+	"context"
 
+	"github.com/form3/logging"
+	"github.com/form3/trace"
+	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
+// PAYMENT_COLLECTION is the default collection storing the payments
 const PAYMENT_COLLECTION = "payments"
 
 type Payment struct {
@@ -14,7 +18,9 @@ type Payment struct {
 	Type           string        `json:"type,omitempty" bson:"type,omitempty"`
 	Version        int           `json:"version" bson:"version"`
 	OrganisationID string        `json:"organisation_id,omitempty" bson:"organisation_id,omitempty"`
-	Attributes     Attributes    `json:"attributes,omitempty" bson:"attributes,omitempty"`
+	// Status is PaymentReconciled once a statement entry settles the payment
+	Status     string     `json:"status,omitempty" bson:"status,omitempty"`
+	Attributes Attributes `json:"attributes,omitempty" bson:"attributes,omitempty"`
 }
 
 type Attributes struct {
@@ -74,77 +80,83 @@ type Sponsor struct {
 }
 
 type PaymentProvider interface {
-	ListPayments() ([]Payment, error)
-	ListPaymentID(id bson.ObjectId) (*Payment, error)
-	CreatePayment(payment Payment) (*Payment, error)
-	RemovePayment(id bson.ObjectId) error
-	UpdatePayment(payment Payment) (*Payment, error)
+	StreamPayments(ctx context.Context, filter PaymentFilter) (PaymentIter, error)
+	ListPaymentID(ctx context.Context, id bson.ObjectId) (*Payment, error)
+	CreatePayment(ctx context.Context, payment Payment) (*Payment, error)
+	CreatePayments(ctx context.Context, payments []Payment, ordered bool) ([]BatchResult, error)
+	RemovePayment(ctx context.Context, id bson.ObjectId) error
+	UpdatePayment(ctx context.Context, payment Payment) (*Payment, error)
 }
 
+var logger = logging.Default()
+
 type PaymentDataBase struct {
 	*MongoDBConn
 }
 
-func (p *PaymentDataBase) ListPayments() (payments []Payment, err error) {
-	log.Printf("DataBase ListPayments  \n")
-	conn := p.GetConn()
-	defer conn.Close()
-	c := conn.DB(p.db).C(PAYMENT_COLLECTION)
-	err = c.Find(bson.M{}).All(&payments)
-	return
+// find tags the query with the request ID so it can be found in the mongo
+// profiler and logs.
+func find(ctx context.Context, c *mgo.Collection, query interface{}) *mgo.Query {
+	q := c.Find(query)
+	if id := trace.RequestID(ctx); id != "" {
+		q = q.Comment("request_id:" + id)
+	}
+	return q
 }
 
-func (p *PaymentDataBase) ListPaymentID(id bson.ObjectId) (payment *Payment, err error) {
-	log.Printf("DataBase ListPaymentID  \n")
-	conn := p.GetConn()
-	defer conn.Close()
-	c := conn.DB(p.db).C(PAYMENT_COLLECTION)
-	err = c.Find(bson.M{"_id": id}).One(&payment)
+func (p *PaymentDataBase) ListPaymentID(ctx context.Context, id bson.ObjectId) (payment *Payment, err error) {
+	logging.FromContext(ctx).Debug("DataBase ListPaymentID", logging.Fields{"payment": id.Hex()})
+	err = p.read(func(conn *mgo.Session) error {
+		c := conn.DB(p.db).C(p.collection)
+		return find(ctx, c, bson.M{"_id": id}).One(&payment)
+	})
 	return
 }
 
 // Create a payment
-func (p *PaymentDataBase) CreatePayment(payment Payment) (*Payment, error) {
-	log.Printf("DataBase Create Payment  \n")
-	conn := p.GetConn()
-	defer conn.Close()
-	var err error
-	c := conn.DB(p.db).C(PAYMENT_COLLECTION)
+func (p *PaymentDataBase) CreatePayment(ctx context.Context, payment Payment) (*Payment, error) {
+	logging.FromContext(ctx).Debug("DataBase Create Payment")
 	payment.MongoID = bson.NewObjectId()
-	err = c.Insert(payment)
+	err := p.write(func(conn *mgo.Session) error {
+		c := conn.DB(p.db).C(p.collection)
+		return c.Insert(payment)
+	})
 	return &payment, err
 }
 
 // Delete a payment
-func (p *PaymentDataBase) RemovePayment(id bson.ObjectId) error {
-	log.Printf("DataBase Remove Payment  \n")
-	conn := p.GetConn()
-	defer conn.Close()
-	c := conn.DB(p.db).C(PAYMENT_COLLECTION)
-	err := c.Remove(bson.M{"_id": id})
+func (p *PaymentDataBase) RemovePayment(ctx context.Context, id bson.ObjectId) error {
+	logging.FromContext(ctx).Debug("DataBase Remove Payment", logging.Fields{"payment": id.Hex()})
+	err := p.write(func(conn *mgo.Session) error {
+		c := conn.DB(p.db).C(p.collection)
+		return c.Remove(bson.M{"_id": id})
+	})
 	return err
 }
 
-func (p *PaymentDataBase) UpdatePayment(payment Payment) (*Payment, error) {
-	log.Printf("DataBase Update Payment  \n")
-	conn := p.GetConn()
-	defer conn.Close()
-	c := conn.DB(p.db).C(PAYMENT_COLLECTION)
+func (p *PaymentDataBase) UpdatePayment(ctx context.Context, payment Payment) (*Payment, error) {
+	logger := logging.FromContext(ctx)
+	logger.Debug("DataBase Update Payment", logging.Fields{"payment": payment.MongoID.Hex()})
 
 	// update existing object:
 	mongoID := payment.MongoID
-	err := c.Update(bson.M{"_id": mongoID}, payment)
-	log.Printf("Find return update error %+v \n", err)
+	err := p.write(func(conn *mgo.Session) error {
+		c := conn.DB(p.db).C(p.collection)
+		return c.Update(bson.M{"_id": mongoID}, payment)
+	})
 	if err != nil {
-		log.Println("Error could not update:", err.Error())
+		logger.Warn("Could not update payment", logging.Fields{"payment": mongoID.Hex(), "error": err})
 	} else {
 		updatedPayment := &Payment{}
-		err = c.Find(bson.M{"_id": mongoID}).One(updatedPayment)
+		err = p.read(func(conn *mgo.Session) error {
+			c := conn.DB(p.db).C(p.collection)
+			return find(ctx, c, bson.M{"_id": mongoID}).One(updatedPayment)
+		})
 		if err == nil {
-			log.Printf("Updated payment in models %+v \n", updatedPayment)
+			logger.Debug("Updated payment in models", logging.Fields{"payment": updatedPayment})
 			return updatedPayment, err
 		} else {
-			log.Printf("Find error %+v \n", err)
+			logger.Warn("Could not find updated payment", logging.Fields{"payment": mongoID.Hex(), "error": err})
 		}
 	}
 
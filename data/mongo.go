@@ -1,45 +1,143 @@
 package data
 
 import (
-	"log"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
 	"time"
 
+	"github.com/form3/logging"
 	"gopkg.in/mgo.v2"
 )
 
-type MongoDBConn struct {
-	session *mgo.Session
-	db      string
+// ConnState is the state of the connection to mongo as seen by the service.
+type ConnState int
+
+const (
+	StateDisconnected ConnState = iota
+	StateConnecting
+	StateConnected
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	default:
+		return "disconnected"
+	}
 }
 
-func NewMongoDBConn() *MongoDBConn {
-	return &MongoDBConn{}
+// ConnectOptions describe how to reach and authenticate to the payments
+// database.
+type ConnectOptions struct {
+	Addrs         []string
+	Database      string
+	Collection    string
+	SocketTimeout time.Duration
+	DialTimeout   time.Duration
+
+	Username      string
+	Password      string
+	AuthSource    string // database holding the user, defaults to Database
+	AuthMechanism string // SCRAM-SHA-1 or MONGODB-CR
+	ReplicaSet    string
+
+	// TLS encrypts the connections to every server when set.
+	TLS *tls.Config
 }
 
-func (m *MongoDBConn) Connect(host string, dbName string) *mgo.Session {
+// dialInfo builds the mgo dial information from the options.
+func (opts ConnectOptions) dialInfo() *mgo.DialInfo {
+	info := &mgo.DialInfo{
+		Addrs:          opts.Addrs,
+		Timeout:        opts.DialTimeout,
+		Database:       opts.Database,
+		ReplicaSetName: opts.ReplicaSet,
+		Source:         opts.AuthSource,
+		Mechanism:      opts.AuthMechanism,
+		Username:       opts.Username,
+		Password:       opts.Password,
+	}
+	if opts.TLS != nil {
+		info.DialServer = func(addr *mgo.ServerAddr) (net.Conn, error) {
+			config := opts.TLS.Clone()
+			if config.ServerName == "" {
+				if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+					config.ServerName = host
+				}
+			}
+			dialer := &net.Dialer{Timeout: opts.DialTimeout}
+			return tls.DialWithDialer(dialer, "tcp", addr.String(), config)
+		}
+	}
+	return info
+}
 
-	var url string
+type MongoDBConn struct {
+	session    *mgo.Session
+	db         string
+	collection string
 
-	url = "mongodb://" + host
+	connectBackoff Backoff
+	readBackoff    Backoff
 
-	dialinfo, err := mgo.ParseURL(url)
-	if err != nil {
-		log.Println("Couldn't parse mongodb url ", url)
-		log.Fatalln(err)
+	mu    sync.RWMutex
+	state ConnState
+}
+
+func NewMongoDBConn() *MongoDBConn {
+	return &MongoDBConn{
+		collection:     PAYMENT_COLLECTION,
+		connectBackoff: DefaultConnectBackoff,
+		readBackoff:    DefaultReadBackoff,
 	}
+}
 
-	session, err := mgo.Dial(url)
-	if err != nil {
-		log.Println("URL: ", url)
-		log.Printf("Couldn't connect to %v", dialinfo.Database)
-		log.Fatalln(err)
+// SetBackoff overrides the backoff used to dial mongo and to retry reads.
+func (m *MongoDBConn) SetBackoff(connect, read Backoff) {
+	m.connectBackoff = connect
+	m.readBackoff = read
+}
+
+// Connect dials mongo, retrying with exponential backoff until the server is
+// reachable or the connect backoff runs out of attempts.
+func (m *MongoDBConn) Connect(opts ConnectOptions) (*mgo.Session, error) {
+	if len(opts.Addrs) == 0 {
+		return nil, errors.New("no mongo address given")
+	}
+	dialinfo := opts.dialInfo()
+	fields := logging.Fields{"addrs": opts.Addrs, "tls": opts.TLS != nil, "replica_set": opts.ReplicaSet, "user": opts.Username}
+
+	m.setState(StateConnecting)
+	var session *mgo.Session
+	var err error
+	for attempt := 1; ; attempt++ {
+		session, err = mgo.DialWithInfo(dialinfo)
+		if err == nil {
+			break
+		}
+		logger.Warn("Couldn't connect to mongo", logging.Fields{"attempt": attempt, "error": err}, fields)
+		if m.connectBackoff.Exhausted(attempt) {
+			m.setState(StateDisconnected)
+			return nil, err
+		}
+		time.Sleep(m.connectBackoff.Delay(attempt))
 	}
 
-	m.SetDB(dbName)
-	session.SetSocketTimeout(time.Duration(10 * time.Minute))
+	m.SetDB(opts.Database)
+	if opts.Collection != "" {
+		m.SetCollection(opts.Collection)
+	}
+	session.SetSocketTimeout(opts.SocketTimeout)
 
 	m.session = session
-	return m.session
+	m.setState(StateConnected)
+	logger.Info("Connected to mongo", fields)
+	return m.session, nil
 }
 
 func (m *MongoDBConn) SetDB(db string) {
@@ -50,23 +148,91 @@ func (m *MongoDBConn) GetDB() string {
 	return m.db
 }
 
+// SetCollection sets the collection storing the payments.
+func (m *MongoDBConn) SetCollection(collection string) {
+	m.collection = collection
+}
+
+func (m *MongoDBConn) GetCollection() string {
+	return m.collection
+}
+
 func (m *MongoDBConn) Stop() {
 	m.session.Close()
+	m.setState(StateDisconnected)
 }
 
 func (m *MongoDBConn) GetConn() *mgo.Session {
 	return m.session.Copy()
 }
 
-func (m *MongoDBConn) SetIndex(key, db, collection string) error {
-	index := mgo.Index{
-		Key:        []string{key},
-		Unique:     true,
-		DropDups:   true,
-		Background: true,
-		Sparse:     true,
+// State returns the last known state of the connection.
+func (m *MongoDBConn) State() ConnState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state
+}
+
+func (m *MongoDBConn) setState(state ConnState) {
+	m.mu.Lock()
+	if m.state != state {
+		logger.Info("Mongo connection state changed", logging.Fields{"from": m.state, "to": state})
+	}
+	m.state = state
+	m.mu.Unlock()
+}
+
+// Ping checks the server is reachable within timeout, refreshing the master
+// session when it is not.
+func (m *MongoDBConn) Ping(timeout time.Duration) error {
+	conn := m.GetConn()
+	defer conn.Close()
+	conn.SetSyncTimeout(timeout)
+	conn.SetSocketTimeout(timeout)
+	err := conn.Ping()
+	m.checkErr(err)
+	return err
+}
+
+// Refresh drops the sockets held by the master session so the next operation
+// picks a live server, e.g. after a primary step down.
+func (m *MongoDBConn) Refresh() {
+	m.session.Refresh()
+}
+
+// checkErr refreshes the master session after a network error and keeps the
+// connection state in line with the result of the last operation.
+func (m *MongoDBConn) checkErr(err error) {
+	if IsNetworkError(err) {
+		m.setState(StateDisconnected)
+		m.Refresh()
+	} else {
+		m.setState(StateConnected)
 	}
-	collectionDb := m.session.DB(db).C(collection)
-	err := collectionDb.EnsureIndex(index)
+}
+
+// read runs an idempotent operation on a copy of the master session,
+// retrying it with backoff when it fails because of a network error.
+func (m *MongoDBConn) read(fn func(conn *mgo.Session) error) error {
+	for attempt := 1; ; attempt++ {
+		conn := m.GetConn()
+		err := fn(conn)
+		conn.Close()
+		m.checkErr(err)
+		if !IsNetworkError(err) || m.readBackoff.Exhausted(attempt) {
+			return err
+		}
+		logger.Warn("Retrying read after network error", logging.Fields{"attempt": attempt, "error": err})
+		time.Sleep(m.readBackoff.Delay(attempt))
+	}
+}
+
+// write runs a non idempotent operation on a copy of the master session. It
+// is never retried but a network error still refreshes the master session.
+func (m *MongoDBConn) write(fn func(conn *mgo.Session) error) error {
+	conn := m.GetConn()
+	defer conn.Close()
+	err := fn(conn)
+	m.checkErr(err)
 	return err
 }
@@ -0,0 +1,45 @@
+package data
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+)
+
+func TestDialInfo(t *testing.T) {
+	opts := ConnectOptions{
+		Addrs:         []string{"mongo1:27017", "mongo2:27017"},
+		Database:      "form3_db",
+		DialTimeout:   5 * time.Second,
+		Username:      "form3",
+		Password:      "s3cr3t",
+		AuthSource:    "admin",
+		AuthMechanism: "SCRAM-SHA-1",
+		ReplicaSet:    "rs0",
+	}
+
+	info := opts.dialInfo()
+	if len(info.Addrs) != 2 || info.Database != "form3_db" || info.Timeout != 5*time.Second {
+		t.Errorf("unexpected dial info %+v", info)
+	}
+	if info.Username != "form3" || info.Password != "s3cr3t" || info.Source != "admin" || info.Mechanism != "SCRAM-SHA-1" {
+		t.Errorf("unexpected credentials in dial info %+v", info)
+	}
+	if info.ReplicaSetName != "rs0" {
+		t.Errorf("unexpected replica set %v", info.ReplicaSetName)
+	}
+	if info.DialServer != nil {
+		t.Errorf("expected plain connections without TLS")
+	}
+
+	opts.TLS = &tls.Config{}
+	if opts.dialInfo().DialServer == nil {
+		t.Errorf("expected a TLS dialer")
+	}
+}
+
+func TestConnectWithoutAddress(t *testing.T) {
+	if _, err := NewMongoDBConn().Connect(ConnectOptions{}); err == nil {
+		t.Errorf("expected error without address")
+	}
+}
@@ -0,0 +1,92 @@
+package data
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+)
+
+// Backoff describes an exponential backoff with jitter used when dialling
+// mongo and when retrying idempotent reads.
+type Backoff struct {
+	Initial     time.Duration
+	Max         time.Duration
+	Multiplier  float64
+	Jitter      float64 // fraction of the delay randomised, between 0 and 1
+	MaxAttempts int     // 0 means retry forever
+}
+
+// DefaultConnectBackoff is used by Connect while waiting for mongo to come up.
+var DefaultConnectBackoff = Backoff{
+	Initial:     500 * time.Millisecond,
+	Max:         30 * time.Second,
+	Multiplier:  2,
+	Jitter:      0.2,
+	MaxAttempts: 0,
+}
+
+// DefaultReadBackoff bounds the retries of idempotent reads after a network error.
+var DefaultReadBackoff = Backoff{
+	Initial:     100 * time.Millisecond,
+	Max:         2 * time.Second,
+	Multiplier:  2,
+	Jitter:      0.2,
+	MaxAttempts: 3,
+}
+
+// Delay returns the wait before the given retry attempt (starting at 1).
+func (b Backoff) Delay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := float64(b.Initial)
+	for i := 1; i < attempt; i++ {
+		delay *= b.Multiplier
+		if b.Max > 0 && delay >= float64(b.Max) {
+			delay = float64(b.Max)
+			break
+		}
+	}
+	if b.Jitter > 0 {
+		delay += delay * b.Jitter * (rand.Float64()*2 - 1)
+	}
+	if b.Max > 0 && delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+	return time.Duration(delay)
+}
+
+// Exhausted reports if no more attempts are allowed after the given attempt.
+func (b Backoff) Exhausted(attempt int) bool {
+	return b.MaxAttempts > 0 && attempt >= b.MaxAttempts
+}
+
+// IsNetworkError reports if err was caused by a lost or unreachable server,
+// in which case the session should be refreshed before being used again.
+func IsNetworkError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	msg := err.Error()
+	for _, s := range []string{
+		"no reachable servers",
+		"Closed explicitly",
+		"connection reset",
+		"broken pipe",
+		"connection refused",
+		"i/o timeout",
+	} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
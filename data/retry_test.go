@@ -0,0 +1,63 @@
+package data
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2}
+
+	expected := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+	for i, e := range expected {
+		if d := b.Delay(i + 1); d != e {
+			t.Errorf("attempt %d: expected %v got %v", i+1, e, d)
+		}
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: 10 * time.Second, Multiplier: 2, Jitter: 0.5}
+
+	for i := 0; i < 100; i++ {
+		d := b.Delay(2)
+		if d < time.Second || d > 3*time.Second {
+			t.Fatalf("delay %v out of jitter range", d)
+		}
+	}
+}
+
+func TestBackoffExhausted(t *testing.T) {
+	if (Backoff{}).Exhausted(100) {
+		t.Errorf("unbounded backoff should never be exhausted")
+	}
+	b := Backoff{MaxAttempts: 3}
+	if b.Exhausted(2) || !b.Exhausted(3) {
+		t.Errorf("expected exhaustion at attempt 3")
+	}
+}
+
+func TestIsNetworkError(t *testing.T) {
+	cases := map[error]bool{
+		nil:                                false,
+		io.EOF:                             true,
+		errors.New("no reachable servers"): true,
+		errors.New("Closed explicitly"):    true,
+		errors.New("not found"):            false,
+		errors.New("E11000 duplicate key"): false,
+	}
+	for err, expected := range cases {
+		if IsNetworkError(err) != expected {
+			t.Errorf("IsNetworkError(%v) expected %v", err, expected)
+		}
+	}
+}
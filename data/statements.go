@@ -0,0 +1,266 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/form3/logging"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// STATEMENT_COLLECTION is the collection storing the imported bank statement
+// entries
+const STATEMENT_COLLECTION = "statement_entries"
+
+// PaymentReconciled is the status of a payment matched to a statement entry.
+const PaymentReconciled = "reconciled"
+
+// Statement entry statuses
+const (
+	EntryUnmatched = "unmatched"
+	EntryMatched   = "matched"
+)
+
+// Credit and debit indicators of statement entries
+const (
+	Credit = "CRDT"
+	Debit  = "DBIT"
+)
+
+// ErrAlreadyMatched is returned when matching an entry that was matched
+// meanwhile.
+var ErrAlreadyMatched = errors.New("the statement entry is already matched")
+
+// ErrPaymentReconciled is returned when matching an entry to a payment that
+// was reconciled meanwhile. The entry is left unmatched.
+var ErrPaymentReconciled = errors.New("the payment is already reconciled")
+
+// StatementEntry is a booking of a bank statement, matched against the
+// payments it settles.
+type StatementEntry struct {
+	ID             bson.ObjectId `json:"id" bson:"_id"`
+	OrganisationID string        `json:"organisation_id,omitempty" bson:"organisation_id,omitempty"`
+	// Format is the format of the imported statement, camt.053 or MT940
+	Format string `json:"format" bson:"format"`
+	// Account and StatementID identify the statement, and Sequence the entry
+	// within it, from 0
+	Account     string `json:"account" bson:"account"`
+	StatementID string `json:"statement_id" bson:"statement_id"`
+	Sequence    int    `json:"sequence" bson:"sequence"`
+
+	EntryReference    string  `json:"entry_reference,omitempty" bson:"entry_reference,omitempty"`
+	EndToEndReference string  `json:"end_to_end_reference,omitempty" bson:"end_to_end_reference,omitempty"`
+	Amount            float64 `json:"amount" bson:"amount"`
+	Currency          string  `json:"currency" bson:"currency"`
+	CreditDebit       string  `json:"credit_debit" bson:"credit_debit"`
+	// Reversal is true when the entry cancels an earlier entry, of the other
+	// credit debit indicator
+	Reversal bool `json:"reversal,omitempty" bson:"reversal,omitempty"`
+	// BookingDate and ValueDate are YYYY-MM-DD
+	BookingDate string `json:"booking_date,omitempty" bson:"booking_date,omitempty"`
+	ValueDate   string `json:"value_date,omitempty" bson:"value_date,omitempty"`
+	Information string `json:"information,omitempty" bson:"information,omitempty"`
+
+	Status    string         `json:"status" bson:"status"`
+	PaymentID *bson.ObjectId `json:"payment_id,omitempty" bson:"payment_id,omitempty"`
+	MatchedAt *time.Time     `json:"matched_at,omitempty" bson:"matched_at,omitempty"`
+	CreatedAt time.Time      `json:"created_at" bson:"created_at"`
+}
+
+// Date returns the booking date of the entry, or else its value date.
+func (e *StatementEntry) Date() string {
+	if e.BookingDate != "" {
+		return e.BookingDate
+	}
+	return e.ValueDate
+}
+
+// StatementFilter selects statement entries. Empty fields match every entry.
+type StatementFilter struct {
+	OrganisationID string
+	Status         string
+	// Limit bounds the number of entries, 0 for no limit
+	Limit int
+}
+
+func (f StatementFilter) query() bson.M {
+	query := bson.M{}
+	if f.OrganisationID != "" {
+		query["organisation_id"] = f.OrganisationID
+	}
+	if f.Status != "" {
+		query["status"] = f.Status
+	}
+	return query
+}
+
+type StatementProvider interface {
+	// SaveStatementEntries stores the new entries and returns those stored.
+	// The entries of a statement imported before are skipped, and those of
+	// them still unmatched are returned to be matched again.
+	SaveStatementEntries(ctx context.Context, entries []StatementEntry) (saved, unmatched []StatementEntry, err error)
+	ListStatementEntries(ctx context.Context, filter StatementFilter) ([]StatementEntry, error)
+	// MatchStatementEntry records that an unmatched entry settles a payment
+	// not reconciled yet, and marks the payment reconciled.
+	MatchStatementEntry(ctx context.Context, entryID, paymentID bson.ObjectId) error
+}
+
+// StatementIndexes are the indexes of the statement entries collection. The
+// entries of a statement are unique within an organisation so importing it
+// again adds nothing.
+func StatementIndexes() []IndexSpec {
+	return []IndexSpec{
+		{Collection: STATEMENT_COLLECTION, Key: []string{"organisation_id", "account", "statement_id", "sequence"}, Unique: true, Required: true},
+		{Collection: STATEMENT_COLLECTION, Key: []string{"status", "organisation_id"}},
+	}
+}
+
+// StatementDataBase stores statement entries next to the payments they match.
+type StatementDataBase struct {
+	*MongoDBConn
+}
+
+func (s *StatementDataBase) SaveStatementEntries(ctx context.Context, entries []StatementEntry) (saved, unmatched []StatementEntry, err error) {
+	logging.FromContext(ctx).Debug("DataBase Save Statement Entries", logging.Fields{"count": len(entries)})
+	if len(entries) == 0 {
+		return nil, nil, nil
+	}
+	now := time.Now().UTC()
+	docs := make([]interface{}, len(entries))
+	for i := range entries {
+		entries[i].ID = bson.NewObjectId()
+		entries[i].Status, entries[i].CreatedAt = EntryUnmatched, now
+		docs[i] = entries[i]
+	}
+	err = s.write(func(conn *mgo.Session) error {
+		bulk := conn.DB(s.db).C(STATEMENT_COLLECTION).Bulk()
+		bulk.Unordered()
+		bulk.Insert(docs...)
+		_, err := bulk.Run()
+		return err
+	})
+	if err == nil {
+		return entries, nil, nil
+	}
+	bulkErr, ok := err.(*mgo.BulkError)
+	if !ok {
+		return nil, nil, err
+	}
+	duplicate := map[int]bool{}
+	for _, c := range bulkErr.Cases() {
+		if !mgo.IsDup(c.Err) {
+			return nil, nil, c.Err
+		}
+		duplicate[c.Index] = true
+	}
+	var duplicates []StatementEntry
+	for i, entry := range entries {
+		if duplicate[i] {
+			duplicates = append(duplicates, entry)
+		} else {
+			saved = append(saved, entry)
+		}
+	}
+	unmatched, err = s.unmatchedEntries(ctx, duplicates)
+	if err != nil {
+		return nil, nil, err
+	}
+	return saved, unmatched, nil
+}
+
+// unmatchedEntries returns the stored entries of the same organisation,
+// statement and sequence as entries that are still unmatched, e.g. when an
+// import failed half way or matched no payment.
+func (s *StatementDataBase) unmatchedEntries(ctx context.Context, entries []StatementEntry) (unmatched []StatementEntry, err error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	type statement struct {
+		organisation, account, id string
+	}
+	sequences := map[statement]map[int]bool{}
+	var statements []bson.M
+	for _, entry := range entries {
+		key := statement{entry.OrganisationID, entry.Account, entry.StatementID}
+		if sequences[key] == nil {
+			sequences[key] = map[int]bool{}
+			// an entry without organisation has no organisation_id field,
+			// which the null query matches
+			var organisation interface{}
+			if entry.OrganisationID != "" {
+				organisation = entry.OrganisationID
+			}
+			statements = append(statements, bson.M{"organisation_id": organisation, "account": entry.Account, "statement_id": entry.StatementID})
+		}
+		sequences[key][entry.Sequence] = true
+	}
+	var stored []StatementEntry
+	err = s.read(func(conn *mgo.Session) error {
+		query := bson.M{"status": EntryUnmatched, "$or": statements}
+		return find(ctx, conn.DB(s.db).C(STATEMENT_COLLECTION), query).Sort("_id").All(&stored)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range stored {
+		if sequences[statement{entry.OrganisationID, entry.Account, entry.StatementID}][entry.Sequence] {
+			unmatched = append(unmatched, entry)
+		}
+	}
+	return unmatched, nil
+}
+
+// ListStatementEntries returns the entries matching filter in order of booking
+// date.
+func (s *StatementDataBase) ListStatementEntries(ctx context.Context, filter StatementFilter) (entries []StatementEntry, err error) {
+	logging.FromContext(ctx).Debug("DataBase List Statement Entries")
+	err = s.read(func(conn *mgo.Session) error {
+		q := find(ctx, conn.DB(s.db).C(STATEMENT_COLLECTION), filter.query()).Sort("booking_date", "_id")
+		if filter.Limit > 0 {
+			q = q.Limit(filter.Limit)
+		}
+		return q.All(&entries)
+	})
+	return
+}
+
+func (s *StatementDataBase) MatchStatementEntry(ctx context.Context, entryID, paymentID bson.ObjectId) error {
+	logger := logging.FromContext(ctx)
+	logger.Debug("DataBase Match Statement Entry", logging.Fields{"entry": entryID.Hex(), "payment": paymentID.Hex()})
+	now := time.Now().UTC()
+	err := s.write(func(conn *mgo.Session) error {
+		return conn.DB(s.db).C(STATEMENT_COLLECTION).Update(
+			bson.M{"_id": entryID, "status": EntryUnmatched},
+			bson.M{"$set": bson.M{"status": EntryMatched, "payment_id": paymentID, "matched_at": now}})
+	})
+	if err == mgo.ErrNotFound {
+		return ErrAlreadyMatched
+	}
+	if err != nil {
+		return err
+	}
+	err = s.write(func(conn *mgo.Session) error {
+		return conn.DB(s.db).C(s.collection).Update(
+			bson.M{"_id": paymentID, "status": bson.M{"$ne": PaymentReconciled}},
+			bson.M{"$set": bson.M{"status": PaymentReconciled}})
+	})
+	if err == nil {
+		return nil
+	}
+	if err == mgo.ErrNotFound {
+		err = ErrPaymentReconciled
+	}
+	// the payment was reconciled meanwhile, deleted or could not be updated,
+	// so the entry is unmatched again to be matched by a later import
+	rollback := s.write(func(conn *mgo.Session) error {
+		return conn.DB(s.db).C(STATEMENT_COLLECTION).Update(
+			bson.M{"_id": entryID, "status": EntryMatched, "payment_id": paymentID},
+			bson.M{"$set": bson.M{"status": EntryUnmatched}, "$unset": bson.M{"payment_id": "", "matched_at": ""}})
+	})
+	if rollback != nil {
+		logger.Error("Could not unmatch statement entry", logging.Fields{"entry": entryID.Hex(), "payment": paymentID.Hex(), "error": rollback})
+	}
+	return err
+}
@@ -0,0 +1,54 @@
+package data
+
+import (
+	"context"
+
+	"github.com/form3/logging"
+	"gopkg.in/mgo.v2"
+)
+
+// streamBatchSize is the number of payments fetched from mongo at a time
+// while streaming.
+const streamBatchSize = 500
+
+// PaymentIter iterates over payments without loading them all in memory.
+type PaymentIter interface {
+	// Next decodes the next payment into payment and reports if there was one.
+	Next(payment *Payment) bool
+	// Close releases the cursor and returns the error that stopped the
+	// iteration, if any.
+	Close() error
+}
+
+type mongoIter struct {
+	*mgo.Iter
+	conn *mgo.Session
+	m    *MongoDBConn
+}
+
+func (it *mongoIter) Next(payment *Payment) bool {
+	*payment = Payment{}
+	return it.Iter.Next(payment)
+}
+
+func (it *mongoIter) Close() error {
+	err := it.Iter.Close()
+	it.conn.Close()
+	it.m.checkErr(err)
+	return err
+}
+
+// StreamPayments iterates over the payments matching filter in order of
+// creation, at most filter.Limit when set. The iteration is not retried, it
+// must be closed.
+func (p *PaymentDataBase) StreamPayments(ctx context.Context, filter PaymentFilter) (PaymentIter, error) {
+	logging.FromContext(ctx).Debug("DataBase StreamPayments")
+	conn := p.GetConn()
+	c := conn.DB(p.db).C(p.collection)
+	q := find(ctx, c, filter.Query()).Sort("_id").Batch(streamBatchSize)
+	if filter.Limit > 0 {
+		q = q.Limit(filter.Limit)
+	}
+	iter := q.Iter()
+	return &mongoIter{Iter: iter, conn: conn, m: p.MongoDBConn}, nil
+}
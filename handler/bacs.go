@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"mime"
+	"net/http"
+
+	"github.com/form3/bacs"
+	"github.com/form3/logging"
+)
+
+// Export the filtered BACS payments as a Standard 18 submission
+func (a *App) ExportBacs(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	query := r.URL.Query()
+	query.Set("payment_scheme", bacs.Scheme)
+	r.URL.RawQuery = query.Encode()
+	payments := a.filteredPayments(w, r)
+	if payments == nil {
+		return
+	}
+	submission, err := bacs.New(payments, bacs.Options{
+		ServiceUserNumber: query.Get("service_user_number"),
+		ServiceUserName:   query.Get("service_user_name"),
+		VolumeSerial:      query.Get("volume_serial"),
+	})
+	if sendConversionError(w, r, err) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=us-ascii")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": submission.VolumeSerial() + ".txt"}))
+	w.WriteHeader(http.StatusOK)
+	if err := submission.Write(w); err != nil {
+		logging.FromContext(r.Context()).Warn("Could not write submission", logging.Fields{"volume": submission.VolumeSerial(), "error": err})
+	}
+}
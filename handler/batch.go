@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	data "github.com/form3/data"
+	"github.com/form3/logging"
+)
+
+// DefaultMaxBatchSize is the number of payments accepted by a batch create
+// when the App has no other limit.
+const DefaultMaxBatchSize = 1000
+
+// batchRequest is the body of POST /payments/batch. Batches are ordered
+// unless ordered is false.
+type batchRequest struct {
+	Ordered  *bool             `json:"ordered"`
+	Payments []json.RawMessage `json:"payments"`
+}
+
+// batchDocument is the JSON:API body of POST /payments/batch, with the
+// payments as resources in data. Batches are ordered unless meta.ordered is
+// false.
+type batchDocument struct {
+	Data []json.RawMessage `json:"data"`
+	Meta *batchMeta        `json:"meta,omitempty"`
+}
+
+type batchMeta struct {
+	Ordered *bool `json:"ordered"`
+}
+
+// batchItem is the outcome of one payment of a batch, at index in the request.
+type batchItem struct {
+	Index   int           `json:"index"`
+	Status  string        `json:"status"`
+	Payment *data.Payment `json:"payment,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+const (
+	batchCreated      = "created"
+	batchInvalid      = "invalid"
+	batchFailed       = "failed"
+	batchNotAttempted = "not_attempted"
+	// batchUnknown is the status of the payments that may or may not have
+	// been created when the bulk write failed as a whole
+	batchUnknown = "unknown"
+)
+
+// setOutcome sets the status of item from the result of its insertion.
+func (item *batchItem) setOutcome(result data.BatchResult) {
+	switch {
+	case result.Err == data.ErrNotAttempted:
+		item.Status, item.Error = batchNotAttempted, result.Err.Error()
+	case data.IsUnknownOutcome(result.Err):
+		item.Status, item.Error = batchUnknown, result.Err.Error()
+	case result.Err != nil:
+		item.Status, item.Error = batchFailed, result.Err.Error()
+	default:
+		item.Status, item.Payment = batchCreated, result.Payment
+	}
+}
+
+type batchResponse struct {
+	Ordered bool        `json:"ordered"`
+	Created int         `json:"created"`
+	Failed  int         `json:"failed"`
+	Results []batchItem `json:"results"`
+}
+
+// SetMaxBodySize sets the size in bytes of the bodies of the creates read
+// whole, by a batch create and to hash the requests with an idempotency key.
+func (a *App) SetMaxBodySize(n int64) {
+	a.maxBodySize = n
+}
+
+func (a *App) bodyLimit() int64 {
+	if a.maxBodySize <= 0 {
+		return DefaultMaxBodySize
+	}
+	return a.maxBodySize
+}
+
+// SetMaxBatchSize sets the number of payments accepted by a batch create.
+func (a *App) SetMaxBatchSize(n int) {
+	a.maxBatchSize = n
+}
+
+func (a *App) batchLimit() int {
+	if a.maxBatchSize <= 0 {
+		return DefaultMaxBatchSize
+	}
+	return a.maxBatchSize
+}
+
+// Create many payments with a single bulk write
+func (a *App) CreatePayments(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	logger := logging.FromContext(r.Context())
+	r.Body = http.MaxBytesReader(w, r.Body, a.bodyLimit())
+
+	batch, err := decodeBatch(r)
+	if err != nil {
+		logger.Info("Could not decode batch", logging.Fields{"error": err})
+		if _, ok := err.(*http.MaxBytesError); ok {
+			sendError(w, r, http.StatusRequestEntityTooLarge, err)
+			return
+		}
+		sendError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if len(batch.Payments) == 0 {
+		sendError(w, r, http.StatusBadRequest, errors.New("the batch has no payments"))
+		return
+	}
+	if limit := a.batchLimit(); len(batch.Payments) > limit {
+		err := fmt.Errorf("the batch has %d payments, the maximum is %d", len(batch.Payments), limit)
+		sendError(w, r, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	ordered := batch.Ordered == nil || *batch.Ordered
+
+	response := batchResponse{Ordered: ordered, Results: make([]batchItem, len(batch.Payments))}
+	for i := range response.Results {
+		response.Results[i].Index = i
+	}
+	var payments []data.Payment
+	var indexes []int
+	ids := map[string]int{}
+	for i, raw := range batch.Payments {
+		payment, err := a.decodeBatchPayment(r, raw, ids, i)
+		if err != nil {
+			response.Results[i].Status, response.Results[i].Error = batchInvalid, err.Error()
+			if ordered {
+				break
+			}
+			continue
+		}
+		payments = append(payments, payment)
+		indexes = append(indexes, i)
+	}
+
+	results, err := a.db.CreatePayments(r.Context(), payments, ordered)
+	if err != nil {
+		logger.Error("Could not create payments", logging.Fields{"count": len(payments), "error": err})
+		results = data.UnknownOutcomes(len(payments), err)
+	}
+	for j, result := range results {
+		response.Results[indexes[j]].setOutcome(result)
+	}
+	for i := range response.Results {
+		item := &response.Results[i]
+		if item.Status == "" {
+			// after an invalid payment of an ordered batch
+			item.Status, item.Error = batchNotAttempted, data.ErrNotAttempted.Error()
+		}
+		if item.Status == batchCreated {
+			response.Created++
+		} else {
+			response.Failed++
+		}
+	}
+	logger.Info("Payments batch created", logging.Fields{"created": response.Created, "failed": response.Failed, "ordered": ordered})
+	SendJson(w, response)
+}
+
+// decodeBatch reads the batch of the request body, a JSON:API document when
+// sent as such and else a batch request.
+func decodeBatch(r *http.Request) (batchRequest, error) {
+	var batch batchRequest
+	if !sendsJSONAPI(r) {
+		err := json.NewDecoder(r.Body).Decode(&batch)
+		return batch, err
+	}
+	var doc batchDocument
+	if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+		return batch, err
+	}
+	batch.Payments = doc.Data
+	if doc.Meta != nil {
+		batch.Ordered = doc.Meta.Ordered
+	}
+	return batch, nil
+}
+
+// decodeBatchPayment decodes and checks the payment at index i of a batch, a
+// resource when the batch is a JSON:API document. ids maps the payment ids
+// already seen in the batch to their index.
+func (a *App) decodeBatchPayment(r *http.Request, raw json.RawMessage, ids map[string]int, i int) (data.Payment, error) {
+	var payment data.Payment
+	if sendsJSONAPI(r) {
+		var resource paymentResource
+		if err := json.Unmarshal(raw, &resource); err != nil {
+			return payment, err
+		}
+		payment = resource.payment()
+	} else if err := json.Unmarshal(raw, &payment); err != nil {
+		return payment, err
+	}
+	// set by the service
+	payment.Status = ""
+	if err := checkOrganisation(r, &payment); err != nil {
+		return payment, err
+	}
+	if payment.ID != "" {
+		if first, ok := ids[payment.ID]; ok {
+			return payment, fmt.Errorf("duplicate id %v, already used by payment %d", payment.ID, first)
+		}
+		ids[payment.ID] = i
+	}
+	return payment, nil
+}
@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	data "github.com/form3/data"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// CreatePayments fails the payments with the id "existing" like a duplicate
+// key error of mongo.
+func (mdb *mockDB) CreatePayments(ctx context.Context, payments []data.Payment, ordered bool) ([]data.BatchResult, error) {
+	if mdb.testCaseDbError {
+		return nil, errors.New(DB_ERROR)
+	}
+	results := make([]data.BatchResult, len(payments))
+	failed := false
+	for i := range payments {
+		switch {
+		case failed && ordered:
+			results[i].Err = data.ErrNotAttempted
+		case payments[i].ID == "existing":
+			results[i].Err = errors.New("E11000 duplicate key error")
+			failed = true
+		default:
+			payments[i].MongoID = bson.ObjectIdHex(fmt.Sprintf("5b2ce1c5c089711b0e3bc2%02x", i))
+			results[i].Payment = &payments[i]
+		}
+	}
+	return results, nil
+}
+
+func postBatch(app *App, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/payments/batch", bytes.NewBufferString(body))
+	app.CreatePayments(rec, req)
+	return rec
+}
+
+func TestCreatePaymentsOrdered(t *testing.T) {
+	app := &App{db: &mockDB{}}
+
+	rec := postBatch(app, `{"payments": [{"id": "a"}, {"id": "existing"}, {"id": "b"}]}`)
+
+	expected := `{"ordered":true,"created":1,"failed":2,"results":[` +
+		`{"index":0,"status":"created","payment":{"_id":"5b2ce1c5c089711b0e3bc200","id":"a","version":0,"attributes":{"beneficiary_party":{},"charges_information":{},"debtor_party":{},"fx":{},"sponsor_party":{}}}},` +
+		`{"index":1,"status":"failed","error":"E11000 duplicate key error"},` +
+		`{"index":2,"status":"not_attempted","error":"not attempted, an earlier payment of the ordered batch failed"}]}`
+	if expected != rec.Body.String() {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, rec.Body.String())
+	}
+}
+
+func TestCreatePaymentsUnordered(t *testing.T) {
+	app := &App{db: &mockDB{}}
+
+	rec := postBatch(app, `{"ordered": false, "payments": [{"id": "existing"}, {"id": 1}, {"id": "a"}, {"id": "a"}]}`)
+
+	body := rec.Body.String()
+	for _, e := range []string{
+		`"ordered":false,"created":1,"failed":3`,
+		`{"index":0,"status":"failed","error":"E11000 duplicate key error"}`,
+		`{"index":1,"status":"invalid","error":"json: cannot unmarshal number into Go struct field Payment.id of type string"}`,
+		`{"index":2,"status":"created","payment":{"_id":"5b2ce1c5c089711b0e3bc201","id":"a"`,
+		`{"index":3,"status":"invalid","error":"duplicate id a, already used by payment 2"}`,
+	} {
+		if !strings.Contains(body, e) {
+			t.Errorf("expected %v in %v", e, body)
+		}
+	}
+}
+
+func TestCreatePaymentsJSONAPI(t *testing.T) {
+	app := &App{db: &mockDB{}}
+	body := `{"meta": {"ordered": false}, "data": [` +
+		`{"type": "Payment", "id": "existing"},` +
+		`{"type": "Payment", "id": "a", "attributes": {"amount": 100.21}, "meta": {"status": "reconciled"}},` +
+		`{"type": "Payment", "id": 1}]}`
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/payments/batch", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", jsonAPIType)
+	app.CreatePayments(rec, req)
+
+	obtained := rec.Body.String()
+	for _, e := range []string{
+		`"ordered":false,"created":1,"failed":2`,
+		`{"index":0,"status":"failed","error":"E11000 duplicate key error"}`,
+		`{"index":1,"status":"created","payment":{"_id":"5b2ce1c5c089711b0e3bc201","id":"a","type":"Payment","version":0,"attributes":{"amount":100.21,`,
+		`{"index":2,"status":"invalid","error":"json: cannot unmarshal number into Go struct field paymentResource.id of type string"}`,
+	} {
+		if !strings.Contains(obtained, e) {
+			t.Errorf("expected %v in %v", e, obtained)
+		}
+	}
+	if strings.Contains(obtained, "reconciled") {
+		t.Errorf("expected the status of the resource to be ignored, got %v", obtained)
+	}
+
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/payments/batch", bytes.NewBufferString(`{"data": []}`))
+	req.Header.Set("Content-Type", jsonAPIType)
+	req.Header.Set("Accept", jsonAPIType)
+	app.CreatePayments(rec, req)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"detail":"the batch has no payments"`) {
+		t.Errorf("expected an error document, got %v %v", rec.Code, rec.Body.String())
+	}
+}
+
+func TestCreatePaymentsInvalidOrdered(t *testing.T) {
+	app := &App{db: &mockDB{}}
+
+	rec := postBatch(app, `{"payments": [{"id": "a"}, {"id": 1}, {"id": "b"}]}`)
+
+	body := rec.Body.String()
+	for _, e := range []string{
+		`"created":1,"failed":2`,
+		`{"index":1,"status":"invalid"`,
+		`{"index":2,"status":"not_attempted"`,
+	} {
+		if !strings.Contains(body, e) {
+			t.Errorf("expected %v in %v", e, body)
+		}
+	}
+}
+
+func TestCreatePaymentsMaxBatchSize(t *testing.T) {
+	app := &App{db: &mockDB{}}
+	app.SetMaxBatchSize(2)
+
+	rec := postBatch(app, `{"payments": [{}, {}, {}]}`)
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status %v got %v", http.StatusRequestEntityTooLarge, rec.Code)
+	}
+	expected := `{"status":"the batch has 3 payments, the maximum is 2"}`
+	if expected != rec.Body.String() {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, rec.Body.String())
+	}
+}
+
+func TestCreatePaymentsEmpty(t *testing.T) {
+	app := &App{db: &mockDB{}}
+
+	rec := postBatch(app, `{"payments": []}`)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %v got %v", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestCreatePaymentsDbError(t *testing.T) {
+	app := &App{db: &mockDB{testCaseDbError: true}}
+
+	rec := postBatch(app, `{"ordered": false, "payments": [{"id": "a"}, {"organisation_id": 1}]}`)
+
+	// the payment may have been created before the write failed
+	expected := `{"ordered":false,"created":0,"failed":2,"results":[` +
+		`{"index":0,"status":"unknown","error":"unknown outcome, the payment may have been created: database error"},` +
+		`{"index":1,"status":"invalid","error":"json: cannot unmarshal number into Go struct field Payment.organisation_id of type string"}]}`
+	if rec.Code != http.StatusOK || expected != rec.Body.String() {
+		t.Errorf("\n...expected = %v\n...obtained = %v %v", expected, rec.Code, rec.Body.String())
+	}
+}
+
+func TestCreatePaymentsMaxBodySize(t *testing.T) {
+	app := &App{db: &mockDB{}}
+	app.SetMaxBodySize(64)
+
+	rec := postBatch(app, `{"payments": [{"id": "a", "attributes": {"reference": "`+strings.Repeat("x", 64)+`"}}]}`)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status %v got %v %v", http.StatusRequestEntityTooLarge, rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,209 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	data "github.com/form3/data"
+	"github.com/form3/logging"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	csvType    = "text/csv"
+	ndjsonType = "application/x-ndjson"
+)
+
+// exportFlushRows is the number of rows written between flushes of the
+// response.
+const exportFlushRows = 100
+
+var errExportType = errors.New("export is available as text/csv or application/x-ndjson")
+
+// exportType returns the media type of an export: the format query parameter
+// (csv or ndjson) or else the first supported type of the Accept header,
+// defaulting to CSV.
+func exportType(r *http.Request) (string, error) {
+	switch r.URL.Query().Get("format") {
+	case "csv":
+		return csvType, nil
+	case "ndjson":
+		return ndjsonType, nil
+	case "":
+	default:
+		return "", errExportType
+	}
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return csvType, nil
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, _ := mime.ParseMediaType(strings.TrimSpace(part))
+		switch mediaType {
+		case csvType, "*/*", "text/*":
+			return csvType, nil
+		case ndjsonType:
+			return ndjsonType, nil
+		}
+	}
+	return "", errExportType
+}
+
+// exportColumns returns the columns selected with the columns query parameter,
+// every payment field by default.
+func exportColumns(r *http.Request) ([]string, error) {
+	selected := r.URL.Query().Get("columns")
+	if selected == "" {
+		return data.PaymentFields(), nil
+	}
+	var columns []string
+	for _, column := range strings.Split(selected, ",") {
+		column = strings.TrimSpace(column)
+		if column == "" {
+			continue
+		}
+		if _, err := data.PaymentField(reflect.ValueOf(data.Payment{}), column); err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// cell formats the field of payment at path for an export.
+func cell(payment *data.Payment, path string) interface{} {
+	v, _ := data.PaymentField(reflect.ValueOf(payment).Elem(), path)
+	if id, ok := v.Interface().(bson.ObjectId); ok {
+		return id.Hex()
+	}
+	return v.Interface()
+}
+
+func csvCell(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int, int64:
+		return fmt.Sprint(v)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// Export payments as CSV or NDJSON, streamed from the database
+func (a *App) ExportPayments(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	logger := logging.FromContext(r.Context())
+
+	mediaType, err := exportType(r)
+	if err != nil {
+		SendJsonWithStatus(w, http.StatusNotAcceptable, errorResponse(r, err))
+		return
+	}
+	filter, err := paymentFilter(r)
+	if err != nil {
+		SendJsonWithStatus(w, http.StatusBadRequest, errorResponse(r, err))
+		return
+	}
+	_, selected := r.URL.Query()["columns"]
+	columns, err := exportColumns(r)
+	if err != nil {
+		SendJsonWithStatus(w, http.StatusBadRequest, errorResponse(r, err))
+		return
+	}
+
+	iter, err := a.db.StreamPayments(r.Context(), filter)
+	if err != nil {
+		logger.Error("Could not export payments", logging.Fields{"error": err})
+		SendJsonWithStatus(w, http.StatusInternalServerError, errorResponse(r, err))
+		return
+	}
+	// the first payment is read before the headers are sent, so an error of
+	// the query is still reported with its status
+	var payment data.Payment
+	more := iter.Next(&payment)
+	if !more {
+		if err := iter.Close(); err != nil {
+			logger.Error("Could not export payments", logging.Fields{"error": err})
+			SendJsonWithStatus(w, http.StatusInternalServerError, errorResponse(r, err))
+			return
+		}
+	}
+
+	extension := "csv"
+	if mediaType == ndjsonType {
+		extension = "ndjson"
+	}
+	w.Header().Set("Content-Type", mediaType+"; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="payments.`+extension+`"`)
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	var write func(payment *data.Payment) error
+	var flush func() error
+	if mediaType == csvType {
+		cw := csv.NewWriter(w)
+		cw.Write(columns)
+		record := make([]string, len(columns))
+		write = func(payment *data.Payment) error {
+			for i, column := range columns {
+				record[i] = csvCell(cell(payment, column))
+			}
+			return cw.Write(record)
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	} else {
+		enc := json.NewEncoder(w)
+		write = func(payment *data.Payment) error {
+			if !selected {
+				return enc.Encode(payment)
+			}
+			row := make(map[string]interface{}, len(columns))
+			for _, column := range columns {
+				row[column] = cell(payment, column)
+			}
+			return enc.Encode(row)
+		}
+		flush = func() error { return nil }
+	}
+
+	rows := 0
+	for ; more; more = iter.Next(&payment) {
+		if err = write(&payment); err != nil {
+			break
+		}
+		if rows++; rows%exportFlushRows == 0 {
+			if err = flush(); err != nil {
+				break
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+	if closeErr := iter.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		// the status is sent already, abort the response so the client does
+		// not mistake it for a complete export
+		logger.Error("Export failed", logging.Fields{"rows": rows, "error": err})
+		panic(http.ErrAbortHandler)
+	}
+	logger.Info("Payments exported", logging.Fields{"rows": rows, "format": extension})
+}
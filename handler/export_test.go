@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	data "github.com/form3/data"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type sliceIter struct {
+	payments []data.Payment
+	err      error
+}
+
+func (it *sliceIter) Next(payment *data.Payment) bool {
+	if len(it.payments) == 0 {
+		return false
+	}
+	*payment, it.payments = it.payments[0], it.payments[1:]
+	return true
+}
+
+func (it *sliceIter) Close() error {
+	return it.err
+}
+
+func (mdb *mockDB) StreamPayments(ctx context.Context, filter data.PaymentFilter) (data.PaymentIter, error) {
+	if mdb.testCaseDbError {
+		return nil, errors.New(DB_ERROR)
+	}
+	payments, _ := mdb.ListPayments(ctx, filter)
+	return &sliceIter{payments: payments}, nil
+}
+
+func export(app *App, url, accept string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", url, &bytes.Buffer{})
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	app.ExportPayments(rec, req)
+	return rec
+}
+
+func TestExportPaymentsCSV(t *testing.T) {
+	app := &App{db: &mockDB{}}
+
+	rec := export(app, "/payments/export?columns=_id,id,attributes.amount,attributes.beneficiary_party.account_number,attributes.charges_information.sender_charges", "text/csv")
+
+	expected := "_id,id,attributes.amount,attributes.beneficiary_party.account_number,attributes.charges_information.sender_charges\n" +
+		"5b290f5b802b0f1479000002,4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43,0,31926819,\"[{\"\"currency\"\":\"\"GBP\"\"},{\"\"currency\"\":\"\"GBP\"\"}]\"\n" +
+		"5b290f5b802b0f1479000003,4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43,0,31926819,\"[{\"\"currency\"\":\"\"GBP\"\"},{\"\"currency\"\":\"\"GBP\"\"}]\"\n"
+	if expected != rec.Body.String() {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Errorf("unexpected content type %v", ct)
+	}
+}
+
+func TestExportPaymentsAllColumns(t *testing.T) {
+	app := &App{db: &mockDB{}}
+
+	rec := export(app, "/payments/export?format=csv", "")
+
+	header, _ := bytes.NewBufferString(rec.Body.String()).ReadString('\n')
+	if expected := len(data.PaymentFields()); bytes.Count([]byte(header), []byte(",")) != expected-1 {
+		t.Errorf("expected %v columns in %v", expected, header)
+	}
+}
+
+func TestExportPaymentsNDJSON(t *testing.T) {
+	app := &App{db: &mockDB{}}
+
+	rec := export(app, "/payments/export?columns=id,attributes.fx.original_currency", "application/x-ndjson")
+
+	row := `{"attributes.fx.original_currency":"USD","id":"4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43"}` + "\n"
+	expected := row + row
+	if expected != rec.Body.String() {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, rec.Body.String())
+	}
+}
+
+func TestExportPaymentsInvalid(t *testing.T) {
+	app := &App{db: &mockDB{}}
+
+	for url, code := range map[string]int{
+		"/payments/export?format=xml":                     http.StatusNotAcceptable,
+		"/payments/export?columns=attributes.unknown":     http.StatusBadRequest,
+		"/payments/export?processing_date_from=yesterday": http.StatusBadRequest,
+	} {
+		if rec := export(app, url, ""); rec.Code != code {
+			t.Errorf("%v: %+v != %+v", url, rec.Code, code)
+		}
+	}
+	if rec := export(app, "/payments/export", "application/json"); rec.Code != http.StatusNotAcceptable {
+		t.Errorf("%+v != %+v", rec.Code, http.StatusNotAcceptable)
+	}
+}
+
+func TestExportPaymentsDbError(t *testing.T) {
+	for _, db := range []data.PaymentProvider{
+		&mockDB{testCaseDbError: true},
+		// the query fails before the first payment
+		&failingStream{payments: []data.Payment{}},
+	} {
+		rec := export(&App{db: db}, "/payments/export", "")
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("%+v != %+v", rec.Code, http.StatusInternalServerError)
+		}
+		if disposition := rec.Header().Get("Content-Disposition"); disposition != "" {
+			t.Errorf("expected no attachment, got %v", disposition)
+		}
+	}
+}
+
+func TestExportPaymentsCursorError(t *testing.T) {
+	app := &App{db: &failingStream{}}
+
+	defer func() {
+		if r := recover(); r != http.ErrAbortHandler {
+			t.Errorf("expected the response to be aborted, got %v", r)
+		}
+	}()
+	export(app, "/payments/export", "")
+}
+
+// failingStream streams payments, one by default, then fails.
+type failingStream struct {
+	mockDB
+	payments []data.Payment
+}
+
+func (f *failingStream) StreamPayments(ctx context.Context, filter data.PaymentFilter) (data.PaymentIter, error) {
+	if f.payments == nil {
+		return &sliceIter{payments: []data.Payment{{MongoID: bson.NewObjectId()}}, err: errors.New("cursor not found")}, nil
+	}
+	return &sliceIter{payments: f.payments, err: errors.New("cursor not found")}, nil
+}
@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultCheckTimeout is how long a readiness check may take when the Health
+// has no other timeout.
+const DefaultCheckTimeout = 2 * time.Second
+
+// CheckFunc reports an error when the dependency it checks is not usable.
+type CheckFunc func() error
+
+type check struct {
+	name string
+	fn   CheckFunc
+
+	mu sync.Mutex
+	// inFlight is the run of fn not returned yet, maybe after timing out
+	inFlight *checkRun
+}
+
+// checkRun is a run of a check, done when it returns err.
+type checkRun struct {
+	done chan struct{}
+	err  error
+}
+
+// CheckResult is the outcome of a single readiness check.
+type CheckResult struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// HealthReport is the body returned by the liveness and readiness endpoints.
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// Health serves the liveness and readiness probes of the service.
+type Health struct {
+	mu       sync.RWMutex
+	checks   []*check
+	timeout  time.Duration
+	draining int32
+}
+
+func NewHealth() *Health {
+	return &Health{}
+}
+
+// AddCheck registers a check run on every readiness probe.
+func (h *Health) AddCheck(name string, fn CheckFunc) {
+	h.mu.Lock()
+	h.checks = append(h.checks, &check{name: name, fn: fn})
+	h.mu.Unlock()
+}
+
+// SetTimeout sets how long a readiness check may take before it is reported
+// as timed out.
+func (h *Health) SetTimeout(timeout time.Duration) {
+	h.mu.Lock()
+	h.timeout = timeout
+	h.mu.Unlock()
+}
+
+// Drain makes readiness fail so the orchestrator stops routing traffic to
+// this instance before it shuts down.
+func (h *Health) Drain() {
+	atomic.StoreInt32(&h.draining, 1)
+}
+
+// Draining reports if Drain has been called.
+func (h *Health) Draining() bool {
+	return atomic.LoadInt32(&h.draining) == 1
+}
+
+// Liveness reports the process is alive and able to serve requests
+func (h *Health) Liveness(w http.ResponseWriter, r *http.Request) {
+	SendJson(w, HealthReport{Status: "ok"})
+}
+
+// Readiness runs every registered check concurrently and fails if any of
+// them fails or times out, or the service is shutting down
+func (h *Health) Readiness(w http.ResponseWriter, r *http.Request) {
+	report := HealthReport{Status: "ok", Checks: map[string]CheckResult{}}
+
+	if h.Draining() {
+		report.Status = "fail"
+		report.Checks["shutdown"] = CheckResult{Status: "fail", Error: "draining"}
+	}
+
+	h.mu.RLock()
+	checks, timeout := h.checks, h.timeout
+	h.mu.RUnlock()
+	if timeout <= 0 {
+		timeout = DefaultCheckTimeout
+	}
+
+	results := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *check) {
+			defer wg.Done()
+			results[i] = c.run(timeout)
+		}(i, c)
+	}
+	wg.Wait()
+	for i, c := range checks {
+		if results[i].Status != "ok" {
+			report.Status = "fail"
+		}
+		report.Checks[c.name] = results[i]
+	}
+
+	statusCode := http.StatusOK
+	if report.Status != "ok" {
+		statusCode = http.StatusServiceUnavailable
+	}
+	SendJsonWithStatus(w, statusCode, report)
+}
+
+// start runs the check, or returns the run in flight so the probes share it
+// rather than pile up on a hung dependency.
+func (c *check) start() *checkRun {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.inFlight == nil {
+		run := &checkRun{done: make(chan struct{})}
+		c.inFlight = run
+		go func() {
+			run.err = c.fn()
+			c.mu.Lock()
+			c.inFlight = nil
+			c.mu.Unlock()
+			close(run.done)
+		}()
+	}
+	return c.inFlight
+}
+
+// run waits up to timeout for the result of the check. A run that times out
+// is left to return in the background.
+func (c *check) run(timeout time.Duration) CheckResult {
+	start := time.Now()
+	run := c.start()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-run.done:
+		result := CheckResult{Status: "ok", LatencyMs: float64(time.Since(start)) / float64(time.Millisecond)}
+		if run.err != nil {
+			result.Status, result.Error = "fail", run.err.Error()
+		}
+		return result
+	case <-timer.C:
+		return CheckResult{Status: "timeout", LatencyMs: float64(time.Since(start)) / float64(time.Millisecond), Error: "no result after " + timeout.String()}
+	}
+}
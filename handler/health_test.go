@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLiveness(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/healthz", &bytes.Buffer{})
+
+	NewHealth().Liveness(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%+v != %+v", rec.Code, http.StatusOK)
+	}
+
+	expected := `{"status":"ok"}`
+
+	if expected != rec.Body.String() {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, rec.Body.String())
+	}
+}
+
+func TestReadinessOk(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/readyz", &bytes.Buffer{})
+
+	health := NewHealth()
+	health.AddCheck("mongo", func() error { return nil })
+	health.Readiness(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%+v != %+v", rec.Code, http.StatusOK)
+	}
+
+	var report HealthReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	if report.Status != "ok" || report.Checks["mongo"].Status != "ok" {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
+
+func TestReadinessCheckFails(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/readyz", &bytes.Buffer{})
+
+	health := NewHealth()
+	health.AddCheck("mongo", func() error { return nil })
+	health.AddCheck("indexes", func() error { return errors.New("missing unique index id") })
+	health.Readiness(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("%+v != %+v", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	var report HealthReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	if report.Status != "fail" || report.Checks["indexes"].Error != "missing unique index id" {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
+
+func TestReadinessTimeout(t *testing.T) {
+	release := make(chan struct{})
+	var runs int32
+	health := NewHealth()
+	health.SetTimeout(10 * time.Millisecond)
+	health.AddCheck("mongo", func() error { return nil })
+	health.AddCheck("indexes", func() error {
+		atomic.AddInt32(&runs, 1)
+		<-release
+		return nil
+	})
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/readyz", &bytes.Buffer{})
+		health.Readiness(rec, req)
+
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("%+v != %+v", rec.Code, http.StatusServiceUnavailable)
+		}
+		var report HealthReport
+		if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+			t.Fatalf("Didn't expect error %v", err)
+		}
+		if report.Checks["indexes"].Status != "timeout" || report.Checks["mongo"].Status != "ok" {
+			t.Errorf("unexpected report %+v", report)
+		}
+	}
+	// the second probe waits for the run in flight
+	if n := atomic.LoadInt32(&runs); n != 1 {
+		t.Errorf("expected a single run of the hung check, got %v", n)
+	}
+
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/readyz", &bytes.Buffer{})
+		health.Readiness(rec, req)
+		if rec.Code == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the check to recover, got %v %v", rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestReadinessDraining(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/readyz", &bytes.Buffer{})
+
+	health := NewHealth()
+	health.Drain()
+	health.Readiness(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("%+v != %+v", rec.Code, http.StatusServiceUnavailable)
+	}
+}
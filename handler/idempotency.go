@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	data "github.com/form3/data"
+	"github.com/form3/logging"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// ReplayedHeader is set on the responses replayed for an idempotency key
+	ReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKey = 255
+)
+
+var (
+	errKeyTooLong    = errors.New("the idempotency key is longer than 255 characters")
+	errKeyInProgress = errors.New("a request with this idempotency key is in progress")
+	errKeyReused     = errors.New("the idempotency key was used by another request")
+)
+
+// SetIdempotencyProvider enables replaying the response of a create sent
+// again with the same Idempotency-Key header.
+func (a *App) SetIdempotencyProvider(keys data.IdempotencyProvider) {
+	a.keys = keys
+}
+
+// recordingWriter keeps a copy of the body written.
+type recordingWriter struct {
+	*statusWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.statusWriter.Write(b)
+}
+
+// legacyError reports if body is an error of the legacy format, a status
+// message with the request id, which database errors are sent as with 200.
+func legacyError(body []byte) bool {
+	var response map[string]json.RawMessage
+	if err := json.Unmarshal(body, &response); err != nil {
+		return false
+	}
+	if _, ok := response["status"]; !ok {
+		return false
+	}
+	delete(response, "status")
+	delete(response, "request_id")
+	return len(response) == 0
+}
+
+// idempotent runs handler once per Idempotency-Key of the organisation, the
+// requests sent again with the key getting the stored response. Requests
+// failing with a server error, or a database error sent to legacy clients
+// with 200, release their key so they can be retried.
+func (a *App) idempotent(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if a.keys == nil || key == "" {
+			handler(w, r)
+			return
+		}
+		logger := logging.FromContext(r.Context())
+		if len(key) > maxIdempotencyKey {
+			sendError(w, r, http.StatusBadRequest, errKeyTooLong)
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, a.bodyLimit()))
+		if _, ok := err.(*http.MaxBytesError); ok {
+			sendError(w, r, http.StatusRequestEntityTooLarge, err)
+			return
+		} else if err != nil {
+			sendError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		hash := sha256.New()
+		hash.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+		hash.Write(body)
+		request := data.IdempotentRequest{Key: Organisation(r.Context()) + "/" + key, Hash: hex.EncodeToString(hash.Sum(nil))}
+
+		stored, err := a.keys.ReserveKey(r.Context(), request)
+		switch {
+		case err != nil:
+			logger.Error("Could not reserve idempotency key", logging.Fields{"error": err})
+			sendDBError(w, r, err)
+		case stored == nil:
+			rec := &recordingWriter{statusWriter: newStatusWriter(w)}
+			handler(rec, r)
+			request.Status, request.ContentType, request.Body = rec.Status(), rec.Header().Get("Content-Type"), rec.body.Bytes()
+			if request.Status >= http.StatusInternalServerError || request.Status == http.StatusOK && legacyError(request.Body) {
+				err = a.keys.ReleaseKey(r.Context(), request.Key)
+			} else {
+				err = a.keys.CompleteKey(r.Context(), request)
+			}
+			if err != nil {
+				logger.Error("Could not store idempotent response", logging.Fields{"status": request.Status, "error": err})
+			}
+		case stored.Hash != request.Hash:
+			sendError(w, r, http.StatusUnprocessableEntity, errKeyReused)
+		case !stored.Completed:
+			sendError(w, r, http.StatusConflict, errKeyInProgress)
+		default:
+			logger.Info("Replaying idempotent response", logging.Fields{"status": stored.Status})
+			w.Header().Set("Content-Type", stored.ContentType)
+			w.Header().Set(ReplayedHeader, "true")
+			w.WriteHeader(stored.Status)
+			w.Write(stored.Body)
+		}
+	}
+}
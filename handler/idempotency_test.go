@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	data "github.com/form3/data"
+)
+
+// memoryKeys stores idempotency keys in memory.
+type memoryKeys struct {
+	requests map[string]data.IdempotentRequest
+}
+
+func (m *memoryKeys) ReserveKey(ctx context.Context, request data.IdempotentRequest) (*data.IdempotentRequest, error) {
+	if stored, ok := m.requests[request.Key]; ok {
+		return &stored, nil
+	}
+	m.requests[request.Key] = request
+	return nil, nil
+}
+
+func (m *memoryKeys) CompleteKey(ctx context.Context, request data.IdempotentRequest) error {
+	request.Completed = true
+	m.requests[request.Key] = request
+	return nil
+}
+
+func (m *memoryKeys) ReleaseKey(ctx context.Context, key string) error {
+	delete(m.requests, key)
+	return nil
+}
+
+// countingDB counts the payments created.
+type countingDB struct {
+	mockDB
+	created int
+}
+
+func (m *countingDB) CreatePayment(ctx context.Context, payment data.Payment) (*data.Payment, error) {
+	m.created++
+	return m.mockDB.CreatePayment(ctx, payment)
+}
+
+func postIdempotent(app *App, key, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/payments", bytes.NewBufferString(body))
+	req.Header.Set(IdempotencyKeyHeader, key)
+	app.idempotent(app.CreatePayment)(rec, req)
+	return rec
+}
+
+func TestIdempotentCreate(t *testing.T) {
+	db := &countingDB{}
+	keys := &memoryKeys{requests: map[string]data.IdempotentRequest{}}
+	app := &App{db: db}
+	app.SetIdempotencyProvider(keys)
+	body := `{"id":"4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43","attributes":{"amount":100.21}}`
+
+	first := postIdempotent(app, "key-1", body)
+	second := postIdempotent(app, "key-1", body)
+	if db.created != 1 || first.Code != http.StatusOK || first.Body.String() != second.Body.String() {
+		t.Errorf("expected the response of the single create to be replayed, got %v creates and %v %v", db.created, first.Body.String(), second.Body.String())
+	}
+	if first.Header().Get(ReplayedHeader) != "" || second.Header().Get(ReplayedHeader) != "true" ||
+		second.Header().Get("Content-Type") != first.Header().Get("Content-Type") {
+		t.Errorf("expected the second response to be marked replayed, got %v and %v", first.Header(), second.Header())
+	}
+
+	if rec := postIdempotent(app, "key-1", `{"id":"216d4da9"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422 for a reused key got %v", rec.Code)
+	}
+	if postIdempotent(app, "key-2", body); db.created != 2 {
+		t.Errorf("expected another key to create a payment, got %v creates", db.created)
+	}
+	if postIdempotent(app, "", body); db.created != 3 {
+		t.Errorf("expected a request without key to create a payment, got %v creates", db.created)
+	}
+	if rec := postIdempotent(app, strings.Repeat("k", 256), body); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a long key got %v", rec.Code)
+	}
+}
+
+func TestIdempotentInProgress(t *testing.T) {
+	keys := &memoryKeys{requests: map[string]data.IdempotentRequest{}}
+	app := &App{db: &mockDB{}}
+	app.SetIdempotencyProvider(keys)
+	body := `{"id":"4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43"}`
+	postIdempotent(app, "key-1", body)
+	stored := keys.requests["/key-1"]
+	stored.Completed = false
+	keys.requests["/key-1"] = stored
+
+	if rec := postIdempotent(app, "key-1", body); rec.Code != http.StatusConflict {
+		t.Errorf("expected status 409 got %v", rec.Code)
+	}
+}
+
+func TestIdempotentServerError(t *testing.T) {
+	keys := &memoryKeys{requests: map[string]data.IdempotentRequest{}}
+	app := &App{db: &mockDB{testCaseDbError: true}}
+	app.SetIdempotencyProvider(keys)
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/payments", bytes.NewBufferString(`{"data":{"type":"Payment"}}`))
+	req.Header.Set(IdempotencyKeyHeader, "key-1")
+	req.Header.Set("Accept", jsonAPIType)
+	req.Header.Set("Content-Type", jsonAPIType)
+	app.idempotent(app.CreatePayment)(rec, req)
+	if _, ok := keys.requests["/key-1"]; rec.Code != http.StatusInternalServerError || ok {
+		t.Errorf("expected the key to be released after a server error, got %v %v", rec.Code, keys.requests)
+	}
+}
+
+func TestIdempotentMaxBodySize(t *testing.T) {
+	db := &countingDB{}
+	app := &App{db: db}
+	app.SetIdempotencyProvider(&memoryKeys{requests: map[string]data.IdempotentRequest{}})
+	app.SetMaxBodySize(64)
+
+	rec := postIdempotent(app, "key-1", `{"attributes":{"reference":"`+strings.Repeat("x", 64)+`"}}`)
+	if rec.Code != http.StatusRequestEntityTooLarge || db.created != 0 {
+		t.Errorf("expected status 413 without create, got %v and %v creates", rec.Code, db.created)
+	}
+}
+
+func TestIdempotentLegacyError(t *testing.T) {
+	keys := &memoryKeys{requests: map[string]data.IdempotentRequest{}}
+	db := &countingDB{mockDB: mockDB{testCaseDbError: true}}
+	app := &App{db: db}
+	app.SetIdempotencyProvider(keys)
+	body := `{"id":"4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43"}`
+
+	// legacy clients get database errors with 200
+	rec := postIdempotent(app, "key-1", body)
+	if _, ok := keys.requests["/key-1"]; rec.Code != http.StatusOK || ok {
+		t.Errorf("expected the key to be released after a database error, got %v %v", rec.Body.String(), keys.requests)
+	}
+
+	db.testCaseDbError = false
+	if rec := postIdempotent(app, "key-1", body); db.created != 2 || rec.Header().Get(ReplayedHeader) != "" {
+		t.Errorf("expected the create to be retried with the key, got %v creates", db.created)
+	}
+	if _, ok := keys.requests["/key-1"]; !ok {
+		t.Errorf("expected the created payment to be stored with the key")
+	}
+}
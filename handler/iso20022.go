@@ -0,0 +1,256 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+
+	data "github.com/form3/data"
+	"github.com/form3/iso20022"
+	"github.com/form3/logging"
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const xmlType = "application/xml"
+
+// DefaultMaxMessagePayments is the number of payments an exported message
+// holds at most when no maximum is set.
+const DefaultMaxMessagePayments = 10000
+
+var (
+	errPaymentNotFound = errors.New("payment not found")
+	errNoPayments      = errors.New("no payments match the filter")
+	errXMLType         = errors.New("expected an application/xml body")
+)
+
+// SetMaxMessagePayments sets the number of payments an exported message holds
+// at most.
+func (a *App) SetMaxMessagePayments(n int) {
+	a.maxMessagePayments = n
+}
+
+func (a *App) messageLimit() int {
+	if a.maxMessagePayments <= 0 {
+		return DefaultMaxMessagePayments
+	}
+	return a.maxMessagePayments
+}
+
+// importResponse is the outcome of every transaction of an imported message,
+// at their index in the message.
+type importResponse struct {
+	MessageID   string                 `json:"message_id"`
+	Created     int                    `json:"created"`
+	Failed      int                    `json:"failed"`
+	Results     []batchItem            `json:"results"`
+	Unsupported []iso20022.Unsupported `json:"unsupported,omitempty"`
+}
+
+// messageOptions reads the group header of a message from the message_id,
+// initiating_party and settlement_method query parameters.
+func messageOptions(r *http.Request) iso20022.MessageOptions {
+	return iso20022.MessageOptions{
+		MessageID:        r.URL.Query().Get("message_id"),
+		InitiatingParty:  r.URL.Query().Get("initiating_party"),
+		SettlementMethod: r.URL.Query().Get("settlement_method"),
+	}
+}
+
+// Export the filtered payments as a pain.001 customer credit transfer initiation
+func (a *App) ExportPain001(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if payments := a.filteredPayments(w, r); payments != nil {
+		doc, err := iso20022.NewPain001(payments, messageOptions(r))
+		sendMessage(w, r, doc, err)
+	}
+}
+
+// Get a payment as a pain.001 customer credit transfer initiation
+func (a *App) GetPaymentPain001(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if payment := a.payment(w, r); payment != nil {
+		doc, err := iso20022.NewPain001([]data.Payment{*payment}, messageOptions(r))
+		sendMessage(w, r, doc, err)
+	}
+}
+
+// Export the filtered payments as a pacs.008 FI to FI customer credit transfer
+func (a *App) ExportPacs008(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if payments := a.filteredPayments(w, r); payments != nil {
+		doc, err := iso20022.NewPacs008(payments, messageOptions(r))
+		sendMessage(w, r, doc, err)
+	}
+}
+
+// Get a payment as a pacs.008 FI to FI customer credit transfer
+func (a *App) GetPaymentPacs008(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if payment := a.payment(w, r); payment != nil {
+		doc, err := iso20022.NewPacs008([]data.Payment{*payment}, messageOptions(r))
+		sendMessage(w, r, doc, err)
+	}
+}
+
+// payment returns the payment of the request, sending an error response when
+// it cannot be found.
+func (a *App) payment(w http.ResponseWriter, r *http.Request) *data.Payment {
+	id := mux.Vars(r)["id"]
+	if !bson.IsObjectIdHex(id) {
+		SendJsonWithStatus(w, http.StatusNotFound, errorResponse(r, errPaymentNotFound))
+		return nil
+	}
+	payment, err := a.db.ListPaymentID(r.Context(), bson.ObjectIdHex(id))
+	if err == mgo.ErrNotFound || err == nil && !ownedBy(r, payment) {
+		SendJsonWithStatus(w, http.StatusNotFound, errorResponse(r, errPaymentNotFound))
+		return nil
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("Could not get payment", logging.Fields{"payment": id, "error": err})
+		SendJsonWithStatus(w, http.StatusInternalServerError, errorResponse(r, err))
+		return nil
+	}
+	return payment
+}
+
+// filteredPayments returns the payments matching the filter of the request,
+// sending an error response when there are none or more than a message holds.
+// Messages carry their totals in the header so the payments are read before
+// writing them.
+func (a *App) filteredPayments(w http.ResponseWriter, r *http.Request) []data.Payment {
+	logger := logging.FromContext(r.Context())
+	filter, err := paymentFilter(r)
+	if err != nil {
+		SendJsonWithStatus(w, http.StatusBadRequest, errorResponse(r, err))
+		return nil
+	}
+	max := a.messageLimit()
+	if filter.Limit <= 0 || filter.Limit > max {
+		// one more payment tells a filter matching too many
+		filter.Limit = max + 1
+	}
+	iter, err := a.db.StreamPayments(r.Context(), filter)
+	if err != nil {
+		logger.Error("Could not read payments", logging.Fields{"error": err})
+		SendJsonWithStatus(w, http.StatusInternalServerError, errorResponse(r, err))
+		return nil
+	}
+	var payments []data.Payment
+	var payment data.Payment
+	for iter.Next(&payment) {
+		payments = append(payments, payment)
+	}
+	if err := iter.Close(); err != nil {
+		logger.Error("Could not read payments", logging.Fields{"error": err})
+		SendJsonWithStatus(w, http.StatusInternalServerError, errorResponse(r, err))
+		return nil
+	}
+	if len(payments) == 0 {
+		SendJsonWithStatus(w, http.StatusNotFound, errorResponse(r, errNoPayments))
+		return nil
+	}
+	if len(payments) > max {
+		err := fmt.Errorf("more than %d payments match the filter, narrow it or page it with a limit of at most %d", max, max)
+		SendJsonWithStatus(w, http.StatusUnprocessableEntity, errorResponse(r, err))
+		return nil
+	}
+	return payments
+}
+
+// sendMessage writes doc, or the payments that could not be converted with
+// 422 when err lists them.
+func sendMessage(w http.ResponseWriter, r *http.Request, doc iso20022.Message, err error) {
+	logger := logging.FromContext(r.Context())
+	if sendConversionError(w, r, err) {
+		return
+	}
+	w.Header().Set("Content-Type", xmlType+"; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": doc.MessageID() + ".xml"}))
+	w.WriteHeader(http.StatusOK)
+	if err := doc.Write(w); err != nil {
+		logger.Warn("Could not write message", logging.Fields{"message": doc.MessageID(), "error": err})
+	}
+}
+
+// sendConversionError sends err, if any, and reports whether it did: the
+// payments that could not be converted with 422 when err lists them, or else
+// 400.
+func sendConversionError(w http.ResponseWriter, r *http.Request, err error) bool {
+	if errs, ok := err.(iso20022.Errors); ok {
+		logging.FromContext(r.Context()).Info("Could not convert payments", logging.Fields{"error": err})
+		response := errorResponse(r, errors.New("payments cannot be converted"))
+		response["errors"] = errs
+		SendJsonWithStatus(w, http.StatusUnprocessableEntity, response)
+		return true
+	}
+	if err != nil {
+		SendJsonWithStatus(w, http.StatusBadRequest, errorResponse(r, err))
+		return true
+	}
+	return false
+}
+
+// Import the credit transfers of a pain.001 customer credit transfer initiation
+func (a *App) ImportPain001(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	logger := logging.FromContext(r.Context())
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != xmlType && mediaType != "text/xml" {
+		SendJsonWithStatus(w, http.StatusUnsupportedMediaType, errorResponse(r, errXMLType))
+		return
+	}
+	if a.maxUploadSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, a.maxUploadSize)
+	}
+	imp, err := iso20022.ParsePain001(r.Body, Organisation(r.Context()))
+	if err != nil {
+		logger.Info("Could not read pain.001 message", logging.Fields{"error": err})
+		SendJsonWithStatus(w, http.StatusBadRequest, errorResponse(r, err))
+		return
+	}
+
+	response := importResponse{MessageID: imp.MessageID, Results: make([]batchItem, len(imp.Payments)), Unsupported: imp.Unsupported}
+	for i := range response.Results {
+		response.Results[i].Index = i
+	}
+	for _, err := range imp.Errors {
+		response.Results[err.Index].Status, response.Results[err.Index].Error = batchInvalid, err.Err.Error()
+	}
+	valid, indexes := imp.Valid()
+	var payments []data.Payment
+	var created []int
+	for j, payment := range valid {
+		if err := checkOrganisation(r, &payment); err != nil {
+			response.Results[indexes[j]].Status, response.Results[indexes[j]].Error = batchInvalid, err.Error()
+			continue
+		}
+		payments = append(payments, payment)
+		created = append(created, indexes[j])
+	}
+
+	for start := 0; start < len(payments); start += a.batchLimit() {
+		end := start + a.batchLimit()
+		if end > len(payments) {
+			end = len(payments)
+		}
+		results, err := a.db.CreatePayments(r.Context(), payments[start:end], false)
+		if err != nil {
+			logger.Error("Could not create payments", logging.Fields{"message": imp.MessageID, "count": end - start, "error": err})
+			results = data.UnknownOutcomes(end-start, err)
+		}
+		for j, result := range results {
+			response.Results[created[start+j]].setOutcome(result)
+		}
+	}
+	for _, item := range response.Results {
+		if item.Status == batchCreated {
+			response.Created++
+		} else {
+			response.Failed++
+		}
+	}
+	logger.Info("Payments imported", logging.Fields{"message": imp.MessageID, "created": response.Created, "failed": response.Failed, "unsupported": len(imp.Unsupported)})
+	SendJson(w, response)
+}
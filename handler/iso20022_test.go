@@ -0,0 +1,312 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	data "github.com/form3/data"
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// messageDB holds payments that can be written as messages.
+type messageDB struct {
+	mockDB
+	payments []data.Payment
+}
+
+func (m *messageDB) StreamPayments(ctx context.Context, filter data.PaymentFilter) (data.PaymentIter, error) {
+	var payments []data.Payment
+	for _, payment := range m.payments {
+		if (filter.Currency == "" || payment.Attributes.Currency == filter.Currency) &&
+			(filter.PaymentScheme == "" || payment.Attributes.PaymentScheme == filter.PaymentScheme) &&
+			(filter.After == "" || payment.MongoID > filter.After) && (filter.Limit == 0 || len(payments) < filter.Limit) {
+			payments = append(payments, payment)
+		}
+	}
+	return &sliceIter{payments: payments}, nil
+}
+
+func (m *messageDB) ListPaymentID(ctx context.Context, id bson.ObjectId) (*data.Payment, error) {
+	for _, payment := range m.payments {
+		if payment.MongoID == id {
+			return &payment, nil
+		}
+	}
+	return nil, mgo.ErrNotFound
+}
+
+func messagePayment() data.Payment {
+	return data.Payment{
+		MongoID:        bson.ObjectIdHex("5b290f5b802b0f1479000002"),
+		ID:             "4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43",
+		OrganisationID: "743d5b63-8e6f-432e-a8fa-c5d8d2ee5fcb",
+		Attributes: data.Attributes{
+			Amount:            100.21,
+			Currency:          "GBP",
+			EndToEndReference: "Wil piano Jan",
+			ProcessingDate:    "2017-01-18",
+			BeneficiaryParty:  data.Account{Name: "Wilfred Jeremiah Owens", AccountNumber: "31926819", BankID: "403000", BankIDCode: "GBDSC"},
+			DebtorParty:       data.Account{Name: "Emelia Jane Brown", AccountNumber: "GB29XABC10161234567801", AccountNumberCode: "IBAN", BankID: "203301", BankIDCode: "GBDSC"},
+		},
+	}
+}
+
+func serveMessage(app *App, url string) *httptest.ResponseRecorder {
+	r := mux.NewRouter()
+	r.HandleFunc("/payments/export/pain001", app.ExportPain001).Methods("GET")
+	r.HandleFunc("/payments/{id}/pain001", app.GetPaymentPain001).Methods("GET")
+	r.HandleFunc("/payments/export/pacs008", app.ExportPacs008).Methods("GET")
+	r.HandleFunc("/payments/export/bacs", app.ExportBacs).Methods("GET")
+	r.HandleFunc("/payments/{id}/pacs008", app.GetPaymentPacs008).Methods("GET")
+	r.HandleFunc("/payments/{id}/mt103", app.GetPaymentMT103).Methods("GET")
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", url, &bytes.Buffer{})
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestExportPain001(t *testing.T) {
+	euro := messagePayment()
+	euro.Attributes.Currency = "EUR"
+	app := &App{db: &messageDB{payments: []data.Payment{messagePayment(), euro}}}
+
+	rec := serveMessage(app, "/payments/export/pain001?currency=gbp&message_id=MSG-1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %v: %v", rec.Code, rec.Body.String())
+	}
+	expected := `attachment; filename=MSG-1.xml`
+	if obtained := rec.Header().Get("Content-Disposition"); expected != obtained {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, obtained)
+	}
+	body := rec.Body.String()
+	for _, expected := range []string{"<MsgId>MSG-1</MsgId>", "<NbOfTxs>1</NbOfTxs>", `<InstdAmt Ccy="GBP">100.21</InstdAmt>`} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected %v in\n%v", expected, body)
+		}
+	}
+
+	rec = serveMessage(app, "/payments/export/pain001?currency=USD")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 got %v", rec.Code)
+	}
+	rec = serveMessage(app, "/payments/export/pain001?processing_date_from=yesterday")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 got %v", rec.Code)
+	}
+}
+
+func TestExportPain001Invalid(t *testing.T) {
+	invalid := messagePayment()
+	invalid.Attributes.Amount = 0
+	app := &App{db: &messageDB{payments: []data.Payment{messagePayment(), invalid}}}
+
+	rec := serveMessage(app, "/payments/export/pain001")
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status 422 got %v", rec.Code)
+	}
+	var response struct {
+		Errors []map[string]interface{} `json:"errors"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	expected := `[map[error:amount must be positive id:4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43 index:1]]`
+	if obtained := fmt.Sprint(response.Errors); expected != obtained {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, obtained)
+	}
+
+	rec = serveMessage(&App{db: &messageDB{payments: []data.Payment{messagePayment()}}}, "/payments/export/pain001?message_id="+strings.Repeat("x", 36))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 got %v", rec.Code)
+	}
+}
+
+func TestExportPain001MaxPayments(t *testing.T) {
+	second := messagePayment()
+	second.MongoID = bson.ObjectIdHex("5b290f5b802b0f1479000003")
+	app := &App{db: &messageDB{payments: []data.Payment{messagePayment(), second}}}
+	app.SetMaxMessagePayments(1)
+
+	if rec := serveMessage(app, "/payments/export/pain001"); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422 got %v", rec.Code)
+	}
+	// the payments are paged within the maximum
+	if rec := serveMessage(app, "/payments/export/pain001?limit=1"); rec.Code != http.StatusOK {
+		t.Errorf("expected status 200 got %v: %v", rec.Code, rec.Body.String())
+	}
+	if rec := serveMessage(app, "/payments/export/pain001?limit=1&after=5b290f5b802b0f1479000002"); rec.Code != http.StatusOK {
+		t.Errorf("expected status 200 got %v: %v", rec.Code, rec.Body.String())
+	}
+
+	if rec := serveMessage(&App{db: &mockDB{testCaseDbError: true}}, "/payments/export/pain001"); rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500 got %v", rec.Code)
+	}
+}
+
+func TestGetPaymentPain001(t *testing.T) {
+	app := &App{db: &messageDB{payments: []data.Payment{messagePayment()}}}
+
+	rec := serveMessage(app, "/payments/5b290f5b802b0f1479000002/pain001")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "<EndToEndId>Wil piano Jan</EndToEndId>") {
+		t.Errorf("expected the payment as pain.001, got %v\n%v", rec.Code, rec.Body.String())
+	}
+	for _, id := range []string{"5b290f5b802b0f1479000009", "unknown"} {
+		if rec := serveMessage(app, "/payments/"+id+"/pain001"); rec.Code != http.StatusNotFound {
+			t.Errorf("expected status 404 for %v got %v", id, rec.Code)
+		}
+	}
+}
+
+func TestPacs008(t *testing.T) {
+	payment := messagePayment()
+	payment.Attributes.ChargesInformation.BearerCode = "SHAR"
+	app := &App{db: &messageDB{payments: []data.Payment{payment}}}
+
+	rec := serveMessage(app, "/payments/5b290f5b802b0f1479000002/pacs008?settlement_method=INDA")
+	body := rec.Body.String()
+	for _, expected := range []string{"<SttlmMtd>INDA</SttlmMtd>", "<IntrBkSttlmDt>2017-01-18</IntrBkSttlmDt>", "<MmbId>403000</MmbId>"} {
+		if rec.Code != http.StatusOK || !strings.Contains(body, expected) {
+			t.Errorf("expected %v in %v\n%v", expected, rec.Code, body)
+		}
+	}
+
+	app = &App{db: &messageDB{payments: []data.Payment{messagePayment()}}}
+	rec = serveMessage(app, "/payments/export/pacs008")
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "charges bearer code is required") {
+		t.Errorf("expected the missing charges bearer to be reported, got %v %v", rec.Code, rec.Body.String())
+	}
+}
+
+func TestGetPaymentMT103(t *testing.T) {
+	payment := messagePayment()
+	payment.Attributes.ChargesInformation.BearerCode = "DEBT"
+	app := &App{db: &messageDB{payments: []data.Payment{payment}}}
+
+	rec := serveMessage(app, "/payments/5b290f5b802b0f1479000002/mt103")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), ":32A:170118GBP100,21\r\n") {
+		t.Errorf("expected the payment as MT103, got %v %q", rec.Code, rec.Body.String())
+	}
+
+	app = &App{db: &messageDB{payments: []data.Payment{messagePayment()}}}
+	rec = serveMessage(app, "/payments/5b290f5b802b0f1479000002/mt103")
+	expected := `{"errors":"unknown charges bearer code \"\"","status":"payment cannot be converted"}`
+	if rec.Code != http.StatusUnprocessableEntity || rec.Body.String() != expected {
+		t.Errorf("\n...expected = %v\n...obtained = %v %v", expected, rec.Code, rec.Body.String())
+	}
+}
+
+const importMessage = `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:pain.001.001.09">
+  <CstmrCdtTrfInitn>
+    <GrpHdr><MsgId>MSG-3</MsgId><CreDtTm>2017-01-17T10:30:00</CreDtTm><NbOfTxs>3</NbOfTxs><InitgPty/></GrpHdr>
+    <PmtInf>
+      <PmtInfId>MSG-3-1</PmtInfId>
+      <PmtMtd>TRF</PmtMtd>
+      <ReqdExctnDt><Dt>2017-01-18</Dt></ReqdExctnDt>
+      <Dbtr><Nm>Emelia Jane Brown</Nm></Dbtr>
+      <DbtrAcct><Id><IBAN>GB29XABC10161234567801</IBAN></Id></DbtrAcct>
+      <DbtrAgt><FinInstnId><Othr><Id>NOTPROVIDED</Id></Othr></FinInstnId></DbtrAgt>
+      <CdtTrfTxInf>
+        <PmtId><EndToEndId>E2E-1</EndToEndId></PmtId>
+        <Amt><InstdAmt Ccy="GBP">10</InstdAmt></Amt>
+        <CdtrAcct><Id><Othr><Id>31926819</Id></Othr></Id></CdtrAcct>
+      </CdtTrfTxInf>
+      <CdtTrfTxInf>
+        <PmtId><EndToEndId>E2E-2</EndToEndId></PmtId>
+        <Amt><InstdAmt Ccy="GBP">20</InstdAmt></Amt>
+        <Tax><Cdtr/></Tax>
+      </CdtTrfTxInf>
+      <CdtTrfTxInf>
+        <PmtId><EndToEndId>E2E-3</EndToEndId></PmtId>
+        <Amt><InstdAmt Ccy="GBP">30</InstdAmt></Amt>
+        <CdtrAcct><Id><Othr><Id>31926820</Id></Othr></Id></CdtrAcct>
+      </CdtTrfTxInf>
+    </PmtInf>
+  </CstmrCdtTrfInitn>
+</Document>`
+
+func postMessage(app *App, contentType, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/payments/import/pain001", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", contentType)
+	app.ImportPain001(rec, req)
+	return rec
+}
+
+func TestImportPain001(t *testing.T) {
+	app := &App{db: &mockDB{}, maxBatchSize: 1}
+
+	rec := postMessage(app, "application/xml", importMessage)
+	var response importResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	var statuses []string
+	for _, item := range response.Results {
+		statuses = append(statuses, fmt.Sprintf("%d:%v:%v", item.Index, item.Status, item.Error))
+	}
+	expected := `[0:created: 1:invalid:creditor account is required 2:created:]`
+	if obtained := fmt.Sprint(statuses); expected != obtained {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, obtained)
+	}
+	if response.MessageID != "MSG-3" || response.Created != 2 || response.Failed != 1 {
+		t.Errorf("expected MSG-3 with 2 created and 1 failed, got %+v", response)
+	}
+	if expected := "[{CstmrCdtTrfInitn/PmtInf/CdtTrfTxInf/Tax 1}]"; fmt.Sprint(response.Unsupported) != expected {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, response.Unsupported)
+	}
+	if payment := response.Results[2].Payment; payment == nil || payment.Attributes.Amount != 30 || payment.Attributes.BeneficiaryParty.AccountNumber != "31926820" {
+		t.Errorf("expected the third transaction to be created, got %+v", payment)
+	}
+}
+
+func TestImportPain001Invalid(t *testing.T) {
+	app := &App{db: &mockDB{}}
+
+	if rec := postMessage(app, "text/csv", importMessage); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected status 415 got %v", rec.Code)
+	}
+	rec := postMessage(app, "text/xml", strings.Replace(importMessage, "<NbOfTxs>3</NbOfTxs>", "<NbOfTxs>2</NbOfTxs>", 1))
+	expected := `{"status":"the group header declares 2 transactions, it has 3"}`
+	if rec.Code != http.StatusBadRequest || rec.Body.String() != expected {
+		t.Errorf("\n...expected = %v\n...obtained = %v %v", expected, rec.Code, rec.Body.String())
+	}
+}
+
+func TestExportBacs(t *testing.T) {
+	payment := messagePayment()
+	payment.Attributes.PaymentScheme = "BACS"
+	payment.Attributes.Reference = "Wil piano Jan"
+	payment.Attributes.DebtorParty.AccountNumber = "71268996"
+	app := &App{db: &messageDB{payments: []data.Payment{payment, messagePayment()}}}
+
+	rec := serveMessage(app, "/payments/export/bacs?service_user_number=123456&service_user_name=Form3&volume_serial=VOL001")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %v: %v", rec.Code, rec.Body.String())
+	}
+	expected := `attachment; filename=VOL001.txt`
+	if obtained := rec.Header().Get("Content-Disposition"); expected != obtained {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, obtained)
+	}
+	detail := "4030003192681909920330171268996    00000010021FORM3             WIL PIANO JAN     WILFRED JEREMIAH O"
+	if !strings.Contains(rec.Body.String(), detail+"\r\n") || strings.Count(rec.Body.String(), "\r\n") != 9 {
+		t.Errorf("expected one credit %v in\n%v", detail, rec.Body.String())
+	}
+
+	if rec := serveMessage(app, "/payments/export/bacs"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 without a service user number got %v", rec.Code)
+	}
+	payment.Attributes.Reference = "Wilfred's piano lessons"
+	app.db = &messageDB{payments: []data.Payment{payment}}
+	if rec := serveMessage(app, "/payments/export/bacs?service_user_number=123456"); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422 for a long reference got %v", rec.Code)
+	}
+}
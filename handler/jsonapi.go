@@ -0,0 +1,257 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	data "github.com/form3/data"
+	"github.com/form3/trace"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// jsonAPIType is the media type of JSON:API documents. Clients listing it in
+// Accept get payments as resources of a data/links/meta document, the others
+// the bare payments and status messages of the legacy format.
+const jsonAPIType = "application/vnd.api+json"
+
+// paymentType is the type of the payment resources stored without one.
+const paymentType = "Payment"
+
+var errNoData = errors.New("the document has no data")
+
+// errResourceID is sent with 409 when the id of an updated resource is not the
+// id of the payment at the URL.
+var errResourceID = errors.New("the id of the resource is not the id of the payment at this URL")
+
+// paymentResource is a payment as a JSON:API resource object. The id is the
+// id of the payment, assigned by the client, while the routes use the mongo
+// id: the self link is the URL of the resource. Updates are checked against
+// the id of the payment at the URL, see checkResourceID.
+type paymentResource struct {
+	Type           string          `json:"type"`
+	ID             string          `json:"id,omitempty"`
+	Version        int             `json:"version"`
+	OrganisationID string          `json:"organisation_id,omitempty"`
+	Attributes     data.Attributes `json:"attributes"`
+	Meta           *resourceMeta   `json:"meta,omitempty"`
+	Links          *links          `json:"links,omitempty"`
+}
+
+// resourceMeta holds the fields of a payment maintained by the service.
+type resourceMeta struct {
+	Status string `json:"status,omitempty"`
+}
+
+type links struct {
+	Self string `json:"self"`
+	// Next is the URL of the next page of a list requested with a limit
+	Next string `json:"next,omitempty"`
+}
+
+// document is a JSON:API top level document, holding either data or errors.
+type document struct {
+	Data   interface{} `json:"data,omitempty"`
+	Errors []apiError  `json:"errors,omitempty"`
+	Links  *links      `json:"links,omitempty"`
+	Meta   Response    `json:"meta,omitempty"`
+}
+
+// resourceDocument is a document of a single payment.
+type resourceDocument struct {
+	Data  *paymentResource `json:"data"`
+	Links *links           `json:"links,omitempty"`
+}
+
+// resourcesDocument is the document of a list of payments, as written by
+// writeResources.
+type resourcesDocument struct {
+	Data  []paymentResource `json:"data"`
+	Links *links            `json:"links"`
+	Meta  Response          `json:"meta"`
+}
+
+// apiError is a JSON:API error object.
+type apiError struct {
+	Status string   `json:"status"`
+	Title  string   `json:"title"`
+	Detail string   `json:"detail"`
+	Meta   Response `json:"meta,omitempty"`
+}
+
+// acceptsJSONAPI reports if the Accept header of r lists the JSON:API media
+// type.
+func acceptsJSONAPI(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, _ := mime.ParseMediaType(strings.TrimSpace(part)); mediaType == jsonAPIType {
+			return true
+		}
+	}
+	return false
+}
+
+func newResource(payment *data.Payment) *paymentResource {
+	resource := &paymentResource{
+		Type:           payment.Type,
+		ID:             payment.ID,
+		Version:        payment.Version,
+		OrganisationID: payment.OrganisationID,
+		Attributes:     payment.Attributes,
+	}
+	if resource.Type == "" {
+		resource.Type = paymentType
+	}
+	if payment.Status != "" {
+		resource.Meta = &resourceMeta{Status: payment.Status}
+	}
+	if payment.MongoID.Valid() {
+		resource.Links = &links{Self: "/payments/" + payment.MongoID.Hex()}
+	}
+	return resource
+}
+
+// decodePayment reads the payment of the request body, a JSON:API document
+// when sent as such and else a bare payment. The status is set by the service
+// and ignored.
+func decodePayment(r *http.Request) (data.Payment, error) {
+	var payment data.Payment
+	if !sendsJSONAPI(r) {
+		err := json.NewDecoder(r.Body).Decode(&payment)
+		payment.Status = ""
+		return payment, err
+	}
+	var doc resourceDocument
+	if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+		return payment, err
+	}
+	if doc.Data == nil {
+		return payment, errNoData
+	}
+	return doc.Data.payment(), nil
+}
+
+// payment returns the payment sent as resource. The meta and links are set
+// by the service and ignored.
+func (resource *paymentResource) payment() data.Payment {
+	return data.Payment{
+		Type:           resource.Type,
+		ID:             resource.ID,
+		Version:        resource.Version,
+		OrganisationID: resource.OrganisationID,
+		Attributes:     resource.Attributes,
+	}
+}
+
+// sendsJSONAPI reports if the body of r is a JSON:API document.
+func sendsJSONAPI(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == jsonAPIType
+}
+
+// checkResourceID checks the resource of a JSON:API update identifies the
+// stored payment, by its id or the mongo id of the URL, and keeps the id of
+// the payment. A resource without id is the payment at the URL. Bare payments
+// replace the id as they always did.
+func checkResourceID(r *http.Request, payment *data.Payment, stored *data.Payment) error {
+	if !sendsJSONAPI(r) {
+		return nil
+	}
+	if payment.ID != "" && payment.ID != stored.ID && payment.ID != stored.MongoID.Hex() {
+		return errResourceID
+	}
+	payment.ID = stored.ID
+	return nil
+}
+
+// sendPayment sends payment as a resource or a bare payment, following the
+// Accept header.
+func sendPayment(w http.ResponseWriter, r *http.Request, payment *data.Payment) {
+	if !acceptsJSONAPI(r) {
+		SendJson(w, payment)
+		return
+	}
+	resource := newResource(payment)
+	sendWithType(w, http.StatusOK, jsonAPIType, resourceDocument{Data: resource, Links: resource.Links})
+}
+
+// sendStatus sends a status message, in the meta of a document for JSON:API
+// clients.
+func sendStatus(w http.ResponseWriter, r *http.Request, status string) {
+	if !acceptsJSONAPI(r) {
+		SendJson(w, Response{"status": status})
+		return
+	}
+	sendDocument(w, http.StatusOK, document{Meta: Response{"status": status}})
+}
+
+// sendError sends a client error with status.
+func sendError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	if !acceptsJSONAPI(r) {
+		SendJsonWithStatus(w, status, errorResponse(r, err))
+		return
+	}
+	sendDocument(w, status, errorDocument(r, status, err))
+}
+
+// sendDBError sends an error of the database. Legacy clients get it with 200,
+// as they always did, JSON:API clients with 404 when a payment is not found
+// and 500 otherwise.
+func sendDBError(w http.ResponseWriter, r *http.Request, err error) {
+	if !acceptsJSONAPI(r) {
+		SendJson(w, errorResponse(r, err))
+		return
+	}
+	status := http.StatusInternalServerError
+	if err == mgo.ErrNotFound {
+		status = http.StatusNotFound
+	}
+	sendDocument(w, status, errorDocument(r, status, err))
+}
+
+func errorDocument(r *http.Request, status int, err error) document {
+	e := apiError{Status: strconv.Itoa(status), Title: http.StatusText(status), Detail: err.Error()}
+	if id := trace.RequestID(r.Context()); id != "" {
+		e.Meta = Response{"request_id": id}
+	}
+	return document{Errors: []apiError{e}}
+}
+
+func sendDocument(w http.ResponseWriter, status int, doc document) {
+	sendWithType(w, status, jsonAPIType, doc)
+}
+
+// writeResources writes payment and the rest of iter as the resources of a
+// JSON:API document, with the count of payments in its meta. A full page of
+// limit payments links to the next page.
+func writeResources(w http.ResponseWriter, r *http.Request, iter data.PaymentIter, payment *data.Payment, limit int) error {
+	if _, err := io.WriteString(w, `{"data":`); err != nil {
+		iter.Close()
+		return err
+	}
+	var last bson.ObjectId
+	count, err := writePayments(w, iter, payment, func(p *data.Payment) interface{} {
+		last = p.MongoID
+		return newResource(p)
+	})
+	if err != nil {
+		return err
+	}
+	page := &links{Self: r.URL.RequestURI()}
+	if limit > 0 && count == limit {
+		query := r.URL.Query()
+		query.Set("after", last.Hex())
+		page.Next = r.URL.Path + "?" + query.Encode()
+	}
+	tail, err := json.Marshal(document{Links: page, Meta: Response{"count": count}})
+	if err != nil {
+		return err
+	}
+	// the tail document is spliced after the data
+	_, err = w.Write(append([]byte{','}, tail[1:]...))
+	return err
+}
@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	data "github.com/form3/data"
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func serveJSONAPI(app *App, method, url, body string) *httptest.ResponseRecorder {
+	r := mux.NewRouter()
+	r.HandleFunc("/payments", app.GetAllPayments).Methods("GET")
+	r.HandleFunc("/payments/{id}", app.GetPayment).Methods("GET")
+	r.HandleFunc("/payments", app.CreatePayment).Methods("POST")
+	r.HandleFunc("/payments/{id}", app.UpdatePayment).Methods("PUT")
+	r.HandleFunc("/payments/{id}", app.DeletePayment).Methods("DELETE")
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+	req.Header.Set("Accept", "application/json, application/vnd.api+json")
+	if body != "" {
+		req.Header.Set("Content-Type", jsonAPIType)
+	}
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestGetPaymentJSONAPI(t *testing.T) {
+	payment := messagePayment()
+	payment.Status = data.PaymentReconciled
+	app := &App{db: &messageDB{payments: []data.Payment{payment}}}
+
+	rec := serveJSONAPI(app, "GET", "/payments/5b290f5b802b0f1479000002", "")
+	if contentType := rec.Header().Get("Content-Type"); rec.Code != http.StatusOK || contentType != jsonAPIType {
+		t.Fatalf("expected a JSON:API document got %v %v", rec.Code, contentType)
+	}
+	expected := `{"data":{"type":"Payment","id":"4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43","version":0,` +
+		`"organisation_id":"743d5b63-8e6f-432e-a8fa-c5d8d2ee5fcb","attributes":{"amount":100.21,` +
+		`"beneficiary_party":{"account_number":"31926819","bank_id":"403000","bank_id_code":"GBDSC","name":"Wilfred Jeremiah Owens"},` +
+		`"charges_information":{},"currency":"GBP",` +
+		`"debtor_party":{"account_number":"GB29XABC10161234567801","account_number_code":"IBAN","bank_id":"203301","bank_id_code":"GBDSC","name":"Emelia Jane Brown"},` +
+		`"end_to_end_reference":"Wil piano Jan","fx":{},"processing_date":"2017-01-18","sponsor_party":{}},` +
+		`"meta":{"status":"reconciled"},"links":{"self":"/payments/5b290f5b802b0f1479000002"}},` +
+		`"links":{"self":"/payments/5b290f5b802b0f1479000002"}}`
+	if obtained := rec.Body.String(); expected != obtained {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, obtained)
+	}
+}
+
+func TestGetPaymentJSONAPINotFound(t *testing.T) {
+	app := &App{db: &messageDB{}}
+
+	rec := serveJSONAPI(app, "GET", "/payments/5b290f5b802b0f1479000009", "")
+	expected := `{"errors":[{"status":"404","title":"Not Found","detail":"not found"}]}`
+	if obtained := rec.Body.String(); rec.Code != http.StatusNotFound || expected != obtained {
+		t.Errorf("\n...expected = %v\n...obtained = %v %v", expected, rec.Code, obtained)
+	}
+}
+
+func TestGetAllPaymentsJSONAPI(t *testing.T) {
+	second := messagePayment()
+	second.MongoID, second.ID = bson.ObjectIdHex("5b290f5b802b0f1479000003"), "216d4da9-e59a-4cc6-8df3-3da6e7580b77"
+	app := &App{db: &messageDB{payments: []data.Payment{messagePayment(), second}}}
+
+	rec := serveJSONAPI(app, "GET", "/payments?currency=GBP", "")
+	var doc struct {
+		Data  []paymentResource `json:"data"`
+		Links links             `json:"links"`
+		Meta  Response          `json:"meta"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("Didn't expect error %v in %v", err, rec.Body.String())
+	}
+	if len(doc.Data) != 2 || doc.Data[1].ID != second.ID || doc.Data[1].Links == nil || doc.Data[1].Links.Self != "/payments/5b290f5b802b0f1479000003" {
+		t.Errorf("expected the 2 payments as resources, got %+v", doc.Data)
+	}
+	if doc.Links.Self != "/payments?currency=GBP" || doc.Meta["count"] != float64(2) {
+		t.Errorf("unexpected links %+v and meta %+v", doc.Links, doc.Meta)
+	}
+
+	rec = serveJSONAPI(app, "GET", "/payments?limit=1", "")
+	if !strings.HasSuffix(rec.Body.String(), `"links":{"self":"/payments?limit=1","next":"/payments?after=5b290f5b802b0f1479000002\u0026limit=1"},"meta":{"count":1}}`) {
+		t.Errorf("expected a link to the next page, got %v", rec.Body.String())
+	}
+	rec = serveJSONAPI(app, "GET", "/payments?limit=1&after=5b290f5b802b0f1479000002", "")
+	if !strings.Contains(rec.Body.String(), `"id":"216d4da9-e59a-4cc6-8df3-3da6e7580b77"`) {
+		t.Errorf("expected the second payment, got %v", rec.Body.String())
+	}
+	// a full page may be the last one
+	rec = serveJSONAPI(app, "GET", "/payments?after=5b290f5b802b0f1479000003&limit=1", "")
+	if !strings.HasPrefix(rec.Body.String(), `{"data":[],`) {
+		t.Errorf("expected an empty page, got %v", rec.Body.String())
+	}
+
+	rec = serveJSONAPI(app, "GET", "/payments?currency=EUR", "")
+	expected := `{"data":[],"links":{"self":"/payments?currency=EUR"},"meta":{"count":0}}`
+	if obtained := rec.Body.String(); expected != obtained {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, obtained)
+	}
+
+	rec = serveJSONAPI(app, "GET", "/payments?processing_date_to=tomorrow", "")
+	if rec.Code != http.StatusBadRequest || !strings.HasPrefix(rec.Body.String(), `{"errors":[{"status":"400"`) {
+		t.Errorf("expected a 400 error document got %v %v", rec.Code, rec.Body.String())
+	}
+}
+
+func TestCreatePaymentJSONAPI(t *testing.T) {
+	app := &App{db: &mockDB{}}
+
+	body := `{"data":{"type":"Payment","id":"4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43","version":1,` +
+		`"organisation_id":"743d5b63-8e6f-432e-a8fa-c5d8d2ee5fcb","attributes":{"amount":100.21,"currency":"GBP"}}}`
+	rec := serveJSONAPI(app, "POST", "/payments", body)
+	var doc struct {
+		Data paymentResource `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	resource := doc.Data
+	if resource.ID != "4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43" || resource.Version != 1 || resource.Attributes.Amount != 100.21 ||
+		resource.Links == nil || resource.Links.Self != "/payments/5b2ce1c5c089711b0e3bc2fa" {
+		t.Errorf("expected the created payment, got %+v", resource)
+	}
+
+	rec = serveJSONAPI(app, "POST", "/payments", `{"id":"4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43"}`)
+	expected := `{"errors":[{"status":"400","title":"Bad Request","detail":"the document has no data"}]}`
+	if obtained := rec.Body.String(); rec.Code != http.StatusBadRequest || expected != obtained {
+		t.Errorf("\n...expected = %v\n...obtained = %v %v", expected, rec.Code, obtained)
+	}
+}
+
+func TestUpdateAndDeletePaymentJSONAPI(t *testing.T) {
+	app := &App{db: &mockDB{}}
+
+	rec := serveJSONAPI(app, "PUT", "/payments/5b290f5b802b0f1479000002", `{"data":{"type":"Payment","version":2,"attributes":{"amount":5}}}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"version":2,"attributes":{"amount":5,`) {
+		t.Errorf("expected the updated payment got %v %v", rec.Code, rec.Body.String())
+	}
+	// the resource id is the id of the payment, the URL has its mongo id
+	for _, id := range []string{"4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43", "5b290f5b802b0f1479000002"} {
+		rec = serveJSONAPI(app, "PUT", "/payments/5b290f5b802b0f1479000002", `{"data":{"type":"Payment","id":"`+id+`"}}`)
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"id":"4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43"`) {
+			t.Errorf("%v: expected the updated payment got %v %v", id, rec.Code, rec.Body.String())
+		}
+	}
+	rec = serveJSONAPI(app, "PUT", "/payments/5b290f5b802b0f1479000002", `{"data":{"type":"Payment","id":"another"}}`)
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), errResourceID.Error()) {
+		t.Errorf("expected status 409 got %v %v", rec.Code, rec.Body.String())
+	}
+	rec = serveJSONAPI(app, "PUT", "/payments/5b290f5b802b0f1479000009", `{"data":{"type":"Payment"}}`)
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500 got %v", rec.Code)
+	}
+
+	rec = serveJSONAPI(app, "DELETE", "/payments/5b290f5b802b0f1479000002", "")
+	expected := `{"meta":{"status":"deleted"}}`
+	if obtained := rec.Body.String(); expected != obtained {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, obtained)
+	}
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	data "github.com/form3/data"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// generatedIter yields n copies of a payment like a cursor would, without
+// holding them in memory.
+type generatedIter struct {
+	n   int
+	err error
+}
+
+func (it *generatedIter) Next(payment *data.Payment) bool {
+	if it.n == 0 {
+		return false
+	}
+	it.n--
+	*payment = data.Payment{
+		MongoID:        bson.NewObjectId(),
+		ID:             "4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43",
+		Type:           "Payment",
+		OrganisationID: "743d5b63-8e6f-432e-a8fa-c5d8d2ee5fcb",
+		Attributes: data.Attributes{
+			Amount:            100.21,
+			Currency:          "GBP",
+			EndToEndReference: "Wil piano Jan",
+			PaymentScheme:     "FPS",
+			ProcessingDate:    "2017-01-18",
+			BeneficiaryParty:  data.Account{AccountName: "W Owens", AccountNumber: "31926819", BankID: "403000"},
+			DebtorParty:       data.Account{AccountName: "EJ Brown Black", AccountNumber: "GB29XABC10161234567801", BankID: "203301"},
+		},
+	}
+	return true
+}
+
+func (it *generatedIter) Close() error {
+	return it.err
+}
+
+type generatedDB struct {
+	mockDB
+	n   int
+	err error
+}
+
+func (g *generatedDB) StreamPayments(ctx context.Context, filter data.PaymentFilter) (data.PaymentIter, error) {
+	return &generatedIter{n: g.n, err: g.err}, nil
+}
+
+func TestGetAllPaymentsBatches(t *testing.T) {
+	app := &App{db: &generatedDB{n: 2*listBatchSize + 1}}
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/payments", &bytes.Buffer{})
+	app.GetAllPayments(rec, req)
+
+	var payments []data.Payment
+	if err := json.Unmarshal(rec.Body.Bytes(), &payments); err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	if len(payments) != 2*listBatchSize+1 {
+		t.Errorf("expected %v payments got %v", 2*listBatchSize+1, len(payments))
+	}
+	if !rec.Flushed {
+		t.Errorf("expected the response to be flushed between batches")
+	}
+}
+
+func TestGetAllPaymentsCursorError(t *testing.T) {
+	app := &App{db: &generatedDB{n: 1, err: errors.New("cursor not found")}}
+
+	defer func() {
+		if r := recover(); r != http.ErrAbortHandler {
+			t.Errorf("expected the response to be aborted, got %v", r)
+		}
+	}()
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/payments", &bytes.Buffer{})
+	app.GetAllPayments(rec, req)
+}
+
+func TestGetAllPaymentsFirstReadError(t *testing.T) {
+	app := &App{db: &generatedDB{err: errors.New("cursor not found")}}
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/payments", &bytes.Buffer{})
+	app.GetAllPayments(rec, req)
+
+	expected := `{"status":"cursor not found"}`
+	if expected != rec.Body.String() {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, rec.Body.String())
+	}
+}
+
+// discardWriter is a ResponseWriter that does not keep the body, so the
+// benchmarks only measure the memory used to produce it.
+type discardWriter struct {
+	header http.Header
+}
+
+func (d *discardWriter) Header() http.Header {
+	return d.header
+}
+
+func (d *discardWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (d *discardWriter) WriteHeader(int) {}
+
+func benchmarkList(b *testing.B, n int, list func(w http.ResponseWriter, r *http.Request, db *generatedDB)) {
+	db := &generatedDB{n: n}
+	req, _ := http.NewRequest("GET", "/payments", &bytes.Buffer{})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		list(&discardWriter{header: http.Header{}}, req, db)
+	}
+}
+
+// marshalAll is the list implementation before streaming: every payment is
+// loaded with All() and the slice is marshalled at once.
+func marshalAll(w http.ResponseWriter, r *http.Request, db *generatedDB) {
+	iter, _ := db.StreamPayments(r.Context(), data.PaymentFilter{})
+	var payments []data.Payment
+	var payment data.Payment
+	for iter.Next(&payment) {
+		payments = append(payments, payment)
+	}
+	SendJson(w, payments)
+}
+
+func stream(w http.ResponseWriter, r *http.Request, db *generatedDB) {
+	(&App{db: db}).GetAllPayments(w, r)
+}
+
+func BenchmarkListMarshalAll1000(b *testing.B)   { benchmarkList(b, 1000, marshalAll) }
+func BenchmarkListMarshalAll10000(b *testing.B)  { benchmarkList(b, 10000, marshalAll) }
+func BenchmarkListMarshalAll100000(b *testing.B) { benchmarkList(b, 100000, marshalAll) }
+func BenchmarkListStream1000(b *testing.B)       { benchmarkList(b, 1000, stream) }
+func BenchmarkListStream10000(b *testing.B)      { benchmarkList(b, 10000, stream) }
+func BenchmarkListStream100000(b *testing.B)     { benchmarkList(b, 100000, stream) }
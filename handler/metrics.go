@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	data "github.com/form3/data"
+	"github.com/form3/metrics"
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	httpRequests = metrics.NewCounterVec("form3_http_requests_total",
+		"Number of HTTP requests by route, method and status code.", "route", "method", "code")
+	httpDuration = metrics.NewHistogramVec("form3_http_request_duration_seconds",
+		"Latency of HTTP requests by route, method and status code.", metrics.DefBuckets, "route", "method", "code")
+	providerDuration = metrics.NewHistogramVec("form3_payment_provider_duration_seconds",
+		"Latency of PaymentProvider calls by method and result.", metrics.DefBuckets, "method", "result")
+	paymentsCreated = metrics.NewCounterVec("form3_payments_created_total",
+		"Number of payments created by payment scheme and currency.", "scheme", "currency")
+)
+
+// otherLabel replaces the label values clients send outside the known ones,
+// bounding the number of series.
+const otherLabel = "other"
+
+var (
+	knownMethods = labelSet("GET POST PUT PATCH DELETE HEAD OPTIONS")
+	knownSchemes = labelSet("BACS CHAPS FPS SEPA SEPAINSTANT SWIFT")
+	// knownCurrencies are the active ISO 4217 codes
+	knownCurrencies = labelSet("" +
+		"AED AFN ALL AMD ANG AOA ARS AUD AWG AZN BAM BBD BDT BGN BHD BIF BMD BND BOB BRL BSD BTN BWP BYN BZD " +
+		"CAD CDF CHF CLP CNY COP CRC CUP CVE CZK DJF DKK DOP DZD EGP ERN ETB EUR FJD FKP GBP GEL GHS GIP GMD " +
+		"GNF GTQ GYD HKD HNL HTG HUF IDR ILS INR IQD IRR ISK JMD JOD JPY KES KGS KHR KMF KPW KRW KWD KYD KZT " +
+		"LAK LBP LKR LRD LSL LYD MAD MDL MGA MKD MMK MNT MOP MRU MUR MVR MWK MXN MYR MZN NAD NGN NIO NOK NPR " +
+		"NZD OMR PAB PEN PGK PHP PKR PLN PYG QAR RON RSD RUB RWF SAR SBD SCR SDG SEK SGD SHP SLE SOS SRD SSP " +
+		"STN SVC SYP SZL THB TJS TMT TND TOP TRY TTD TWD TZS UAH UGX USD UYU UZS VES VND VUV WST XAF XCD XOF " +
+		"XPF YER ZAR ZMW ZWL")
+)
+
+func labelSet(values string) map[string]bool {
+	set := map[string]bool{}
+	for _, v := range strings.Fields(values) {
+		set[v] = true
+	}
+	return set
+}
+
+// countCreated counts a created payment, by its scheme and currency when
+// known.
+func countCreated(payment *data.Payment) {
+	scheme, currency := payment.Attributes.PaymentScheme, payment.Attributes.Currency
+	if !knownSchemes[scheme] {
+		scheme = otherLabel
+	}
+	if !knownCurrencies[currency] {
+		currency = otherLabel
+	}
+	paymentsCreated.Inc(scheme, currency)
+}
+
+// Metrics records the request count and latency of every request served by
+// router, labelled with the route template rather than the raw path and with
+// the standard methods only.
+func Metrics(router *mux.Router) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := newStatusWriter(w)
+			next.ServeHTTP(sw, r)
+
+			route := "unmatched"
+			var match mux.RouteMatch
+			if router.Match(r, &match) {
+				if tpl, err := match.Route.GetPathTemplate(); err == nil {
+					route = tpl
+				}
+			}
+			method := r.Method
+			if !knownMethods[method] {
+				method = otherLabel
+			}
+			code := strconv.Itoa(sw.Status())
+			httpRequests.Inc(route, method, code)
+			httpDuration.Observe(time.Since(start).Seconds(), route, method, code)
+		})
+	}
+}
+
+// RegisterMongoStats enables the mgo driver statistics and exposes them.
+func RegisterMongoStats() {
+	mgo.SetStats(true)
+	stat := func(field func(mgo.Stats) int) func() float64 {
+		return func() float64 { return float64(field(mgo.GetStats())) }
+	}
+	metrics.NewGaugeFunc("form3_mongo_clusters", "Number of mongo clusters known by the driver.",
+		stat(func(s mgo.Stats) int { return s.Clusters }))
+	metrics.NewGaugeFunc("form3_mongo_master_conns", "Number of connections to master servers.",
+		stat(func(s mgo.Stats) int { return s.MasterConns }))
+	metrics.NewGaugeFunc("form3_mongo_slave_conns", "Number of connections to slave servers.",
+		stat(func(s mgo.Stats) int { return s.SlaveConns }))
+	metrics.NewCounterFunc("form3_mongo_sent_ops_total", "Number of operations sent to mongo.",
+		stat(func(s mgo.Stats) int { return s.SentOps }))
+	metrics.NewCounterFunc("form3_mongo_received_ops_total", "Number of replies received from mongo.",
+		stat(func(s mgo.Stats) int { return s.ReceivedOps }))
+	metrics.NewCounterFunc("form3_mongo_received_docs_total", "Number of documents received from mongo.",
+		stat(func(s mgo.Stats) int { return s.ReceivedDocs }))
+	metrics.NewGaugeFunc("form3_mongo_sockets_alive", "Number of sockets open to mongo.",
+		stat(func(s mgo.Stats) int { return s.SocketsAlive }))
+	metrics.NewGaugeFunc("form3_mongo_sockets_in_use", "Number of sockets reserved by sessions.",
+		stat(func(s mgo.Stats) int { return s.SocketsInUse }))
+	metrics.NewGaugeFunc("form3_mongo_socket_refs", "Number of session references to sockets.",
+		stat(func(s mgo.Stats) int { return s.SocketRefs }))
+}
+
+// instrumentedProvider times every call made to the wrapped provider and
+// counts the payments created.
+type instrumentedProvider struct {
+	next data.PaymentProvider
+}
+
+func observe(method string, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	providerDuration.Observe(time.Since(start).Seconds(), method, result)
+}
+
+// StreamPayments observes the time to open the cursor, not to iterate it.
+func (p *instrumentedProvider) StreamPayments(ctx context.Context, filter data.PaymentFilter) (iter data.PaymentIter, err error) {
+	defer func(start time.Time) { observe("StreamPayments", start, err) }(time.Now())
+	return p.next.StreamPayments(ctx, filter)
+}
+
+func (p *instrumentedProvider) ListPaymentID(ctx context.Context, id bson.ObjectId) (payment *data.Payment, err error) {
+	defer func(start time.Time) { observe("ListPaymentID", start, err) }(time.Now())
+	return p.next.ListPaymentID(ctx, id)
+}
+
+func (p *instrumentedProvider) CreatePayment(ctx context.Context, payment data.Payment) (created *data.Payment, err error) {
+	defer func(start time.Time) { observe("CreatePayment", start, err) }(time.Now())
+	created, err = p.next.CreatePayment(ctx, payment)
+	if err == nil {
+		countCreated(created)
+	}
+	return
+}
+
+func (p *instrumentedProvider) CreatePayments(ctx context.Context, payments []data.Payment, ordered bool) (results []data.BatchResult, err error) {
+	defer func(start time.Time) { observe("CreatePayments", start, err) }(time.Now())
+	results, err = p.next.CreatePayments(ctx, payments, ordered)
+	for _, result := range results {
+		if result.Err == nil {
+			countCreated(result.Payment)
+		}
+	}
+	return
+}
+
+func (p *instrumentedProvider) RemovePayment(ctx context.Context, id bson.ObjectId) (err error) {
+	defer func(start time.Time) { observe("RemovePayment", start, err) }(time.Now())
+	return p.next.RemovePayment(ctx, id)
+}
+
+func (p *instrumentedProvider) UpdatePayment(ctx context.Context, payment data.Payment) (updated *data.Payment, err error) {
+	defer func(start time.Time) { observe("UpdatePayment", start, err) }(time.Now())
+	return p.next.UpdatePayment(ctx, payment)
+}
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	data "github.com/form3/data"
+	"github.com/gorilla/mux"
+)
+
+func paymentForScheme(scheme, currency string) data.Payment {
+	return data.Payment{Attributes: data.Attributes{PaymentScheme: scheme, Currency: currency}}
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	router := mux.NewRouter()
+	app := &App{db: &instrumentedProvider{next: &mockDB{}}}
+	router.HandleFunc("/payments/{id}", app.GetPayment).Methods("GET")
+
+	before := httpRequests.Value("/payments/{id}", "GET", "200")
+	beforeProvider := providerDuration.Count("ListPaymentID", "ok")
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/payments/5b290f5b802b0f1479000002", &bytes.Buffer{})
+	Chain(router, Metrics(router)).ServeHTTP(rec, req)
+
+	if got := httpRequests.Value("/payments/{id}", "GET", "200"); got != before+1 {
+		t.Errorf("expected request counter %v, got %v", before+1, got)
+	}
+	if got := providerDuration.Count("ListPaymentID", "ok"); got != beforeProvider+1 {
+		t.Errorf("expected provider observations %v, got %v", beforeProvider+1, got)
+	}
+}
+
+func TestMetricsUnmatchedRoute(t *testing.T) {
+	router := mux.NewRouter()
+
+	before := httpRequests.Value("unmatched", "GET", "404")
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/nothing/here", &bytes.Buffer{})
+	Chain(router, Metrics(router)).ServeHTTP(rec, req)
+
+	if got := httpRequests.Value("unmatched", "GET", "404"); got != before+1 {
+		t.Errorf("expected request counter %v, got %v", before+1, got)
+	}
+}
+
+func TestMetricsMethodLabelsBounded(t *testing.T) {
+	router := mux.NewRouter()
+
+	before := httpRequests.Value("unmatched", "other", "404")
+
+	for _, method := range []string{"FOO", "BAR"} {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, "/nothing/here", &bytes.Buffer{})
+		Chain(router, Metrics(router)).ServeHTTP(rec, req)
+	}
+
+	if got := httpRequests.Value("unmatched", "other", "404"); got != before+2 {
+		t.Errorf("expected request counter %v, got %v", before+2, got)
+	}
+	if got := httpRequests.Value("unmatched", "FOO", "404"); got != 0 {
+		t.Errorf("expected no series for an unknown method, got %v", got)
+	}
+}
+
+func TestPaymentsCreatedCounter(t *testing.T) {
+	provider := &instrumentedProvider{next: &mockDB{}}
+	payment := paymentForScheme("FPS", "GBP")
+
+	before := paymentsCreated.Value("FPS", "GBP")
+	if _, err := provider.CreatePayment(context.Background(), payment); err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	if got := paymentsCreated.Value("FPS", "GBP"); got != before+1 {
+		t.Errorf("expected payments created %v, got %v", before+1, got)
+	}
+}
+
+func TestPaymentsCreatedLabelsBounded(t *testing.T) {
+	provider := &instrumentedProvider{next: &mockDB{}}
+	before := paymentsCreated.Value("other", "other")
+	for _, payment := range []data.Payment{paymentForScheme("x1", "GBX"), paymentForScheme("FPS\n", "gbp")} {
+		if _, err := provider.CreatePayment(context.Background(), payment); err != nil {
+			t.Fatalf("Didn't expect error %v", err)
+		}
+	}
+	if got := paymentsCreated.Value("other", "other"); got != before+2 {
+		t.Errorf("expected payments created %v, got %v", before+2, got)
+	}
+}
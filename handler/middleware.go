@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/form3/logging"
+	"github.com/form3/trace"
+)
+
+const (
+	RequestIDHeader   = "X-Request-ID"
+	TraceparentHeader = "traceparent"
+)
+
+// Middleware wraps an http.Handler with extra behaviour.
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps h with the middlewares, the first one being the outermost.
+func Chain(h http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// RequestID accepts the caller's X-Request-ID or generates one, continues the
+// W3C trace given in traceparent and echoes both in the response headers.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := trace.New(r.Header.Get(RequestIDHeader), r.Header.Get(TraceparentHeader))
+		w.Header().Set(RequestIDHeader, info.RequestID)
+		w.Header().Set(TraceparentHeader, info.Traceparent())
+		next.ServeHTTP(w, r.WithContext(trace.NewContext(r.Context(), info)))
+	})
+}
+
+// Logging attaches a logger carrying the request fields to the request
+// context and logs every request once it has been served.
+func Logging(logger *logging.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			fields := logging.Fields{
+				"method": r.Method,
+				"path":   r.URL.Path,
+				"remote": r.RemoteAddr,
+			}
+			if info, ok := trace.FromContext(r.Context()); ok {
+				fields["request_id"] = info.RequestID
+				fields["trace_id"] = info.TraceID
+				fields["span_id"] = info.SpanID
+			}
+			reqLogger := logger.With(fields)
+			sw := newStatusWriter(w)
+			next.ServeHTTP(sw, r.WithContext(logging.NewContext(r.Context(), reqLogger)))
+			reqLogger.Info("request", logging.Fields{
+				"status":      sw.Status(),
+				"size":        sw.size,
+				"duration_ms": float64(time.Since(start)) / float64(time.Millisecond),
+			})
+		})
+	}
+}
+
+// statusWriter records the status code and size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func newStatusWriter(w http.ResponseWriter) *statusWriter {
+	return &statusWriter{ResponseWriter: w}
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+// Flush lets streaming handlers flush through the wrapper.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Status returns the status code written, 200 if the handler never wrote one.
+func (w *statusWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
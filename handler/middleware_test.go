@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/form3/logging"
+	"github.com/gorilla/mux"
+)
+
+func TestRequestIDEchoed(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/payments/5b290f5b802b0f1479000003", &bytes.Buffer{})
+	req.Header.Set("X-Request-ID", "customer-ref-1")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	router := mux.NewRouter()
+	app := &App{db: &mockDB{}}
+	router.HandleFunc("/payments/{id}", app.GetPayment).Methods("GET")
+
+	Chain(router, RequestID).ServeHTTP(rec, req)
+
+	if id := rec.Header().Get("X-Request-ID"); id != "customer-ref-1" {
+		t.Errorf("expected request ID echoed, got %v", id)
+	}
+	if tp := rec.Header().Get("traceparent"); !strings.HasPrefix(tp, "00-4bf92f3577b34da6a3ce929d0e0e4736-") {
+		t.Errorf("expected trace to be continued, got %v", tp)
+	}
+
+	expected := `{"request_id":"customer-ref-1","status":"not found"}`
+
+	if expected != rec.Body.String() {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, rec.Body.String())
+	}
+}
+
+func TestRequestIDGenerated(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/healthz", &bytes.Buffer{})
+
+	Chain(http.HandlerFunc(NewHealth().Liveness), RequestID).ServeHTTP(rec, req)
+
+	if id := rec.Header().Get("X-Request-ID"); len(id) != 32 {
+		t.Errorf("expected generated request ID, got %q", id)
+	}
+}
+
+func TestLoggingRequestFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(&buf, logging.InfoLevel)
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/healthz", &bytes.Buffer{})
+	req.Header.Set("X-Request-ID", "customer-ref-2")
+
+	Chain(http.HandlerFunc(NewHealth().Liveness), RequestID, Logging(logger)).ServeHTTP(rec, req)
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	if record["request_id"] != "customer-ref-2" || record["path"] != "/healthz" || record["status"] != float64(200) {
+		t.Errorf("unexpected access log record %v", record)
+	}
+}
@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/form3/logging"
+	"github.com/form3/swift"
+)
+
+// Get a payment as the text block of an MT103 single customer credit transfer
+func (a *App) GetPaymentMT103(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	payment := a.payment(w, r)
+	if payment == nil {
+		return
+	}
+	message, err := swift.WriteMT103(*payment)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("Could not convert payment", logging.Fields{"payment": payment.MongoID.Hex(), "error": err})
+		response := errorResponse(r, errors.New("payment cannot be converted"))
+		response["errors"] = err.Error()
+		SendJsonWithStatus(w, http.StatusUnprocessableEntity, response)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=us-ascii")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, message)
+}
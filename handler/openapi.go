@@ -0,0 +1,363 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	data "github.com/form3/data"
+	"github.com/form3/logging"
+	"github.com/form3/openapi"
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	// objectID is the schema of the mongo ids of payments and jobs in paths
+	objectID = &openapi.Schema{Type: "string", Pattern: "^[0-9a-fA-F]{24}$"}
+	// bsonObjectID is the schema of bson.ObjectId values, empty in the
+	// payments sent to be created
+	bsonObjectID = &openapi.Schema{Type: "string", Pattern: "^([0-9a-fA-F]{24})?$"}
+	text         = &openapi.Schema{Type: "string"}
+	binary       = &openapi.Schema{Type: "string", Format: "binary"}
+	date         = &openapi.Schema{Type: "string", Format: "date"}
+	one          = 1.0
+
+	pathVar = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+	methods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+)
+
+// operation describes a route of the service in its OpenAPI document.
+type operation struct {
+	id      string
+	summary string
+	// parameters are the query and header parameters
+	parameters []openapi.Parameter
+	body       content
+	// status is the status of a successful response, 200 when not set
+	status   int
+	response content
+}
+
+// content maps media types to a value of the Go type of their schema, or to
+// the *openapi.Schema itself.
+type content map[string]interface{}
+
+func queryParam(name, description string, schema *openapi.Schema) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+func enum(values ...string) *openapi.Schema {
+	return &openapi.Schema{Type: "string", Enum: values}
+}
+
+// params concatenates groups of parameters.
+func params(groups ...[]openapi.Parameter) []openapi.Parameter {
+	var all []openapi.Parameter
+	for _, group := range groups {
+		all = append(all, group...)
+	}
+	return all
+}
+
+var (
+	filterParams = []openapi.Parameter{
+		queryParam("organisation_id", "", text),
+		queryParam("currency", "ISO 4217 code", text),
+		queryParam("end_to_end_reference", "", text),
+		queryParam("processing_date_from", "first processing date, included", date),
+		queryParam("processing_date_to", "last processing date, included", date),
+	}
+	schemeParam = []openapi.Parameter{queryParam("payment_scheme", "", text)}
+	pageParams  = []openapi.Parameter{
+		queryParam("after", "id of the last payment of the previous page", objectID),
+		queryParam("limit", "number of payments of a page, all by default", &openapi.Schema{Type: "integer", Minimum: &one}),
+	}
+	idempotencyKey = []openapi.Parameter{{Name: IdempotencyKeyHeader, In: "header",
+		Description: "replays the response of the first request sent with the key for 24 hours", Schema: text}}
+	reconciledParam = []openapi.Parameter{queryParam("reconciled", "reconciled payments when true, the others when false", &openapi.Schema{Type: "boolean"})}
+	messageParams   = []openapi.Parameter{
+		queryParam("message_id", "message identification, a new object id by default", text),
+		queryParam("initiating_party", "name of the sender", text),
+	}
+	settlementParam = []openapi.Parameter{queryParam("settlement_method", "CLRG by default", enum("INDA", "INGA", "COVE", "CLRG"))}
+	bacsParams      = []openapi.Parameter{
+		queryParam("service_user_number", "", &openapi.Schema{Type: "string", Pattern: "^[0-9]{6}$"}),
+		queryParam("service_user_name", "", text),
+		queryParam("volume_serial", "000001 by default", text),
+	}
+
+	paymentBody      = content{"application/json": data.Payment{}, jsonAPIType: resourceDocument{}}
+	paymentsResponse = content{"application/json": []data.Payment{}, jsonAPIType: resourcesDocument{}}
+	xmlBody          = content{xmlType: text, "text/xml": text}
+	errorContent     = content{"application/json": Response{}, jsonAPIType: document{}}
+)
+
+// operations describes the routes of the service by method and path template.
+var operations = map[string]operation{
+	"GET /healthz":      {id: "Liveness", summary: "Report the process is alive", response: content{"application/json": HealthReport{}}},
+	"GET /readyz":       {id: "Readiness", summary: "Check the service can serve requests", response: content{"application/json": HealthReport{}}},
+	"GET /metrics":      {id: "Metrics", summary: "Prometheus metrics", response: content{"text/plain": text}},
+	"GET /openapi.json": {id: "OpenAPI", summary: "This document", response: content{"application/json": &openapi.Schema{Type: "object"}}},
+
+	"GET /payments": {id: "GetAllPayments", summary: "List the filtered payments",
+		parameters: params(filterParams, schemeParam, reconciledParam, pageParams), response: paymentsResponse},
+	"GET /payments/export": {id: "ExportPayments", summary: "Export the filtered payments as CSV or NDJSON",
+		parameters: params(filterParams, schemeParam, reconciledParam, []openapi.Parameter{
+			queryParam("format", "export format, else chosen by the Accept header", enum("csv", "ndjson")),
+			queryParam("columns", "comma separated dotted JSON paths of the exported fields", text),
+		}),
+		response: content{csvType: text, ndjsonType: text}},
+	"GET /payments/export/pain001": {id: "ExportPain001", summary: "Export the filtered payments as a pain.001 customer credit transfer initiation",
+		parameters: params(filterParams, schemeParam, reconciledParam, messageParams), response: content{xmlType: text}},
+	"GET /payments/export/pacs008": {id: "ExportPacs008", summary: "Export the filtered payments as a pacs.008 FI to FI customer credit transfer",
+		parameters: params(filterParams, schemeParam, reconciledParam, messageParams, settlementParam), response: content{xmlType: text}},
+	"GET /payments/export/bacs": {id: "ExportBacs", summary: "Export the filtered BACS payments as a Standard 18 submission",
+		parameters: params(filterParams, reconciledParam, bacsParams), response: content{"text/plain": text}},
+	"GET /payments/{id}": {id: "GetPayment", summary: "Get a payment", response: paymentBody},
+	"GET /payments/{id}/pain001": {id: "GetPaymentPain001", summary: "Get a payment as a pain.001 message",
+		parameters: messageParams, response: content{xmlType: text}},
+	"GET /payments/{id}/pacs008": {id: "GetPaymentPacs008", summary: "Get a payment as a pacs.008 message",
+		parameters: params(messageParams, settlementParam), response: content{xmlType: text}},
+	"GET /payments/{id}/mt103": {id: "GetPaymentMT103", summary: "Get a payment as a SWIFT MT103 message", response: content{"text/plain": text}},
+	"POST /payments": {id: "CreatePayment", summary: "Create a payment",
+		parameters: idempotencyKey, body: paymentBody, response: paymentBody},
+	"POST /payments/batch": {id: "CreatePayments", summary: "Create many payments with a single bulk write",
+		parameters: idempotencyKey, body: content{"application/json": batchRequest{}, jsonAPIType: batchDocument{}}, response: content{"application/json": batchResponse{}}},
+	"POST /payments/upload": {id: "UploadPayments", summary: "Upload a CSV file of payments, created in the background",
+		body: content{csvType: text, "multipart/form-data": &openapi.Schema{Type: "object", Properties: map[string]*openapi.Schema{
+			"file": binary, "mapping": text,
+		}}},
+		status: http.StatusAccepted, response: content{"application/json": jobResponse{}}},
+	"POST /payments/import/pain001": {id: "ImportPain001", summary: "Create the payments of a pain.001 customer credit transfer initiation",
+		body: xmlBody, response: content{"application/json": importResponse{}}},
+	"POST /statements/import/camt053": {id: "ImportCamt053", summary: "Import the entries of a camt.053 statement and reconcile them",
+		body: xmlBody, response: content{"application/json": statementResponse{}}},
+	"POST /statements/import/mt940": {id: "ImportMT940", summary: "Import the entries of MT940 statements and reconcile them",
+		body: content{"text/plain": text}, response: content{"application/json": statementResponse{}}},
+	"GET /reconciliation/entries": {id: "GetUnmatchedEntries", summary: "List the statement entries not matched to a payment, oldest first",
+		parameters: []openapi.Parameter{queryParam("limit", "1000 by default", &openapi.Schema{Type: "integer", Minimum: &one})},
+		response:   content{"application/json": []data.StatementEntry{}}},
+	"GET /reconciliation/payments": {id: "GetUnmatchedPayments", summary: "List the filtered payments not reconciled",
+		parameters: params(filterParams, schemeParam, pageParams), response: paymentsResponse},
+	"GET /jobs/{id}":        {id: "GetJob", summary: "Get the status of an upload job", response: content{"application/json": jobResponse{}}},
+	"GET /jobs/{id}/errors": {id: "GetJobErrors", summary: "Download the row errors of an upload job as CSV", response: content{csvType: text}},
+	"PUT /payments/{id}":    {id: "UpdatePayment", summary: "Replace a payment", body: paymentBody, response: paymentBody},
+	"DELETE /payments/{id}": {id: "DeletePayment", summary: "Delete a payment", response: content{"application/json": Response{}, jsonAPIType: document{}}},
+}
+
+// Spec is the OpenAPI document of the routes of a router, generated on first
+// use once every route is registered.
+type Spec struct {
+	router *mux.Router
+	once   sync.Once
+	doc    *openapi.Document
+	err    error
+}
+
+func NewSpec(router *mux.Router) *Spec {
+	return &Spec{router: router}
+}
+
+// Document returns the OpenAPI document of the routes, failing when a route
+// is not described or a described operation has no route.
+func (s *Spec) Document() (*openapi.Document, error) {
+	s.once.Do(func() {
+		s.doc, s.err = generateSpec(s.router)
+	})
+	return s.doc, s.err
+}
+
+// Serve the OpenAPI document of the service
+func (s *Spec) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	doc, err := s.Document()
+	if err != nil {
+		SendJsonWithStatus(w, http.StatusInternalServerError, errorResponse(r, err))
+		return
+	}
+	SendJson(w, doc)
+}
+
+func generateSpec(router *mux.Router) (*openapi.Document, error) {
+	doc := openapi.New(openapi.Info{
+		Title:       "Form3 payments API",
+		Description: "Generated from the routes and data types of the service.",
+		Version:     "1.0",
+	})
+	doc.Components.Define(bson.ObjectId(""), bsonObjectID)
+	described := map[string]bool{}
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		routeMethods := matchedMethods(route, path)
+		if len(routeMethods) == 0 {
+			return fmt.Errorf("route %v matches none of the methods %v", path, strings.Join(methods, ", "))
+		}
+		for _, method := range routeMethods {
+			key := method + " " + path
+			op, ok := operations[key]
+			if !ok {
+				return fmt.Errorf("route %v is not described", key)
+			}
+			described[key] = true
+			doc.AddOperation(method, path, op.build(doc.Components, path))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for key := range operations {
+		if !described[key] {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("described operations have no route: %v", strings.Join(missing, ", "))
+	}
+	return doc, nil
+}
+
+// matchedMethods returns the methods accepted by route, found by matching
+// requests as the vendored mux does not expose them.
+func matchedMethods(route *mux.Route, path string) []string {
+	url := pathVar.ReplaceAllString(path, "5b290f5b802b0f1479000002")
+	var matched []string
+	for _, method := range methods {
+		var match mux.RouteMatch
+		if route.Match(httptest.NewRequest(method, url, nil), &match) {
+			matched = append(matched, method)
+		}
+	}
+	return matched
+}
+
+func (op operation) build(components *openapi.Components, path string) *openapi.Operation {
+	o := &openapi.Operation{OperationID: op.id, Summary: op.summary, Responses: map[string]*openapi.Response{}}
+	for _, v := range pathVar.FindAllStringSubmatch(path, -1) {
+		o.Parameters = append(o.Parameters, openapi.Parameter{Name: v[1], In: "path", Required: true, Schema: objectID})
+	}
+	o.Parameters = append(o.Parameters, op.parameters...)
+	if op.body != nil {
+		o.RequestBody = &openapi.RequestBody{Required: true, Content: op.body.build(components)}
+	}
+	status := op.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	o.Responses[fmt.Sprint(status)] = &openapi.Response{Description: http.StatusText(status), Content: op.response.build(components)}
+	o.Responses["default"] = &openapi.Response{Description: "Error", Content: errorContent.build(components)}
+	return o
+}
+
+func (c content) build(components *openapi.Components) map[string]openapi.MediaType {
+	media := map[string]openapi.MediaType{}
+	for mediaType, v := range c {
+		schema, ok := v.(*openapi.Schema)
+		if !ok {
+			schema = components.SchemaOf(v)
+		}
+		media[mediaType] = openapi.MediaType{Schema: schema}
+	}
+	return media
+}
+
+// DefaultMaxBodySize is the size in bytes of the JSON bodies read when no
+// limit is set.
+const DefaultMaxBodySize = 10 << 20
+
+// Validation rejects with 400 the requests whose path or query parameters or
+// JSON body do not match the operation of their route in spec, and with 413
+// the JSON bodies larger than maxBodySize bytes, DefaultMaxBodySize when not
+// positive. Query parameters not described and bodies of other media types
+// are left to the handlers.
+func Validation(spec *Spec, maxBodySize int64) Middleware {
+	if maxBodySize <= 0 {
+		maxBodySize = DefaultMaxBodySize
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			doc, err := spec.Document()
+			var match mux.RouteMatch
+			if err != nil || !spec.router.Match(r, &match) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			path, _ := match.Route.GetPathTemplate()
+			op := doc.Operation(r.Method, path)
+			if op == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if err := validateRequest(w, doc.Components, op, r, match.Vars, maxBodySize); err != nil {
+				logging.FromContext(r.Context()).Info("Invalid request", logging.Fields{"operation": op.OperationID, "error": err})
+				if _, ok := err.(*http.MaxBytesError); ok {
+					sendError(w, r, http.StatusRequestEntityTooLarge, err)
+					return
+				}
+				sendError(w, r, http.StatusBadRequest, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func validateRequest(w http.ResponseWriter, components *openapi.Components, op *openapi.Operation, r *http.Request, vars map[string]string, maxBodySize int64) error {
+	query := r.URL.Query()
+	for _, p := range op.Parameters {
+		values := query[p.Name]
+		switch p.In {
+		case "path":
+			values = []string{vars[p.Name]}
+		case "header":
+			values = r.Header[http.CanonicalHeaderKey(p.Name)]
+		}
+		for _, value := range values {
+			// the handlers ignore empty parameters
+			if value = strings.TrimSpace(value); value == "" && p.In != "path" {
+				continue
+			}
+			if err := components.ValidateString(p.Schema, p.Name, value); err != nil {
+				return fmt.Errorf("invalid %v parameter: %v", p.In, err)
+			}
+		}
+	}
+
+	// the handlers decode bodies of undeclared media types as JSON
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	schema, ok := op.Body(mediaType)
+	if !ok {
+		mediaType = "application/json"
+		schema, ok = op.Body(mediaType)
+	}
+	if !ok || !openapi.IsJSON(mediaType) {
+		return nil
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		return err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Errorf("invalid body: %v", err)
+	}
+	if err := components.Validate(schema, value); err != nil {
+		return fmt.Errorf("invalid body: %v", err)
+	}
+	return nil
+}
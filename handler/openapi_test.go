@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+var updateSpec = flag.Bool("update", false, "rewrite testdata/openapi.json from the routes")
+
+const specFile = "testdata/openapi.json"
+
+// TestOpenAPIDrift fails when the routes or the types they send change and
+// the committed specification was not regenerated with
+// go test ./handler -run OpenAPIDrift -update
+func TestOpenAPIDrift(t *testing.T) {
+	doc, err := Routes(mux.NewRouter(), NewApp(), NewHealth()).Document()
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	obtained, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	obtained = append(obtained, '\n')
+	if *updateSpec {
+		if err := ioutil.WriteFile(specFile, obtained, 0644); err != nil {
+			t.Fatalf("Didn't expect error %v", err)
+		}
+	}
+	expected, err := ioutil.ReadFile(specFile)
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	if !bytes.Equal(expected, obtained) {
+		t.Errorf("%v is out of date, regenerate it with go test ./handler -run OpenAPIDrift -update", specFile)
+	}
+}
+
+func TestOpenAPIUndescribedRoute(t *testing.T) {
+	r := mux.NewRouter()
+	Routes(r, NewApp(), NewHealth())
+	r.HandleFunc("/payments/{id}/refund", func(w http.ResponseWriter, r *http.Request) {}).Methods("POST")
+	if _, err := NewSpec(r).Document(); err == nil || err.Error() != "route POST /payments/{id}/refund is not described" {
+		t.Errorf("expected the route to be reported, got %v", err)
+	}
+
+	r = mux.NewRouter()
+	r.HandleFunc("/payments", func(w http.ResponseWriter, r *http.Request) {}).Methods("GET")
+	if _, err := NewSpec(r).Document(); err == nil || !strings.HasPrefix(err.Error(), "described operations have no route: DELETE /payments/{id}, GET /healthz") {
+		t.Errorf("expected the operations without route to be reported, got %v", err)
+	}
+}
+
+func serveValidated(method, url, contentType, body string) *httptest.ResponseRecorder {
+	r := mux.NewRouter()
+	spec := Routes(r, &App{db: &mockDB{}}, NewHealth())
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", contentType)
+	Chain(r, Validation(spec, 1024)).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestValidation(t *testing.T) {
+	for _, test := range []struct {
+		method, url, contentType, body string
+		expected                       string
+	}{
+		{"GET", "/payments?reconciled=maybe", "", "",
+			`{"status":"invalid query parameter: reconciled must be true or false, got \"maybe\""}`},
+		{"GET", "/reconciliation/entries?limit=0", "", "",
+			`{"status":"invalid query parameter: limit must be at least 1, got 0"}`},
+		{"GET", "/payments/export?format=xlsx", "", "",
+			`{"status":"invalid query parameter: format must be one of csv, ndjson, got \"xlsx\""}`},
+		{"GET", "/payments/not-an-id", "", "",
+			`{"status":"invalid path parameter: id must match ^[0-9a-fA-F]{24}$, got \"not-an-id\""}`},
+		{"POST", "/payments", "application/json", `{"attributes":{"amount":"100.21"}}`,
+			`{"status":"invalid body: attributes.amount must be a number"}`},
+		// undeclared media types are decoded as JSON by the handler
+		{"POST", "/payments", "text/plain", `{"version":1.5}`,
+			`{"status":"invalid body: version must be an integer"}`},
+		{"POST", "/payments/batch", "application/json", `{"ordered":"yes","payments":[]}`,
+			`{"status":"invalid body: ordered must be true or false"}`},
+		{"POST", "/payments", jsonAPIType, `{"data":{"type":"Payment","attributes":{"debtor_party":{"account_type":"1"}}}}`,
+			`{"status":"invalid body: data.attributes.debtor_party.account_type must be an integer"}`},
+		{"PUT", "/payments/5b290f5b802b0f1479000002", "application/json", `[]`,
+			`{"status":"invalid body: value must be an object"}`},
+		{"PUT", "/payments/5b290f5b802b0f1479000002", "application/json", `{"_id":"5b29"`,
+			`{"status":"invalid body: unexpected end of JSON input"}`},
+	} {
+		rec := serveValidated(test.method, test.url, test.contentType, test.body)
+		if obtained := strings.TrimSpace(rec.Body.String()); rec.Code != http.StatusBadRequest || test.expected != obtained {
+			t.Errorf("%v %v\n...expected = %v\n...obtained = %v %v", test.method, test.url, test.expected, rec.Code, obtained)
+		}
+	}
+}
+
+func TestValidationBodySize(t *testing.T) {
+	rec := serveValidated("POST", "/payments", "application/json", `{"attributes":{"reference":"`+strings.Repeat("x", 1024)+`"}}`)
+	expected := `{"status":"http: request body too large"}`
+	if obtained := strings.TrimSpace(rec.Body.String()); rec.Code != http.StatusRequestEntityTooLarge || expected != obtained {
+		t.Errorf("\n...expected = %v\n...obtained = %v %v", expected, rec.Code, obtained)
+	}
+}
+
+func TestValidationAccepted(t *testing.T) {
+	rec := serveValidated("POST", "/payments", "application/json", `{"_id":"","id":"4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43",`+
+		`"version":0,"unknown":true,"attributes":{"amount":100.21,"currency":"GBP","sponsor_party":null}}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"amount":100.21`) {
+		t.Errorf("expected the payment to be created, got %v %v", rec.Code, rec.Body.String())
+	}
+
+	// empty query parameters are ignored by the handlers
+	rec = serveValidated("GET", "/payments?reconciled=&currency=GBP", "", "")
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status 200 got %v %v", rec.Code, rec.Body.String())
+	}
+
+	rec = serveValidated("GET", "/openapi.json", "", "")
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil || doc.OpenAPI != "3.0.3" {
+		t.Errorf("expected the OpenAPI document, got %v %v", err, rec.Body.String())
+	}
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	data "github.com/form3/data"
+	"github.com/form3/logging"
+)
+
+type organisationKey struct{}
+
+var (
+	errUnknownClient     = errors.New("client certificate is not mapped to an organisation")
+	errOrganisationMatch = errors.New("payment belongs to another organisation")
+)
+
+// ClientOrganisation maps the common name of a verified client certificate
+// to the organisation the client acts for. Requests presenting a certificate
+// missing from subjects are rejected.
+func ClientOrganisation(subjects map[string]string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(subjects) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+			organisation, ok := subjects[cn]
+			if !ok {
+				logging.FromContext(r.Context()).Warn("Rejected client certificate", logging.Fields{"subject": cn})
+				SendJsonWithStatus(w, http.StatusForbidden, errorResponse(r, errUnknownClient))
+				return
+			}
+			ctx := context.WithValue(r.Context(), organisationKey{}, organisation)
+			ctx = logging.NewContext(ctx, logging.FromContext(ctx).With(logging.Fields{"organisation": organisation}))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Organisation returns the organisation of the client certificate of the
+// request, or an empty string.
+func Organisation(ctx context.Context) string {
+	organisation, _ := ctx.Value(organisationKey{}).(string)
+	return organisation
+}
+
+// checkOrganisation sets the organisation of a payment sent by a client
+// authenticated with a certificate, refusing payments of other organisations.
+func checkOrganisation(r *http.Request, payment *data.Payment) error {
+	organisation := Organisation(r.Context())
+	if organisation == "" {
+		return nil
+	}
+	if payment.OrganisationID == "" {
+		payment.OrganisationID = organisation
+	}
+	if payment.OrganisationID != organisation {
+		return errOrganisationMatch
+	}
+	return nil
+}
+
+// paymentFilter reads the payment filter of the request, restricted to the
+// organisation of its client certificate whatever organisation_id it asks
+// for.
+func paymentFilter(r *http.Request) (data.PaymentFilter, error) {
+	filter, err := data.ParsePaymentFilter(r.URL.Query())
+	if organisation := Organisation(r.Context()); organisation != "" {
+		filter.OrganisationID = organisation
+	}
+	return filter, err
+}
+
+// ownedBy reports if payment can be seen by the client of the request: any
+// payment without a client certificate, else the payments of its
+// organisation.
+func ownedBy(r *http.Request, payment *data.Payment) bool {
+	organisation := Organisation(r.Context())
+	return organisation == "" || payment.OrganisationID == organisation
+}
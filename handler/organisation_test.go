@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func requestWithClientCert(body, cn string) *http.Request {
+	return clientCertRequest("POST", "/payments", body, cn)
+}
+
+func clientCertRequest(method, url, body, cn string) *http.Request {
+	req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{
+		{Subject: pkix.Name{CommonName: cn}},
+	}}}
+	return req
+}
+
+func TestClientOrganisationSetsPaymentOrganisation(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := requestWithClientCert(`{"type": "Payment"}`, "payroll")
+
+	app := &App{db: &mockDB{}}
+	subjects := map[string]string{"payroll": "743d5b63-8e6f-432e-a8fa-c5d8d2ee5fcb"}
+	Chain(http.HandlerFunc(app.CreatePayment), ClientOrganisation(subjects)).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%+v != %+v", rec.Code, http.StatusOK)
+	}
+
+	expected := `{"_id":"5b2ce1c5c089711b0e3bc2fa","type":"Payment","version":0,"organisation_id":"743d5b63-8e6f-432e-a8fa-c5d8d2ee5fcb","attributes":{"beneficiary_party":{},"charges_information":{},"debtor_party":{},"fx":{},"sponsor_party":{}}}`
+
+	if expected != rec.Body.String() {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, rec.Body.String())
+	}
+}
+
+func TestClientOrganisationRejectsOtherOrganisation(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := requestWithClientCert(`{"organisation_id": "another"}`, "payroll")
+
+	app := &App{db: &mockDB{}}
+	subjects := map[string]string{"payroll": "743d5b63-8e6f-432e-a8fa-c5d8d2ee5fcb"}
+	Chain(http.HandlerFunc(app.CreatePayment), ClientOrganisation(subjects)).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("%+v != %+v", rec.Code, http.StatusForbidden)
+	}
+
+	expected := `{"status":"payment belongs to another organisation"}`
+
+	if expected != rec.Body.String() {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, rec.Body.String())
+	}
+}
+
+func TestClientOrganisationUnknownSubject(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := requestWithClientCert(`{}`, "stranger")
+
+	app := &App{db: &mockDB{}}
+	subjects := map[string]string{"payroll": "743d5b63-8e6f-432e-a8fa-c5d8d2ee5fcb"}
+	Chain(http.HandlerFunc(app.CreatePayment), ClientOrganisation(subjects)).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("%+v != %+v", rec.Code, http.StatusForbidden)
+	}
+}
+
+// serveWithClientCert serves a request of the client with the certificate
+// cn, mapped to organisations, by the routes of app.
+func serveWithClientCert(app *App, method, url, body, cn string) *httptest.ResponseRecorder {
+	r := mux.NewRouter()
+	Routes(r, app, NewHealth())
+	subjects := map[string]string{"payroll": "743d5b63-8e6f-432e-a8fa-c5d8d2ee5fcb", "treasury": "another"}
+	rec := httptest.NewRecorder()
+	Chain(r, ClientOrganisation(subjects)).ServeHTTP(rec, clientCertRequest(method, url, body, cn))
+	return rec
+}
+
+func TestClientOrganisationOtherPayments(t *testing.T) {
+	for _, test := range []struct {
+		method, url, body string
+	}{
+		{"GET", "/payments/5b290f5b802b0f1479000002", ""},
+		{"PUT", "/payments/5b290f5b802b0f1479000002", `{"organisation_id":"another"}`},
+		{"DELETE", "/payments/5b290f5b802b0f1479000002", ""},
+		{"GET", "/payments/5b290f5b802b0f1479000002/mt103", ""},
+	} {
+		rec := serveWithClientCert(&App{db: &mockDB{}}, test.method, test.url, test.body, "treasury")
+		if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "payment not found") {
+			t.Errorf("%v %v expected status 404 got %v %v", test.method, test.url, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := serveWithClientCert(&App{db: &mockDB{}}, "GET", "/payments/5b290f5b802b0f1479000002", "", "payroll")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"organisation_id":"743d5b63-8e6f-432e-a8fa-c5d8d2ee5fcb"`) {
+		t.Errorf("expected the payment of the organisation, got %v %v", rec.Code, rec.Body.String())
+	}
+	rec = serveWithClientCert(&App{db: &mockDB{}}, "DELETE", "/payments/5b290f5b802b0f1479000002", "", "payroll")
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"status":"deleted"}` {
+		t.Errorf("expected the payment of the organisation to be deleted, got %v %v", rec.Code, rec.Body.String())
+	}
+}
+
+func TestClientOrganisationFilter(t *testing.T) {
+	for _, url := range []string{"/payments?organisation_id=743d5b63-8e6f-432e-a8fa-c5d8d2ee5fcb", "/payments/export", "/payments/export/pain001"} {
+		db := &reconciledDB{}
+		serveWithClientCert(&App{db: db}, "GET", url, "", "treasury")
+		if db.filter.OrganisationID != "another" {
+			t.Errorf("%v expected the payments of the organisation, got %+v", url, db.filter)
+		}
+	}
+}
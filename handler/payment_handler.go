@@ -1,11 +1,15 @@
 package handler
 
 import (
+	"bytes"
 	"encoding/json"
-	"log"
 	"net/http"
 
 	data "github.com/form3/data"
+	"github.com/form3/ingest"
+	"github.com/form3/logging"
+	"github.com/form3/reconcile"
+	"github.com/form3/trace"
 	"github.com/gorilla/mux"
 	"gopkg.in/mgo.v2/bson"
 )
@@ -13,6 +17,22 @@ import (
 type App struct {
 	//data base interface
 	db data.PaymentProvider
+	// maximum number of payments of a batch create
+	maxBatchSize int
+	// maximum size of the bodies read whole
+	maxBodySize int64
+	// maximum number of payments of an exported message
+	maxMessagePayments int
+
+	jobs          data.JobProvider
+	ingester      *ingest.Ingester
+	maxUploadSize int64
+
+	statements data.StatementProvider
+	reconciler *reconcile.Reconciler
+
+	// idempotency keys of the creates, none when nil
+	keys data.IdempotencyProvider
 }
 
 func NewApp() *App {
@@ -21,61 +41,173 @@ func NewApp() *App {
 
 type Response map[string]interface{}
 
+// errorResponse builds the body returned when a request fails, carrying the
+// request ID so the client can quote it when reporting the problem.
+func errorResponse(r *http.Request, err error) Response {
+	response := Response{"status": err.Error()}
+	if id := trace.RequestID(r.Context()); id != "" {
+		response["request_id"] = id
+	}
+	return response
+}
+
 func (a *App) SetMongoProvider(dbConnection *data.MongoDBConn) {
-	a.db = &data.PaymentDataBase{dbConnection}
+	a.db = &instrumentedProvider{next: &data.PaymentDataBase{MongoDBConn: dbConnection}}
 }
 
-// Get list of all payments
+// listBatchSize is the number of payments encoded before being written to
+// the response, bounding the memory used by a list whatever its length.
+const listBatchSize = 100
+
+// Get list of all payments, streamed from the database
 func (a *App) GetAllPayments(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
-	log.Printf("GetAllPayments  \n")
-	payments, err := a.db.ListPayments()
-	log.Printf("Payments %+v \n", payments)
-	log.Printf("Error %+v \n", err)
+	logger := logging.FromContext(r.Context())
+	filter, err := paymentFilter(r)
+	if err != nil {
+		sendError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	iter, err := a.db.StreamPayments(r.Context(), filter)
 	if err != nil {
-		SendJson(w, Response{"status": err.Error()})
-	} else if len(payments) > 0 {
-		SendJson(w, payments)
+		logger.Error("Could not list payments", logging.Fields{"error": err})
+		sendDBError(w, r, err)
+		return
+	}
+
+	jsonAPI := acceptsJSONAPI(r)
+	var payment data.Payment
+	if !iter.Next(&payment) {
+		if err := iter.Close(); err != nil {
+			logger.Error("Could not list payments", logging.Fields{"error": err})
+			sendDBError(w, r, err)
+		} else if jsonAPI {
+			// JSON:API clients tell an empty list from a message
+			sendDocument(w, http.StatusOK, document{Data: []paymentResource{}, Links: &links{Self: r.URL.RequestURI()}, Meta: Response{"count": 0}})
+		} else {
+			SendJson(w, Response{"status": "there are not any payments in the collection"})
+		}
+		return
+	}
+
+	if jsonAPI {
+		w.Header().Set("Content-Type", jsonAPIType)
+		w.WriteHeader(http.StatusOK)
+		err = writeResources(w, r, iter, &payment, filter.Limit)
 	} else {
-		SendJson(w, Response{"status": "there are not any payments in the collection"})
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_, err = writePayments(w, iter, &payment, func(p *data.Payment) interface{} { return p })
+	}
+	if err != nil {
+		// the status is sent already, abort the response so the client does
+		// not mistake it for a complete list
+		logger.Error("Could not list payments", logging.Fields{"error": err})
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// writePayments writes payment and the rest of iter, each as encoded by
+// encode, as a JSON array, flushing every listBatchSize payments, and closes
+// iter. It returns the number of payments written.
+func writePayments(w http.ResponseWriter, iter data.PaymentIter, payment *data.Payment, encode func(*data.Payment) interface{}) (int, error) {
+	flusher, _ := w.(http.Flusher)
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	n := 1
+	for ; ; n++ {
+		b, err := json.Marshal(encode(payment))
+		if err != nil {
+			iter.Close()
+			return n, err
+		}
+		buf.Write(b)
+		if n%listBatchSize == 0 {
+			if _, err := w.Write(buf.Bytes()); err != nil {
+				iter.Close()
+				return n, err
+			}
+			buf.Reset()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if !iter.Next(payment) {
+			break
+		}
+		buf.WriteByte(',')
+	}
+	if err := iter.Close(); err != nil {
+		return n, err
 	}
+	buf.WriteByte(']')
+	_, err := w.Write(buf.Bytes())
+	return n, err
 }
 
 // Get Payment by ID
 func (a *App) GetPayment(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
-	log.Printf("GetPayment  \n")
+	logger := logging.FromContext(r.Context())
 
 	params := mux.Vars(r)
 	id := params["id"]
 
-	payment, err := a.db.ListPaymentID(bson.ObjectIdHex(id))
-	log.Printf("Payment %+v \n", payment)
-	log.Printf("Error %+v \n", err)
+	payment, err := a.db.ListPaymentID(r.Context(), bson.ObjectIdHex(id))
 	if err != nil {
-		SendJson(w, Response{"status": err.Error()})
+		logger.Warn("Could not get payment", logging.Fields{"payment": id, "error": err})
+		sendDBError(w, r, err)
+	} else if !ownedBy(r, payment) {
+		sendError(w, r, http.StatusNotFound, errPaymentNotFound)
 	} else {
-		SendJson(w, payment)
+		sendPayment(w, r, payment)
 	}
 }
 
+// checkOwner reports if the payment id can be changed by the client of the
+// request, sending an error response when it cannot: payments of other
+// organisations are not found.
+func (a *App) checkOwner(w http.ResponseWriter, r *http.Request, id bson.ObjectId) bool {
+	if Organisation(r.Context()) == "" {
+		return true
+	}
+	return a.storedPayment(w, r, id) != nil
+}
+
+// storedPayment returns the payment id owned by the client of the request,
+// or nil after sending an error response.
+func (a *App) storedPayment(w http.ResponseWriter, r *http.Request, id bson.ObjectId) *data.Payment {
+	payment, err := a.db.ListPaymentID(r.Context(), id)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("Could not get payment", logging.Fields{"payment": id.Hex(), "error": err})
+		sendDBError(w, r, err)
+		return nil
+	}
+	if !ownedBy(r, payment) {
+		sendError(w, r, http.StatusNotFound, errPaymentNotFound)
+		return nil
+	}
+	return payment
+}
+
 // Create payment
 func (a *App) CreatePayment(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
-	log.Printf("CreatePayment  \n")
-
-	var payment data.Payment
+	logger := logging.FromContext(r.Context())
 
-	if err := json.NewDecoder(r.Body).Decode(&payment); err != nil {
-		SendJsonWithStatus(w, http.StatusBadRequest, Response{"status": err.Error()})
+	if payment, err := decodePayment(r); err != nil {
+		logger.Info("Could not decode payment", logging.Fields{"error": err})
+		sendError(w, r, http.StatusBadRequest, err)
+	} else if err := checkOrganisation(r, &payment); err != nil {
+		sendError(w, r, http.StatusForbidden, err)
 	} else {
-		newPayment, err := a.db.CreatePayment(payment)
-		log.Printf("Payment %+v \n", newPayment)
-		log.Printf("Payment:err %+v \n", err)
+		newPayment, err := a.db.CreatePayment(r.Context(), payment)
 		if err != nil {
-			SendJson(w, Response{"status": err.Error()})
+			logger.Error("Could not create payment", logging.Fields{"error": err})
+			sendDBError(w, r, err)
 		} else {
-			SendJson(w, newPayment)
+			logger.Info("Payment created", logging.Fields{"payment": newPayment})
+			sendPayment(w, r, newPayment)
 		}
 	}
 }
@@ -83,44 +215,52 @@ func (a *App) CreatePayment(w http.ResponseWriter, r *http.Request) {
 // Delete payment
 func (a *App) DeletePayment(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
-	log.Printf("DeletePayment  \n")
+	logger := logging.FromContext(r.Context())
 
 	params := mux.Vars(r)
 	id := params["id"]
 	bsonObjectID := bson.ObjectIdHex(id)
-	log.Printf("Params request %+v \n", params)
+	if !a.checkOwner(w, r, bsonObjectID) {
+		return
+	}
 
-	err := a.db.RemovePayment(bsonObjectID)
-	log.Printf("Error %+v \n", err)
+	err := a.db.RemovePayment(r.Context(), bsonObjectID)
 	if err != nil {
-		SendJson(w, Response{"status": err.Error()})
+		logger.Warn("Could not delete payment", logging.Fields{"payment": id, "error": err})
+		sendDBError(w, r, err)
 	} else {
-		SendJson(w, Response{"status": "deleted"})
+		logger.Info("Payment deleted", logging.Fields{"payment": id})
+		sendStatus(w, r, "deleted")
 	}
 
 }
 
 func (a *App) UpdatePayment(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
-	log.Printf("UpdatePayment  \n")
+	logger := logging.FromContext(r.Context())
 
 	params := mux.Vars(r)
 	id := params["id"]
-	log.Printf("Params request %+v \n", params)
 
-	var payment data.Payment
-	if err := json.NewDecoder(r.Body).Decode(&payment); err != nil {
-		SendJsonWithStatus(w, http.StatusBadRequest, Response{"status": err.Error()})
-	} else {
-		log.Printf("Payment decoded  %+v \n", payment)
-		payment.MongoID = bson.ObjectIdHex(id)
-		paymentUpdated, err := a.db.UpdatePayment(payment)
-		log.Printf("PaymentUpdated  %+v \n", paymentUpdated)
-		log.Printf("Error %+v \n", err)
+	if payment, err := decodePayment(r); err != nil {
+		logger.Info("Could not decode payment", logging.Fields{"payment": id, "error": err})
+		sendError(w, r, http.StatusBadRequest, err)
+	} else if err := checkOrganisation(r, &payment); err != nil {
+		sendError(w, r, http.StatusForbidden, err)
+	} else if stored := a.storedPayment(w, r, bson.ObjectIdHex(id)); stored != nil {
+		if err := checkResourceID(r, &payment, stored); err != nil {
+			sendError(w, r, http.StatusConflict, err)
+			return
+		}
+		// the whole document is replaced, keeping the status set by the service
+		payment.MongoID, payment.Status = stored.MongoID, stored.Status
+		paymentUpdated, err := a.db.UpdatePayment(r.Context(), payment)
 		if err != nil {
-			SendJson(w, Response{"status": err.Error()})
+			logger.Warn("Could not update payment", logging.Fields{"payment": id, "error": err})
+			sendDBError(w, r, err)
 		} else {
-			SendJson(w, paymentUpdated)
+			logger.Info("Payment updated", logging.Fields{"payment": paymentUpdated})
+			sendPayment(w, r, paymentUpdated)
 		}
 	}
 
@@ -129,17 +269,21 @@ func (a *App) UpdatePayment(w http.ResponseWriter, r *http.Request) {
 // Sets the content type to "application/json" and send the data variable in a JSON format. The output is
 // a result of `json.Marshal()` call. If the content cannot be marshaled the result is empty string.
 func SendJsonWithStatus(w http.ResponseWriter, statusCode int, data interface{}) {
+	sendWithType(w, statusCode, "application/json; charset=utf-8", data)
+}
+
+// sendWithType sends data as JSON with the media type contentType.
+func sendWithType(w http.ResponseWriter, statusCode int, contentType string, data interface{}) {
 	result, err := json.Marshal(data)
 	if err != nil {
-		log.Println("Error marshalling response", err)
+		logging.Default().Error("Error marshalling response", logging.Fields{"error": err})
 		http.Error(w, "Internal server error.", http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(statusCode)
 	w.Write(result)
-	return
 }
 
 // Internally calls `SendJsonWithStatus`
@@ -2,6 +2,7 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -22,7 +23,7 @@ type mockDB struct {
 	testCaseDbError bool
 }
 
-func (mdb *mockDB) ListPayments() (payments []data.Payment, err error) {
+func (mdb *mockDB) ListPayments(ctx context.Context, filter data.PaymentFilter) (payments []data.Payment, err error) {
 	if mdb.testCaseDbError != true {
 		if mdb.testCaseEmpty != true {
 			payments = []data.Payment{
@@ -328,7 +329,7 @@ func TestGetAllPaymentsDBError(t *testing.T) {
 	}
 }
 
-func (mdb *mockDB) ListPaymentID(id bson.ObjectId) (payment *data.Payment, err error) {
+func (mdb *mockDB) ListPaymentID(ctx context.Context, id bson.ObjectId) (payment *data.Payment, err error) {
 	// in database we have
 	// MongoID: bson.ObjectIdHex("5b290f5b802b0f1479000002"),
 	fmt.Printf("Mock ListPaymentID id %v", id)
@@ -536,7 +537,7 @@ func TestGetPaymentIDDBError(t *testing.T) {
 	}
 }
 
-func (mdb *mockDB) CreatePayment(payment data.Payment) (*data.Payment, error) {
+func (mdb *mockDB) CreatePayment(ctx context.Context, payment data.Payment) (*data.Payment, error) {
 	if mdb.testCaseDbError != true {
 		payment.MongoID = bson.ObjectIdHex("5b2ce1c5c089711b0e3bc2fa")
 		return &payment, nil
@@ -845,7 +846,7 @@ func TestCreatePaymentDecodeError(t *testing.T) {
 	}
 }
 
-func (mdb *mockDB) RemovePayment(id bson.ObjectId) error {
+func (mdb *mockDB) RemovePayment(ctx context.Context, id bson.ObjectId) error {
 	// in database we have
 	// MongoID: bson.ObjectIdHex("5b290f5b802b0f1479000002"),
 	fmt.Printf("Mock ListPaymentID id %v", id)
@@ -927,7 +928,7 @@ func TestRemovePaymentIDExists(t *testing.T) {
 	}
 }
 
-func (mdb *mockDB) UpdatePayment(payment data.Payment) (*data.Payment, error) {
+func (mdb *mockDB) UpdatePayment(ctx context.Context, payment data.Payment) (*data.Payment, error) {
 	// in database we have
 	// MongoID:bson.ObjectIdHex("5b290f5b802b0f1479000002"),
 	fmt.Printf("Mock ListPaymentID id %v", payment.MongoID)
@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"github.com/form3/metrics"
+	"github.com/gorilla/mux"
+)
+
+// Routes registers the routes of the service on r and returns the OpenAPI
+// specification describing them, served at /openapi.json.
+func Routes(r *mux.Router, app *App, health *Health) *Spec {
+	spec := NewSpec(r)
+
+	r.HandleFunc("/healthz", health.Liveness).Methods("GET")
+	r.HandleFunc("/readyz", health.Readiness).Methods("GET")
+	r.Handle("/metrics", metrics.Handler()).Methods("GET")
+	r.Handle("/openapi.json", spec).Methods("GET")
+
+	r.HandleFunc("/payments", app.GetAllPayments).Methods("GET")
+	r.HandleFunc("/payments/export", app.ExportPayments).Methods("GET")
+	r.HandleFunc("/payments/export/pain001", app.ExportPain001).Methods("GET")
+	r.HandleFunc("/payments/export/pacs008", app.ExportPacs008).Methods("GET")
+	r.HandleFunc("/payments/export/bacs", app.ExportBacs).Methods("GET")
+	r.HandleFunc("/payments/{id}", app.GetPayment).Methods("GET")
+	r.HandleFunc("/payments/{id}/pain001", app.GetPaymentPain001).Methods("GET")
+	r.HandleFunc("/payments/{id}/pacs008", app.GetPaymentPacs008).Methods("GET")
+	r.HandleFunc("/payments/{id}/mt103", app.GetPaymentMT103).Methods("GET")
+	r.HandleFunc("/payments", app.idempotent(app.CreatePayment)).Methods("POST")
+	r.HandleFunc("/payments/batch", app.idempotent(app.CreatePayments)).Methods("POST")
+	r.HandleFunc("/payments/upload", app.UploadPayments).Methods("POST")
+	r.HandleFunc("/payments/import/pain001", app.ImportPain001).Methods("POST")
+	r.HandleFunc("/statements/import/camt053", app.ImportCamt053).Methods("POST")
+	r.HandleFunc("/statements/import/mt940", app.ImportMT940).Methods("POST")
+	r.HandleFunc("/reconciliation/entries", app.GetUnmatchedEntries).Methods("GET")
+	r.HandleFunc("/reconciliation/payments", app.GetUnmatchedPayments).Methods("GET")
+	r.HandleFunc("/jobs/{id}", app.GetJob).Methods("GET")
+	r.HandleFunc("/jobs/{id}/errors", app.GetJobErrors).Methods("GET")
+	r.HandleFunc("/payments/{id}", app.DeletePayment).Methods("DELETE")
+	r.HandleFunc("/payments/{id}", app.UpdatePayment).Methods("PUT")
+	return spec
+}
@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+
+	data "github.com/form3/data"
+	"github.com/form3/iso20022"
+	"github.com/form3/logging"
+	"github.com/form3/reconcile"
+	"github.com/form3/swift"
+)
+
+// DefaultEntriesLimit is the number of unmatched statement entries listed when
+// the request does not set a limit.
+const DefaultEntriesLimit = 1000
+
+// statementResponse is the outcome of importing a statement.
+type statementResponse struct {
+	Entries    int               `json:"entries"`
+	Imported   int               `json:"imported"`
+	Duplicates int               `json:"duplicates"`
+	Matched    []reconcile.Match `json:"matched"`
+	Unmatched  int               `json:"unmatched"`
+}
+
+func (a *App) SetStatementProvider(statements data.StatementProvider, tolerance reconcile.Tolerance) {
+	a.statements = statements
+	a.reconciler = reconcile.New(a.db, statements, tolerance)
+}
+
+// Import the entries of a camt.053 bank to customer statement and reconcile them
+func (a *App) ImportCamt053(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != xmlType && mediaType != "text/xml" {
+		SendJsonWithStatus(w, http.StatusUnsupportedMediaType, errorResponse(r, errXMLType))
+		return
+	}
+	a.importStatement(w, r, iso20022.ParseCamt053)
+}
+
+// Import the entries of MT940 customer statements and reconcile them
+func (a *App) ImportMT940(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	a.importStatement(w, r, func(body io.Reader) ([]data.StatementEntry, error) {
+		b, err := ioutil.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		return swift.ParseMT940(string(b))
+	})
+}
+
+// importStatement stores the entries read from the body by parse, skipping
+// those imported before, and matches the new ones to payments.
+func (a *App) importStatement(w http.ResponseWriter, r *http.Request, parse func(io.Reader) ([]data.StatementEntry, error)) {
+	logger := logging.FromContext(r.Context())
+	if a.maxUploadSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, a.maxUploadSize)
+	}
+	entries, err := parse(r.Body)
+	if err != nil {
+		logger.Info("Could not read statement", logging.Fields{"error": err})
+		SendJsonWithStatus(w, http.StatusBadRequest, errorResponse(r, err))
+		return
+	}
+	organisation := Organisation(r.Context())
+	for i := range entries {
+		entries[i].OrganisationID = organisation
+	}
+	saved, unmatched, err := a.statements.SaveStatementEntries(r.Context(), entries)
+	if err != nil {
+		logger.Error("Could not save statement entries", logging.Fields{"count": len(entries), "error": err})
+		SendJson(w, errorResponse(r, err))
+		return
+	}
+	// the entries of an earlier import left unmatched are matched again
+	result, err := a.reconciler.Reconcile(r.Context(), append(saved, unmatched...))
+	if err != nil {
+		logger.Error("Could not reconcile statement entries", logging.Fields{"count": len(saved) + len(unmatched), "error": err})
+		SendJson(w, errorResponse(r, err))
+		return
+	}
+	logger.Info("Statement imported", logging.Fields{"entries": len(entries), "imported": len(saved), "matched": len(result.Matched)})
+	SendJson(w, statementResponse{
+		Entries:    len(entries),
+		Imported:   len(saved),
+		Duplicates: len(entries) - len(saved),
+		Matched:    result.Matched,
+		Unmatched:  result.Unmatched,
+	})
+}
+
+// Get the queue of statement entries not matched to a payment, oldest first
+func (a *App) GetUnmatchedEntries(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	filter := data.StatementFilter{OrganisationID: Organisation(r.Context()), Status: data.EntryUnmatched, Limit: DefaultEntriesLimit}
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			SendJsonWithStatus(w, http.StatusBadRequest, errorResponse(r, errors.New("limit must be a positive number")))
+			return
+		}
+		filter.Limit = n
+	}
+	entries, err := a.statements.ListStatementEntries(r.Context(), filter)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Could not list statement entries", logging.Fields{"error": err})
+		SendJson(w, errorResponse(r, err))
+		return
+	}
+	if entries == nil {
+		entries = []data.StatementEntry{}
+	}
+	SendJson(w, entries)
+}
+
+// Get the queue of payments no statement entry matched, streamed like the
+// list of payments
+func (a *App) GetUnmatchedPayments(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	query.Set("reconciled", "false")
+	r.URL.RawQuery = query.Encode()
+	a.GetAllPayments(w, r)
+}
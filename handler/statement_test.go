@@ -0,0 +1,249 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	data "github.com/form3/data"
+	"github.com/form3/reconcile"
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// statementDB stores statement entries, recording their matches in the
+// payments of db.
+type statementDB struct {
+	db      *messageDB
+	entries []data.StatementEntry
+	filter  data.StatementFilter
+}
+
+func (s *statementDB) SaveStatementEntries(ctx context.Context, entries []data.StatementEntry) (saved, unmatched []data.StatementEntry, err error) {
+	for _, entry := range entries {
+		duplicate := false
+		for _, e := range s.entries {
+			if e.OrganisationID == entry.OrganisationID && e.Account == entry.Account && e.StatementID == entry.StatementID && e.Sequence == entry.Sequence {
+				duplicate = true
+				if e.Status == data.EntryUnmatched {
+					unmatched = append(unmatched, e)
+				}
+			}
+		}
+		if !duplicate {
+			entry.ID, entry.Status = bson.NewObjectId(), data.EntryUnmatched
+			s.entries = append(s.entries, entry)
+			saved = append(saved, entry)
+		}
+	}
+	return saved, unmatched, nil
+}
+
+func (s *statementDB) ListStatementEntries(ctx context.Context, filter data.StatementFilter) ([]data.StatementEntry, error) {
+	s.filter = filter
+	var entries []data.StatementEntry
+	for _, entry := range s.entries {
+		if entry.Status == filter.Status {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (s *statementDB) MatchStatementEntry(ctx context.Context, entryID, paymentID bson.ObjectId) error {
+	for i := range s.entries {
+		if s.entries[i].ID == entryID {
+			s.entries[i].Status, s.entries[i].PaymentID = data.EntryMatched, &paymentID
+		}
+	}
+	for i := range s.db.payments {
+		if s.db.payments[i].MongoID == paymentID {
+			s.db.payments[i].Status = data.PaymentReconciled
+		}
+	}
+	return nil
+}
+
+func statementApp() (*App, *statementDB) {
+	db := &messageDB{payments: []data.Payment{messagePayment()}}
+	statements := &statementDB{db: db}
+	app := &App{db: db}
+	app.SetStatementProvider(statements, reconcile.Tolerance{Days: 2})
+	return app, statements
+}
+
+func postStatement(app *App, url, contentType, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", url, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", contentType)
+	switch url {
+	case "/statements/import/camt053":
+		app.ImportCamt053(rec, req)
+	default:
+		app.ImportMT940(rec, req)
+	}
+	return rec
+}
+
+const statementMT940 = ":20:STMT1801\n:25:203301/71268996\n:28C:18/1\n:60F:C170117GBP1000,00\n" +
+	":61:1701190119D100,21NTRFWil piano Jan//BANK-1\n:61:1701190119D20,NTRFUnknown\n:62F:C170119GBP879,79\n-"
+
+func TestImportMT940(t *testing.T) {
+	app, statements := statementApp()
+
+	rec := postStatement(app, "/statements/import/mt940", "text/plain", statementMT940)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %v: %v", rec.Code, rec.Body.String())
+	}
+	var response statementResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	expected := statementResponse{Entries: 2, Imported: 2, Matched: []reconcile.Match{{EntryID: statements.entries[0].ID.Hex(), PaymentID: "5b290f5b802b0f1479000002"}}, Unmatched: 1}
+	if !reflect.DeepEqual(expected, response) {
+		t.Errorf("\n...expected = %+v\n...obtained = %+v", expected, response)
+	}
+	if status := statements.db.payments[0].Status; status != data.PaymentReconciled {
+		t.Errorf("expected the payment to be reconciled, got %q", status)
+	}
+
+	// importing the statement again adds nothing, and the entry left is
+	// matched again
+	rec = postStatement(app, "/statements/import/mt940", "text/plain", statementMT940)
+	expectedBody := `{"entries":2,"imported":0,"duplicates":2,"matched":[],"unmatched":1}`
+	if obtained := strings.TrimSpace(rec.Body.String()); expectedBody != obtained {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expectedBody, obtained)
+	}
+}
+
+func TestImportMT940Again(t *testing.T) {
+	app, statements := statementApp()
+	payments := statements.db.payments
+	statements.db.payments = nil
+
+	rec := postStatement(app, "/statements/import/mt940", "text/plain", statementMT940)
+	expectedBody := `{"entries":2,"imported":2,"duplicates":0,"matched":[],"unmatched":2}`
+	if obtained := strings.TrimSpace(rec.Body.String()); expectedBody != obtained {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expectedBody, obtained)
+	}
+
+	// the entries stored unmatched are matched when the statement is
+	// imported again
+	statements.db.payments = payments
+	rec = postStatement(app, "/statements/import/mt940", "text/plain", statementMT940)
+	var response statementResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	expected := statementResponse{Entries: 2, Duplicates: 2, Matched: []reconcile.Match{{EntryID: statements.entries[0].ID.Hex(), PaymentID: "5b290f5b802b0f1479000002"}}, Unmatched: 1}
+	if !reflect.DeepEqual(expected, response) {
+		t.Errorf("\n...expected = %+v\n...obtained = %+v", expected, response)
+	}
+}
+
+func TestImportCamt053(t *testing.T) {
+	app, _ := statementApp()
+	doc := `<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.053.001.02"><BkToCstmrStmt><Stmt><Id>S1</Id>
+<Acct><Id><IBAN>GB29XABC10161234567801</IBAN></Id></Acct>
+<Ntry><Amt Ccy="GBP">100.21</Amt><CdtDbtInd>DBIT</CdtDbtInd><BookgDt><Dt>2017-01-18</Dt></BookgDt>
+<NtryDtls><TxDtls><Refs><EndToEndId>Wil piano Jan</EndToEndId></Refs></TxDtls></NtryDtls></Ntry>
+</Stmt></BkToCstmrStmt></Document>`
+
+	if rec := postStatement(app, "/statements/import/camt053", "text/plain", doc); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected status 415 got %v", rec.Code)
+	}
+	rec := postStatement(app, "/statements/import/camt053", "application/xml", doc)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"payment_id":"5b290f5b802b0f1479000002"`) {
+		t.Errorf("expected the entry to match the payment, got %v %v", rec.Code, rec.Body.String())
+	}
+	if rec := postStatement(app, "/statements/import/camt053", "application/xml", "<Document/>"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an invalid statement got %v", rec.Code)
+	}
+}
+
+func TestGetUnmatchedEntries(t *testing.T) {
+	app, statements := statementApp()
+	postStatement(app, "/statements/import/mt940", "text/plain", statementMT940)
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/reconciliation/entries?limit=10", &bytes.Buffer{})
+	app.GetUnmatchedEntries(rec, req)
+	var entries []data.StatementEntry
+	if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	if len(entries) != 1 || entries[0].EndToEndReference != "Unknown" || statements.filter.Limit != 10 {
+		t.Errorf("expected the unknown entry, got %+v with %+v", entries, statements.filter)
+	}
+
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/reconciliation/entries?limit=all", &bytes.Buffer{})
+	app.GetUnmatchedEntries(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 got %v", rec.Code)
+	}
+}
+
+// reconciledDB records the filter of the payments listed.
+type reconciledDB struct {
+	messageDB
+	filter data.PaymentFilter
+}
+
+func (m *reconciledDB) StreamPayments(ctx context.Context, filter data.PaymentFilter) (data.PaymentIter, error) {
+	m.filter = filter
+	return m.messageDB.StreamPayments(ctx, filter)
+}
+
+func TestGetUnmatchedPayments(t *testing.T) {
+	db := &reconciledDB{messageDB: messageDB{payments: []data.Payment{messagePayment()}}}
+	app := &App{db: db}
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/reconciliation/payments?reconciled=true&currency=GBP", &bytes.Buffer{})
+	app.GetUnmatchedPayments(rec, req)
+	if rec.Code != http.StatusOK || db.filter.Reconciled == nil || *db.filter.Reconciled || db.filter.Currency != "GBP" {
+		t.Errorf("expected the unreconciled GBP payments, got %v with %+v", rec.Code, db.filter)
+	}
+	if !strings.Contains(rec.Body.String(), `"id":"4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43"`) {
+		t.Errorf("expected the payment in %v", rec.Body.String())
+	}
+}
+
+func TestPaymentStatusSetByService(t *testing.T) {
+	reconciled := messagePayment()
+	reconciled.Status = data.PaymentReconciled
+	app := &App{db: &messageDB{payments: []data.Payment{reconciled}}}
+	router := mux.NewRouter()
+	router.HandleFunc("/payments", app.CreatePayment).Methods("POST")
+	router.HandleFunc("/payments/{id}", app.UpdatePayment).Methods("PUT")
+
+	for _, test := range []struct {
+		method, url, contentType, body string
+		expected                       string
+	}{
+		{"POST", "/payments", "application/json", `{"id":"p1","status":"reconciled"}`, ""},
+		{"POST", "/payments", jsonAPIType, `{"data":{"type":"Payment","id":"p1","meta":{"status":"reconciled"}}}`, ""},
+		// the stored status is kept
+		{"PUT", "/payments/5b290f5b802b0f1479000002", "application/json", `{"id":"p1","status":""}`, data.PaymentReconciled},
+		{"PUT", "/payments/5b290f5b802b0f1479000002", jsonAPIType, `{"data":{"type":"Payment"}}`, data.PaymentReconciled},
+	} {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(test.method, test.url, bytes.NewBufferString(test.body))
+		req.Header.Set("Content-Type", test.contentType)
+		router.ServeHTTP(rec, req)
+
+		var payment data.Payment
+		if err := json.Unmarshal(rec.Body.Bytes(), &payment); err != nil {
+			t.Fatalf("Didn't expect error %v", err)
+		}
+		if rec.Code != http.StatusOK || payment.Status != test.expected {
+			t.Errorf("%v %v\n...expected = %v\n...obtained = %v %v", test.method, test.body, test.expected, rec.Code, rec.Body.String())
+		}
+	}
+}
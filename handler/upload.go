@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+
+	data "github.com/form3/data"
+	"github.com/form3/ingest"
+	"github.com/form3/logging"
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// jobErrorsShown is the number of row errors returned with a job, the others
+// are in its error report.
+const jobErrorsShown = 100
+
+var (
+	errJobNotFound = errors.New("job not found")
+	errUploadType  = errors.New("expected a text/csv body or a multipart/form-data file field")
+)
+
+type jobResponse struct {
+	*data.Job
+	Progress    float64         `json:"progress"`
+	Errors      []data.RowError `json:"errors,omitempty"`
+	ErrorReport string          `json:"error_report,omitempty"`
+}
+
+func newJobResponse(job *data.Job) jobResponse {
+	response := jobResponse{Job: job, Progress: job.Progress(), Errors: job.Errors}
+	if len(response.Errors) > jobErrorsShown {
+		response.Errors = response.Errors[:jobErrorsShown]
+	}
+	if job.FailedRows > 0 {
+		response.ErrorReport = "/jobs/" + job.ID.Hex() + "/errors"
+	}
+	return response
+}
+
+// SetIngester creates the ingester of uploaded CSV files, using mapping by
+// default. Its Run method must be started for the files to be processed.
+func (a *App) SetIngester(jobs data.JobProvider, mapping ingest.Mapping, queueSize int, maxUploadSize int64) *ingest.Ingester {
+	a.jobs = jobs
+	a.ingester = ingest.New(a.db, jobs, mapping, queueSize)
+	a.maxUploadSize = maxUploadSize
+	return a.ingester
+}
+
+// Upload a CSV file of payments, created in the background
+func (a *App) UploadPayments(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	logger := logging.FromContext(r.Context())
+	if a.maxUploadSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, a.maxUploadSize)
+	}
+
+	file, name, mapping, err := uploadedFile(r)
+	if err == errUploadType {
+		SendJsonWithStatus(w, http.StatusUnsupportedMediaType, errorResponse(r, err))
+		return
+	}
+	if err != nil {
+		logger.Info("Could not read uploaded file", logging.Fields{"error": err})
+		SendJsonWithStatus(w, http.StatusBadRequest, errorResponse(r, err))
+		return
+	}
+	defer file.Close()
+
+	if err := a.ingester.CheckMapping(mapping); err != nil {
+		SendJsonWithStatus(w, http.StatusBadRequest, errorResponse(r, err))
+		return
+	}
+
+	tmp, err := ioutil.TempFile("", "payments-*.csv")
+	if err == nil {
+		_, err = io.Copy(tmp, file)
+		tmp.Close()
+	}
+	if err != nil {
+		if tmp != nil {
+			os.Remove(tmp.Name())
+		}
+		logger.Error("Could not store uploaded file", logging.Fields{"error": err})
+		SendJsonWithStatus(w, http.StatusBadRequest, errorResponse(r, err))
+		return
+	}
+
+	job := &data.Job{OrganisationID: Organisation(r.Context()), FileName: name}
+	if err := a.ingester.Submit(r.Context(), job, tmp.Name(), mapping); err == ingest.ErrQueueFull {
+		os.Remove(tmp.Name())
+		SendJsonWithStatus(w, http.StatusServiceUnavailable, errorResponse(r, err))
+		return
+	} else if err != nil {
+		os.Remove(tmp.Name())
+		logger.Error("Could not queue uploaded file", logging.Fields{"error": err})
+		SendJson(w, errorResponse(r, err))
+		return
+	}
+	w.Header().Set("Location", "/jobs/"+job.ID.Hex())
+	SendJsonWithStatus(w, http.StatusAccepted, newJobResponse(job))
+}
+
+// uploadedFile returns the CSV file of the request, its name and the column
+// mapping sent with it.
+func uploadedFile(r *http.Request) (io.ReadCloser, string, ingest.Mapping, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		return ioutil.NopCloser(r.Body), "", nil, nil
+	case "multipart/form-data":
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			return nil, "", nil, err
+		}
+		var mapping ingest.Mapping
+		if m := r.FormValue("mapping"); m != "" {
+			if err := json.Unmarshal([]byte(m), &mapping); err != nil {
+				return nil, "", nil, errors.New("mapping: " + err.Error())
+			}
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			return nil, "", nil, err
+		}
+		return file, header.Filename, mapping, nil
+	}
+	return nil, "", nil, errUploadType
+}
+
+// job returns the job of the request, sending an error response when it
+// cannot be returned to the client.
+func (a *App) job(w http.ResponseWriter, r *http.Request) *data.Job {
+	id := mux.Vars(r)["id"]
+	if !bson.IsObjectIdHex(id) {
+		SendJsonWithStatus(w, http.StatusNotFound, errorResponse(r, errJobNotFound))
+		return nil
+	}
+	job, err := a.jobs.GetJob(r.Context(), bson.ObjectIdHex(id))
+	// the jobs of other organisations are not found, like their payments,
+	// so their ids are not disclosed
+	organisation := Organisation(r.Context())
+	if err == mgo.ErrNotFound || err == nil && organisation != "" && job.OrganisationID != organisation {
+		SendJsonWithStatus(w, http.StatusNotFound, errorResponse(r, errJobNotFound))
+		return nil
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("Could not get job", logging.Fields{"job": id, "error": err})
+		SendJsonWithStatus(w, http.StatusInternalServerError, errorResponse(r, err))
+		return nil
+	}
+	return job
+}
+
+// Get the progress of an upload job
+func (a *App) GetJob(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if job := a.job(w, r); job != nil {
+		SendJson(w, newJobResponse(job))
+	}
+}
+
+// Download the row errors of an upload job as CSV
+func (a *App) GetJobErrors(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	job := a.job(w, r)
+	if job == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+job.ID.Hex()+`-errors.csv"`)
+	if err := ingest.WriteErrorReport(w, job); err != nil {
+		logging.FromContext(r.Context()).Warn("Could not write error report", logging.Fields{"job": job.ID.Hex(), "error": err})
+	}
+}
@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	data "github.com/form3/data"
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type mockJobs struct {
+	job *data.Job
+	// updated receives the status of the updated jobs when not nil
+	updated chan string
+}
+
+func (m *mockJobs) CreateJob(ctx context.Context, job *data.Job) error {
+	job.ID = bson.ObjectIdHex("5b2ce1c5c089711b0e3bc2fb")
+	return nil
+}
+
+func (m *mockJobs) UpdateJob(ctx context.Context, job *data.Job) error {
+	if m.updated != nil {
+		m.updated <- job.Status
+	}
+	return nil
+}
+
+func (m *mockJobs) GetJob(ctx context.Context, id bson.ObjectId) (*data.Job, error) {
+	if m.job == nil || m.job.ID != id {
+		return nil, mgo.ErrNotFound
+	}
+	return m.job, nil
+}
+
+func jobsRouter(app *App) *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/payments/upload", app.UploadPayments).Methods("POST")
+	router.HandleFunc("/jobs/{id}", app.GetJob).Methods("GET")
+	router.HandleFunc("/jobs/{id}/errors", app.GetJobErrors).Methods("GET")
+	return router
+}
+
+func TestUploadPayments(t *testing.T) {
+	// the queued file is never processed
+	t.Setenv("TMPDIR", t.TempDir())
+	app := &App{db: &mockDB{}}
+	app.SetIngester(&mockJobs{}, nil, 1, 1024)
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/payments/upload", bytes.NewBufferString("id,attributes.amount\np1,10\n"))
+	req.Header.Set("Content-Type", "text/csv")
+	jobsRouter(app).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("%+v != %+v", rec.Code, http.StatusAccepted)
+	}
+	if location := rec.Header().Get("Location"); location != "/jobs/5b2ce1c5c089711b0e3bc2fb" {
+		t.Errorf("unexpected location %v", location)
+	}
+	if !strings.Contains(rec.Body.String(), `"id":"5b2ce1c5c089711b0e3bc2fb","type":"payments_csv","status":"queued"`) {
+		t.Errorf("unexpected body %v", rec.Body.String())
+	}
+}
+
+// TestUploadPaymentsProcessed sends the response while the file is processed,
+// run with -race.
+func TestUploadPaymentsProcessed(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	jobs := &mockJobs{updated: make(chan string, 10)}
+	app := &App{db: &mockDB{}}
+	in := app.SetIngester(jobs, nil, 1, 1024)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		in.Run(done)
+		close(stopped)
+	}()
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/payments/upload", bytes.NewBufferString("id,attributes.amount\np1,10\n"))
+	req.Header.Set("Content-Type", "text/csv")
+	jobsRouter(app).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusAccepted || !strings.Contains(rec.Body.String(), `"id":"5b2ce1c5c089711b0e3bc2fb"`) {
+		t.Fatalf("unexpected response %v %v", rec.Code, rec.Body.String())
+	}
+	for status := range jobs.updated {
+		if status == data.JobCompleted || status == data.JobFailed {
+			break
+		}
+	}
+}
+
+func TestUploadPaymentsInvalid(t *testing.T) {
+	app := &App{db: &mockDB{}}
+	app.SetIngester(&mockJobs{}, nil, 1, 8)
+
+	for contentType, code := range map[string]int{
+		"application/json": http.StatusUnsupportedMediaType,
+		"text/csv":         http.StatusBadRequest, // larger than the maximum size
+	} {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/payments/upload", bytes.NewBufferString("id,attributes.amount\n"))
+		req.Header.Set("Content-Type", contentType)
+		jobsRouter(app).ServeHTTP(rec, req)
+
+		if rec.Code != code {
+			t.Errorf("%v: %+v != %+v", contentType, rec.Code, code)
+		}
+	}
+}
+
+func TestGetJob(t *testing.T) {
+	job := &data.Job{
+		ID:         bson.ObjectIdHex("5b2ce1c5c089711b0e3bc2fb"),
+		Type:       "payments_csv",
+		Status:     data.JobRunning,
+		TotalRows:  4,
+		FailedRows: 1,
+		Errors:     []data.RowError{{Line: 3, Column: "Amount", Error: `"ten" is not a number`}},
+	}
+	app := &App{db: &mockDB{}}
+	app.SetIngester(&mockJobs{job: job}, nil, 1, 1024)
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/jobs/5b2ce1c5c089711b0e3bc2fb", &bytes.Buffer{})
+	jobsRouter(app).ServeHTTP(rec, req)
+
+	for _, e := range []string{`"progress":0`, `"failed_rows":1`, `"errors":[{"line":3,"column":"Amount","error":"\"ten\" is not a number"}]`, `"error_report":"/jobs/5b2ce1c5c089711b0e3bc2fb/errors"`} {
+		if !strings.Contains(rec.Body.String(), e) {
+			t.Errorf("expected %v in %v", e, rec.Body.String())
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/jobs/5b2ce1c5c089711b0e3bc2fb/errors", &bytes.Buffer{})
+	jobsRouter(app).ServeHTTP(rec, req)
+
+	expected := "line,column,error\n3,Amount,\"\"\"ten\"\" is not a number\"\n"
+	if expected != rec.Body.String() {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Errorf("unexpected content type %v", ct)
+	}
+}
+
+func TestGetJobNotFound(t *testing.T) {
+	app := &App{db: &mockDB{}}
+	app.SetIngester(&mockJobs{}, nil, 1, 1024)
+
+	for _, path := range []string{"/jobs/5b2ce1c5c089711b0e3bc2fc", "/jobs/unknown"} {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, &bytes.Buffer{})
+		jobsRouter(app).ServeHTTP(rec, req)
+
+		expected := `{"status":"job not found"}`
+		if rec.Code != http.StatusNotFound || expected != rec.Body.String() {
+			t.Errorf("%v: unexpected response %v %v", path, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestGetJobOtherOrganisation(t *testing.T) {
+	app := &App{db: &mockDB{}}
+	job := &data.Job{ID: bson.ObjectIdHex("5b2ce1c5c089711b0e3bc2fb"), OrganisationID: "743d5b63-8e6f-432e-a8fa-c5d8d2ee5fcb", Status: data.JobRunning}
+	app.SetIngester(&mockJobs{job: job}, nil, 1, 1024)
+
+	for _, path := range []string{"/jobs/5b2ce1c5c089711b0e3bc2fb", "/jobs/5b2ce1c5c089711b0e3bc2fb/errors"} {
+		rec := serveWithClientCert(app, "GET", path, "", "treasury")
+		expected := `{"status":"job not found"}`
+		if rec.Code != http.StatusNotFound || expected != rec.Body.String() {
+			t.Errorf("%v: unexpected response %v %v", path, rec.Code, rec.Body.String())
+		}
+	}
+	if rec := serveWithClientCert(app, "GET", "/jobs/5b2ce1c5c089711b0e3bc2fb", "", "payroll"); rec.Code != http.StatusOK {
+		t.Errorf("expected the job of the organisation, got %v %v", rec.Code, rec.Body.String())
+	}
+}
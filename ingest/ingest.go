@@ -0,0 +1,281 @@
+// Package ingest imports payments from CSV files in the background, tracking
+// the progress and the errors of every file in a job.
+package ingest
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	data "github.com/form3/data"
+	"github.com/form3/logging"
+)
+
+// JobType is the type of the jobs importing a CSV file.
+const JobType = "payments_csv"
+
+// ErrQueueFull is returned when too many files are waiting to be processed.
+var ErrQueueFull = errors.New("too many files are waiting to be processed, retry later")
+
+type task struct {
+	job     *data.Job
+	path    string
+	mapping Mapping
+}
+
+// Ingester creates the payments of uploaded CSV files.
+type Ingester struct {
+	payments data.PaymentProvider
+	jobs     data.JobProvider
+	mapping  Mapping
+	queue    chan task
+
+	// BatchSize is the number of rows created with each bulk write, and
+	// between updates of the job progress.
+	BatchSize int
+}
+
+// New returns an Ingester using the default mapping and queueing up to
+// queueSize files.
+func New(payments data.PaymentProvider, jobs data.JobProvider, mapping Mapping, queueSize int) *Ingester {
+	return &Ingester{
+		payments:  payments,
+		jobs:      jobs,
+		mapping:   mapping,
+		queue:     make(chan task, queueSize),
+		BatchSize: 100,
+	}
+}
+
+// CheckMapping returns an error if mapping, merged over the default mapping,
+// maps a column to an unknown field.
+func (in *Ingester) CheckMapping(mapping Mapping) error {
+	return in.mapping.Merge(mapping).Validate()
+}
+
+// Submit creates a job for the CSV file at path and queues it. The file is
+// removed once processed. mapping is merged over the default mapping. A copy
+// of job is processed so the caller can keep reading job once it is queued.
+func (in *Ingester) Submit(ctx context.Context, job *data.Job, path string, mapping Mapping) error {
+	mapping = in.mapping.Merge(mapping)
+	if err := mapping.Validate(); err != nil {
+		return err
+	}
+	job.Type, job.Status = JobType, data.JobQueued
+	if err := in.jobs.CreateJob(ctx, job); err != nil {
+		return err
+	}
+	queued := *job
+	select {
+	case in.queue <- task{job: &queued, path: path, mapping: mapping}:
+		logging.FromContext(ctx).Info("Queued payments file", logging.Fields{"job": job.ID.Hex(), "file": job.FileName})
+		return nil
+	default:
+		in.finish(ctx, job, data.JobFailed, ErrQueueFull)
+		return ErrQueueFull
+	}
+}
+
+// Run processes the queued files until done is closed. Files still queued
+// then are marked as interrupted.
+func (in *Ingester) Run(done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			for {
+				select {
+				case t := <-in.queue:
+					os.Remove(t.path)
+					in.finish(context.Background(), t.job, data.JobInterrupted, errors.New("the service stopped before processing the file"))
+				default:
+					return
+				}
+			}
+		case t := <-in.queue:
+			in.process(done, t)
+		}
+	}
+}
+
+func (in *Ingester) finish(ctx context.Context, job *data.Job, status string, err error) {
+	now := time.Now().UTC()
+	job.Status, job.FinishedAt = status, &now
+	if err != nil {
+		job.Error = err.Error()
+	}
+	if err := in.jobs.UpdateJob(ctx, job); err != nil {
+		logging.FromContext(ctx).Error("Could not update job", logging.Fields{"job": job.ID.Hex(), "error": err})
+	}
+}
+
+func (in *Ingester) process(done <-chan struct{}, t task) {
+	defer os.Remove(t.path)
+	job := t.job
+	logger := logging.Default().With(logging.Fields{"job": job.ID.Hex()})
+	ctx := logging.NewContext(context.Background(), logger)
+	logger.Info("Processing payments file", logging.Fields{"file": job.FileName})
+
+	total, err := countRows(t.path)
+	if err != nil {
+		in.finish(ctx, job, data.JobFailed, err)
+		return
+	}
+	job.Status, job.TotalRows = data.JobRunning, total
+	if err := in.jobs.UpdateJob(ctx, job); err != nil {
+		logger.Error("Could not update job", logging.Fields{"error": err})
+	}
+
+	f, err := os.Open(t.path)
+	if err != nil {
+		in.finish(ctx, job, data.JobFailed, err)
+		return
+	}
+	defer f.Close()
+
+	status, err := in.importRows(ctx, done, job, csv.NewReader(f), t.mapping)
+	in.finish(ctx, job, status, err)
+	logger.Info("Processed payments file", logging.Fields{
+		"status":  job.Status,
+		"created": job.CreatedRows,
+		"failed":  job.FailedRows,
+	})
+}
+
+// importRows creates the payments of every row read by r and returns the
+// final status of the job.
+func (in *Ingester) importRows(ctx context.Context, done <-chan struct{}, job *data.Job, r *csv.Reader, mapping Mapping) (string, error) {
+	header, err := r.Read()
+	if err == io.EOF {
+		return data.JobCompleted, nil
+	}
+	if err != nil {
+		return data.JobFailed, err
+	}
+	paths, err := mapping.columns(header)
+	if err != nil {
+		return data.JobFailed, err
+	}
+
+	var payments []data.Payment
+	var lines []int
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			line := 0
+			if pe, ok := err.(*csv.ParseError); ok {
+				line = pe.StartLine
+			}
+			job.AddError(data.RowError{Line: line, Error: err.Error()})
+			job.ProcessedRows++
+			continue
+		}
+		line, _ := r.FieldPos(0)
+		payment, column, err := in.payment(job, paths, header, record)
+		if err != nil {
+			job.AddError(data.RowError{Line: line, Column: column, Error: err.Error()})
+			job.ProcessedRows++
+			continue
+		}
+		payments, lines = append(payments, payment), append(lines, line)
+		if len(payments) < in.BatchSize {
+			continue
+		}
+		in.create(ctx, job, payments, lines)
+		payments, lines = nil, nil
+		select {
+		case <-done:
+			return data.JobInterrupted, errors.New("the service stopped while processing the file")
+		default:
+		}
+	}
+	if len(payments) > 0 {
+		in.create(ctx, job, payments, lines)
+	}
+	return data.JobCompleted, nil
+}
+
+// payment builds the payment of a row. On error it also returns the header of
+// the invalid column.
+func (in *Ingester) payment(job *data.Job, paths, header, record []string) (data.Payment, string, error) {
+	var payment data.Payment
+	for i, path := range paths {
+		if err := set(&payment, path, record[i]); err != nil {
+			return payment, header[i], err
+		}
+	}
+	if job.OrganisationID != "" {
+		if payment.OrganisationID == "" {
+			payment.OrganisationID = job.OrganisationID
+		}
+		if payment.OrganisationID != job.OrganisationID {
+			return payment, "", errors.New("payment belongs to another organisation")
+		}
+	}
+	return payment, "", nil
+}
+
+// create inserts a batch of payments read at lines and saves the progress.
+func (in *Ingester) create(ctx context.Context, job *data.Job, payments []data.Payment, lines []int) {
+	results, err := in.payments.CreatePayments(ctx, payments, false)
+	for i, line := range lines {
+		switch {
+		case err != nil:
+			job.AddError(data.RowError{Line: line, Error: err.Error()})
+		case results[i].Err != nil:
+			job.AddError(data.RowError{Line: line, Error: results[i].Err.Error()})
+		default:
+			job.CreatedRows++
+		}
+	}
+	job.ProcessedRows += len(payments)
+	if err := in.jobs.UpdateJob(ctx, job); err != nil {
+		logging.FromContext(ctx).Error("Could not update job", logging.Fields{"error": err})
+	}
+}
+
+// countRows returns the number of rows of a CSV file, without its header.
+func countRows(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	rows := 0
+	for {
+		_, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if _, ok := err.(*csv.ParseError); err != nil && !ok {
+			return 0, fmt.Errorf("reading %v: %v", path, err)
+		}
+		rows++
+	}
+	if rows > 0 {
+		rows--
+	}
+	return rows, nil
+}
+
+// WriteErrorReport writes the row errors of job as CSV with a header line.
+func WriteErrorReport(w io.Writer, job *data.Job) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"line", "column", "error"})
+	for _, e := range job.Errors {
+		cw.Write([]string{fmt.Sprint(e.Line), e.Column, e.Error})
+	}
+	if omitted := job.FailedRows - len(job.Errors); omitted > 0 {
+		cw.Write([]string{"", "", fmt.Sprintf("%d more rows failed, only the first %d errors are kept", omitted, data.MaxJobErrors)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
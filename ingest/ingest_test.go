@@ -0,0 +1,168 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	data "github.com/form3/data"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// payments fails the payments with the id "existing".
+type payments struct {
+	data.PaymentProvider
+	created []data.Payment
+}
+
+func (p *payments) CreatePayments(ctx context.Context, batch []data.Payment, ordered bool) ([]data.BatchResult, error) {
+	results := make([]data.BatchResult, len(batch))
+	for i := range batch {
+		if batch[i].ID == "existing" {
+			results[i].Err = errors.New("E11000 duplicate key error")
+			continue
+		}
+		p.created = append(p.created, batch[i])
+		results[i].Payment = &batch[i]
+	}
+	return results, nil
+}
+
+type jobs struct {
+	updates int
+}
+
+func (j *jobs) CreateJob(ctx context.Context, job *data.Job) error {
+	job.ID = bson.NewObjectId()
+	return nil
+}
+
+func (j *jobs) UpdateJob(ctx context.Context, job *data.Job) error {
+	j.updates++
+	return nil
+}
+
+func (j *jobs) GetJob(ctx context.Context, id bson.ObjectId) (*data.Job, error) {
+	return nil, errors.New("not found")
+}
+
+func writeCSV(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "ingest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "payments.csv")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMappingValidate(t *testing.T) {
+	valid := Mapping{"Amount": "attributes.amount", "Beneficiary": "attributes.beneficiary_party.account_number"}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Didn't expect error %v", err)
+	}
+	for _, path := range []string{"attributes.unknown", "attributes", "attributes.charges_information.sender_charges", "_id", "status"} {
+		if err := (Mapping{"column": path}).Validate(); err == nil {
+			t.Errorf("expected an error for %v", path)
+		}
+	}
+}
+
+func TestMappingColumns(t *testing.T) {
+	m := Mapping{"Amount": "attributes.amount"}
+
+	paths, err := m.columns([]string{"id", " Amount "})
+	if err != nil || strings.Join(paths, ",") != "id,attributes.amount" {
+		t.Errorf("unexpected columns %v %v", paths, err)
+	}
+	if _, err := m.columns([]string{"Reference"}); err == nil {
+		t.Errorf("expected an error for an unmapped column")
+	}
+	if _, err := m.columns([]string{"Amount", "attributes.amount"}); err == nil {
+		t.Errorf("expected an error for columns mapped to the same field")
+	}
+}
+
+func TestProcess(t *testing.T) {
+	path := writeCSV(t, "id,Amount,attributes.currency,organisation_id\n"+
+		"p1,10.5,GBP,\n"+
+		"p2,ten,GBP,\n"+
+		"existing,1,GBP,\n"+
+		"p3,2,EUR,other\n"+
+		"p4,3\n"+
+		"p5,4,USD,org\n")
+	defer os.RemoveAll(filepath.Dir(path))
+	p, j := &payments{}, &jobs{}
+	in := New(p, j, Mapping{"Amount": "attributes.amount"}, 1)
+	in.BatchSize = 2
+
+	submitted := &data.Job{OrganisationID: "org"}
+	if err := in.Submit(context.Background(), submitted, path, nil); err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	task := <-in.queue
+	in.process(make(chan struct{}), task)
+
+	job := task.job
+	if job == submitted || submitted.Status != data.JobQueued {
+		t.Errorf("expected a copy of the job to be processed, submitted %+v", submitted)
+	}
+
+	if job.Status != data.JobCompleted || job.TotalRows != 6 || job.ProcessedRows != 6 || job.CreatedRows != 2 || job.FailedRows != 4 {
+		t.Errorf("unexpected job %+v", job)
+	}
+	if len(p.created) != 2 || p.created[0].Attributes.Amount != 10.5 || p.created[0].OrganisationID != "org" || p.created[1].ID != "p5" {
+		t.Errorf("unexpected payments %+v", p.created)
+	}
+
+	var report bytes.Buffer
+	if err := WriteErrorReport(&report, job); err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	expected := "line,column,error\n" +
+		"3,Amount,\"\"\"ten\"\" is not a number\"\n" +
+		"4,,E11000 duplicate key error\n" +
+		"5,,payment belongs to another organisation\n" +
+		"6,,record on line 6: wrong number of fields\n"
+	if expected != report.String() {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, report.String())
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the uploaded file should be removed")
+	}
+}
+
+func TestProcessUnmappedColumn(t *testing.T) {
+	path := writeCSV(t, "id,Reference\np1,x\n")
+	defer os.RemoveAll(filepath.Dir(path))
+	in := New(&payments{}, &jobs{}, nil, 1)
+
+	in.Submit(context.Background(), &data.Job{}, path, nil)
+	task := <-in.queue
+	in.process(make(chan struct{}), task)
+
+	job := task.job
+
+	if job.Status != data.JobFailed || job.Error != `column "Reference" is not mapped to a payment field` {
+		t.Errorf("unexpected job %+v", job)
+	}
+}
+
+func TestSubmitQueueFull(t *testing.T) {
+	in := New(&payments{}, &jobs{}, nil, 0)
+
+	job := &data.Job{}
+	if err := in.Submit(context.Background(), job, "payments.csv", nil); err != ErrQueueFull {
+		t.Errorf("expected %v got %v", ErrQueueFull, err)
+	}
+	if job.Status != data.JobFailed {
+		t.Errorf("unexpected status %v", job.Status)
+	}
+}
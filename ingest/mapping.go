@@ -0,0 +1,106 @@
+package ingest
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	data "github.com/form3/data"
+)
+
+// Mapping maps CSV column headers to the dotted JSON path of a payment field,
+// e.g. "Beneficiary account" to "attributes.beneficiary_party.account_number".
+// Columns missing from the mapping are matched by their header when it is
+// itself a field path.
+type Mapping map[string]string
+
+// Merge returns the mapping with the entries of other added or replaced.
+func (m Mapping) Merge(other Mapping) Mapping {
+	merged := Mapping{}
+	for column, path := range m {
+		merged[column] = path
+	}
+	for column, path := range other {
+		merged[column] = path
+	}
+	return merged
+}
+
+// Validate checks every mapped path is a field of a payment that can be read
+// from a CSV cell.
+func (m Mapping) Validate() error {
+	for column, path := range m {
+		if _, err := field(reflect.ValueOf(&data.Payment{}).Elem(), path); err != nil {
+			return fmt.Errorf("column %q: %v", column, err)
+		}
+	}
+	return nil
+}
+
+// columns resolves the field path of every header.
+func (m Mapping) columns(header []string) ([]string, error) {
+	paths := make([]string, len(header))
+	seen := map[string]string{}
+	for i, column := range header {
+		column = strings.TrimSpace(column)
+		path, ok := m[column]
+		if !ok {
+			path = column
+		}
+		if _, err := field(reflect.ValueOf(&data.Payment{}).Elem(), path); err != nil {
+			return nil, fmt.Errorf("column %q is not mapped to a payment field", column)
+		}
+		if other, ok := seen[path]; ok {
+			return nil, fmt.Errorf("columns %q and %q are both mapped to %v", other, column, path)
+		}
+		seen[path] = column
+		paths[i] = path
+	}
+	return paths, nil
+}
+
+// field returns the settable field of v, a payment, at the dotted JSON path.
+func field(v reflect.Value, path string) (reflect.Value, error) {
+	if path == "_id" || path == "status" {
+		return reflect.Value{}, fmt.Errorf("%v is set by the service", path)
+	}
+	v, err := data.PaymentField(v, path)
+	if err != nil {
+		return v, err
+	}
+	switch v.Kind() {
+	case reflect.String, reflect.Float64, reflect.Int, reflect.Int64:
+		return v, nil
+	}
+	return reflect.Value{}, fmt.Errorf("%v cannot be read from a CSV cell", path)
+}
+
+// set parses the cell s into the field of payment at path.
+func set(payment *data.Payment, path, s string) error {
+	v, err := field(reflect.ValueOf(payment).Elem(), path)
+	if err != nil {
+		return err
+	}
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", s)
+		}
+		v.SetFloat(f)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", s)
+		}
+		v.SetInt(n)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package iso20022
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	data "github.com/form3/data"
+)
+
+// Camt053Prefix starts the namespace of every version of the bank to customer
+// statement.
+const Camt053Prefix = "urn:iso:std:iso:20022:tech:xsd:camt.053."
+
+// Camt053 is a camt.053 bank to customer statement document. Only the
+// elements read into statement entries are declared, the same in every
+// version from 2 on.
+type Camt053 struct {
+	XMLName   xml.Name                `xml:"Document"`
+	Statement BankToCustomerStatement `xml:"BkToCstmrStmt"`
+}
+
+// BankToCustomerStatement lists the statements of the message.
+type BankToCustomerStatement struct {
+	GroupHeader struct {
+		MessageID string `xml:"MsgId"`
+	} `xml:"GrpHdr"`
+	Statements []AccountStatement `xml:"Stmt"`
+}
+
+// AccountStatement is the statement of one account.
+type AccountStatement struct {
+	ID      string        `xml:"Id"`
+	Account CashAccount   `xml:"Acct"`
+	Entries []ReportEntry `xml:"Ntry"`
+}
+
+// ReportEntry is a booking of a statement, possibly batching several
+// transactions.
+type ReportEntry struct {
+	Reference         string         `xml:"NtryRef"`
+	Amount            Amount         `xml:"Amt"`
+	CreditDebit       string         `xml:"CdtDbtInd"`
+	Reversal          bool           `xml:"RvslInd"`
+	BookingDate       DateChoice     `xml:"BookgDt"`
+	ValueDate         DateChoice     `xml:"ValDt"`
+	ServicerReference string         `xml:"AcctSvcrRef"`
+	Details           []EntryDetails `xml:"NtryDtls"`
+	Information       string         `xml:"AddtlNtryInf"`
+}
+
+// EntryDetails lists the transactions of an entry.
+type EntryDetails struct {
+	Transactions []TransactionDetails `xml:"TxDtls"`
+}
+
+// TransactionDetails is one transaction of an entry.
+type TransactionDetails struct {
+	References struct {
+		EndToEndID        string `xml:"EndToEndId"`
+		ServicerReference string `xml:"AcctSvcrRef"`
+	} `xml:"Refs"`
+	Amount     *Amount                `xml:"Amt"`
+	Remittance *RemittanceInformation `xml:"RmtInf"`
+}
+
+// ParseCamt053 reads the entries of the statements of a camt.053 message, one
+// per transaction of a batched entry. The account of a statement is its IBAN,
+// or else its other identification.
+func ParseCamt053(r io.Reader) ([]data.StatementEntry, error) {
+	var doc Camt053
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(doc.XMLName.Space, Camt053Prefix) {
+		return nil, fmt.Errorf("expected a Document of namespace %v*, got %v", Camt053Prefix, doc.XMLName.Space)
+	}
+	if len(doc.Statement.Statements) == 0 {
+		return nil, fmt.Errorf("the message has no statement")
+	}
+	var entries []data.StatementEntry
+	for _, stmt := range doc.Statement.Statements {
+		account := stmt.Account.ID.IBAN
+		if account == "" && stmt.Account.ID.Other != nil {
+			account = stmt.Account.ID.Other.ID
+		}
+		if stmt.ID == "" || account == "" {
+			return nil, fmt.Errorf("statement %q has no identification or account", stmt.ID)
+		}
+		sequence := 0
+		for i, ntry := range stmt.Entries {
+			entry := data.StatementEntry{
+				Format:         "camt.053",
+				Account:        account,
+				StatementID:    stmt.ID,
+				EntryReference: ntry.ServicerReference,
+				Currency:       ntry.Amount.Currency,
+				CreditDebit:    ntry.CreditDebit,
+				Reversal:       ntry.Reversal,
+				BookingDate:    ntry.BookingDate.date(),
+				ValueDate:      ntry.ValueDate.date(),
+				Information:    ntry.Information,
+			}
+			if entry.EntryReference == "" {
+				entry.EntryReference = ntry.Reference
+			}
+			if entry.CreditDebit != data.Credit && entry.CreditDebit != data.Debit {
+				return nil, fmt.Errorf("entry %d of statement %v: credit debit indicator %q is not CRDT or DBIT", i, stmt.ID, ntry.CreditDebit)
+			}
+			amount, err := strconv.ParseFloat(strings.TrimSpace(ntry.Amount.Value), 64)
+			if err != nil {
+				return nil, fmt.Errorf("entry %d of statement %v: amount %q is not a number", i, stmt.ID, ntry.Amount.Value)
+			}
+			var txs []TransactionDetails
+			for _, details := range ntry.Details {
+				txs = append(txs, details.Transactions...)
+			}
+			if len(txs) == 0 {
+				entry.Sequence, entry.Amount = sequence, amount
+				entries = append(entries, entry)
+				sequence++
+				continue
+			}
+			for _, tx := range txs {
+				e := entry
+				e.Sequence, e.Amount = sequence, amount
+				sequence++
+				if tx.Amount != nil {
+					if e.Amount, err = strconv.ParseFloat(strings.TrimSpace(tx.Amount.Value), 64); err != nil {
+						return nil, fmt.Errorf("entry %d of statement %v: amount %q is not a number", i, stmt.ID, tx.Amount.Value)
+					}
+					e.Currency = tx.Amount.Currency
+				} else if len(txs) > 1 {
+					return nil, fmt.Errorf("entry %d of statement %v batches transactions without amounts", i, stmt.ID)
+				}
+				if tx.References.EndToEndID != NotProvided {
+					e.EndToEndReference = tx.References.EndToEndID
+				}
+				if tx.References.ServicerReference != "" {
+					e.EntryReference = tx.References.ServicerReference
+				}
+				if tx.Remittance != nil {
+					e.Information = strings.Join(tx.Remittance.Unstructured, " ")
+				}
+				entries = append(entries, e)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// date returns the date, or the date of the date and time.
+func (d DateChoice) date() string {
+	if d.Date == "" && len(d.DateTime) >= 10 {
+		return d.DateTime[:10]
+	}
+	return d.Date
+}
@@ -0,0 +1,86 @@
+package iso20022
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	data "github.com/form3/data"
+)
+
+const testCamt053 = `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.053.001.08">
+  <BkToCstmrStmt>
+    <GrpHdr><MsgId>STMT-MSG-1</MsgId><CreDtTm>2017-01-19T06:00:00</CreDtTm></GrpHdr>
+    <Stmt>
+      <Id>STMT-2017-01-18</Id>
+      <Acct><Id><IBAN>GB29XABC10161234567801</IBAN></Id><Ccy>GBP</Ccy></Acct>
+      <Ntry>
+        <Amt Ccy="GBP">100.21</Amt>
+        <CdtDbtInd>DBIT</CdtDbtInd>
+        <Sts><Cd>BOOK</Cd></Sts>
+        <BookgDt><Dt>2017-01-18</Dt></BookgDt>
+        <ValDt><Dt>2017-01-18</Dt></ValDt>
+        <AcctSvcrRef>BANK-1</AcctSvcrRef>
+        <NtryDtls><TxDtls>
+          <Refs><EndToEndId>Wil piano Jan</EndToEndId></Refs>
+          <RmtInf><Ustrd>Payment for Em's piano lessons</Ustrd></RmtInf>
+        </TxDtls></NtryDtls>
+      </Ntry>
+      <Ntry>
+        <Amt Ccy="GBP">80.00</Amt>
+        <CdtDbtInd>DBIT</CdtDbtInd>
+        <BookgDt><DtTm>2017-01-18T15:00:00</DtTm></BookgDt>
+        <AcctSvcrRef>BANK-2</AcctSvcrRef>
+        <NtryDtls><TxDtls>
+          <Refs><AcctSvcrRef>BANK-2-1</AcctSvcrRef><EndToEndId>Rent Jan</EndToEndId></Refs>
+          <Amt Ccy="GBP">50.00</Amt>
+        </TxDtls><TxDtls>
+          <Refs><EndToEndId>NOTPROVIDED</EndToEndId></Refs>
+          <Amt Ccy="GBP">30.00</Amt>
+        </TxDtls></NtryDtls>
+      </Ntry>
+      <Ntry>
+        <Amt Ccy="GBP">12.50</Amt>
+        <CdtDbtInd>CRDT</CdtDbtInd>
+        <RvslInd>true</RvslInd>
+        <BookgDt><Dt>2017-01-18</Dt></BookgDt>
+        <AddtlNtryInf>Interest</AddtlNtryInf>
+      </Ntry>
+    </Stmt>
+  </BkToCstmrStmt>
+</Document>`
+
+func TestParseCamt053(t *testing.T) {
+	entries, err := ParseCamt053(strings.NewReader(testCamt053))
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	statement := data.StatementEntry{Format: "camt.053", Account: "GB29XABC10161234567801", StatementID: "STMT-2017-01-18", Currency: "GBP", BookingDate: "2017-01-18"}
+	expected := make([]data.StatementEntry, 4)
+	for i := range expected {
+		expected[i] = statement
+		expected[i].Sequence = i
+	}
+	expected[0].EntryReference, expected[0].EndToEndReference, expected[0].Amount, expected[0].CreditDebit = "BANK-1", "Wil piano Jan", 100.21, data.Debit
+	expected[0].ValueDate, expected[0].Information = "2017-01-18", "Payment for Em's piano lessons"
+	expected[1].EntryReference, expected[1].EndToEndReference, expected[1].Amount, expected[1].CreditDebit = "BANK-2-1", "Rent Jan", 50, data.Debit
+	expected[2].EntryReference, expected[2].Amount, expected[2].CreditDebit = "BANK-2", 30, data.Debit
+	expected[3].Amount, expected[3].CreditDebit, expected[3].Reversal, expected[3].Information = 12.5, data.Credit, true, "Interest"
+	if !reflect.DeepEqual(expected, entries) {
+		t.Errorf("\n...expected = %+v\n...obtained = %+v", expected, entries)
+	}
+}
+
+func TestParseCamt053Invalid(t *testing.T) {
+	for name, doc := range map[string]string{
+		"namespace":    strings.Replace(testCamt053, "camt.053.001.08", "pain.001.001.09", 1),
+		"indicator":    strings.Replace(testCamt053, "<CdtDbtInd>CRDT</CdtDbtInd>", "<CdtDbtInd>C</CdtDbtInd>", 1),
+		"amount":       strings.Replace(testCamt053, ">100.21<", ">100,21<", 1),
+		"no statement": `<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.053.001.02"><BkToCstmrStmt/></Document>`,
+	} {
+		if _, err := ParseCamt053(strings.NewReader(doc)); err == nil {
+			t.Errorf("expected an error for the %v", name)
+		}
+	}
+}
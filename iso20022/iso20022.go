@@ -0,0 +1,395 @@
+// Package iso20022 renders payments as ISO 20022 messages and reads them back.
+package iso20022
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	data "github.com/form3/data"
+)
+
+// NotProvided is written in place of mandatory identifiers a payment does not
+// carry, as the usage guidelines recommend.
+const NotProvided = "NOTPROVIDED"
+
+const dateTimeLayout = "2006-01-02T15:04:05"
+
+var (
+	currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+	bicPattern      = regexp.MustCompile(`^[A-Z0-9]{4}[A-Z]{2}[A-Z0-9]{2}([A-Z0-9]{3})?$`)
+	// uetrPattern is a lower case UUID v4, the unique end-to-end transaction
+	// reference carried from the payment ID.
+	uetrPattern = regexp.MustCompile(`^[a-f0-9]{8}-[a-f0-9]{4}-4[a-f0-9]{3}-[89ab][a-f0-9]{3}-[a-f0-9]{12}$`)
+)
+
+// Message is an ISO 20022 document.
+type Message interface {
+	MessageID() string
+	Write(w io.Writer) error
+}
+
+// TransactionError is the problem with one payment of a message.
+type TransactionError struct {
+	// Index of the payment in the message, from 0
+	Index int
+	// ID is the end-to-end reference or ID of the payment, when known
+	ID  string
+	Err error
+}
+
+func (e *TransactionError) Error() string {
+	if e.ID != "" {
+		return fmt.Sprintf("transaction %d (%v): %v", e.Index, e.ID, e.Err)
+	}
+	return fmt.Sprintf("transaction %d: %v", e.Index, e.Err)
+}
+
+// MarshalJSON includes the message of the error.
+func (e *TransactionError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Index int    `json:"index"`
+		ID    string `json:"id,omitempty"`
+		Error string `json:"error"`
+	}{e.Index, e.ID, e.Err.Error()})
+}
+
+// Errors lists the payments of a message that could not be converted.
+type Errors []*TransactionError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// problems collects the validation problems of one transaction.
+type problems []string
+
+func (p *problems) add(format string, args ...interface{}) {
+	*p = append(*p, fmt.Sprintf(format, args...))
+}
+
+// text checks the length of a MaxNText value.
+func (p *problems) text(name, s string, max int) {
+	if len([]rune(s)) > max {
+		p.add("%v is longer than %d characters", name, max)
+	}
+}
+
+func (p problems) err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%v", strings.Join(p, ", "))
+}
+
+// Amount is an amount of money in a currency.
+type Amount struct {
+	Currency string `xml:"Ccy,attr"`
+	Value    string `xml:",chardata"`
+}
+
+func newAmount(amount float64, currency string) *Amount {
+	return &Amount{Currency: currency, Value: decimal(amount)}
+}
+
+// decimal formats an amount with at most the 5 fraction digits of the
+// ISO 20022 amount types.
+func decimal(f float64) string {
+	return strconv.FormatFloat(math.Round(f*1e5)/1e5, 'f', -1, 64)
+}
+
+// DateChoice is a date, or a date and time.
+type DateChoice struct {
+	Date     string `xml:"Dt,omitempty"`
+	DateTime string `xml:"DtTm,omitempty"`
+}
+
+// PostalAddress is an address as free text lines.
+type PostalAddress struct {
+	AddressLines []string `xml:"AdrLine"`
+}
+
+// GenericID is an identification under a scheme.
+type GenericID struct {
+	ID         string      `xml:"Id"`
+	SchemeName *CodeChoice `xml:"SchmeNm,omitempty"`
+}
+
+// CodeChoice is either an external code or a proprietary value.
+type CodeChoice struct {
+	Code        string `xml:"Cd,omitempty"`
+	Proprietary string `xml:"Prtry,omitempty"`
+}
+
+// PartyID identifies an organisation.
+type PartyID struct {
+	Organisation *OrganisationID `xml:"OrgId,omitempty"`
+}
+
+// OrganisationID lists identifications of an organisation.
+type OrganisationID struct {
+	Other []GenericID `xml:"Othr"`
+}
+
+// Party is a debtor, creditor or initiating party.
+type Party struct {
+	Name    string         `xml:"Nm,omitempty"`
+	Address *PostalAddress `xml:"PstlAdr,omitempty"`
+	ID      *PartyID       `xml:"Id,omitempty"`
+}
+
+// AccountID is an IBAN or another account number.
+type AccountID struct {
+	IBAN  string     `xml:"IBAN,omitempty"`
+	Other *GenericID `xml:"Othr,omitempty"`
+}
+
+// CashAccount is the account of a debtor or creditor.
+type CashAccount struct {
+	ID   AccountID `xml:"Id"`
+	Name string    `xml:"Nm,omitempty"`
+}
+
+// ClearingSystemMember is the identification of a bank in a clearing system,
+// e.g. a UK sort code.
+type ClearingSystemMember struct {
+	System   CodeChoice `xml:"ClrSysId"`
+	MemberID string     `xml:"MmbId"`
+}
+
+// FinancialInstitution identifies a bank.
+type FinancialInstitution struct {
+	BIC            string                `xml:"BICFI,omitempty"`
+	ClearingSystem *ClearingSystemMember `xml:"ClrSysMmbId,omitempty"`
+	Other          *GenericID            `xml:"Othr,omitempty"`
+}
+
+// Agent is the bank of a party.
+type Agent struct {
+	FinancialInstitution FinancialInstitution `xml:"FinInstnId"`
+}
+
+// ExchangeRate is the exchange rate agreed for a transaction.
+type ExchangeRate struct {
+	Rate       string `xml:"XchgRate,omitempty"`
+	RateType   string `xml:"RateTp,omitempty"`
+	ContractID string `xml:"CtrctId,omitempty"`
+}
+
+// RemittanceInformation is the reference of a transaction for the creditor.
+type RemittanceInformation struct {
+	Unstructured []string `xml:"Ustrd"`
+}
+
+// party returns the debtor or creditor of an account, named by its holder
+// name or else by its account name.
+func party(account data.Account, role string, p *problems) *Party {
+	party := &Party{Name: account.Name}
+	if party.Name == "" {
+		party.Name = account.AccountName
+	}
+	p.text(role+" name", party.Name, 140)
+	if account.Address != "" {
+		party.Address = &PostalAddress{AddressLines: addressLines(account.Address)}
+		if len(party.Address.AddressLines) > 7 {
+			p.add("%v address is longer than 7 lines of 70 characters", role)
+		}
+	}
+	return party
+}
+
+// addressLines splits an address into lines of at most 70 characters,
+// breaking between words.
+func addressLines(address string) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(address) {
+		switch {
+		case line == "":
+			line = word
+		case len([]rune(line))+1+len([]rune(word)) <= 70:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+		for len([]rune(line)) > 70 {
+			lines = append(lines, string([]rune(line)[:70]))
+			line = string([]rune(line)[70:])
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// cashAccount returns the account of a party, identified by its IBAN when
+// account_number_code is IBAN.
+func cashAccount(account data.Account, role string, p *problems) *CashAccount {
+	if account.AccountNumber == "" {
+		p.add("%v account number is required", role)
+		return nil
+	}
+	cash := &CashAccount{Name: account.AccountName}
+	p.text(role+" account name", cash.Name, 70)
+	if strings.EqualFold(account.AccountNumberCode, "IBAN") {
+		cash.ID.IBAN = strings.ToUpper(strings.Replace(account.AccountNumber, " ", "", -1))
+		if !ibanPattern.MatchString(cash.ID.IBAN) {
+			p.add("%v account number %q is not an IBAN", role, account.AccountNumber)
+		}
+		return cash
+	}
+	cash.ID.Other = &GenericID{ID: account.AccountNumber}
+	p.text(role+" account number", account.AccountNumber, 34)
+	if account.AccountNumberCode != "" {
+		cash.ID.Other.SchemeName = codeChoice(account.AccountNumberCode, 4)
+	}
+	return cash
+}
+
+var ibanPattern = regexp.MustCompile(`^[A-Z]{2}[0-9]{2}[A-Z0-9]{1,30}$`)
+
+// codeChoice returns s as a code when it fits the maximum length of the
+// external code list, or else as a proprietary value.
+func codeChoice(s string, max int) *CodeChoice {
+	if len(s) <= max && strings.ToUpper(s) == s && !strings.Contains(s, " ") {
+		return &CodeChoice{Code: s}
+	}
+	return &CodeChoice{Proprietary: s}
+}
+
+// agent returns the bank of an account: a BIC when bank_id_code is SWBIC,
+// a clearing system member such as a GBDSC sort code otherwise, or
+// NOTPROVIDED when the account has no bank.
+func agent(bankID, bankIDCode, role string, p *problems) *Agent {
+	a := &Agent{}
+	switch {
+	case bankID == "":
+		a.FinancialInstitution.Other = &GenericID{ID: NotProvided}
+	case strings.EqualFold(bankIDCode, "SWBIC") || bankIDCode == "" && bicPattern.MatchString(bankID):
+		a.FinancialInstitution.BIC = strings.ToUpper(bankID)
+		if !bicPattern.MatchString(a.FinancialInstitution.BIC) {
+			p.add("%v bank id %q is not a BIC", role, bankID)
+		}
+	default:
+		member := &ClearingSystemMember{MemberID: bankID}
+		p.text(role+" bank id", bankID, 35)
+		if bankIDCode == "" {
+			p.add("%v bank id code is required with a bank id that is not a BIC", role)
+		} else {
+			member.System = *codeChoice(bankIDCode, 5)
+			p.text(role+" bank id code", bankIDCode, 35)
+		}
+		a.FinancialInstitution.ClearingSystem = member
+	}
+	return a
+}
+
+// ChargesBearer returns the ISO 20022 charge bearer code of a bearer code,
+// accepting the MT codes OUR, BEN and SHA as well.
+func ChargesBearer(code string) (string, error) {
+	switch strings.ToUpper(code) {
+	case "DEBT", "OUR":
+		return "DEBT", nil
+	case "CRED", "BEN":
+		return "CRED", nil
+	case "SHAR", "SHA":
+		return "SHAR", nil
+	case "SLEV":
+		return "SLEV", nil
+	}
+	return "", fmt.Errorf("unknown charges bearer code %q", code)
+}
+
+// exchangeRate returns the exchange rate information of a payment, nil when
+// it has none.
+func exchangeRate(fx data.Fx, p *problems) *ExchangeRate {
+	if fx.ExchangeRate == "" && fx.ContractReference == "" {
+		return nil
+	}
+	rate := &ExchangeRate{ContractID: fx.ContractReference}
+	p.text("fx contract reference", fx.ContractReference, 35)
+	if fx.ExchangeRate != "" {
+		f, err := strconv.ParseFloat(fx.ExchangeRate, 64)
+		if err != nil || f <= 0 {
+			p.add("fx exchange rate %q is not a positive number", fx.ExchangeRate)
+		}
+		rate.Rate = fx.ExchangeRate
+	}
+	if fx.ContractReference != "" {
+		rate.RateType = "AGRD"
+	}
+	return rate
+}
+
+// checkAmount checks the amount and currency of a payment.
+func checkAmount(a data.Attributes, p *problems) {
+	if a.Amount <= 0 {
+		p.add("amount must be positive")
+	}
+	if !currencyPattern.MatchString(a.Currency) {
+		p.add("currency %q is not an ISO 4217 code", a.Currency)
+	}
+}
+
+// checkDate checks the processing date of a payment.
+func checkDate(date string, p *problems) {
+	if date == "" {
+		p.add("processing date is required")
+	} else if _, err := time.Parse("2006-01-02", date); err != nil {
+		p.add("processing date %q is not a date as YYYY-MM-DD", date)
+	}
+}
+
+// uetr returns the payment ID when it can be used as the UETR of the
+// transaction.
+func uetr(payment data.Payment) string {
+	if uetrPattern.MatchString(payment.ID) {
+		return payment.ID
+	}
+	return ""
+}
+
+// endToEndID returns the end-to-end reference of a payment, NOTPROVIDED when
+// it has none.
+func endToEndID(a data.Attributes, p *problems) string {
+	if a.EndToEndReference == "" {
+		return NotProvided
+	}
+	p.text("end to end reference", a.EndToEndReference, 35)
+	return a.EndToEndReference
+}
+
+// suffixed returns id with suffix, shortening id so the result fits max
+// characters.
+func suffixed(id, suffix string, max int) string {
+	if len(id)+len(suffix) > max {
+		id = id[:max-len(suffix)]
+	}
+	return id + suffix
+}
+
+// writeXML writes v as an indented XML document.
+func writeXML(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
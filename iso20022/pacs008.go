@@ -0,0 +1,226 @@
+package iso20022
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+
+	data "github.com/form3/data"
+)
+
+// Pacs008Namespace is the namespace of the FI to FI customer credit transfer,
+// version 8.
+const Pacs008Namespace = "urn:iso:std:iso:20022:tech:xsd:pacs.008.001.08"
+
+// DefaultSettlementMethod settles the transfers through a clearing system.
+const DefaultSettlementMethod = "CLRG"
+
+// Pacs008 is a pacs.008.001.08 FI to FI customer credit transfer document.
+type Pacs008 struct {
+	XMLName  xml.Name                     `xml:"urn:iso:std:iso:20022:tech:xsd:pacs.008.001.08 Document"`
+	Transfer FIToFICustomerCreditTransfer `xml:"FIToFICstmrCdtTrf"`
+}
+
+// FIToFICustomerCreditTransfer lists the interbank credit transfers.
+type FIToFICustomerCreditTransfer struct {
+	GroupHeader  InterbankGroupHeader      `xml:"GrpHdr"`
+	Transactions []InterbankCreditTransfer `xml:"CdtTrfTxInf"`
+}
+
+// InterbankGroupHeader identifies the message and how it settles.
+type InterbankGroupHeader struct {
+	MessageID                      string                `xml:"MsgId"`
+	CreationDateTime               string                `xml:"CreDtTm"`
+	NumberOfTransactions           string                `xml:"NbOfTxs"`
+	TotalInterbankSettlementAmount *Amount               `xml:"TtlIntrBkSttlmAmt,omitempty"`
+	SettlementInformation          SettlementInstruction `xml:"SttlmInf"`
+}
+
+// SettlementInstruction is the settlement method of the transfers.
+type SettlementInstruction struct {
+	Method string `xml:"SttlmMtd"`
+}
+
+// Charges is an amount of charges taken by an agent.
+type Charges struct {
+	Amount *Amount `xml:"Amt"`
+	Agent  *Agent  `xml:"Agt"`
+}
+
+// InterbankCreditTransfer is one credit transfer between the agents of the
+// debtor and the creditor.
+type InterbankCreditTransfer struct {
+	PaymentID                 PaymentID               `xml:"PmtId"`
+	PaymentType               *PaymentTypeInformation `xml:"PmtTpInf,omitempty"`
+	InterbankSettlementAmount *Amount                 `xml:"IntrBkSttlmAmt"`
+	InterbankSettlementDate   string                  `xml:"IntrBkSttlmDt,omitempty"`
+	InstructedAmount          *Amount                 `xml:"InstdAmt,omitempty"`
+	ExchangeRate              string                  `xml:"XchgRate,omitempty"`
+	ChargeBearer              string                  `xml:"ChrgBr"`
+	Charges                   []Charges               `xml:"ChrgsInf"`
+	InstructingAgent          *Agent                  `xml:"InstgAgt,omitempty"`
+	InstructedAgent           *Agent                  `xml:"InstdAgt,omitempty"`
+	Debtor                    *Party                  `xml:"Dbtr"`
+	DebtorAccount             *CashAccount            `xml:"DbtrAcct,omitempty"`
+	DebtorAgent               *Agent                  `xml:"DbtrAgt"`
+	CreditorAgent             *Agent                  `xml:"CdtrAgt"`
+	Creditor                  *Party                  `xml:"Cdtr"`
+	CreditorAccount           *CashAccount            `xml:"CdtrAcct,omitempty"`
+	Purpose                   *CodeChoice             `xml:"Purp,omitempty"`
+	Remittance                *RemittanceInformation  `xml:"RmtInf,omitempty"`
+}
+
+// NewPacs008 builds an FI to FI customer credit transfer of payments, one
+// transaction per payment, settled with opts.SettlementMethod. It returns
+// Errors listing every payment that cannot be written.
+func NewPacs008(payments []data.Payment, opts MessageOptions) (*Pacs008, error) {
+	if len(payments) == 0 {
+		return nil, ErrNoPayments
+	}
+	var p problems
+	header := opts.header(&p)
+	method := opts.SettlementMethod
+	switch method {
+	case "":
+		method = DefaultSettlementMethod
+	case "INDA", "INGA", "COVE", "CLRG":
+	default:
+		p.add("settlement method %q is not one of INDA, INGA, COVE or CLRG", method)
+	}
+	if err := p.err(); err != nil {
+		return nil, err
+	}
+
+	doc := &Pacs008{}
+	doc.Transfer.GroupHeader = InterbankGroupHeader{
+		MessageID:             header.MessageID,
+		CreationDateTime:      header.CreationDateTime,
+		NumberOfTransactions:  strconv.Itoa(len(payments)),
+		SettlementInformation: SettlementInstruction{Method: method},
+	}
+	var errs Errors
+	currency := payments[0].Attributes.Currency
+	var sum float64
+	for i, payment := range payments {
+		tx, err := interbankTransfer(payment)
+		if err != nil {
+			errs = append(errs, &TransactionError{Index: i, ID: payment.ID, Err: err})
+			continue
+		}
+		doc.Transfer.Transactions = append(doc.Transfer.Transactions, *tx)
+		if payment.Attributes.Currency != currency {
+			currency = ""
+		}
+		sum += payment.Attributes.Amount
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	// the total is only meaningful when every transfer settles in the same
+	// currency
+	if currency != "" {
+		doc.Transfer.GroupHeader.TotalInterbankSettlementAmount = newAmount(sum, currency)
+	}
+	return doc, nil
+}
+
+// interbankTransfer validates a payment and returns its transaction. The
+// agents of the debtor and the creditor instruct and are instructed, and the
+// transfer settles on the processing date.
+func interbankTransfer(payment data.Payment) (*InterbankCreditTransfer, error) {
+	var p problems
+	a := payment.Attributes
+	checkAmount(a, &p)
+	checkDate(a.ProcessingDate, &p)
+	debtor, beneficiary := a.DebtorParty, a.BeneficiaryParty
+	if debtor.BankID == "" {
+		p.add("debtor bank id is required")
+	}
+	if beneficiary.BankID == "" {
+		p.add("beneficiary bank id is required")
+	}
+
+	tx := &InterbankCreditTransfer{
+		PaymentID: PaymentID{
+			EndToEndID:    endToEndID(a, &p),
+			TransactionID: a.PaymentID,
+			UETR:          uetr(payment),
+		},
+		InterbankSettlementAmount: newAmount(a.Amount, a.Currency),
+		InterbankSettlementDate:   a.ProcessingDate,
+		InstructingAgent:          agent(debtor.BankID, debtor.BankIDCode, "debtor", &p),
+		InstructedAgent:           agent(beneficiary.BankID, beneficiary.BankIDCode, "beneficiary", &p),
+		Debtor:                    party(debtor, "debtor", &p),
+		DebtorAccount:             cashAccount(debtor, "debtor", &p),
+		Creditor:                  party(beneficiary, "beneficiary", &p),
+		CreditorAccount:           cashAccount(beneficiary, "beneficiary", &p),
+	}
+	tx.DebtorAgent, tx.CreditorAgent = tx.InstructingAgent, tx.InstructedAgent
+	p.text("payment id", a.PaymentID, 35)
+	if a.PaymentScheme != "" {
+		tx.PaymentType = &PaymentTypeInformation{LocalInstrument: &CodeChoice{Proprietary: a.PaymentScheme}}
+		p.text("payment scheme", a.PaymentScheme, 35)
+	}
+
+	fx := a.Fx
+	if fx.ExchangeRate != "" {
+		if rate := exchangeRate(fx, &p); rate != nil {
+			tx.ExchangeRate = rate.Rate
+		}
+	}
+	if fx.OriginalAmount > 0 {
+		if !currencyPattern.MatchString(fx.OriginalCurrency) {
+			p.add("fx original currency %q is not an ISO 4217 code", fx.OriginalCurrency)
+		}
+		tx.InstructedAmount = newAmount(fx.OriginalAmount, fx.OriginalCurrency)
+		if fx.OriginalCurrency != a.Currency && fx.ExchangeRate == "" {
+			p.add("fx exchange rate is required to convert %v to %v", fx.OriginalCurrency, a.Currency)
+		}
+	}
+
+	charges := a.ChargesInformation
+	if charges.BearerCode == "" {
+		p.add("charges bearer code is required")
+	} else if bearer, err := ChargesBearer(charges.BearerCode); err != nil {
+		p.add("%v", err)
+	} else {
+		tx.ChargeBearer = bearer
+	}
+	for _, charge := range charges.SenderCharges {
+		if charge.Amount > 0 {
+			tx.Charges = append(tx.Charges, agentCharges(charge, tx.DebtorAgent, &p))
+		}
+	}
+	if charges.ReceiverChargesAmount > 0 {
+		charge := data.AmountCurrency{Amount: charges.ReceiverChargesAmount, Currency: charges.ReceiverChargesCurrency}
+		tx.Charges = append(tx.Charges, agentCharges(charge, tx.CreditorAgent, &p))
+	}
+
+	if a.PaymentPurpose != "" {
+		tx.Purpose = codeChoice(a.PaymentPurpose, 4)
+		p.text("payment purpose", a.PaymentPurpose, 35)
+	}
+	if a.Reference != "" {
+		tx.Remittance = &RemittanceInformation{Unstructured: []string{a.Reference}}
+		p.text("reference", a.Reference, 140)
+	}
+	return tx, p.err()
+}
+
+// agentCharges returns the charges taken by agent.
+func agentCharges(charge data.AmountCurrency, agent *Agent, p *problems) Charges {
+	if !currencyPattern.MatchString(charge.Currency) {
+		p.add("charges currency %q is not an ISO 4217 code", charge.Currency)
+	}
+	return Charges{Amount: newAmount(charge.Amount, charge.Currency), Agent: agent}
+}
+
+// MessageID returns the identification of the message.
+func (d *Pacs008) MessageID() string {
+	return d.Transfer.GroupHeader.MessageID
+}
+
+// Write writes the document as XML.
+func (d *Pacs008) Write(w io.Writer) error {
+	return writeXML(w, d)
+}
@@ -0,0 +1,207 @@
+package iso20022
+
+import (
+	"bytes"
+	"testing"
+
+	data "github.com/form3/data"
+)
+
+func TestNewPacs008(t *testing.T) {
+	payment := testPayment()
+	payment.Attributes.ChargesInformation = data.ChargesInformation{
+		BearerCode:              "SHAR",
+		SenderCharges:           []data.AmountCurrency{{Amount: 5, Currency: "GBP"}, {Amount: 0, Currency: "GBP"}},
+		ReceiverChargesAmount:   1.5,
+		ReceiverChargesCurrency: "USD",
+	}
+
+	doc, err := NewPacs008([]data.Payment{payment}, testOptions)
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	var buf bytes.Buffer
+	if err := doc.Write(&buf); err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:pacs.008.001.08">
+  <FIToFICstmrCdtTrf>
+    <GrpHdr>
+      <MsgId>MSG-1</MsgId>
+      <CreDtTm>2017-01-17T10:30:00</CreDtTm>
+      <NbOfTxs>1</NbOfTxs>
+      <TtlIntrBkSttlmAmt Ccy="GBP">100.21</TtlIntrBkSttlmAmt>
+      <SttlmInf>
+        <SttlmMtd>CLRG</SttlmMtd>
+      </SttlmInf>
+    </GrpHdr>
+    <CdtTrfTxInf>
+      <PmtId>
+        <EndToEndId>Wil piano Jan</EndToEndId>
+        <TxId>123456789012345678</TxId>
+        <UETR>4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43</UETR>
+      </PmtId>
+      <PmtTpInf>
+        <LclInstrm>
+          <Prtry>FPS</Prtry>
+        </LclInstrm>
+      </PmtTpInf>
+      <IntrBkSttlmAmt Ccy="GBP">100.21</IntrBkSttlmAmt>
+      <IntrBkSttlmDt>2017-01-18</IntrBkSttlmDt>
+      <InstdAmt Ccy="USD">200.42</InstdAmt>
+      <XchgRate>2.00000</XchgRate>
+      <ChrgBr>SHAR</ChrgBr>
+      <ChrgsInf>
+        <Amt Ccy="GBP">5</Amt>
+        <Agt>
+          <FinInstnId>
+            <ClrSysMmbId>
+              <ClrSysId>
+                <Cd>GBDSC</Cd>
+              </ClrSysId>
+              <MmbId>203301</MmbId>
+            </ClrSysMmbId>
+          </FinInstnId>
+        </Agt>
+      </ChrgsInf>
+      <ChrgsInf>
+        <Amt Ccy="USD">1.5</Amt>
+        <Agt>
+          <FinInstnId>
+            <ClrSysMmbId>
+              <ClrSysId>
+                <Cd>GBDSC</Cd>
+              </ClrSysId>
+              <MmbId>403000</MmbId>
+            </ClrSysMmbId>
+          </FinInstnId>
+        </Agt>
+      </ChrgsInf>
+      <InstgAgt>
+        <FinInstnId>
+          <ClrSysMmbId>
+            <ClrSysId>
+              <Cd>GBDSC</Cd>
+            </ClrSysId>
+            <MmbId>203301</MmbId>
+          </ClrSysMmbId>
+        </FinInstnId>
+      </InstgAgt>
+      <InstdAgt>
+        <FinInstnId>
+          <ClrSysMmbId>
+            <ClrSysId>
+              <Cd>GBDSC</Cd>
+            </ClrSysId>
+            <MmbId>403000</MmbId>
+          </ClrSysMmbId>
+        </FinInstnId>
+      </InstdAgt>
+      <Dbtr>
+        <Nm>Emelia Jane Brown</Nm>
+        <PstlAdr>
+          <AdrLine>10 Debtor Crescent Sourcetown NE1</AdrLine>
+        </PstlAdr>
+      </Dbtr>
+      <DbtrAcct>
+        <Id>
+          <IBAN>GB29XABC10161234567801</IBAN>
+        </Id>
+        <Nm>EJ Brown Black</Nm>
+      </DbtrAcct>
+      <DbtrAgt>
+        <FinInstnId>
+          <ClrSysMmbId>
+            <ClrSysId>
+              <Cd>GBDSC</Cd>
+            </ClrSysId>
+            <MmbId>203301</MmbId>
+          </ClrSysMmbId>
+        </FinInstnId>
+      </DbtrAgt>
+      <CdtrAgt>
+        <FinInstnId>
+          <ClrSysMmbId>
+            <ClrSysId>
+              <Cd>GBDSC</Cd>
+            </ClrSysId>
+            <MmbId>403000</MmbId>
+          </ClrSysMmbId>
+        </FinInstnId>
+      </CdtrAgt>
+      <Cdtr>
+        <Nm>Wilfred Jeremiah Owens</Nm>
+        <PstlAdr>
+          <AdrLine>1 The Beneficiary Localtown SE2</AdrLine>
+        </PstlAdr>
+      </Cdtr>
+      <CdtrAcct>
+        <Id>
+          <Othr>
+            <Id>31926819</Id>
+            <SchmeNm>
+              <Cd>BBAN</Cd>
+            </SchmeNm>
+          </Othr>
+        </Id>
+        <Nm>W Owens</Nm>
+      </CdtrAcct>
+      <Purp>
+        <Prtry>Paying for goods/services</Prtry>
+      </Purp>
+      <RmtInf>
+        <Ustrd>Payment for Em&#39;s piano lessons</Ustrd>
+      </RmtInf>
+    </CdtTrfTxInf>
+  </FIToFICstmrCdtTrf>
+</Document>
+`
+	if expected != buf.String() {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, buf.String())
+	}
+}
+
+func TestNewPacs008Errors(t *testing.T) {
+	payment := testPayment()
+	payment.Attributes.DebtorParty.BankID = ""
+	payment.Attributes.ChargesInformation.BearerCode = ""
+	payment.Attributes.Fx = data.Fx{OriginalAmount: 200.42, OriginalCurrency: "USD"}
+	other := testPayment()
+	other.Attributes.ChargesInformation.SenderCharges = []data.AmountCurrency{{Amount: 1}}
+
+	_, err := NewPacs008([]data.Payment{payment, testPayment(), other}, testOptions)
+	expected := `transaction 0 (4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43): debtor bank id is required, fx exchange rate is required to convert USD to GBP, charges bearer code is required; ` +
+		`transaction 2 (4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43): charges currency "" is not an ISO 4217 code`
+	if err == nil || err.Error() != expected {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, err)
+	}
+
+	opts := testOptions
+	opts.SettlementMethod = "CASH"
+	_, err = NewPacs008([]data.Payment{testPayment()}, opts)
+	expected = `settlement method "CASH" is not one of INDA, INGA, COVE or CLRG`
+	if err == nil || err.Error() != expected {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, err)
+	}
+}
+
+func TestNewPacs008Currencies(t *testing.T) {
+	euro := testPayment()
+	euro.Attributes.Currency = "EUR"
+	doc, err := NewPacs008([]data.Payment{testPayment(), testPayment()}, testOptions)
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	if total := doc.Transfer.GroupHeader.TotalInterbankSettlementAmount; total == nil || *total != (Amount{Currency: "GBP", Value: "200.42"}) {
+		t.Errorf("expected a total of 200.42 GBP, got %v", total)
+	}
+	doc, err = NewPacs008([]data.Payment{testPayment(), euro}, testOptions)
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	if total := doc.Transfer.GroupHeader.TotalInterbankSettlementAmount; total != nil {
+		t.Errorf("expected no total for transfers in GBP and EUR, got %v", total)
+	}
+}
@@ -0,0 +1,259 @@
+package iso20022
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	data "github.com/form3/data"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ErrNoPayments is returned for a message without payments, which the schema
+// does not allow.
+var ErrNoPayments = errors.New("a message needs at least one payment")
+
+// Pain001Namespace is the namespace of the customer credit transfer
+// initiation, version 9.
+const Pain001Namespace = "urn:iso:std:iso:20022:tech:xsd:pain.001.001.09"
+
+// Pain001 is a pain.001.001.09 customer credit transfer initiation document.
+type Pain001 struct {
+	XMLName    xml.Name                         `xml:"urn:iso:std:iso:20022:tech:xsd:pain.001.001.09 Document"`
+	Initiation CustomerCreditTransferInitiation `xml:"CstmrCdtTrfInitn"`
+}
+
+// CustomerCreditTransferInitiation groups the credit transfers by debtor.
+type CustomerCreditTransferInitiation struct {
+	GroupHeader        GroupHeader          `xml:"GrpHdr"`
+	PaymentInformation []PaymentInformation `xml:"PmtInf"`
+}
+
+// GroupHeader identifies the message and totals its transactions.
+type GroupHeader struct {
+	MessageID            string `xml:"MsgId"`
+	CreationDateTime     string `xml:"CreDtTm"`
+	NumberOfTransactions string `xml:"NbOfTxs"`
+	ControlSum           string `xml:"CtrlSum,omitempty"`
+	InitiatingParty      Party  `xml:"InitgPty"`
+}
+
+// PaymentInformation is the set of credit transfers debited from one account
+// on one date.
+type PaymentInformation struct {
+	ID                     string                      `xml:"PmtInfId"`
+	Method                 string                      `xml:"PmtMtd"`
+	NumberOfTransactions   string                      `xml:"NbOfTxs,omitempty"`
+	ControlSum             string                      `xml:"CtrlSum,omitempty"`
+	RequestedExecutionDate DateChoice                  `xml:"ReqdExctnDt"`
+	Debtor                 Party                       `xml:"Dbtr"`
+	DebtorAccount          CashAccount                 `xml:"DbtrAcct"`
+	DebtorAgent            Agent                       `xml:"DbtrAgt"`
+	ChargeBearer           string                      `xml:"ChrgBr,omitempty"`
+	Transactions           []CreditTransferTransaction `xml:"CdtTrfTxInf"`
+}
+
+// PaymentID holds the references of a transaction.
+type PaymentID struct {
+	InstructionID string `xml:"InstrId,omitempty"`
+	EndToEndID    string `xml:"EndToEndId"`
+	TransactionID string `xml:"TxId,omitempty"`
+	UETR          string `xml:"UETR,omitempty"`
+}
+
+// PaymentTypeInformation carries the payment scheme as the local instrument.
+type PaymentTypeInformation struct {
+	LocalInstrument *CodeChoice `xml:"LclInstrm,omitempty"`
+}
+
+// TransactionAmount is the amount to transfer.
+type TransactionAmount struct {
+	Instructed *Amount `xml:"InstdAmt,omitempty"`
+}
+
+// CreditTransferTransaction is one payment to a creditor.
+type CreditTransferTransaction struct {
+	PaymentID       PaymentID               `xml:"PmtId"`
+	PaymentType     *PaymentTypeInformation `xml:"PmtTpInf,omitempty"`
+	Amount          TransactionAmount       `xml:"Amt"`
+	ExchangeRate    *ExchangeRate           `xml:"XchgRateInf,omitempty"`
+	ChargeBearer    string                  `xml:"ChrgBr,omitempty"`
+	CreditorAgent   *Agent                  `xml:"CdtrAgt,omitempty"`
+	Creditor        *Party                  `xml:"Cdtr,omitempty"`
+	CreditorAccount *CashAccount            `xml:"CdtrAcct,omitempty"`
+	Purpose         *CodeChoice             `xml:"Purp,omitempty"`
+	Remittance      *RemittanceInformation  `xml:"RmtInf,omitempty"`
+}
+
+// MessageOptions sets the group header of a message.
+type MessageOptions struct {
+	// MessageID defaults to a new object ID
+	MessageID string
+	// CreatedAt defaults to now
+	CreatedAt time.Time
+	// InitiatingParty is the name of the party sending the message
+	InitiatingParty string
+	// SettlementMethod of a pacs.008, defaults to DefaultSettlementMethod
+	SettlementMethod string
+}
+
+func (o MessageOptions) header(p *problems) GroupHeader {
+	if o.MessageID == "" {
+		o.MessageID = bson.NewObjectId().Hex()
+	}
+	if o.CreatedAt.IsZero() {
+		o.CreatedAt = time.Now()
+	}
+	p.text("message id", o.MessageID, 35)
+	p.text("initiating party", o.InitiatingParty, 140)
+	return GroupHeader{
+		MessageID:        o.MessageID,
+		CreationDateTime: o.CreatedAt.UTC().Format(dateTimeLayout),
+		InitiatingParty:  Party{Name: o.InitiatingParty},
+	}
+}
+
+// debtorKey identifies the payment information of a payment.
+type debtorKey struct {
+	account data.Account
+	date    string
+}
+
+// NewPain001 builds a credit transfer initiation of payments, with one
+// payment information per debtor account and processing date, in the order
+// of the payments. It returns Errors listing every payment that cannot be
+// written.
+func NewPain001(payments []data.Payment, opts MessageOptions) (*Pain001, error) {
+	if len(payments) == 0 {
+		return nil, ErrNoPayments
+	}
+	var errs Errors
+	var p problems
+	doc := &Pain001{}
+	doc.Initiation.GroupHeader = opts.header(&p)
+	if err := p.err(); err != nil {
+		return nil, err
+	}
+
+	organisation := ""
+	infos := map[debtorKey]int{}
+	var sum float64
+	for i, payment := range payments {
+		tx, err := creditTransfer(payment)
+		if err != nil {
+			errs = append(errs, &TransactionError{Index: i, ID: payment.ID, Err: err})
+			continue
+		}
+		if i == 0 {
+			organisation = payment.OrganisationID
+		} else if payment.OrganisationID != organisation {
+			organisation = ""
+		}
+		key := debtorKey{account: payment.Attributes.DebtorParty, date: payment.Attributes.ProcessingDate}
+		n, ok := infos[key]
+		if !ok {
+			n = len(doc.Initiation.PaymentInformation)
+			infos[key] = n
+			doc.Initiation.PaymentInformation = append(doc.Initiation.PaymentInformation, paymentInformation(doc.Initiation.GroupHeader.MessageID, n, payment))
+		}
+		info := &doc.Initiation.PaymentInformation[n]
+		info.Transactions = append(info.Transactions, *tx)
+		sum += payment.Attributes.Amount
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	header := &doc.Initiation.GroupHeader
+	header.NumberOfTransactions = strconv.Itoa(len(payments))
+	header.ControlSum = decimal(sum)
+	if organisation != "" {
+		header.InitiatingParty.ID = &PartyID{Organisation: &OrganisationID{Other: []GenericID{{ID: organisation}}}}
+	}
+	for i := range doc.Initiation.PaymentInformation {
+		info := &doc.Initiation.PaymentInformation[i]
+		var sum float64
+		for _, tx := range info.Transactions {
+			f, _ := strconv.ParseFloat(tx.Amount.Instructed.Value, 64)
+			sum += f
+		}
+		info.NumberOfTransactions = strconv.Itoa(len(info.Transactions))
+		info.ControlSum = decimal(sum)
+	}
+	return doc, nil
+}
+
+// paymentInformation returns the n-th payment information of a message, for
+// the debtor and processing date of payment.
+func paymentInformation(messageID string, n int, payment data.Payment) PaymentInformation {
+	var p problems
+	debtor := payment.Attributes.DebtorParty
+	return PaymentInformation{
+		ID:                     suffixed(messageID, "-"+strconv.Itoa(n+1), 35),
+		Method:                 "TRF",
+		RequestedExecutionDate: DateChoice{Date: payment.Attributes.ProcessingDate},
+		Debtor:                 *party(debtor, "debtor", &p),
+		DebtorAccount:          *cashAccount(debtor, "debtor", &p),
+		DebtorAgent:            *agent(debtor.BankID, debtor.BankIDCode, "debtor", &p),
+	}
+}
+
+// creditTransfer validates a payment and returns its transaction.
+func creditTransfer(payment data.Payment) (*CreditTransferTransaction, error) {
+	var p problems
+	a := payment.Attributes
+	checkAmount(a, &p)
+	checkDate(a.ProcessingDate, &p)
+	// the debtor is checked here, it is written in the payment information
+	party(a.DebtorParty, "debtor", &p)
+	cashAccount(a.DebtorParty, "debtor", &p)
+	agent(a.DebtorParty.BankID, a.DebtorParty.BankIDCode, "debtor", &p)
+
+	tx := &CreditTransferTransaction{
+		PaymentID: PaymentID{
+			InstructionID: a.PaymentID,
+			EndToEndID:    endToEndID(a, &p),
+			UETR:          uetr(payment),
+		},
+		Amount:          TransactionAmount{Instructed: newAmount(a.Amount, a.Currency)},
+		ExchangeRate:    exchangeRate(a.Fx, &p),
+		Creditor:        party(a.BeneficiaryParty, "beneficiary", &p),
+		CreditorAccount: cashAccount(a.BeneficiaryParty, "beneficiary", &p),
+	}
+	p.text("payment id", a.PaymentID, 35)
+	if a.PaymentScheme != "" {
+		tx.PaymentType = &PaymentTypeInformation{LocalInstrument: &CodeChoice{Proprietary: a.PaymentScheme}}
+		p.text("payment scheme", a.PaymentScheme, 35)
+	}
+	if a.ChargesInformation.BearerCode != "" {
+		bearer, err := ChargesBearer(a.ChargesInformation.BearerCode)
+		if err != nil {
+			p.add("%v", err)
+		}
+		tx.ChargeBearer = bearer
+	}
+	if a.BeneficiaryParty.BankID != "" {
+		tx.CreditorAgent = agent(a.BeneficiaryParty.BankID, a.BeneficiaryParty.BankIDCode, "beneficiary", &p)
+	}
+	if a.PaymentPurpose != "" {
+		tx.Purpose = codeChoice(a.PaymentPurpose, 4)
+		p.text("payment purpose", a.PaymentPurpose, 35)
+	}
+	if a.Reference != "" {
+		tx.Remittance = &RemittanceInformation{Unstructured: []string{a.Reference}}
+		p.text("reference", a.Reference, 140)
+	}
+	return tx, p.err()
+}
+
+// MessageID returns the identification of the message.
+func (d *Pain001) MessageID() string {
+	return d.Initiation.GroupHeader.MessageID
+}
+
+// Write writes the document as XML.
+func (d *Pain001) Write(w io.Writer) error {
+	return writeXML(w, d)
+}
@@ -0,0 +1,339 @@
+package iso20022
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	data "github.com/form3/data"
+)
+
+// Unsupported is an element of a message that is not read into the payments,
+// with the number of times it appears.
+type Unsupported struct {
+	Path  string `json:"path"`
+	Count int    `json:"count"`
+}
+
+// Pain001Import is a pain.001 message read into payments.
+type Pain001Import struct {
+	MessageID string
+	// Payments holds the payment of every transaction, in the order of the
+	// message. The payments of the transactions in Errors are incomplete.
+	Payments []data.Payment
+	Errors   Errors
+	// Unsupported lists the elements of the message that were ignored.
+	Unsupported []Unsupported
+}
+
+// Valid returns the payments of the transactions without errors and their
+// index in the message.
+func (imp *Pain001Import) Valid() ([]data.Payment, []int) {
+	invalid := map[int]bool{}
+	for _, err := range imp.Errors {
+		invalid[err.Index] = true
+	}
+	var payments []data.Payment
+	var indexes []int
+	for i, payment := range imp.Payments {
+		if !invalid[i] {
+			payments = append(payments, payment)
+			indexes = append(indexes, i)
+		}
+	}
+	return payments, indexes
+}
+
+// pain001Ignored lists the elements read by Pain001 that are not mapped onto
+// payments.
+var pain001Ignored = []string{
+	"CstmrCdtTrfInitn/PmtInf/Dbtr/Id",
+	"CstmrCdtTrfInitn/PmtInf/CdtTrfTxInf/Cdtr/Id",
+}
+
+// ParsePain001 reads a pain.001.001.09 customer credit transfer initiation.
+// The payments belong to organisationID, or else to the organisation
+// identifying the initiating party. It fails when the message itself is
+// invalid; the problems of single transactions are listed in Errors.
+func ParsePain001(r io.Reader, organisationID string) (*Pain001Import, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	known := knownElements(reflect.TypeOf(Pain001{}), "", map[string]bool{})
+	for _, path := range pain001Ignored {
+		delete(known, path)
+	}
+	unsupported, err := unsupportedElements(b, Pain001Namespace, known)
+	if err != nil {
+		return nil, err
+	}
+	var doc Pain001
+	if err := xml.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	if err := doc.check(); err != nil {
+		return nil, err
+	}
+
+	header := doc.Initiation.GroupHeader
+	if id := header.InitiatingParty.ID; organisationID == "" && id != nil && id.Organisation != nil && len(id.Organisation.Other) > 0 {
+		organisationID = id.Organisation.Other[0].ID
+	}
+	imp := &Pain001Import{MessageID: header.MessageID, Unsupported: unsupported}
+	for i := range doc.Initiation.PaymentInformation {
+		info := &doc.Initiation.PaymentInformation[i]
+		for j := range info.Transactions {
+			payment, err := info.Transactions[j].payment(info, organisationID)
+			if err != nil {
+				id := payment.Attributes.EndToEndReference
+				if id == "" {
+					id = payment.ID
+				}
+				imp.Errors = append(imp.Errors, &TransactionError{Index: len(imp.Payments), ID: id, Err: err})
+			}
+			imp.Payments = append(imp.Payments, payment)
+		}
+	}
+	return imp, nil
+}
+
+// check compares the number of transactions and control sums of the message
+// with its transactions.
+func (d *Pain001) check() error {
+	header := d.Initiation.GroupHeader
+	if header.MessageID == "" {
+		return fmt.Errorf("the group header has no message id")
+	}
+	if len(d.Initiation.PaymentInformation) == 0 {
+		return ErrNoPayments
+	}
+	var count int
+	var sum float64
+	for _, info := range d.Initiation.PaymentInformation {
+		var infoSum float64
+		for _, tx := range info.Transactions {
+			if tx.Amount.Instructed != nil {
+				f, _ := strconv.ParseFloat(tx.Amount.Instructed.Value, 64)
+				infoSum += f
+			}
+		}
+		if err := checkTotals("payment information "+info.ID, info.NumberOfTransactions, info.ControlSum, len(info.Transactions), infoSum); err != nil {
+			return err
+		}
+		count += len(info.Transactions)
+		sum += infoSum
+	}
+	if header.NumberOfTransactions == "" {
+		return fmt.Errorf("the group header has no number of transactions")
+	}
+	return checkTotals("the group header", header.NumberOfTransactions, header.ControlSum, count, sum)
+}
+
+// checkTotals checks the declared number of transactions and control sum, when
+// present, of a block against its transactions.
+func checkTotals(block, declaredCount, declaredSum string, count int, sum float64) error {
+	if declaredCount != "" && declaredCount != strconv.Itoa(count) {
+		return fmt.Errorf("%v declares %v transactions, it has %d", block, declaredCount, count)
+	}
+	if declaredSum != "" {
+		f, err := strconv.ParseFloat(declaredSum, 64)
+		if err != nil || math.Abs(f-sum) > 1e-6 {
+			return fmt.Errorf("%v declares a control sum of %v, its transactions total %v", block, declaredSum, decimal(sum))
+		}
+	}
+	return nil
+}
+
+// payment maps a transaction of info onto a payment.
+func (tx *CreditTransferTransaction) payment(info *PaymentInformation, organisationID string) (data.Payment, error) {
+	var p problems
+	payment := data.Payment{
+		ID:             tx.PaymentID.UETR,
+		Type:           "Payment",
+		OrganisationID: organisationID,
+	}
+	if info.Method != "TRF" {
+		p.add("payment method %q is not supported, expected TRF", info.Method)
+	}
+	a := &payment.Attributes
+	a.PaymentID = tx.PaymentID.InstructionID
+	if tx.PaymentID.EndToEndID != NotProvided {
+		a.EndToEndReference = tx.PaymentID.EndToEndID
+	}
+	if amount := tx.Amount.Instructed; amount == nil {
+		p.add("instructed amount is required")
+	} else {
+		f, err := strconv.ParseFloat(amount.Value, 64)
+		if err != nil {
+			p.add("amount %q is not a number", amount.Value)
+		}
+		a.Amount, a.Currency = f, amount.Currency
+		checkAmount(*a, &p)
+	}
+	a.ProcessingDate = info.RequestedExecutionDate.Date
+	if a.ProcessingDate == "" && len(info.RequestedExecutionDate.DateTime) >= 10 {
+		a.ProcessingDate = info.RequestedExecutionDate.DateTime[:10]
+	}
+	checkDate(a.ProcessingDate, &p)
+
+	a.DebtorParty = account(&info.Debtor, &info.DebtorAccount, &info.DebtorAgent)
+	if a.DebtorParty.AccountNumber == "" {
+		p.add("debtor account is required")
+	}
+	a.BeneficiaryParty = account(tx.Creditor, tx.CreditorAccount, tx.CreditorAgent)
+	if a.BeneficiaryParty.AccountNumber == "" {
+		p.add("creditor account is required")
+	}
+
+	bearer := tx.ChargeBearer
+	if bearer == "" {
+		bearer = info.ChargeBearer
+	}
+	if bearer != "" {
+		code, err := ChargesBearer(bearer)
+		if err != nil {
+			p.add("%v", err)
+		}
+		a.ChargesInformation.BearerCode = code
+	}
+	if rate := tx.ExchangeRate; rate != nil {
+		a.Fx.ExchangeRate = rate.Rate
+		a.Fx.ContractReference = rate.ContractID
+	}
+	if tx.PaymentType != nil && tx.PaymentType.LocalInstrument != nil {
+		a.PaymentScheme = tx.PaymentType.LocalInstrument.value()
+	}
+	if tx.Purpose != nil {
+		a.PaymentPurpose = tx.Purpose.value()
+	}
+	if tx.Remittance != nil {
+		a.Reference = strings.Join(tx.Remittance.Unstructured, " ")
+	}
+	return payment, p.err()
+}
+
+// value returns the code, or else the proprietary value.
+func (c *CodeChoice) value() string {
+	if c.Code != "" {
+		return c.Code
+	}
+	return c.Proprietary
+}
+
+// account maps a party, its account and its agent onto an account.
+func account(party *Party, cash *CashAccount, agent *Agent) data.Account {
+	var account data.Account
+	if party != nil {
+		account.Name = party.Name
+		if party.Address != nil {
+			account.Address = strings.Join(party.Address.AddressLines, " ")
+		}
+	}
+	if cash != nil {
+		account.AccountName = cash.Name
+		if cash.ID.IBAN != "" {
+			account.AccountNumber, account.AccountNumberCode = cash.ID.IBAN, "IBAN"
+		} else if other := cash.ID.Other; other != nil {
+			account.AccountNumber = other.ID
+			if other.SchemeName != nil {
+				account.AccountNumberCode = other.SchemeName.value()
+			}
+		}
+	}
+	if agent != nil {
+		institution := agent.FinancialInstitution
+		switch {
+		case institution.BIC != "":
+			account.BankID, account.BankIDCode = institution.BIC, "SWBIC"
+		case institution.ClearingSystem != nil:
+			account.BankID = institution.ClearingSystem.MemberID
+			account.BankIDCode = institution.ClearingSystem.System.value()
+		}
+	}
+	return account
+}
+
+// unsupportedElements checks the root element of the document b is a
+// Document of namespace, and returns the elements whose path is not known.
+// Only the outermost unsupported element of a tree is listed.
+func unsupportedElements(b []byte, namespace string, known map[string]bool) ([]Unsupported, error) {
+	var unsupported []Unsupported
+	seen := map[string]int{}
+	var path []string
+	skip := 0
+	dec := xml.NewDecoder(bytes.NewReader(b))
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			if path == nil {
+				if token.Name.Local != "Document" || token.Name.Space != namespace {
+					return nil, fmt.Errorf("expected a Document of namespace %v, got %v %v", namespace, token.Name.Local, token.Name.Space)
+				}
+				path = []string{}
+				continue
+			}
+			path = append(path, token.Name.Local)
+			if skip > 0 {
+				skip++
+				continue
+			}
+			p := strings.Join(path, "/")
+			if !known[p] {
+				skip = 1
+				if i, ok := seen[p]; ok {
+					unsupported[i].Count++
+				} else {
+					seen[p] = len(unsupported)
+					unsupported = append(unsupported, Unsupported{Path: p, Count: 1})
+				}
+			}
+		case xml.EndElement:
+			if len(path) > 0 {
+				path = path[:len(path)-1]
+			}
+			if skip > 0 {
+				skip--
+			}
+		}
+	}
+	if path == nil {
+		return nil, fmt.Errorf("the message is empty")
+	}
+	return unsupported, nil
+}
+
+// knownElements adds the path of every element of the fields of t, the type
+// of a document, to known and returns it.
+func knownElements(t reflect.Type, prefix string, known map[string]bool) map[string]bool {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return known
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("xml")
+		if f.Name == "XMLName" || tag == "" || strings.Contains(tag, ",attr") || strings.HasPrefix(tag, ",") {
+			continue
+		}
+		path := prefix + strings.Split(tag, ",")[0]
+		known[path] = true
+		knownElements(f.Type, path+"/", known)
+	}
+	return known
+}
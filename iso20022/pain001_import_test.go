@@ -0,0 +1,146 @@
+package iso20022
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	data "github.com/form3/data"
+)
+
+func TestParsePain001RoundTrip(t *testing.T) {
+	payment := testPayment()
+	// the original amount of the exchange is not part of a pain.001
+	payment.Attributes.Fx.OriginalAmount = 0
+	payment.Attributes.Fx.OriginalCurrency = ""
+	other := testPayment()
+	other.ID = ""
+	other.Attributes.EndToEndReference = ""
+	other.Attributes.ChargesInformation.BearerCode = "DEBT"
+	other.Attributes.BeneficiaryParty = data.Account{Name: "A Smith", AccountName: "A Smith", AccountNumber: "GB82WEST12345698765432", AccountNumberCode: "IBAN", BankID: "NWBKGB2L", BankIDCode: "SWBIC"}
+	other.Attributes.Fx = data.Fx{}
+
+	doc, err := NewPain001([]data.Payment{payment, other}, testOptions)
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	var buf bytes.Buffer
+	doc.Write(&buf)
+
+	imp, err := ParsePain001(&buf, "")
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	if imp.MessageID != "MSG-1" || len(imp.Errors) != 0 || len(imp.Unsupported) != 0 {
+		t.Errorf("expected a valid message MSG-1, got %v %v %v", imp.MessageID, imp.Errors, imp.Unsupported)
+	}
+	expected := []data.Payment{payment, other}
+	if !reflect.DeepEqual(expected, imp.Payments) {
+		t.Errorf("\n...expected = %+v\n...obtained = %+v", expected, imp.Payments)
+	}
+}
+
+const pain001Message = `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:pain.001.001.09">
+  <CstmrCdtTrfInitn>
+    <GrpHdr>
+      <MsgId>MSG-2</MsgId>
+      <CreDtTm>2017-01-17T10:30:00</CreDtTm>
+      <NbOfTxs>3</NbOfTxs>
+      <CtrlSum>%v</CtrlSum>
+      <InitgPty><Nm>Customer</Nm></InitgPty>
+    </GrpHdr>
+    <PmtInf>
+      <PmtInfId>MSG-2-1</PmtInfId>
+      <PmtMtd>TRF</PmtMtd>
+      <BtchBookg>true</BtchBookg>
+      <ReqdExctnDt><DtTm>2017-01-18T09:00:00</DtTm></ReqdExctnDt>
+      <Dbtr><Nm>Emelia Jane Brown</Nm></Dbtr>
+      <DbtrAcct><Id><IBAN>GB29XABC10161234567801</IBAN></Id></DbtrAcct>
+      <DbtrAgt><FinInstnId><BICFI>XABCGB2L</BICFI></FinInstnId></DbtrAgt>
+      <ChrgBr>SLEV</ChrgBr>
+      <CdtTrfTxInf>
+        <PmtId><EndToEndId>E2E-1</EndToEndId></PmtId>
+        <Amt><InstdAmt Ccy="EUR">10.5</InstdAmt></Amt>
+        <Cdtr><Nm>A Smith</Nm></Cdtr>
+        <CdtrAcct><Id><Othr><Id>31926819</Id></Othr></Id></CdtrAcct>
+        <UltmtCdtr><Nm>B Smith</Nm></UltmtCdtr>
+        <RmtInf><Ustrd>Invoice 1</Ustrd><Ustrd>and 2</Ustrd></RmtInf>
+      </CdtTrfTxInf>
+      <CdtTrfTxInf>
+        <PmtId><EndToEndId>E2E-2</EndToEndId></PmtId>
+        <Amt><EqvtAmt><Amt Ccy="GBP">20</Amt><CcyOfTrf>EUR</CcyOfTrf></EqvtAmt></Amt>
+        <Cdtr><Nm>C Jones</Nm></Cdtr>
+        <UltmtCdtr><Nm>D Jones</Nm></UltmtCdtr>
+      </CdtTrfTxInf>
+      <CdtTrfTxInf>
+        <PmtId><EndToEndId>E2E-3</EndToEndId></PmtId>
+        <Amt><InstdAmt Ccy="EUR">5</InstdAmt></Amt>
+        <ChrgBr>ME</ChrgBr>
+        <Cdtr><Nm>E Evans</Nm></Cdtr>
+        <CdtrAcct><Id><IBAN>GB82WEST12345698765432</IBAN></Id></CdtrAcct>
+      </CdtTrfTxInf>
+    </PmtInf>
+  </CstmrCdtTrfInitn>
+</Document>`
+
+func TestParsePain001Errors(t *testing.T) {
+	imp, err := ParsePain001(strings.NewReader(fmt.Sprintf(pain001Message, "15.5")), "743d5b63-8e6f-432e-a8fa-c5d8d2ee5fcb")
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+
+	expected := `transaction 1 (E2E-2): instructed amount is required, creditor account is required; transaction 2 (E2E-3): unknown charges bearer code "ME"`
+	if imp.Errors.Error() != expected {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, imp.Errors.Error())
+	}
+	expectedUnsupported := []Unsupported{
+		{Path: "CstmrCdtTrfInitn/PmtInf/BtchBookg", Count: 1},
+		{Path: "CstmrCdtTrfInitn/PmtInf/CdtTrfTxInf/UltmtCdtr", Count: 2},
+		{Path: "CstmrCdtTrfInitn/PmtInf/CdtTrfTxInf/Amt/EqvtAmt", Count: 1},
+	}
+	if !reflect.DeepEqual(expectedUnsupported, imp.Unsupported) {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expectedUnsupported, imp.Unsupported)
+	}
+
+	payments, indexes := imp.Valid()
+	if !reflect.DeepEqual([]int{0}, indexes) {
+		t.Fatalf("expected only the first transaction to be valid, got %v", indexes)
+	}
+	expectedPayment := data.Payment{
+		Type:           "Payment",
+		OrganisationID: "743d5b63-8e6f-432e-a8fa-c5d8d2ee5fcb",
+		Attributes: data.Attributes{
+			Amount:             10.5,
+			Currency:           "EUR",
+			EndToEndReference:  "E2E-1",
+			ProcessingDate:     "2017-01-18",
+			ChargesInformation: data.ChargesInformation{BearerCode: "SLEV"},
+			DebtorParty:        data.Account{Name: "Emelia Jane Brown", AccountNumber: "GB29XABC10161234567801", AccountNumberCode: "IBAN", BankID: "XABCGB2L", BankIDCode: "SWBIC"},
+			BeneficiaryParty:   data.Account{Name: "A Smith", AccountNumber: "31926819"},
+			Reference:          "Invoice 1 and 2",
+		},
+	}
+	if !reflect.DeepEqual(expectedPayment, payments[0]) {
+		t.Errorf("\n...expected = %+v\n...obtained = %+v", expectedPayment, payments[0])
+	}
+}
+
+func TestParsePain001Invalid(t *testing.T) {
+	for _, test := range []struct {
+		message  string
+		expected string
+	}{
+		{fmt.Sprintf(pain001Message, "16"), "the group header declares a control sum of 16, its transactions total 15.5"},
+		{strings.Replace(fmt.Sprintf(pain001Message, "15.5"), "<NbOfTxs>3</NbOfTxs>", "<NbOfTxs>4</NbOfTxs>", 1), "the group header declares 4 transactions, it has 3"},
+		{strings.Replace(pain001Message, "pain.001.001.09", "pain.001.001.03", 1), "expected a Document of namespace urn:iso:std:iso:20022:tech:xsd:pain.001.001.09, got Document urn:iso:std:iso:20022:tech:xsd:pain.001.001.03"},
+		{"", "the message is empty"},
+	} {
+		_, err := ParsePain001(strings.NewReader(test.message), "")
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("\n...expected = %v\n...obtained = %v", test.expected, err)
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package iso20022
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	data "github.com/form3/data"
+)
+
+func testPayment() data.Payment {
+	return data.Payment{
+		ID:             "4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43",
+		Type:           "Payment",
+		OrganisationID: "743d5b63-8e6f-432e-a8fa-c5d8d2ee5fcb",
+		Attributes: data.Attributes{
+			Amount: 100.21,
+			BeneficiaryParty: data.Account{
+				AccountName:       "W Owens",
+				AccountNumber:     "31926819",
+				AccountNumberCode: "BBAN",
+				Address:           "1 The Beneficiary Localtown SE2",
+				BankID:            "403000",
+				BankIDCode:        "GBDSC",
+				Name:              "Wilfred Jeremiah Owens",
+			},
+			ChargesInformation: data.ChargesInformation{BearerCode: "SHAR"},
+			Currency:           "GBP",
+			DebtorParty: data.Account{
+				AccountName:       "EJ Brown Black",
+				AccountNumber:     "GB29XABC10161234567801",
+				AccountNumberCode: "IBAN",
+				Address:           "10 Debtor Crescent Sourcetown NE1",
+				BankID:            "203301",
+				BankIDCode:        "GBDSC",
+				Name:              "Emelia Jane Brown",
+			},
+			EndToEndReference: "Wil piano Jan",
+			Fx: data.Fx{
+				ContractReference: "FX123",
+				ExchangeRate:      "2.00000",
+				OriginalAmount:    200.42,
+				OriginalCurrency:  "USD",
+			},
+			PaymentID:      "123456789012345678",
+			PaymentPurpose: "Paying for goods/services",
+			PaymentScheme:  "FPS",
+			ProcessingDate: "2017-01-18",
+			Reference:      "Payment for Em's piano lessons",
+		},
+	}
+}
+
+var testOptions = MessageOptions{
+	MessageID:       "MSG-1",
+	CreatedAt:       time.Date(2017, 1, 17, 10, 30, 0, 0, time.UTC),
+	InitiatingParty: "Form3",
+}
+
+func TestNewPain001(t *testing.T) {
+	second := testPayment()
+	second.ID = "216d4da9-e59a-4cc6-8df3-3da6e7580b77"
+	second.Attributes.Amount = 50.5
+	second.Attributes.EndToEndReference = ""
+	second.Attributes.Fx = data.Fx{}
+	second.Attributes.ChargesInformation.BearerCode = "OUR"
+	second.Attributes.BeneficiaryParty = data.Account{AccountName: "A Smith", AccountNumber: "GB82WEST12345698765432", AccountNumberCode: "IBAN", BankID: "NWBKGB2L", BankIDCode: "SWBIC"}
+	later := testPayment()
+	later.ID = "not a uuid"
+	later.Attributes.ProcessingDate = "2017-01-19"
+	later.Attributes.Reference = ""
+	later.Attributes.PaymentPurpose = "GDDS"
+
+	doc, err := NewPain001([]data.Payment{testPayment(), later, second}, testOptions)
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	var buf bytes.Buffer
+	if err := doc.Write(&buf); err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:pain.001.001.09">
+  <CstmrCdtTrfInitn>
+    <GrpHdr>
+      <MsgId>MSG-1</MsgId>
+      <CreDtTm>2017-01-17T10:30:00</CreDtTm>
+      <NbOfTxs>3</NbOfTxs>
+      <CtrlSum>250.92</CtrlSum>
+      <InitgPty>
+        <Nm>Form3</Nm>
+        <Id>
+          <OrgId>
+            <Othr>
+              <Id>743d5b63-8e6f-432e-a8fa-c5d8d2ee5fcb</Id>
+            </Othr>
+          </OrgId>
+        </Id>
+      </InitgPty>
+    </GrpHdr>
+    <PmtInf>
+      <PmtInfId>MSG-1-1</PmtInfId>
+      <PmtMtd>TRF</PmtMtd>
+      <NbOfTxs>2</NbOfTxs>
+      <CtrlSum>150.71</CtrlSum>
+      <ReqdExctnDt>
+        <Dt>2017-01-18</Dt>
+      </ReqdExctnDt>
+      <Dbtr>
+        <Nm>Emelia Jane Brown</Nm>
+        <PstlAdr>
+          <AdrLine>10 Debtor Crescent Sourcetown NE1</AdrLine>
+        </PstlAdr>
+      </Dbtr>
+      <DbtrAcct>
+        <Id>
+          <IBAN>GB29XABC10161234567801</IBAN>
+        </Id>
+        <Nm>EJ Brown Black</Nm>
+      </DbtrAcct>
+      <DbtrAgt>
+        <FinInstnId>
+          <ClrSysMmbId>
+            <ClrSysId>
+              <Cd>GBDSC</Cd>
+            </ClrSysId>
+            <MmbId>203301</MmbId>
+          </ClrSysMmbId>
+        </FinInstnId>
+      </DbtrAgt>
+      <CdtTrfTxInf>
+        <PmtId>
+          <InstrId>123456789012345678</InstrId>
+          <EndToEndId>Wil piano Jan</EndToEndId>
+          <UETR>4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43</UETR>
+        </PmtId>
+        <PmtTpInf>
+          <LclInstrm>
+            <Prtry>FPS</Prtry>
+          </LclInstrm>
+        </PmtTpInf>
+        <Amt>
+          <InstdAmt Ccy="GBP">100.21</InstdAmt>
+        </Amt>
+        <XchgRateInf>
+          <XchgRate>2.00000</XchgRate>
+          <RateTp>AGRD</RateTp>
+          <CtrctId>FX123</CtrctId>
+        </XchgRateInf>
+        <ChrgBr>SHAR</ChrgBr>
+        <CdtrAgt>
+          <FinInstnId>
+            <ClrSysMmbId>
+              <ClrSysId>
+                <Cd>GBDSC</Cd>
+              </ClrSysId>
+              <MmbId>403000</MmbId>
+            </ClrSysMmbId>
+          </FinInstnId>
+        </CdtrAgt>
+        <Cdtr>
+          <Nm>Wilfred Jeremiah Owens</Nm>
+          <PstlAdr>
+            <AdrLine>1 The Beneficiary Localtown SE2</AdrLine>
+          </PstlAdr>
+        </Cdtr>
+        <CdtrAcct>
+          <Id>
+            <Othr>
+              <Id>31926819</Id>
+              <SchmeNm>
+                <Cd>BBAN</Cd>
+              </SchmeNm>
+            </Othr>
+          </Id>
+          <Nm>W Owens</Nm>
+        </CdtrAcct>
+        <Purp>
+          <Prtry>Paying for goods/services</Prtry>
+        </Purp>
+        <RmtInf>
+          <Ustrd>Payment for Em&#39;s piano lessons</Ustrd>
+        </RmtInf>
+      </CdtTrfTxInf>
+      <CdtTrfTxInf>
+        <PmtId>
+          <InstrId>123456789012345678</InstrId>
+          <EndToEndId>NOTPROVIDED</EndToEndId>
+          <UETR>216d4da9-e59a-4cc6-8df3-3da6e7580b77</UETR>
+        </PmtId>
+        <PmtTpInf>
+          <LclInstrm>
+            <Prtry>FPS</Prtry>
+          </LclInstrm>
+        </PmtTpInf>
+        <Amt>
+          <InstdAmt Ccy="GBP">50.5</InstdAmt>
+        </Amt>
+        <ChrgBr>DEBT</ChrgBr>
+        <CdtrAgt>
+          <FinInstnId>
+            <BICFI>NWBKGB2L</BICFI>
+          </FinInstnId>
+        </CdtrAgt>
+        <Cdtr>
+          <Nm>A Smith</Nm>
+        </Cdtr>
+        <CdtrAcct>
+          <Id>
+            <IBAN>GB82WEST12345698765432</IBAN>
+          </Id>
+          <Nm>A Smith</Nm>
+        </CdtrAcct>
+        <Purp>
+          <Prtry>Paying for goods/services</Prtry>
+        </Purp>
+        <RmtInf>
+          <Ustrd>Payment for Em&#39;s piano lessons</Ustrd>
+        </RmtInf>
+      </CdtTrfTxInf>
+    </PmtInf>
+    <PmtInf>
+      <PmtInfId>MSG-1-2</PmtInfId>
+      <PmtMtd>TRF</PmtMtd>
+      <NbOfTxs>1</NbOfTxs>
+      <CtrlSum>100.21</CtrlSum>
+      <ReqdExctnDt>
+        <Dt>2017-01-19</Dt>
+      </ReqdExctnDt>
+      <Dbtr>
+        <Nm>Emelia Jane Brown</Nm>
+        <PstlAdr>
+          <AdrLine>10 Debtor Crescent Sourcetown NE1</AdrLine>
+        </PstlAdr>
+      </Dbtr>
+      <DbtrAcct>
+        <Id>
+          <IBAN>GB29XABC10161234567801</IBAN>
+        </Id>
+        <Nm>EJ Brown Black</Nm>
+      </DbtrAcct>
+      <DbtrAgt>
+        <FinInstnId>
+          <ClrSysMmbId>
+            <ClrSysId>
+              <Cd>GBDSC</Cd>
+            </ClrSysId>
+            <MmbId>203301</MmbId>
+          </ClrSysMmbId>
+        </FinInstnId>
+      </DbtrAgt>
+      <CdtTrfTxInf>
+        <PmtId>
+          <InstrId>123456789012345678</InstrId>
+          <EndToEndId>Wil piano Jan</EndToEndId>
+        </PmtId>
+        <PmtTpInf>
+          <LclInstrm>
+            <Prtry>FPS</Prtry>
+          </LclInstrm>
+        </PmtTpInf>
+        <Amt>
+          <InstdAmt Ccy="GBP">100.21</InstdAmt>
+        </Amt>
+        <XchgRateInf>
+          <XchgRate>2.00000</XchgRate>
+          <RateTp>AGRD</RateTp>
+          <CtrctId>FX123</CtrctId>
+        </XchgRateInf>
+        <ChrgBr>SHAR</ChrgBr>
+        <CdtrAgt>
+          <FinInstnId>
+            <ClrSysMmbId>
+              <ClrSysId>
+                <Cd>GBDSC</Cd>
+              </ClrSysId>
+              <MmbId>403000</MmbId>
+            </ClrSysMmbId>
+          </FinInstnId>
+        </CdtrAgt>
+        <Cdtr>
+          <Nm>Wilfred Jeremiah Owens</Nm>
+          <PstlAdr>
+            <AdrLine>1 The Beneficiary Localtown SE2</AdrLine>
+          </PstlAdr>
+        </Cdtr>
+        <CdtrAcct>
+          <Id>
+            <Othr>
+              <Id>31926819</Id>
+              <SchmeNm>
+                <Cd>BBAN</Cd>
+              </SchmeNm>
+            </Othr>
+          </Id>
+          <Nm>W Owens</Nm>
+        </CdtrAcct>
+        <Purp>
+          <Cd>GDDS</Cd>
+        </Purp>
+      </CdtTrfTxInf>
+    </PmtInf>
+  </CstmrCdtTrfInitn>
+</Document>
+`
+	if expected != buf.String() {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, buf.String())
+	}
+}
+
+func TestNewPain001Errors(t *testing.T) {
+	invalid := testPayment()
+	invalid.Attributes.Amount = 0
+	invalid.Attributes.Currency = "pounds"
+	invalid.Attributes.ChargesInformation.BearerCode = "ME"
+	invalid.Attributes.BeneficiaryParty.AccountNumber = ""
+	invalid.Attributes.EndToEndReference = strings.Repeat("x", 36)
+
+	_, err := NewPain001([]data.Payment{testPayment(), invalid}, testOptions)
+	expected := `transaction 1 (4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43): amount must be positive, currency "pounds" is not an ISO 4217 code, end to end reference is longer than 35 characters, beneficiary account number is required, unknown charges bearer code "ME"`
+	if err == nil || err.Error() != expected {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, err)
+	}
+	if errs, ok := err.(Errors); !ok || len(errs) != 1 || errs[0].Index != 1 {
+		t.Errorf("expected the errors of the second payment, got %#v", err)
+	}
+
+	if _, err := NewPain001(nil, testOptions); err != ErrNoPayments {
+		t.Errorf("\n...expected = %v\n...obtained = %v", ErrNoPayments, err)
+	}
+}
+
+func TestAddressLines(t *testing.T) {
+	address := strings.Repeat("word ", 20) + strings.Repeat("y", 75)
+	lines := addressLines(address)
+	expected := []string{strings.TrimSpace(strings.Repeat("word ", 14)), strings.TrimSpace(strings.Repeat("word ", 6)), strings.Repeat("y", 70), "yyyyy"}
+	if strings.Join(expected, "|") != strings.Join(lines, "|") {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, lines)
+	}
+}
@@ -0,0 +1,231 @@
+// Package logging writes structured JSON log records with levels and masks
+// account identifiers before they reach the logs.
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+func (l Level) String() string {
+	switch l {
+	case DebugLevel:
+		return "debug"
+	case WarnLevel:
+		return "warn"
+	case ErrorLevel:
+		return "error"
+	default:
+		return "info"
+	}
+}
+
+// ParseLevel converts a level name such as "debug" or "WARN" into a Level.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return DebugLevel, nil
+	case "info", "":
+		return InfoLevel, nil
+	case "warn", "warning":
+		return WarnLevel, nil
+	case "error":
+		return ErrorLevel, nil
+	}
+	return InfoLevel, fmt.Errorf("unknown log level %q", s)
+}
+
+// Fields are the key/value pairs attached to a log record.
+type Fields map[string]interface{}
+
+// Masker is implemented by values holding personal or account data. The
+// logger logs the result of Masked instead of the value itself.
+type Masker interface {
+	Masked() interface{}
+}
+
+// sink is shared by a logger and the loggers derived from it.
+type sink struct {
+	mu    sync.Mutex
+	out   io.Writer
+	level Level
+}
+
+// Logger writes JSON log records, one per line, to its output.
+type Logger struct {
+	sink   *sink
+	fields Fields
+}
+
+func New(out io.Writer, level Level) *Logger {
+	return &Logger{sink: &sink{out: out, level: level}}
+}
+
+var std = New(os.Stderr, InfoLevel)
+
+// Default returns the process wide logger.
+func Default() *Logger {
+	return std
+}
+
+// SetLevel changes the minimum level written by the logger and every logger
+// derived from it with With.
+func (l *Logger) SetLevel(level Level) {
+	l.sink.mu.Lock()
+	l.sink.level = level
+	l.sink.mu.Unlock()
+}
+
+// SetOutput changes where the logger and its derived loggers write.
+func (l *Logger) SetOutput(out io.Writer) {
+	l.sink.mu.Lock()
+	l.sink.out = out
+	l.sink.mu.Unlock()
+}
+
+// With returns a logger adding fields to every record it writes.
+func (l *Logger) With(fields Fields) *Logger {
+	merged := make(Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Logger{sink: l.sink, fields: merged}
+}
+
+func (l *Logger) Debug(msg string, fields ...Fields) {
+	l.log(DebugLevel, msg, fields)
+}
+
+func (l *Logger) Info(msg string, fields ...Fields) {
+	l.log(InfoLevel, msg, fields)
+}
+
+func (l *Logger) Warn(msg string, fields ...Fields) {
+	l.log(WarnLevel, msg, fields)
+}
+
+func (l *Logger) Error(msg string, fields ...Fields) {
+	l.log(ErrorLevel, msg, fields)
+}
+
+// Fatal logs at error level and exits the process.
+func (l *Logger) Fatal(msg string, fields ...Fields) {
+	l.log(ErrorLevel, msg, fields)
+	os.Exit(1)
+}
+
+func (l *Logger) log(level Level, msg string, fields []Fields) {
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+	if level < l.sink.level {
+		return
+	}
+
+	record := make(map[string]interface{}, len(l.fields)+4)
+	for k, v := range l.fields {
+		record[k] = value(v)
+	}
+	for _, f := range fields {
+		for k, v := range f {
+			record[k] = value(v)
+		}
+	}
+	record["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	record["level"] = level.String()
+	record["msg"] = msg
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		line, _ = json.Marshal(map[string]string{"level": "error", "msg": "could not marshal log record", "error": err.Error()})
+	}
+	l.sink.out.Write(append(line, '\n'))
+}
+
+// value prepares a field value for JSON encoding, masking account data and
+// rendering errors as their message.
+func value(v interface{}) interface{} {
+	switch t := v.(type) {
+	case nil:
+		return nil
+	case error:
+		return t.Error()
+	case Masker:
+		return t.Masked()
+	case fmt.Stringer:
+		return t.String()
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return nil
+		}
+		if m, ok := rv.Elem().Interface().(Masker); ok {
+			return m.Masked()
+		}
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Implements(reflect.TypeOf((*Masker)(nil)).Elem()) {
+			masked := make([]interface{}, rv.Len())
+			for i := range masked {
+				masked[i] = rv.Index(i).Interface().(Masker).Masked()
+			}
+			return masked
+		}
+	}
+	return v
+}
+
+// Mask hides all but the last four characters of an account identifier.
+func Mask(s string) string {
+	if s == "" {
+		return ""
+	}
+	if len(s) <= 4 {
+		return strings.Repeat("*", len(s))
+	}
+	return strings.Repeat("*", len(s)-4) + s[len(s)-4:]
+}
+
+// Redact hides a value entirely, keeping track of whether it was set.
+func Redact(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "[REDACTED]"
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying l.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger stored in ctx, or the default logger.
+func FromContext(ctx context.Context) *Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+			return l
+		}
+	}
+	return std
+}
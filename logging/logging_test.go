@@ -0,0 +1,120 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+type account struct {
+	Number string `json:"number"`
+}
+
+func (a account) Masked() interface{} {
+	a.Number = Mask(a.Number)
+	return a
+}
+
+func decode(t *testing.T, buf *bytes.Buffer) map[string]interface{} {
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Didn't expect error %v decoding %q", err, buf.String())
+	}
+	return record
+}
+
+func TestLoggerWritesJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, InfoLevel).With(Fields{"request": "abc"})
+
+	logger.Warn("could not update", Fields{"error": errors.New("not found"), "attempt": 2})
+
+	record := decode(t, &buf)
+	expected := map[string]interface{}{
+		"level":   "warn",
+		"msg":     "could not update",
+		"request": "abc",
+		"error":   "not found",
+		"attempt": float64(2),
+	}
+	for k, v := range expected {
+		if record[k] != v {
+			t.Errorf("%v: expected %v got %v", k, v, record[k])
+		}
+	}
+	if _, ok := record["time"]; !ok {
+		t.Errorf("missing time in %v", record)
+	}
+}
+
+func TestLoggerLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, WarnLevel)
+
+	logger.Info("hidden")
+	if buf.Len() != 0 {
+		t.Errorf("expected info to be filtered, got %v", buf.String())
+	}
+
+	logger.With(Fields{"a": 1}).SetLevel(DebugLevel)
+	logger.Debug("shown")
+	if buf.Len() == 0 {
+		t.Errorf("expected level change to apply to the parent logger")
+	}
+}
+
+func TestLoggerMasksValues(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, InfoLevel)
+
+	logger.Info("payments", Fields{
+		"one":  account{Number: "GB29XABC10161234567801"},
+		"ptr":  &account{Number: "31926819"},
+		"many": []account{{Number: "56781234"}},
+	})
+
+	record := decode(t, &buf)
+	if n := record["one"].(map[string]interface{})["number"]; n != "******************7801" {
+		t.Errorf("value not masked: %v", n)
+	}
+	if n := record["ptr"].(map[string]interface{})["number"]; n != "****6819" {
+		t.Errorf("pointer not masked: %v", n)
+	}
+	if n := record["many"].([]interface{})[0].(map[string]interface{})["number"]; n != "****1234" {
+		t.Errorf("slice not masked: %v", n)
+	}
+}
+
+func TestMask(t *testing.T) {
+	cases := map[string]string{
+		"":         "",
+		"123":      "***",
+		"31926819": "****6819",
+	}
+	for in, expected := range cases {
+		if got := Mask(in); got != expected {
+			t.Errorf("Mask(%q) expected %q got %q", in, expected, got)
+		}
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	if FromContext(context.Background()) != Default() {
+		t.Errorf("expected default logger without a logger in context")
+	}
+	logger := New(&bytes.Buffer{}, InfoLevel)
+	if FromContext(NewContext(context.Background(), logger)) != logger {
+		t.Errorf("expected logger stored in context")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	if l, err := ParseLevel("WARN"); err != nil || l != WarnLevel {
+		t.Errorf("expected warn level, got %v %v", l, err)
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Errorf("expected error for unknown level")
+	}
+}
@@ -2,54 +2,185 @@
 package main
 
 import (
-	"log"
+	"context"
+	"flag"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/form3/config"
 	data "github.com/form3/data"
 	handler "github.com/form3/handler"
+	"github.com/form3/logging"
+	"github.com/form3/reconcile"
+	"github.com/form3/tlsutil"
+	"github.com/form3/worker"
 	"github.com/gorilla/mux"
 )
 
-// getEnv get key environment variable if exist otherwise return defalutValue
-func getEnv(key, defaultValue string) string {
-	value := os.Getenv(key)
-	if len(value) == 0 {
-		return defaultValue
+func main() {
+	logger := logging.Default()
+	cfg, printConfig, err := config.Load(os.Args[0], os.Args[1:], os.LookupEnv)
+	if err == flag.ErrHelp {
+		os.Exit(0)
 	}
-	return value
-}
-
-// Notes:
-// SSL for the REST API in the case is not a public API should be implemented
-// Database access should use username/password encryption in a real environment
+	if err != nil {
+		logger.Fatal("Invalid configuration", logging.Fields{"error": err})
+	}
+	if printConfig {
+		cfg.Print(os.Stdout)
+		return
+	}
+	level, _ := logging.ParseLevel(cfg.Log.Level)
+	logger.SetLevel(level)
 
-func main() {
-	log.Printf("starting\n")
+	logger.Info("starting")
 	r := mux.NewRouter()
 
+	handler.RegisterMongoStats()
 	dbConn := data.NewMongoDBConn()
-	host := getEnv("MONGO_URI", "localhost:27017")
-	log.Printf("Host %+v \n", host)
-	dbConn.Connect(host, "form3_db")
-	log.Printf("DB Connection %+v \n", dbConn)
+	mongoOpts, err := cfg.Mongo.ConnectOptions()
+	if err != nil {
+		logger.Fatal("Invalid mongo TLS configuration", logging.Fields{"error": err})
+	}
+	if _, err = dbConn.Connect(mongoOpts); err != nil {
+		logger.Fatal("Couldn't connect to mongo", logging.Fields{"host": cfg.Mongo.Host, "error": err})
+	}
+
+	if len(cfg.Command) > 0 {
+		code := runCommand(cfg, dbConn, cfg.Command)
+		dbConn.Stop()
+		os.Exit(code)
+	}
 
-	errInd := dbConn.SetIndex("id", "form3_db", data.PAYMENT_COLLECTION)
-	if errInd != nil {
-		log.Fatal(errInd)
+	if cfg.Mongo.ReconcileIndexes {
+		reconcileIndexes(dbConn, cfg)
 	}
 
 	app := handler.NewApp()
 	app.SetMongoProvider(dbConn)
+	app.SetMaxBatchSize(cfg.Server.MaxBatchSize)
+	app.SetMaxBodySize(cfg.Server.MaxBodySize)
+	app.SetMaxMessagePayments(cfg.Server.MaxMessagePayments)
+	app.SetIdempotencyProvider(&data.IdempotencyDataBase{MongoDBConn: dbConn})
+
+	probeTimeout := cfg.Health.ProbeTimeout.Duration()
+	health := handler.NewHealth()
+	health.SetTimeout(probeTimeout)
+	health.AddCheck("mongo", func() error {
+		return dbConn.Ping(probeTimeout)
+	})
+	health.AddCheck("indexes", func() error {
+		return dbConn.CheckIndexes(cfg.Mongo.Database, indexSpecs(cfg))
+	})
+	migrator := newMigrator(cfg, dbConn)
+	health.AddCheck("migrations", migrator.Check)
+
+	workers := worker.NewGroup()
+	ingester := app.SetIngester(&data.JobDataBase{MongoDBConn: dbConn}, cfg.Ingest.Mapping, cfg.Ingest.QueueSize, cfg.Ingest.MaxUploadSize)
+	workers.Go(ingester.Run)
+	app.SetStatementProvider(&data.StatementDataBase{MongoDBConn: dbConn}, reconcile.Tolerance{
+		Amount: cfg.Reconcile.AmountTolerance,
+		Days:   cfg.Reconcile.DateTolerance,
+	})
+	if cfg.Mongo.Migrate {
+		// readiness fails until the migrations are applied
+		workers.Go(func(done <-chan struct{}) {
+			if _, err := migrator.Up(false); err != nil {
+				logger.Error("Couldn't apply migrations", logging.Fields{"error": err})
+			}
+		})
+	}
+
+	spec := handler.Routes(r, app, health)
+	if _, err := spec.Document(); err != nil {
+		logger.Fatal("Invalid OpenAPI specification", logging.Fields{"error": err})
+	}
+
+	server := &http.Server{
+		Addr: cfg.Server.Addr,
+		Handler: handler.Chain(r,
+			handler.RequestID,
+			handler.Logging(logger),
+			handler.ClientOrganisation(cfg.Server.TLS.ClientOrganisations),
+			handler.Metrics(r),
+			handler.Validation(spec, cfg.Server.MaxBodySize)),
+	}
+	if cfg.Server.TLS.Enabled() {
+		certs, err := tlsutil.New(cfg.Server.TLS.Options())
+		if err != nil {
+			logger.Fatal("Couldn't load TLS certificates", logging.Fields{"error": err})
+		}
+		server.TLSConfig = certs.TLSConfig()
+		workers.Go(func(done <-chan struct{}) {
+			certs.Watch(done, cfg.Server.TLS.ReloadInterval.Duration())
+		})
+	}
 
-	r.HandleFunc("/payments", app.GetAllPayments).Methods("GET")
-	r.HandleFunc("/payments/{id}", app.GetPayment).Methods("GET")
-	r.HandleFunc("/payments", app.CreatePayment).Methods("POST")
-	r.HandleFunc("/payments/{id}", app.DeletePayment).Methods("DELETE")
-	r.HandleFunc("/payments/{id}", app.UpdatePayment).Methods("PUT")
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+		s := <-sig
+		logger.Info("Shutting down", logging.Fields{"signal": s})
+		shutdown(server, health, workers, dbConn, cfg.Shutdown.DrainDelay.Duration(), cfg.Shutdown.GracePeriod.Duration())
+	}()
+
+	logger.Info("Listening", logging.Fields{"addr": server.Addr, "tls": cfg.Server.TLS.Enabled()})
+	if cfg.Server.TLS.Enabled() {
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		logger.Fatal("Server stopped", logging.Fields{"error": err})
+	}
+	<-stopped
+	logger.Info("stopped")
+}
 
-	if err := http.ListenAndServe(":5000", r); err != nil {
-		log.Fatal(err)
+// reconcileIndexes builds the missing indexes. Failures are logged rather
+// than fatal: readiness keeps failing while a required index is missing.
+func reconcileIndexes(dbConn *data.MongoDBConn, cfg *config.Config) {
+	logger := logging.Default()
+	report, err := dbConn.ReconcileIndexes(cfg.Mongo.Database, indexSpecs(cfg), true)
+	if err != nil {
+		logger.Error("Couldn't reconcile indexes", logging.Fields{"error": err})
+		return
+	}
+	fields := logging.Fields{"created": report.Created, "extra": report.Extra, "conflicting": report.Conflicting, "failed": report.Failed}
+	if len(report.Extra) > 0 || len(report.Conflicting) > 0 || len(report.Failed) > 0 {
+		logger.Warn("Indexes differ from their declaration", fields)
+	} else {
+		logger.Info("Indexes reconciled", fields)
 	}
+	if err := report.Err(); err != nil {
+		logger.Error("Required indexes are missing, the service will not be ready", logging.Fields{"error": err})
+	}
+}
 
+// shutdown fails readiness and waits drainDelay so the orchestrator stops
+// routing traffic, then stops accepting connections and waits for in-flight
+// requests and background workers within gracePeriod before closing mongo.
+// Mongo stays open when the workers did not finish.
+func shutdown(server *http.Server, health *handler.Health, workers *worker.Group, dbConn *data.MongoDBConn, drainDelay, gracePeriod time.Duration) {
+	logger := logging.Default()
+	health.Drain()
+	time.Sleep(drainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Error("In-flight requests did not finish in time", logging.Fields{"error": err})
+	}
+	if err := workers.Shutdown(ctx); err != nil {
+		// the running workers would panic on a closed session, the session
+		// is left to the exit of the process
+		logger.Error("Background workers did not finish in time, mongo is not closed", logging.Fields{"error": err})
+		return
+	}
+	dbConn.Stop()
 }
@@ -0,0 +1,298 @@
+// Package metrics implements the subset of Prometheus metric types used by
+// the service and renders them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the default latency buckets, in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Collector writes one metric family in the text exposition format.
+type Collector interface {
+	Name() string
+	Write(w io.Writer)
+}
+
+// Registry holds the collectors exposed on a metrics endpoint.
+type Registry struct {
+	mu         sync.RWMutex
+	collectors map[string]Collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{collectors: map[string]Collector{}}
+}
+
+// Default is the registry used by the package level helpers.
+var Default = NewRegistry()
+
+// Register adds c to the registry, panicking if the name is already taken.
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.collectors[c.Name()]; ok {
+		panic("metrics: duplicate metric " + c.Name())
+	}
+	r.collectors[c.Name()] = c
+}
+
+// WriteTo renders every registered metric sorted by name.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		r.collectors[name].Write(&buf)
+	}
+	r.mu.RUnlock()
+	return buf.WriteTo(w)
+}
+
+// ServeHTTP serves the registry in the text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// Handler serves the default registry.
+func Handler() http.Handler {
+	return Default
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.Replace(strings.Replace(help, `\`, `\\`, -1), "\n", `\n`, -1))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(names)+len(extra)/2)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+labelEscaper.Replace(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+labelEscaper.Replace(extra[i+1])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// labelKey joins label values into a map key.
+func labelKey(values []string) string {
+	return strings.Join(values, "\x00")
+}
+
+type vec struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (v *vec) Name() string {
+	return v.name
+}
+
+func (v *vec) checkLabels(values []string) {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+}
+
+// CounterVec is a monotonically increasing value partitioned by labels.
+type CounterVec struct {
+	vec
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labels []string
+	value  float64
+}
+
+// NewCounterVec creates and registers a counter on the default registry.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec: vec{name: name, help: help, labels: labels}, values: map[string]*counterValue{}}
+	Default.Register(c)
+	return c
+}
+
+// Inc adds one to the counter with the given label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds delta, which must not be negative, to the counter.
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	c.checkLabels(labelValues)
+	key := labelKey(labelValues)
+	c.mu.Lock()
+	v, ok := c.values[key]
+	if !ok {
+		v = &counterValue{labels: append([]string(nil), labelValues...)}
+		c.values[key] = v
+	}
+	v.value += delta
+	c.mu.Unlock()
+}
+
+// Value returns the current value of the counter, mostly useful in tests.
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if v, ok := c.values[labelKey(labelValues)]; ok {
+		return v.value
+	}
+	return 0
+}
+
+func (c *CounterVec) Write(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		v := c.values[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, v.labels), formatFloat(v.value))
+	}
+}
+
+// HistogramVec counts observations in cumulative buckets partitioned by labels.
+type HistogramVec struct {
+	vec
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labels []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec creates and registers a histogram on the default registry.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	h := &HistogramVec{vec: vec{name: name, help: help, labels: labels}, buckets: b, values: map[string]*histogramValue{}}
+	Default.Register(h)
+	return h
+}
+
+// Observe records v in the histogram with the given label values.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.checkLabels(labelValues)
+	key := labelKey(labelValues)
+	h.mu.Lock()
+	hv, ok := h.values[key]
+	if !ok {
+		hv = &histogramValue{labels: append([]string(nil), labelValues...), counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hv
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			hv.counts[i]++
+		}
+	}
+	hv.count++
+	hv.sum += v
+	h.mu.Unlock()
+}
+
+// Count returns the number of observations, mostly useful in tests.
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if v, ok := h.values[labelKey(labelValues)]; ok {
+		return v.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) Write(w io.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.values) {
+		v := h.values[key]
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, v.labels, "le", formatFloat(upper)), v.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, v.labels, "le", "+Inf"), v.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, v.labels), formatFloat(v.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, v.labels), v.count)
+	}
+}
+
+// GaugeFunc reports the value returned by a function at scrape time.
+type GaugeFunc struct {
+	vec
+	kind string
+	fn   func() float64
+}
+
+// NewGaugeFunc creates and registers a gauge on the default registry.
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{vec: vec{name: name, help: help}, kind: "gauge", fn: fn}
+	Default.Register(g)
+	return g
+}
+
+// NewCounterFunc creates and registers a counter whose value is read from fn,
+// for counters maintained elsewhere such as the mgo statistics.
+func NewCounterFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{vec: vec{name: name, help: help}, kind: "counter", fn: fn}
+	Default.Register(g)
+	return g
+}
+
+func (g *GaugeFunc) Write(w io.Writer) {
+	writeHeader(w, g.name, g.help, g.kind)
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.fn()))
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch values := m.(type) {
+	case map[string]*counterValue:
+		for k := range values {
+			keys = append(keys, k)
+		}
+	case map[string]*histogramValue:
+		for k := range values {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCounterVecExposition(t *testing.T) {
+	c := NewCounterVec("test_requests_total", "Requests.", "route", "code")
+	c.Inc("/payments", "200")
+	c.Inc("/payments", "200")
+	c.Add(3, "/payments/{id}", "404")
+
+	var buf bytes.Buffer
+	c.Write(&buf)
+
+	expected := `# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{route="/payments",code="200"} 2
+test_requests_total{route="/payments/{id}",code="404"} 3
+`
+	if buf.String() != expected {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, buf.String())
+	}
+}
+
+func TestHistogramVecExposition(t *testing.T) {
+	h := NewHistogramVec("test_duration_seconds", "Latency.", []float64{0.1, 1}, "method")
+	h.Observe(0.05, "GET")
+	h.Observe(0.5, "GET")
+	h.Observe(5, "GET")
+
+	var buf bytes.Buffer
+	h.Write(&buf)
+
+	expected := `# HELP test_duration_seconds Latency.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{method="GET",le="0.1"} 1
+test_duration_seconds_bucket{method="GET",le="1"} 2
+test_duration_seconds_bucket{method="GET",le="+Inf"} 3
+test_duration_seconds_sum{method="GET"} 5.55
+test_duration_seconds_count{method="GET"} 3
+`
+	if buf.String() != expected {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, buf.String())
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	c := NewCounterVec("test_escaped_total", "Escaped.", "value")
+	c.Inc("a\"b\\c\nd")
+
+	var buf bytes.Buffer
+	c.Write(&buf)
+
+	if !strings.Contains(buf.String(), `test_escaped_total{value="a\"b\\c\nd"} 1`) {
+		t.Errorf("label not escaped: %v", buf.String())
+	}
+}
+
+func TestRegistryDuplicate(t *testing.T) {
+	r := NewRegistry()
+	r.Register(&GaugeFunc{vec: vec{name: "dup"}, kind: "gauge", fn: func() float64 { return 1 }})
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic registering a duplicate metric")
+		}
+	}()
+	r.Register(&GaugeFunc{vec: vec{name: "dup"}, kind: "gauge", fn: func() float64 { return 1 }})
+}
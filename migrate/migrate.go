@@ -0,0 +1,348 @@
+// Package migrate applies versioned migrations rewriting the documents stored
+// in mongo as the data model evolves.
+//
+// Migrations are Go functions registered in order of version. The applied ones
+// are recorded in the migrations collection and a lock document ensures only
+// one instance of the service migrates at a time.
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	data "github.com/form3/data"
+	"github.com/form3/logging"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// Collection records the applied migrations.
+	Collection = "migrations"
+	// LockCollection holds the lock taken while migrating.
+	LockCollection = "migrations_lock"
+
+	lockID = "migrations"
+)
+
+var (
+	// ErrLocked is returned when another instance holds the migration lock.
+	ErrLocked = errors.New("migrations are locked by another instance")
+	// ErrLockLost is returned when the lock expired while migrating, and may
+	// have been taken by another instance.
+	ErrLockLost = errors.New("the migration lock was lost")
+)
+
+// Migration rewrites the documents of the database from the previous version.
+type Migration struct {
+	Version     int
+	Description string
+	// Up applies the migration. It must be safe to run again: a migration
+	// interrupted before being recorded is run from the start next time.
+	Up func(run *Run) error
+}
+
+// Record is the document stored for every applied migration.
+type Record struct {
+	Version     int       `bson:"_id" json:"version"`
+	Description string    `bson:"description" json:"description"`
+	AppliedAt   time.Time `bson:"applied_at" json:"applied_at"`
+	DurationMS  int64     `bson:"duration_ms" json:"duration_ms"`
+	Documents   int       `bson:"documents" json:"documents"`
+}
+
+type lock struct {
+	ID        string    `bson:"_id"`
+	Owner     string    `bson:"owner"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// Indexes are the indexes of the migrations collections. Expired locks left
+// by a crashed instance are removed by mongo.
+func Indexes() []data.IndexSpec {
+	return []data.IndexSpec{
+		{Collection: LockCollection, Key: []string{"expires_at"}, ExpireAfter: time.Second},
+	}
+}
+
+// Validate checks the versions are positive and strictly increasing.
+func Validate(migrations []Migration) error {
+	last := 0
+	for _, m := range migrations {
+		if m.Version <= last {
+			return fmt.Errorf("migration %d %q must have a version greater than %d", m.Version, m.Description, last)
+		}
+		if m.Up == nil {
+			return fmt.Errorf("migration %d %q has no Up function", m.Version, m.Description)
+		}
+		last = m.Version
+	}
+	return nil
+}
+
+// pending returns the migrations not in applied.
+func pending(migrations []Migration, applied []Record) []Migration {
+	done := map[int]bool{}
+	for _, r := range applied {
+		done[r.Version] = true
+	}
+	var result []Migration
+	for _, m := range migrations {
+		if !done[m.Version] {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// Migrator applies migrations to a database.
+type Migrator struct {
+	conn       *data.MongoDBConn
+	db         string
+	migrations []Migration
+
+	// Owner identifies this instance in the lock, hostname and pid by default.
+	Owner string
+	// LockTTL is how long the lock is held without being refreshed before
+	// another instance can take it over.
+	LockTTL time.Duration
+	// BatchSize is the number of documents between progress reports.
+	BatchSize int
+	// Progress receives the progress reports, which are logged when nil.
+	Progress func(Progress)
+}
+
+// New returns a Migrator of the migrations, which must be valid, on db.
+func New(conn *data.MongoDBConn, db string, migrations []Migration) *Migrator {
+	host, _ := os.Hostname()
+	return &Migrator{
+		conn:       conn,
+		db:         db,
+		migrations: migrations,
+		Owner:      fmt.Sprintf("%s/%d", host, os.Getpid()),
+		LockTTL:    time.Minute,
+		BatchSize:  1000,
+	}
+}
+
+// Applied returns the applied migrations in order of version.
+func (m *Migrator) Applied() ([]Record, error) {
+	conn := m.conn.GetConn()
+	defer conn.Close()
+	var records []Record
+	err := conn.DB(m.db).C(Collection).Find(nil).Sort("_id").All(&records)
+	return records, err
+}
+
+// Pending returns the migrations not applied yet.
+func (m *Migrator) Pending() ([]Migration, error) {
+	applied, err := m.Applied()
+	if err != nil {
+		return nil, err
+	}
+	return pending(m.migrations, applied), nil
+}
+
+// Check returns an error if a migration is not applied, for readiness.
+func (m *Migrator) Check() error {
+	todo, err := m.Pending()
+	if err != nil {
+		return err
+	}
+	if len(todo) == 0 {
+		return nil
+	}
+	versions := make([]string, len(todo))
+	for i, migration := range todo {
+		versions[i] = fmt.Sprint(migration.Version)
+	}
+	return errors.New("pending migrations: " + strings.Join(versions, ", "))
+}
+
+// Up applies the pending migrations in order while holding the lock. With
+// dryRun the migrations count the documents they would change without writing
+// or recording anything. It returns the migrations run.
+func (m *Migrator) Up(dryRun bool) ([]Record, error) {
+	logger := logging.Default().With(logging.Fields{"dry_run": dryRun})
+	if err := Validate(m.migrations); err != nil {
+		return nil, err
+	}
+	if err := m.acquire(); err != nil {
+		return nil, err
+	}
+	stop := make(chan struct{})
+	lost := make(chan struct{})
+	refreshed := make(chan struct{})
+	go m.refresh(stop, lost, refreshed, m.extend)
+	defer func() {
+		close(stop)
+		<-refreshed
+		if err := m.release(); err != nil {
+			logger.Error("Could not release the migration lock", logging.Fields{"error": err})
+		}
+	}()
+
+	todo, err := m.Pending()
+	if err != nil {
+		return nil, err
+	}
+	return applyAll(todo, lost, logger, func(migration Migration, log *logging.Logger) (Record, error) {
+		return m.apply(migration, dryRun, lost, log)
+	})
+}
+
+// applyAll applies the migrations in order with apply until one fails or lost
+// is closed.
+func applyAll(todo []Migration, lost <-chan struct{}, logger *logging.Logger, apply func(Migration, *logging.Logger) (Record, error)) ([]Record, error) {
+	var records []Record
+	for _, migration := range todo {
+		log := logger.With(logging.Fields{"version": migration.Version, "description": migration.Description})
+		select {
+		case <-lost:
+			log.Error("Migration lock lost, stopping")
+			return records, ErrLockLost
+		default:
+		}
+		log.Info("Applying migration")
+		record, err := apply(migration, log)
+		if err == ErrLockLost {
+			log.Error("Migration lock lost, stopping")
+			return records, err
+		}
+		if err != nil {
+			log.Error("Migration failed", logging.Fields{"error": err})
+			return records, fmt.Errorf("migration %d: %v", migration.Version, err)
+		}
+		log.Info("Applied migration", logging.Fields{"documents": record.Documents, "duration_ms": record.DurationMS})
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (m *Migrator) apply(migration Migration, dryRun bool, lost <-chan struct{}, logger *logging.Logger) (Record, error) {
+	conn := m.conn.GetConn()
+	defer conn.Close()
+	start := time.Now()
+	run := &Run{
+		DB:        conn.DB(m.db),
+		DryRun:    dryRun,
+		version:   migration.Version,
+		batchSize: m.BatchSize,
+		progress:  m.Progress,
+		logger:    logger,
+		lost:      lost,
+	}
+	if err := migration.Up(run); err != nil {
+		return Record{}, err
+	}
+	// the lock may have been lost after the last document was migrated
+	if run.lockLost() {
+		return Record{}, ErrLockLost
+	}
+	record := Record{
+		Version:     migration.Version,
+		Description: migration.Description,
+		AppliedAt:   time.Now().UTC(),
+		DurationMS:  int64(time.Since(start) / time.Millisecond),
+		Documents:   run.changed,
+	}
+	if dryRun {
+		return record, nil
+	}
+	return record, conn.DB(m.db).C(Collection).Insert(record)
+}
+
+// acquire takes the lock, or an expired lock of another instance.
+func (m *Migrator) acquire() error {
+	conn := m.conn.GetConn()
+	defer conn.Close()
+	c := conn.DB(m.db).C(LockCollection)
+	now := time.Now().UTC()
+	err := c.Insert(lock{ID: lockID, Owner: m.Owner, ExpiresAt: now.Add(m.LockTTL)})
+	if !mgo.IsDup(err) {
+		return err
+	}
+	err = c.Update(
+		bson.M{"_id": lockID, "expires_at": bson.M{"$lt": now}},
+		bson.M{"$set": bson.M{"owner": m.Owner, "expires_at": now.Add(m.LockTTL)}})
+	if err == mgo.ErrNotFound {
+		return ErrLocked
+	}
+	return err
+}
+
+// refresh extends the lock with extend until stop is closed. When the lock is
+// no longer held by this instance, lost is closed and refresh returns.
+func (m *Migrator) refresh(stop <-chan struct{}, lost, done chan<- struct{}, extend func() error) {
+	defer close(done)
+	ticker := time.NewTicker(m.LockTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			err := extend()
+			if err == mgo.ErrNotFound {
+				logging.Default().Error("The migration lock expired or was taken by another instance", logging.Fields{"owner": m.Owner})
+				close(lost)
+				return
+			}
+			if err != nil {
+				logging.Default().Error("Could not refresh the migration lock", logging.Fields{"error": err})
+			}
+		}
+	}
+}
+
+// extend extends the lock held by this instance, mgo.ErrNotFound if it is not.
+func (m *Migrator) extend() error {
+	conn := m.conn.GetConn()
+	defer conn.Close()
+	return conn.DB(m.db).C(LockCollection).Update(
+		bson.M{"_id": lockID, "owner": m.Owner},
+		bson.M{"$set": bson.M{"expires_at": time.Now().UTC().Add(m.LockTTL)}})
+}
+
+func (m *Migrator) release() error {
+	conn := m.conn.GetConn()
+	defer conn.Close()
+	err := conn.DB(m.db).C(LockCollection).Remove(bson.M{"_id": lockID, "owner": m.Owner})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// Status describes a migration for the migrate command.
+type Status struct {
+	Version     int        `json:"version"`
+	Description string     `json:"description"`
+	AppliedAt   *time.Time `json:"applied_at,omitempty"`
+}
+
+// Status returns every known or applied migration, in order of version.
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.Applied()
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Status{}
+	for _, migration := range m.migrations {
+		byVersion[migration.Version] = &Status{Version: migration.Version, Description: migration.Description}
+	}
+	for _, record := range applied {
+		appliedAt := record.AppliedAt
+		byVersion[record.Version] = &Status{Version: record.Version, Description: record.Description, AppliedAt: &appliedAt}
+	}
+	var statuses []Status
+	for _, s := range byVersion {
+		statuses = append(statuses, *s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
@@ -0,0 +1,113 @@
+package migrate
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/form3/logging"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func noop(*Run) error { return nil }
+
+func TestValidate(t *testing.T) {
+	if err := Validate(Payments("payments")); err != nil {
+		t.Errorf("Didn't expect error %v", err)
+	}
+	invalid := [][]Migration{
+		{{Version: 0, Up: noop}},
+		{{Version: 1, Up: noop}, {Version: 1, Up: noop}},
+		{{Version: 2, Up: noop}, {Version: 1, Up: noop}},
+		{{Version: 1}},
+	}
+	for _, migrations := range invalid {
+		if err := Validate(migrations); err == nil {
+			t.Errorf("expected an error for %v", migrations)
+		}
+	}
+}
+
+func TestPending(t *testing.T) {
+	migrations := []Migration{{Version: 1, Up: noop}, {Version: 2, Up: noop}, {Version: 3, Up: noop}}
+
+	todo := pending(migrations, []Record{{Version: 1}, {Version: 3}})
+	if len(todo) != 1 || todo[0].Version != 2 {
+		t.Errorf("unexpected pending migrations %v", todo)
+	}
+	if todo := pending(migrations, nil); len(todo) != 3 {
+		t.Errorf("unexpected pending migrations %v", todo)
+	}
+}
+
+func TestNormaliseCurrency(t *testing.T) {
+	update, err := normaliseCurrency(bson.M{"attributes": bson.M{"currency": " gbp"}})
+	if err != nil {
+		t.Errorf("Didn't expect error %v", err)
+	}
+	set := update.(bson.M)["$set"].(bson.M)
+	if set["attributes.currency"] != "GBP" {
+		t.Errorf("unexpected update %v", update)
+	}
+
+	for _, doc := range []bson.M{{"attributes": bson.M{"currency": "GBP"}}, {"attributes": bson.M{}}, {}} {
+		if update, _ := normaliseCurrency(doc); update != nil {
+			t.Errorf("expected no update of %v, got %v", doc, update)
+		}
+	}
+}
+
+func TestProgressPercent(t *testing.T) {
+	if p := (Progress{Processed: 250, Total: 1000}).Percent(); p != 25 {
+		t.Errorf("expected 25 got %v", p)
+	}
+	if p := (Progress{}).Percent(); p != 100 {
+		t.Errorf("expected 100 got %v", p)
+	}
+}
+
+func TestLockLostMidRun(t *testing.T) {
+	m := &Migrator{Owner: "test", LockTTL: 30 * time.Millisecond}
+	extended := 0
+	extend := func() error {
+		extended++
+		if extended == 1 {
+			return errors.New("no reachable servers")
+		}
+		// expired and taken over by another instance
+		return mgo.ErrNotFound
+	}
+	stop := make(chan struct{})
+	lost := make(chan struct{})
+	refreshed := make(chan struct{})
+	go m.refresh(stop, lost, refreshed, extend)
+	defer func() {
+		close(stop)
+		<-refreshed
+	}()
+
+	var applied []int
+	migrations := []Migration{{Version: 1}, {Version: 2}, {Version: 3}}
+	records, err := applyAll(migrations, lost, logging.Default(), func(migration Migration, _ *logging.Logger) (Record, error) {
+		applied = append(applied, migration.Version)
+		if migration.Version == 2 {
+			// a long migration, updating documents until the lock is lost
+			run := &Run{lost: lost}
+			for !run.lockLost() {
+				time.Sleep(time.Millisecond)
+			}
+			return Record{}, run.Update("payments", nil, nil)
+		}
+		return Record{Version: migration.Version}, nil
+	})
+	if err != ErrLockLost {
+		t.Errorf("\n...expected = %v\n...obtained = %v", ErrLockLost, err)
+	}
+	if len(records) != 1 || len(applied) != 2 {
+		t.Errorf("expected the migrations to stop at version 2, applied %v recorded %v", applied, records)
+	}
+	if extended != 2 {
+		t.Errorf("expected the refresh to stop once the lock is lost, extended %v times", extended)
+	}
+}
@@ -0,0 +1,38 @@
+package migrate
+
+import (
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Payments returns the migrations of the payments stored in collection, in
+// order of version. New migrations are appended with the next version and
+// are never changed once released.
+func Payments(collection string) []Migration {
+	return []Migration{
+		{
+			Version:     1,
+			Description: "upper case currency codes",
+			Up: func(run *Run) error {
+				return run.Update(collection, bson.M{"attributes.currency": bson.M{"$regex": "[a-z]|^\\s|\\s$"}}, normaliseCurrency)
+			},
+		},
+	}
+}
+
+func normaliseCurrency(doc bson.M) (interface{}, error) {
+	attributes, ok := doc["attributes"].(bson.M)
+	if !ok {
+		return nil, nil
+	}
+	currency, ok := attributes["currency"].(string)
+	if !ok {
+		return nil, nil
+	}
+	normalised := strings.ToUpper(strings.TrimSpace(currency))
+	if normalised == currency {
+		return nil, nil
+	}
+	return bson.M{"$set": bson.M{"attributes.currency": normalised}}, nil
+}
@@ -0,0 +1,116 @@
+package migrate
+
+import (
+	"github.com/form3/logging"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Progress reports how far a migration went through a collection.
+type Progress struct {
+	Version    int    `json:"version"`
+	Collection string `json:"collection"`
+	Processed  int    `json:"processed"`
+	Total      int    `json:"total"`
+	Changed    int    `json:"changed"`
+}
+
+// Percent returns the share of the documents processed.
+func (p Progress) Percent() float64 {
+	if p.Total == 0 {
+		return 100
+	}
+	return float64(p.Processed) * 100 / float64(p.Total)
+}
+
+// Run is given to a migration while it is applied.
+type Run struct {
+	// DB is the database migrated. Migrations should write through Update so
+	// that dry runs do not change anything.
+	DB *mgo.Database
+	// DryRun is true when nothing must be written.
+	DryRun bool
+
+	version   int
+	batchSize int
+	progress  func(Progress)
+	logger    *logging.Logger
+	changed   int
+	// lost is closed when the migration lock is lost
+	lost <-chan struct{}
+}
+
+// lockLost returns true once the migration lock is lost, when the migration
+// must stop before another instance migrates concurrently.
+func (r *Run) lockLost() bool {
+	select {
+	case <-r.lost:
+		return true
+	default:
+		return false
+	}
+}
+
+// Update calls fn for every document of collection matching query, in order of
+// _id, and applies the update it returns, e.g. bson.M{"$set": ...}. A nil
+// update leaves the document unchanged. Progress is reported every batch of
+// documents. It returns ErrLockLost, without updating more documents, once the
+// migration lock is lost.
+func (r *Run) Update(collection string, query interface{}, fn func(doc bson.M) (update interface{}, err error)) error {
+	if r.lockLost() {
+		return ErrLockLost
+	}
+	c := r.DB.C(collection)
+	total, err := c.Find(query).Count()
+	if err != nil {
+		return err
+	}
+	p := Progress{Version: r.version, Collection: collection, Total: total}
+
+	iter := c.Find(query).Sort("_id").Iter()
+	var doc bson.M
+	for iter.Next(&doc) {
+		if r.lockLost() {
+			iter.Close()
+			return ErrLockLost
+		}
+		update, err := fn(doc)
+		if err != nil {
+			iter.Close()
+			return err
+		}
+		if update != nil {
+			if !r.DryRun {
+				if err := c.UpdateId(doc["_id"], update); err != nil {
+					iter.Close()
+					return err
+				}
+			}
+			p.Changed++
+			r.changed++
+		}
+		p.Processed++
+		if p.Processed%r.batchSize == 0 {
+			r.report(p)
+		}
+		doc = nil
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+	r.report(p)
+	return nil
+}
+
+func (r *Run) report(p Progress) {
+	if r.progress != nil {
+		r.progress(p)
+		return
+	}
+	r.logger.Info("Migration progress", logging.Fields{
+		"collection": p.Collection,
+		"processed":  p.Processed,
+		"total":      p.Total,
+		"changed":    p.Changed,
+	})
+}
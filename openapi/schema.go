@@ -0,0 +1,158 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// Components holds the schemas of the named struct types of a document.
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+
+	types   map[string]reflect.Type
+	defined map[reflect.Type]*Schema
+}
+
+func NewComponents() *Components {
+	return &Components{
+		Schemas: map[string]*Schema{},
+		types:   map[string]reflect.Type{},
+		defined: map[reflect.Type]*Schema{timeType: {Type: "string", Format: "date-time"}},
+	}
+}
+
+// Define sets the schema of the type of v, for the types marshalling
+// themselves to JSON.
+func (c *Components) Define(v interface{}, schema *Schema) {
+	c.defined[reflect.TypeOf(v)] = schema
+}
+
+// SchemaOf returns the schema of the JSON encoding of the type of v, read from
+// its json struct tags. Named struct types are added to the components and
+// referenced.
+func (c *Components) SchemaOf(v interface{}) *Schema {
+	return c.schema(reflect.TypeOf(v))
+}
+
+func (c *Components) schema(t reflect.Type) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+	if s, ok := c.defined[t]; ok {
+		return s
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return c.schema(t.Elem())
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t == rawMessageType {
+			return &Schema{}
+		}
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: c.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: c.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return c.object(t)
+		}
+		return c.ref(t)
+	}
+	return &Schema{}
+}
+
+// ref adds the schema of the named struct t to the components, under its
+// package qualified name when another type has the same name.
+func (c *Components) ref(t reflect.Type) *Schema {
+	name := exported(t.Name())
+	if other, ok := c.types[name]; ok && other != t {
+		name = exported(t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]) + name
+	}
+	if _, ok := c.types[name]; !ok {
+		// registered first so recursive types end
+		c.types[name] = t
+		c.Schemas[name] = c.object(t)
+	}
+	return &Schema{Ref: refPrefix + name}
+}
+
+func (c *Components) object(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	c.addFields(s, t)
+	return s
+}
+
+// addFields adds the fields of struct t to s, with those of embedded structs
+// as encoding/json does.
+func (c *Components) addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				c.addFields(s, embedded)
+				continue
+			}
+		}
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		s.Properties[name] = c.schema(field.Type)
+	}
+}
+
+func exported(name string) string {
+	if name == "" {
+		return name
+	}
+	r := []rune(name)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+// schemaName returns the name of the component referenced by s.
+func schemaName(s *Schema) string {
+	return strings.TrimPrefix(s.Ref, refPrefix)
+}
+
+func (c *Components) resolve(s *Schema) (*Schema, error) {
+	for s.Ref != "" {
+		resolved, ok := c.Schemas[schemaName(s)]
+		if !ok {
+			return nil, fmt.Errorf("unknown schema %v", s.Ref)
+		}
+		s = resolved
+	}
+	return s, nil
+}
@@ -0,0 +1,71 @@
+package openapi
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+type testID string
+
+type base struct {
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type testNode struct {
+	*base
+	ID       testID            `json:"id"`
+	Name     string            `json:"name,omitempty"`
+	Weight   float64           `json:"weight"`
+	Count    *int              `json:"count,omitempty"`
+	Tags     []string          `json:"tags"`
+	Labels   map[string]bool   `json:"labels"`
+	Raw      json.RawMessage   `json:"raw"`
+	Children []testNode        `json:"children"`
+	Data     []byte            `json:"data"`
+	Inline   struct{ On bool } `json:"inline"`
+	Ignored  string            `json:"-"`
+	hidden   string
+}
+
+func TestSchemaOf(t *testing.T) {
+	c := NewComponents()
+	c.Define(testID(""), &Schema{Type: "string", Pattern: "^[0-9]+$"})
+	ref := c.SchemaOf([]testNode{})
+	if ref.Type != "array" || ref.Items.Ref != "#/components/schemas/TestNode" {
+		t.Errorf("expected an array of TestNode, got %+v", ref)
+	}
+	obtained, err := json.Marshal(c.Schemas)
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	expected := `{"TestNode":{"type":"object","properties":{` +
+		`"children":{"type":"array","items":{"$ref":"#/components/schemas/TestNode"}},` +
+		`"count":{"type":"integer"},"created_at":{"type":"string","format":"date-time"},` +
+		`"data":{"type":"string","format":"byte"},"id":{"type":"string","pattern":"^[0-9]+$"},` +
+		`"inline":{"type":"object","properties":{"On":{"type":"boolean"}}},` +
+		`"labels":{"type":"object","additionalProperties":{"type":"boolean"}},"name":{"type":"string"},"raw":{},` +
+		`"tags":{"type":"array","items":{"type":"string"}},"weight":{"type":"number"}}}}`
+	if expected != string(obtained) {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, string(obtained))
+	}
+}
+
+type Unsupported struct {
+	Reason string `json:"reason"`
+}
+
+func TestSchemaOfSameName(t *testing.T) {
+	c := NewComponents()
+	c.SchemaOf(struct {
+		A Unsupported `json:"a"`
+		B base        `json:"b"`
+	}{})
+	type Base struct{}
+	if ref := c.SchemaOf(Base{}); ref.Ref != "#/components/schemas/OpenapiBase" {
+		t.Errorf("expected the package to qualify the name, got %v", ref.Ref)
+	}
+	if _, ok := c.Schemas["Unsupported"]; !ok {
+		t.Errorf("expected the Unsupported schema in %v", c.Schemas)
+	}
+}
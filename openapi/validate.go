@@ -0,0 +1,183 @@
+package openapi
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// patterns caches the compiled patterns of the schemas.
+var patterns sync.Map
+
+// ValidationError is a value not matching its schema.
+type ValidationError struct {
+	// Path is the dotted path of the value, empty for the validated value
+	Path   string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Path == "" {
+		return "value " + e.Reason
+	}
+	return e.Path + " " + e.Reason
+}
+
+// Validate checks value, decoded from JSON, against s. Properties missing
+// from an object schema are accepted, like null values, as encoding/json
+// ignores them.
+func (c *Components) Validate(s *Schema, value interface{}) error {
+	return c.validate(s, value, "")
+}
+
+// ValidateString checks the string value of the parameter name against s,
+// parsing it as the type of the schema.
+func (c *Components) ValidateString(s *Schema, name, value string) error {
+	s, err := c.resolve(s)
+	if err != nil {
+		return err
+	}
+	switch s.Type {
+	case "boolean":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return invalid(name, "must be true or false, got %q", value)
+		}
+		return nil
+	case "integer", "number":
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil || s.Type == "integer" && n != math.Trunc(n) {
+			return invalid(name, "must be %v %v, got %q", article(s.Type), s.Type, value)
+		}
+		return checkNumber(s, n, name)
+	case "string":
+		return checkString(s, value, name)
+	}
+	return nil
+}
+
+func (c *Components) validate(s *Schema, value interface{}, path string) error {
+	s, err := c.resolve(s)
+	if err != nil {
+		return err
+	}
+	if value == nil || s.Type == "" {
+		return nil
+	}
+	switch s.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return invalid(path, "must be an object")
+		}
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			property, ok := s.Properties[key]
+			if !ok {
+				property = s.AdditionalProperties
+			}
+			if property == nil {
+				continue
+			}
+			if err := c.validate(property, object[key], join(path, key)); err != nil {
+				return err
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return invalid(path, "must be an array")
+		}
+		for i, item := range array {
+			if err := c.validate(s.Items, item, fmt.Sprintf("%v[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return invalid(path, "must be a string")
+		}
+		return checkString(s, str, path)
+	case "integer", "number":
+		n, ok := value.(float64)
+		if !ok || s.Type == "integer" && n != math.Trunc(n) {
+			return invalid(path, "must be %v %v", article(s.Type), s.Type)
+		}
+		return checkNumber(s, n, path)
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return invalid(path, "must be true or false")
+		}
+	}
+	return nil
+}
+
+func checkString(s *Schema, value, path string) error {
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			found = found || e == value
+		}
+		if !found {
+			return invalid(path, "must be one of %v, got %q", strings.Join(s.Enum, ", "), value)
+		}
+	}
+	switch s.Format {
+	case "date":
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return invalid(path, "must be a date as YYYY-MM-DD, got %q", value)
+		}
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return invalid(path, "must be an RFC 3339 date and time, got %q", value)
+		}
+	}
+	if s.Pattern != "" {
+		re, ok := patterns.Load(s.Pattern)
+		if !ok {
+			compiled, err := regexp.Compile(s.Pattern)
+			if err != nil {
+				return fmt.Errorf("invalid pattern of %v: %v", path, err)
+			}
+			re, _ = patterns.LoadOrStore(s.Pattern, compiled)
+		}
+		if !re.(*regexp.Regexp).MatchString(value) {
+			return invalid(path, "must match %v, got %q", s.Pattern, value)
+		}
+	}
+	return nil
+}
+
+func checkNumber(s *Schema, n float64, path string) error {
+	if s.Minimum != nil && n < *s.Minimum {
+		return invalid(path, "must be at least %v, got %v", *s.Minimum, n)
+	}
+	return nil
+}
+
+func invalid(path, format string, args ...interface{}) error {
+	return &ValidationError{Path: path, Reason: fmt.Sprintf(format, args...)}
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func article(typ string) string {
+	if typ == "integer" {
+		return "an"
+	}
+	return "a"
+}
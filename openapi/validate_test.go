@@ -0,0 +1,81 @@
+package openapi
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type testPayment struct {
+	ID      testID   `json:"id"`
+	Version int      `json:"version"`
+	Amount  float64  `json:"amount"`
+	Urgent  bool     `json:"urgent"`
+	Charges []charge `json:"charges"`
+}
+
+type charge struct {
+	Currency string `json:"currency"`
+}
+
+func TestValidate(t *testing.T) {
+	c := NewComponents()
+	c.Define(testID(""), &Schema{Type: "string", Pattern: "^[0-9]*$"})
+	schema := c.SchemaOf(testPayment{})
+	c.Schemas["Charge"].Properties["currency"].Enum = []string{"GBP", "EUR"}
+
+	for body, expected := range map[string]string{
+		`{"id":"12","version":1,"amount":1.5,"urgent":true,"charges":[{"currency":"GBP"}],"other":[1]}`: "",
+		`{"id":null,"version":null,"charges":null}`:                                                     "",
+		`{"id":"1a"}`:                             `id must match ^[0-9]*$, got "1a"`,
+		`{"version":1.5}`:                         "version must be an integer",
+		`{"amount":"1.5"}`:                        "amount must be a number",
+		`{"urgent":1}`:                            "urgent must be true or false",
+		`{"charges":{}}`:                          "charges must be an array",
+		`{"charges":[{"currency":"GBP"},"EUR"]}`:  "charges[1] must be an object",
+		`{"charges":[{"currency":"USD"}]}`:        `charges[0].currency must be one of GBP, EUR, got "USD"`,
+		`{"amount":"first","version":"reported"}`: "amount must be a number",
+		`"payment"`:                               "value must be an object",
+	} {
+		var value interface{}
+		if err := json.Unmarshal([]byte(body), &value); err != nil {
+			t.Fatalf("Didn't expect error %v", err)
+		}
+		obtained := ""
+		if err := c.Validate(schema, value); err != nil {
+			obtained = err.Error()
+		}
+		if expected != obtained {
+			t.Errorf("%v\n...expected = %v\n...obtained = %v", body, expected, obtained)
+		}
+	}
+}
+
+func TestValidateString(t *testing.T) {
+	one := 1.0
+	for _, test := range []struct {
+		schema   *Schema
+		value    string
+		expected string
+	}{
+		{&Schema{Type: "boolean"}, "false", ""},
+		{&Schema{Type: "boolean"}, "no", `p must be true or false, got "no"`},
+		{&Schema{Type: "integer", Minimum: &one}, "10", ""},
+		{&Schema{Type: "integer", Minimum: &one}, "0", "p must be at least 1, got 0"},
+		{&Schema{Type: "integer"}, "1.5", `p must be an integer, got "1.5"`},
+		{&Schema{Type: "number"}, "1.5", ""},
+		{&Schema{Type: "number"}, "one", `p must be a number, got "one"`},
+		{&Schema{Type: "string", Format: "date"}, "2017-01-18", ""},
+		{&Schema{Type: "string", Format: "date"}, "tomorrow", `p must be a date as YYYY-MM-DD, got "tomorrow"`},
+		{&Schema{Type: "string", Format: "date-time"}, "2017-01-18T10:00:00Z", ""},
+		{&Schema{Type: "string", Format: "date-time"}, "2017-01-18", `p must be an RFC 3339 date and time, got "2017-01-18"`},
+		{&Schema{Ref: "#/components/schemas/Missing"}, "", "unknown schema #/components/schemas/Missing"},
+	} {
+		obtained := ""
+		if err := NewComponents().ValidateString(test.schema, "p", test.value); err != nil {
+			obtained = err.Error()
+		}
+		if test.expected != obtained {
+			t.Errorf("%+v %q\n...expected = %v\n...obtained = %v", test.schema, test.value, test.expected, obtained)
+		}
+	}
+}
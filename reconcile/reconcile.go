@@ -0,0 +1,127 @@
+// Package reconcile matches bank statement entries to the payments they
+// settle.
+package reconcile
+
+import (
+	"context"
+	"math"
+	"time"
+
+	data "github.com/form3/data"
+	"github.com/form3/logging"
+)
+
+// Tolerance is how far the amount and date of an entry may be from those of
+// the payment it matches. The end-to-end reference and currency must be
+// equal.
+type Tolerance struct {
+	// Amount is the largest difference of amounts
+	Amount float64
+	// Days is the largest number of days between the processing date of the
+	// payment and the booking date of the entry
+	Days int
+}
+
+// Match is a statement entry matched to a payment.
+type Match struct {
+	EntryID   string `json:"entry_id"`
+	PaymentID string `json:"payment_id"`
+}
+
+// Result is the outcome of reconciling entries.
+type Result struct {
+	Matched   []Match `json:"matched"`
+	Unmatched int     `json:"unmatched"`
+}
+
+// Reconciler matches entries against the payments not reconciled yet.
+type Reconciler struct {
+	payments   data.PaymentProvider
+	statements data.StatementProvider
+	tolerance  Tolerance
+}
+
+// New returns a reconciler reading payments and recording matches in
+// statements.
+func New(payments data.PaymentProvider, statements data.StatementProvider, tolerance Tolerance) *Reconciler {
+	return &Reconciler{payments: payments, statements: statements, tolerance: tolerance}
+}
+
+// Reconcile matches every debit entry with an end-to-end reference to the closest
+// payment of the same organisation, reference and currency within the
+// tolerance, and marks the payment reconciled. A payment matches one entry at
+// most. Credits and reversals do not settle payments and, like the entries
+// left and those whose payment was reconciled meanwhile, are unmatched.
+func (r *Reconciler) Reconcile(ctx context.Context, entries []data.StatementEntry) (*Result, error) {
+	logger := logging.FromContext(ctx)
+	result := &Result{Matched: []Match{}}
+	for _, entry := range entries {
+		payment, err := r.candidate(ctx, entry)
+		if err != nil {
+			return nil, err
+		}
+		if payment == nil {
+			result.Unmatched++
+			continue
+		}
+		err = r.statements.MatchStatementEntry(ctx, entry.ID, payment.MongoID)
+		if err == data.ErrAlreadyMatched {
+			continue
+		}
+		if err == data.ErrPaymentReconciled {
+			result.Unmatched++
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		logger.Debug("Statement entry matched", logging.Fields{"entry": entry.ID.Hex(), "payment": payment.MongoID.Hex()})
+		result.Matched = append(result.Matched, Match{EntryID: entry.ID.Hex(), PaymentID: payment.MongoID.Hex()})
+	}
+	return result, nil
+}
+
+// candidate returns the unreconciled payment closest to entry within the
+// tolerance, or nil.
+func (r *Reconciler) candidate(ctx context.Context, entry data.StatementEntry) (*data.Payment, error) {
+	if entry.EndToEndReference == "" || entry.CreditDebit != data.Debit || entry.Reversal {
+		return nil, nil
+	}
+	date, err := time.Parse("2006-01-02", entry.Date())
+	if err != nil {
+		return nil, nil
+	}
+	unreconciled := false
+	iter, err := r.payments.StreamPayments(ctx, data.PaymentFilter{
+		OrganisationID:    entry.OrganisationID,
+		Currency:          entry.Currency,
+		EndToEndReference: entry.EndToEndReference,
+		Reconciled:        &unreconciled,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var best *data.Payment
+	var bestAmount, bestDays float64
+	var payment data.Payment
+	for iter.Next(&payment) {
+		processed, err := time.Parse("2006-01-02", payment.Attributes.ProcessingDate)
+		if err != nil {
+			continue
+		}
+		amount := math.Abs(payment.Attributes.Amount - entry.Amount)
+		days := math.Abs(date.Sub(processed).Hours() / 24)
+		// a tenth of a cent absorbs the rounding of amounts read as floats
+		if amount > r.tolerance.Amount+0.001 || days > float64(r.tolerance.Days) {
+			continue
+		}
+		if best == nil || amount < bestAmount || amount == bestAmount && days < bestDays {
+			p := payment
+			best, bestAmount, bestDays = &p, amount, days
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return best, nil
+}
@@ -0,0 +1,165 @@
+package reconcile
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	data "github.com/form3/data"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// paymentDB holds payments and records the matches of statement entries.
+type paymentDB struct {
+	data.PaymentProvider
+	data.StatementProvider
+	payments []data.Payment
+	matched  map[bson.ObjectId]bson.ObjectId
+}
+
+type sliceIter struct {
+	payments []data.Payment
+}
+
+func (it *sliceIter) Next(payment *data.Payment) bool {
+	if len(it.payments) == 0 {
+		return false
+	}
+	*payment, it.payments = it.payments[0], it.payments[1:]
+	return true
+}
+
+func (it *sliceIter) Close() error {
+	return nil
+}
+
+func (db *paymentDB) StreamPayments(ctx context.Context, filter data.PaymentFilter) (data.PaymentIter, error) {
+	var payments []data.Payment
+	for _, payment := range db.payments {
+		if payment.OrganisationID == filter.OrganisationID && payment.Attributes.Currency == filter.Currency &&
+			payment.Attributes.EndToEndReference == filter.EndToEndReference && payment.Status != data.PaymentReconciled {
+			payments = append(payments, payment)
+		}
+	}
+	return &sliceIter{payments: payments}, nil
+}
+
+func (db *paymentDB) MatchStatementEntry(ctx context.Context, entryID, paymentID bson.ObjectId) error {
+	if _, ok := db.matched[entryID]; ok {
+		return data.ErrAlreadyMatched
+	}
+	for i := range db.payments {
+		if db.payments[i].MongoID == paymentID {
+			if db.payments[i].Status == data.PaymentReconciled {
+				return data.ErrPaymentReconciled
+			}
+			db.payments[i].Status = data.PaymentReconciled
+		}
+	}
+	db.matched[entryID] = paymentID
+	return nil
+}
+
+// racingDB reconciles the payments as another import would, between reading
+// and matching them.
+type racingDB struct {
+	*paymentDB
+}
+
+func (db racingDB) MatchStatementEntry(ctx context.Context, entryID, paymentID bson.ObjectId) error {
+	for i := range db.payments {
+		db.payments[i].Status = data.PaymentReconciled
+	}
+	return db.paymentDB.MatchStatementEntry(ctx, entryID, paymentID)
+}
+
+func testPayment(id string, amount float64, date string) data.Payment {
+	return data.Payment{
+		MongoID:        bson.ObjectIdHex(id),
+		OrganisationID: "743d5b63-8e6f-432e-a8fa-c5d8d2ee5fcb",
+		Attributes:     data.Attributes{Amount: amount, Currency: "GBP", EndToEndReference: "Wil piano Jan", ProcessingDate: date},
+	}
+}
+
+func testEntry(id string, amount float64, date string) data.StatementEntry {
+	return data.StatementEntry{
+		ID:                bson.ObjectIdHex(id),
+		OrganisationID:    "743d5b63-8e6f-432e-a8fa-c5d8d2ee5fcb",
+		EndToEndReference: "Wil piano Jan",
+		Amount:            amount,
+		Currency:          "GBP",
+		CreditDebit:       data.Debit,
+		BookingDate:       date,
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	db := &paymentDB{matched: map[bson.ObjectId]bson.ObjectId{}, payments: []data.Payment{
+		testPayment("5b290f5b802b0f1479000001", 100.21, "2017-01-16"),
+		testPayment("5b290f5b802b0f1479000002", 100.21, "2017-01-18"),
+		testPayment("5b290f5b802b0f1479000003", 100, "2017-01-18"),
+	}}
+	euro := testEntry("5b290f5b802b0f1479000104", 100.21, "2017-01-18")
+	euro.Currency = "EUR"
+	noReference := testEntry("5b290f5b802b0f1479000105", 100.21, "2017-01-18")
+	noReference.EndToEndReference = ""
+	credit := testEntry("5b290f5b802b0f1479000106", 100.21, "2017-01-18")
+	credit.CreditDebit = data.Credit
+	// a reversal of a credit, MT940 RC
+	reversal := testEntry("5b290f5b802b0f1479000107", 100.21, "2017-01-18")
+	reversal.Reversal = true
+	entries := []data.StatementEntry{
+		credit,
+		reversal,
+		// the closest date wins among equal amounts
+		testEntry("5b290f5b802b0f1479000101", 100.21, "2017-01-19"),
+		// the closest amount wins within the tolerance
+		testEntry("5b290f5b802b0f1479000102", 100.2, "2017-01-18"),
+		// every payment within the tolerance is reconciled now
+		testEntry("5b290f5b802b0f1479000103", 100.21, "2017-01-18"),
+		euro,
+		noReference,
+	}
+
+	result, err := New(db, db, Tolerance{Amount: 0.5, Days: 2}).Reconcile(context.Background(), entries)
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	expected := &Result{Matched: []Match{
+		{EntryID: "5b290f5b802b0f1479000101", PaymentID: "5b290f5b802b0f1479000002"},
+		{EntryID: "5b290f5b802b0f1479000102", PaymentID: "5b290f5b802b0f1479000001"},
+		{EntryID: "5b290f5b802b0f1479000103", PaymentID: "5b290f5b802b0f1479000003"},
+	}, Unmatched: 4}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("\n...expected = %+v\n...obtained = %+v", expected, result)
+	}
+}
+
+func TestReconcilePaymentReconciledMeanwhile(t *testing.T) {
+	db := racingDB{&paymentDB{matched: map[bson.ObjectId]bson.ObjectId{}, payments: []data.Payment{testPayment("5b290f5b802b0f1479000001", 100.21, "2017-01-18")}}}
+	result, err := New(db, db, Tolerance{}).Reconcile(context.Background(), []data.StatementEntry{testEntry("5b290f5b802b0f1479000101", 100.21, "2017-01-18")})
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	expected := &Result{Matched: []Match{}, Unmatched: 1}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("\n...expected = %+v\n...obtained = %+v", expected, result)
+	}
+	if len(db.matched) != 0 {
+		t.Errorf("expected the entry to be left unmatched, got %v", db.matched)
+	}
+}
+
+func TestReconcileTolerance(t *testing.T) {
+	for _, entry := range []data.StatementEntry{
+		testEntry("5b290f5b802b0f1479000101", 100.22, "2017-01-18"),
+		testEntry("5b290f5b802b0f1479000102", 100.21, "2017-01-20"),
+		testEntry("5b290f5b802b0f1479000103", 100.21, "not a date"),
+	} {
+		db := &paymentDB{matched: map[bson.ObjectId]bson.ObjectId{}, payments: []data.Payment{testPayment("5b290f5b802b0f1479000001", 100.21, "2017-01-18")}}
+		result, err := New(db, db, Tolerance{Days: 1}).Reconcile(context.Background(), []data.StatementEntry{entry})
+		if err != nil || len(result.Matched) != 0 || result.Unmatched != 1 {
+			t.Errorf("expected %+v to be unmatched, got %+v %v", entry, result, err)
+		}
+	}
+}
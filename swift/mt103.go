@@ -0,0 +1,421 @@
+// Package swift renders payments as SWIFT MT messages and reads them back.
+package swift
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	data "github.com/form3/data"
+)
+
+// Field is a field of the text block of a message, e.g. 32A.
+type Field struct {
+	Tag   string
+	Value string
+}
+
+// format is the number of lines and characters per line of a field.
+type format struct {
+	lines, width int
+}
+
+// mt103Formats lists the fields of an MT103 that are read and written.
+var mt103Formats = map[string]format{
+	"20":  {1, 16},
+	"23B": {1, 4},
+	"32A": {1, 24},
+	"33B": {1, 18},
+	"36":  {1, 12},
+	"50K": {5, 35},
+	"59":  {5, 35},
+	"70":  {4, 35},
+	"71A": {1, 3},
+	"71F": {1, 18},
+}
+
+// xCharacters is the SWIFT X character set, without the line breaks.
+var xCharacters = regexp.MustCompile(`^[a-zA-Z0-9/\-?:().,'+ ]*$`)
+
+var ibanPattern = regexp.MustCompile(`^[A-Z]{2}[0-9]{2}[A-Z0-9]{1,30}$`)
+
+// problems collects the validation problems of a message.
+type problems []string
+
+func (p *problems) add(format string, args ...interface{}) {
+	*p = append(*p, fmt.Sprintf(format, args...))
+}
+
+func (p problems) err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%v", strings.Join(p, ", "))
+}
+
+// checkField checks the value of a field is made of characters of the X
+// character set and fits its lines.
+func checkField(field Field, f format, p *problems) {
+	lines := strings.Split(field.Value, "\n")
+	if len(lines) > f.lines {
+		p.add("field %v has %d lines, the maximum is %d", field.Tag, len(lines), f.lines)
+	}
+	for i, line := range lines {
+		if !xCharacters.MatchString(line) {
+			p.add("field %v line %d has characters outside the SWIFT X character set", field.Tag, i+1)
+		}
+		if len(line) > f.width {
+			p.add("field %v line %d is longer than %d characters", field.Tag, i+1, f.width)
+		}
+		if i > 0 && (strings.HasPrefix(line, ":") || strings.HasPrefix(line, "-")) {
+			p.add("field %v line %d starts with %q", field.Tag, i+1, line[:1])
+		}
+	}
+}
+
+// amount formats an amount with a decimal comma, which is always present.
+func amount(f float64) string {
+	s := strings.Replace(strconv.FormatFloat(f, 'f', -1, 64), ".", ",", 1)
+	if !strings.Contains(s, ",") {
+		s += ","
+	}
+	return s
+}
+
+// rate formats an exchange rate with a decimal comma, which is always present,
+// keeping its digits.
+func rate(s string) string {
+	s = strings.Replace(s, ".", ",", 1)
+	if !strings.Contains(s, ",") {
+		s += ","
+	}
+	return s
+}
+
+func parseAmount(s string) (float64, error) {
+	if !strings.Contains(s, ",") || strings.Count(s, ",") > 1 {
+		return 0, fmt.Errorf("amount %q has no decimal comma", s)
+	}
+	return strconv.ParseFloat(strings.Replace(strings.TrimSuffix(s, ","), ",", ".", 1), 64)
+}
+
+// wrap splits s into lines of at most width characters, breaking between
+// words.
+func wrap(s string, width int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(s) {
+		switch {
+		case line == "":
+			line = word
+		case len(line)+1+len(word) <= width:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+		for len(line) > width {
+			lines = append(lines, line[:width])
+			line = line[width:]
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// customer formats the account number, name and address of a party as the
+// value of 50K or 59.
+func customer(account data.Account) string {
+	var lines []string
+	if account.AccountNumber != "" {
+		lines = append(lines, "/"+account.AccountNumber)
+	}
+	name := account.Name
+	if name == "" {
+		name = account.AccountName
+	}
+	lines = append(lines, wrap(name, 35)...)
+	lines = append(lines, wrap(account.Address, 35)...)
+	return strings.Join(lines, "\n")
+}
+
+// parseCustomer reads the value of 50K or 59. The first line after the
+// account is the name, the other lines the address.
+func parseCustomer(value string) data.Account {
+	var account data.Account
+	lines := strings.Split(value, "\n")
+	if strings.HasPrefix(lines[0], "/") {
+		account.AccountNumber = strings.TrimPrefix(lines[0], "/")
+		if ibanPattern.MatchString(account.AccountNumber) {
+			account.AccountNumberCode = "IBAN"
+		}
+		lines = lines[1:]
+	}
+	if len(lines) > 0 {
+		account.Name = lines[0]
+		account.Address = strings.Join(lines[1:], " ")
+	}
+	return account
+}
+
+// chargesCode returns the 71A code of an ISO 20022 charge bearer code.
+// SLEV, following the service level, has no MT code and is sent as SHA.
+func chargesCode(bearer string) (string, error) {
+	switch strings.ToUpper(bearer) {
+	case "DEBT", "OUR":
+		return "OUR", nil
+	case "CRED", "BEN":
+		return "BEN", nil
+	case "SHAR", "SHA", "SLEV":
+		return "SHA", nil
+	}
+	return "", fmt.Errorf("unknown charges bearer code %q", bearer)
+}
+
+// FormatMT103 returns the fields of the MT103 single customer credit transfer
+// of payment:
+//
+//   - 20 is the end-to-end reference
+//   - 32A the processing date, currency and amount
+//   - 33B and 36 the original amount and exchange rate of the fx
+//   - 50K and 59 the debtor and the beneficiary
+//   - 70 the reference
+//   - 71A the charges bearer and 71F the sender charges
+func FormatMT103(payment data.Payment) ([]Field, error) {
+	var p problems
+	a := payment.Attributes
+	fields := []Field{{"20", a.EndToEndReference}, {"23B", "CRED"}}
+	if a.EndToEndReference == "" {
+		p.add("end to end reference is required for field 20")
+	} else if strings.HasPrefix(a.EndToEndReference, "/") || strings.HasSuffix(a.EndToEndReference, "/") || strings.Contains(a.EndToEndReference, "//") {
+		p.add("field 20 must not start or end with / or contain //")
+	}
+
+	date, err := time.Parse("2006-01-02", a.ProcessingDate)
+	if err != nil {
+		p.add("processing date %q is not a date as YYYY-MM-DD", a.ProcessingDate)
+	}
+	if a.Amount <= 0 {
+		p.add("amount must be positive")
+	}
+	if len(a.Currency) != 3 {
+		p.add("currency %q is not an ISO 4217 code", a.Currency)
+	}
+	value := amount(a.Amount)
+	if len(value) > 15 {
+		p.add("amount %v is longer than 15 digits", value)
+	}
+	fields = append(fields, Field{"32A", date.Format("060102") + a.Currency + value})
+
+	fx := a.Fx
+	if fx.OriginalAmount > 0 {
+		fields = append(fields, Field{"33B", fx.OriginalCurrency + amount(fx.OriginalAmount)})
+		if fx.OriginalCurrency != a.Currency {
+			if fx.ExchangeRate == "" {
+				p.add("fx exchange rate is required for field 36 when the original currency differs")
+			} else {
+				fields = append(fields, Field{"36", rate(fx.ExchangeRate)})
+			}
+		}
+	}
+
+	if a.DebtorParty.AccountNumber == "" {
+		p.add("debtor account number is required for field 50K")
+	}
+	if a.BeneficiaryParty.AccountNumber == "" {
+		p.add("beneficiary account number is required for field 59")
+	}
+	fields = append(fields, Field{"50K", customer(a.DebtorParty)}, Field{"59", customer(a.BeneficiaryParty)})
+	if a.Reference != "" {
+		fields = append(fields, Field{"70", strings.Join(wrap(a.Reference, 35), "\n")})
+	}
+
+	charges := a.ChargesInformation
+	code, err := chargesCode(charges.BearerCode)
+	if err != nil {
+		p.add("%v", err)
+	}
+	fields = append(fields, Field{"71A", code})
+	senderCharges := 0
+	for _, charge := range charges.SenderCharges {
+		if code == "OUR" {
+			if charge.Amount > 0 {
+				p.add("field 71F is not allowed when the debtor bears the charges")
+				break
+			}
+			continue
+		}
+		fields = append(fields, Field{"71F", charge.Currency + amount(charge.Amount)})
+		senderCharges++
+	}
+	if code == "BEN" && senderCharges == 0 {
+		p.add("sender charges are required for field 71F when the beneficiary bears the charges")
+	}
+	// network rule C8
+	if senderCharges > 0 && fx.OriginalAmount <= 0 {
+		p.add("fx original amount is required for field 33B with the sender charges of field 71F")
+	}
+
+	for _, field := range fields {
+		checkField(field, mt103Formats[field.Tag], &p)
+	}
+	if err := p.err(); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// WriteMT103 returns the text block of the MT103 of payment, with CRLF line
+// breaks.
+func WriteMT103(payment data.Payment) (string, error) {
+	fields, err := FormatMT103(payment)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.WriteString("{4:\r\n")
+	for _, field := range fields {
+		b.WriteString(":" + field.Tag + ":" + strings.Replace(field.Value, "\n", "\r\n", -1) + "\r\n")
+	}
+	b.WriteString("-}")
+	return b.String(), nil
+}
+
+// ParseFields reads the fields of the text block of a message, ignoring the
+// blocks around it. Line breaks may be CRLF or LF.
+func ParseFields(message string) ([]Field, error) {
+	message = strings.Replace(message, "\r\n", "\n", -1)
+	start := strings.Index(message, "{4:")
+	if start < 0 {
+		return nil, fmt.Errorf("the message has no text block")
+	}
+	message = message[start+len("{4:"):]
+	end := strings.Index(message, "\n-}")
+	if end < 0 {
+		return nil, fmt.Errorf("the text block is not terminated by -}")
+	}
+	var fields []Field
+	for _, line := range strings.Split(strings.TrimPrefix(message[:end], "\n"), "\n") {
+		if strings.HasPrefix(line, ":") {
+			i := strings.Index(line[1:], ":")
+			if i < 1 {
+				return nil, fmt.Errorf("line %q has no field tag", line)
+			}
+			fields = append(fields, Field{Tag: line[1 : i+1], Value: line[i+2:]})
+			continue
+		}
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("the text block does not start with a field")
+		}
+		fields[len(fields)-1].Value += "\n" + line
+	}
+	return fields, nil
+}
+
+// ParseMT103 reads an MT103 into a payment, the reverse of FormatMT103. It
+// fails on fields other than those FormatMT103 writes, and on fields breaking
+// their format.
+func ParseMT103(message string) (data.Payment, error) {
+	var payment data.Payment
+	fields, err := ParseFields(message)
+	if err != nil {
+		return payment, err
+	}
+	var p problems
+	seen := map[string]bool{}
+	for _, field := range fields {
+		f, ok := mt103Formats[field.Tag]
+		if !ok {
+			p.add("field %v is not supported", field.Tag)
+			continue
+		}
+		if seen[field.Tag] && field.Tag != "71F" {
+			p.add("field %v is repeated", field.Tag)
+		}
+		seen[field.Tag] = true
+		checkField(field, f, &p)
+	}
+	for _, tag := range []string{"20", "23B", "32A", "50K", "59", "71A"} {
+		if !seen[tag] {
+			p.add("field %v is required", tag)
+		}
+	}
+	if err := p.err(); err != nil {
+		return payment, err
+	}
+
+	payment.Type = "Payment"
+	a := &payment.Attributes
+	for _, field := range fields {
+		switch value := field.Value; field.Tag {
+		case "20":
+			a.EndToEndReference = value
+		case "23B":
+			if value != "CRED" {
+				p.add("bank operation code %q is not supported, expected CRED", value)
+			}
+		case "32A":
+			if len(value) < 10 {
+				p.add("field 32A %q is not a date, currency and amount", value)
+				break
+			}
+			date, err := time.Parse("060102", value[:6])
+			if err != nil {
+				p.add("field 32A date %q is not a date as YYMMDD", value[:6])
+			}
+			a.ProcessingDate = date.Format("2006-01-02")
+			a.Currency = value[6:9]
+			if a.Amount, err = parseAmount(value[9:]); err != nil {
+				p.add("field 32A: %v", err)
+			}
+		case "33B":
+			if len(value) < 4 {
+				p.add("field 33B %q is not a currency and amount", value)
+				break
+			}
+			a.Fx.OriginalCurrency = value[:3]
+			var err error
+			if a.Fx.OriginalAmount, err = parseAmount(value[3:]); err != nil {
+				p.add("field 33B: %v", err)
+			}
+		case "36":
+			a.Fx.ExchangeRate = strings.Replace(strings.TrimSuffix(value, ","), ",", ".", 1)
+			if _, err := parseAmount(value); err != nil {
+				p.add("field 36: %v", err)
+			}
+		case "50K":
+			a.DebtorParty = parseCustomer(value)
+		case "59":
+			a.BeneficiaryParty = parseCustomer(value)
+		case "70":
+			a.Reference = strings.Replace(value, "\n", " ", -1)
+		case "71A":
+			switch value {
+			case "OUR":
+				a.ChargesInformation.BearerCode = "DEBT"
+			case "BEN":
+				a.ChargesInformation.BearerCode = "CRED"
+			case "SHA":
+				a.ChargesInformation.BearerCode = "SHAR"
+			default:
+				p.add("field 71A %q is not OUR, BEN or SHA", value)
+			}
+		case "71F":
+			if len(value) < 4 {
+				p.add("field 71F %q is not a currency and amount", value)
+				break
+			}
+			charge := data.AmountCurrency{Currency: value[:3]}
+			var err error
+			if charge.Amount, err = parseAmount(value[3:]); err != nil {
+				p.add("field 71F: %v", err)
+			}
+			a.ChargesInformation.SenderCharges = append(a.ChargesInformation.SenderCharges, charge)
+		}
+	}
+	return payment, p.err()
+}
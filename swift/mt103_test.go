@@ -0,0 +1,149 @@
+package swift
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	data "github.com/form3/data"
+)
+
+func testPayment() data.Payment {
+	return data.Payment{
+		Type: "Payment",
+		Attributes: data.Attributes{
+			Amount: 100.21,
+			BeneficiaryParty: data.Account{
+				AccountNumber: "31926819",
+				Address:       "1 The Beneficiary Localtown SE2",
+				Name:          "Wilfred Jeremiah Owens",
+			},
+			ChargesInformation: data.ChargesInformation{
+				BearerCode:    "SHAR",
+				SenderCharges: []data.AmountCurrency{{Amount: 5, Currency: "GBP"}, {Amount: 10.5, Currency: "USD"}},
+			},
+			Currency: "GBP",
+			DebtorParty: data.Account{
+				AccountNumber:     "GB29XABC10161234567801",
+				AccountNumberCode: "IBAN",
+				Address:           "10 Debtor Crescent Sourcetown NE1 which is a rather long address",
+				Name:              "Emelia Jane Brown",
+			},
+			EndToEndReference: "Wil piano Jan",
+			Fx: data.Fx{
+				ExchangeRate:     "0.5",
+				OriginalAmount:   200.42,
+				OriginalCurrency: "USD",
+			},
+			ProcessingDate: "2017-01-18",
+			Reference:      "Payment for Em's piano lessons from January to March",
+		},
+	}
+}
+
+func TestWriteMT103(t *testing.T) {
+	message, err := WriteMT103(testPayment())
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	expected := "{4:\r\n" +
+		":20:Wil piano Jan\r\n" +
+		":23B:CRED\r\n" +
+		":32A:170118GBP100,21\r\n" +
+		":33B:USD200,42\r\n" +
+		":36:0,5\r\n" +
+		":50K:/GB29XABC10161234567801\r\n" +
+		"Emelia Jane Brown\r\n" +
+		"10 Debtor Crescent Sourcetown NE1\r\n" +
+		"which is a rather long address\r\n" +
+		":59:/31926819\r\n" +
+		"Wilfred Jeremiah Owens\r\n" +
+		"1 The Beneficiary Localtown SE2\r\n" +
+		":70:Payment for Em's piano lessons from\r\n" +
+		"January to March\r\n" +
+		":71A:SHA\r\n" +
+		":71F:GBP5,\r\n" +
+		":71F:USD10,5\r\n" +
+		"-}"
+	if expected != message {
+		t.Errorf("\n...expected = %q\n...obtained = %q", expected, message)
+	}
+}
+
+func TestParseMT103RoundTrip(t *testing.T) {
+	for _, exchangeRate := range []string{"0.5", "2", "1.25"} {
+		payment := testPayment()
+		payment.Attributes.Fx.ExchangeRate = exchangeRate
+		message, err := WriteMT103(payment)
+		if err != nil {
+			t.Fatalf("Didn't expect error %v", err)
+		}
+		obtained, err := ParseMT103("{1:F01BANKGB2LAXXX0000000000}{2:I103BANKDEFFXXXXN}" + message + "{5:{CHK:123456789ABC}}")
+		if err != nil {
+			t.Fatalf("Didn't expect error %v", err)
+		}
+		if !reflect.DeepEqual(payment, obtained) {
+			t.Errorf("\n...expected = %+v\n...obtained = %+v", payment, obtained)
+		}
+	}
+}
+
+func TestFormatMT103Errors(t *testing.T) {
+	payment := testPayment()
+	payment.Attributes.EndToEndReference = "reference/longer/than/16"
+	payment.Attributes.BeneficiaryParty.Name = "Zoë Owens"
+	payment.Attributes.Fx.ExchangeRate = ""
+	payment.Attributes.ChargesInformation.BearerCode = "DEBT"
+	payment.Attributes.Reference = strings.Repeat("word ", 30)
+
+	_, err := FormatMT103(payment)
+	expected := "fx exchange rate is required for field 36 when the original currency differs, " +
+		"field 71F is not allowed when the debtor bears the charges, " +
+		"field 20 line 1 is longer than 16 characters, " +
+		"field 59 line 2 has characters outside the SWIFT X character set, " +
+		"field 70 has 5 lines, the maximum is 4"
+	if err == nil || err.Error() != expected {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, err)
+	}
+
+	payment = testPayment()
+	payment.Attributes.ChargesInformation = data.ChargesInformation{BearerCode: "CRED"}
+	_, err = FormatMT103(payment)
+	expected = "sender charges are required for field 71F when the beneficiary bears the charges"
+	if err == nil || err.Error() != expected {
+		t.Errorf("\n...expected = %v\n...obtained = %v", expected, err)
+	}
+
+	// 71F requires 33B, network rule C8
+	for _, bearer := range []string{"SHAR", "CRED"} {
+		payment = testPayment()
+		payment.Attributes.Fx = data.Fx{}
+		payment.Attributes.ChargesInformation.BearerCode = bearer
+		_, err = FormatMT103(payment)
+		expected = "fx original amount is required for field 33B with the sender charges of field 71F"
+		if err == nil || err.Error() != expected {
+			t.Errorf("%v\n...expected = %v\n...obtained = %v", bearer, expected, err)
+		}
+	}
+}
+
+func TestParseMT103Errors(t *testing.T) {
+	for _, test := range []struct {
+		message  string
+		expected string
+	}{
+		{"{4:\n:20:REF\n:23B:SPRI\n:32A:170118GBP100\n:50K:/1\nA\n:52A:BANKGB2L\n:59:/2\nB\n:71A:XXX\n-}",
+			"field 52A is not supported"},
+		{"{4:\n:20:REF\n:23B:SPRI\n:32A:170118GBP100\n:50K:/1\nA\n:59:/2\nB\n:71A:XXX\n-}",
+			`bank operation code "SPRI" is not supported, expected CRED, field 32A: amount "100" has no decimal comma, field 71A "XXX" is not OUR, BEN or SHA`},
+		{"{4:\n:20:REF\n:20:REF\n:32A:170118GBP100,\n-}",
+			"field 20 is repeated, field 23B is required, field 50K is required, field 59 is required, field 71A is required"},
+		{"{4:\n:20:REF\n", "the text block is not terminated by -}"},
+		{":20:REF", "the message has no text block"},
+	} {
+		_, err := ParseMT103(test.message)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("\n...expected = %v\n...obtained = %v", test.expected, err)
+		}
+	}
+}
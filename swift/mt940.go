@@ -0,0 +1,153 @@
+package swift
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	data "github.com/form3/data"
+)
+
+// statementLine matches field 61: value date, optional entry date, debit or
+// credit mark with R for reversals, optional funds code, amount, transaction
+// type, reference for the account owner and optional bank reference, and
+// supplementary details on the next line.
+var statementLine = regexp.MustCompile(`^(\d{6})(\d{4})?(RC|RD|C|D)([A-Z])?(\d[\d,]*)([A-Z][A-Z0-9]{3})([^\n]*?)(?://([^\n]*))?(?:\n(.*))?$`)
+
+// balance matches the currency of the opening balance fields 60F and 60M.
+var balance = regexp.MustCompile(`^[CD]\d{6}([A-Z]{3})`)
+
+// ParseMT940 reads the entries of the MT940 customer statements of a file.
+// The file holds text blocks, or else the fields of one statement after
+// another. The reference for the account owner is read as the end-to-end
+// reference, as it is the sender reference of an MT103, and field 86 as the
+// information of the entry it follows.
+func ParseMT940(message string) ([]data.StatementEntry, error) {
+	message = strings.Replace(message, "\r\n", "\n", -1)
+	var blocks []string
+	if strings.Contains(message, "{4:") {
+		for _, block := range strings.Split(message, "{4:")[1:] {
+			blocks = append(blocks, "{4:"+block)
+		}
+	} else {
+		// without blocks a statement ends with a line holding -
+		for _, block := range regexp.MustCompile(`(?m)^-\s*$`).Split(message, -1) {
+			if strings.TrimSpace(block) != "" {
+				blocks = append(blocks, "{4:\n"+strings.TrimSpace(block)+"\n-}")
+			}
+		}
+	}
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("the file has no statement")
+	}
+	var entries []data.StatementEntry
+	for i, block := range blocks {
+		fields, err := ParseFields(block)
+		if err != nil {
+			return nil, fmt.Errorf("statement %d: %v", i, err)
+		}
+		statement, err := parseStatement(fields)
+		if err != nil {
+			return nil, fmt.Errorf("statement %d: %v", i, err)
+		}
+		entries = append(entries, statement...)
+	}
+	return entries, nil
+}
+
+// parseStatement reads the entries of the fields of one statement.
+func parseStatement(fields []Field) ([]data.StatementEntry, error) {
+	var reference, account, number, currency string
+	var entries []data.StatementEntry
+	for _, field := range fields {
+		switch field.Tag {
+		case "20":
+			reference = field.Value
+		case "25", "25P":
+			account = strings.SplitN(field.Value, "\n", 2)[0]
+		case "28C":
+			number = field.Value
+		case "60F", "60M":
+			m := balance.FindStringSubmatch(field.Value)
+			if m == nil {
+				return nil, fmt.Errorf("field %v %q is not a balance", field.Tag, field.Value)
+			}
+			currency = m[1]
+		case "61":
+			if currency == "" {
+				return nil, fmt.Errorf("field 61 precedes the opening balance")
+			}
+			entry, err := parseStatementLine(field.Value)
+			if err != nil {
+				return nil, err
+			}
+			entry.Sequence, entry.Currency = len(entries), currency
+			entries = append(entries, entry)
+		case "86":
+			if len(entries) > 0 {
+				entries[len(entries)-1].Information = strings.Replace(field.Value, "\n", " ", -1)
+			}
+		}
+	}
+	if reference == "" || account == "" {
+		return nil, fmt.Errorf("fields 20 and 25 are required")
+	}
+	// the statement number and sequence tell apart the statements of a
+	// reference
+	id := reference
+	if number != "" {
+		id += "/" + number
+	}
+	for i := range entries {
+		entries[i].Format, entries[i].Account, entries[i].StatementID = "MT940", account, id
+	}
+	return entries, nil
+}
+
+// parseStatementLine reads field 61. The year of the entry date is the one
+// closest to the value date.
+func parseStatementLine(value string) (data.StatementEntry, error) {
+	var entry data.StatementEntry
+	m := statementLine.FindStringSubmatch(value)
+	if m == nil {
+		return entry, fmt.Errorf("field 61 %q is not a statement line", value)
+	}
+	valueDate, err := time.Parse("060102", m[1])
+	if err != nil {
+		return entry, fmt.Errorf("field 61 value date %q is not a date", m[1])
+	}
+	entry.ValueDate = valueDate.Format("2006-01-02")
+	entry.BookingDate = entry.ValueDate
+	if m[2] != "" {
+		booking, err := time.Parse("20060102", valueDate.Format("2006")+m[2])
+		if err != nil {
+			return entry, fmt.Errorf("field 61 entry date %q is not a date", m[2])
+		}
+		if days := booking.Sub(valueDate).Hours() / 24; days > 183 {
+			booking = booking.AddDate(-1, 0, 0)
+		} else if days < -183 {
+			booking = booking.AddDate(1, 0, 0)
+		}
+		entry.BookingDate = booking.Format("2006-01-02")
+	}
+	// a reversal of a credit is a debit and the other way round
+	switch m[3] {
+	case "C", "RD":
+		entry.CreditDebit = data.Credit
+	default:
+		entry.CreditDebit = data.Debit
+	}
+	entry.Reversal = strings.HasPrefix(m[3], "R")
+	if entry.Amount, err = parseAmount(m[5]); err != nil {
+		return entry, err
+	}
+	if reference := strings.TrimSpace(m[7]); reference != "NONREF" {
+		entry.EndToEndReference = reference
+	}
+	entry.EntryReference = strings.TrimSpace(m[8])
+	if m[9] != "" && entry.Information == "" {
+		entry.Information = strings.TrimSpace(m[9])
+	}
+	return entry, nil
+}
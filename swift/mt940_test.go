@@ -0,0 +1,74 @@
+package swift
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	data "github.com/form3/data"
+)
+
+const testMT940 = `:20:STMT1801
+:25:203301/71268996
+:28C:18/1
+:60F:C170117GBP1000,00
+:61:1701180118D100,21NTRFWil piano Jan//BANK-1
+Em piano lessons
+:86:Payment for Em's piano lessons
+:61:1712291229RC5,NMSCNONREF
+:62F:C170118GBP894,79
+-
+:20:STMT1801
+:25:203301/71268996
+:28C:18/2
+:60M:C170118GBP894,79
+:61:1712310102C12,5NINT123
+:62F:C170118GBP907,29
+-`
+
+func TestParseMT940(t *testing.T) {
+	entries, err := ParseMT940(strings.Replace(testMT940, "\n", "\r\n", -1))
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	expected := []data.StatementEntry{{
+		Format: "MT940", Account: "203301/71268996", StatementID: "STMT1801/18/1", Sequence: 0,
+		EntryReference: "BANK-1", EndToEndReference: "Wil piano Jan", Amount: 100.21, Currency: "GBP", CreditDebit: data.Debit,
+		BookingDate: "2017-01-18", ValueDate: "2017-01-18", Information: "Payment for Em's piano lessons",
+	}, {
+		Format: "MT940", Account: "203301/71268996", StatementID: "STMT1801/18/1", Sequence: 1,
+		Amount: 5, Currency: "GBP", CreditDebit: data.Debit, Reversal: true, BookingDate: "2017-12-29", ValueDate: "2017-12-29",
+	}, {
+		Format: "MT940", Account: "203301/71268996", StatementID: "STMT1801/18/2", Sequence: 0,
+		EndToEndReference: "123", Amount: 12.5, Currency: "GBP", CreditDebit: data.Credit, BookingDate: "2018-01-02", ValueDate: "2017-12-31",
+	}}
+	if !reflect.DeepEqual(expected, entries) {
+		t.Errorf("\n...expected = %+v\n...obtained = %+v", expected, entries)
+	}
+}
+
+func TestParseMT940Blocks(t *testing.T) {
+	message := "{1:F01BANKGB2LAXXX0000000000}{2:O9401200170118BANKGB2LAXXX00000000001701181200N}{4:\n" +
+		strings.Replace(testMT940, "\n-\n", "\n-}{1:F01BANKGB2LAXXX0000000000}{4:\n", 1) + "}"
+	entries, err := ParseMT940(message)
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	if len(entries) != 3 || entries[2].StatementID != "STMT1801/18/2" {
+		t.Errorf("expected the 3 entries of 2 statements, got %+v", entries)
+	}
+}
+
+func TestParseMT940Invalid(t *testing.T) {
+	for name, message := range map[string]string{
+		"empty":           "\n",
+		"line":            strings.Replace(testMT940, ":61:1701180118D", ":61:170118X", 1),
+		"no balance":      strings.Replace(testMT940, ":60F:", ":65:", 1),
+		"no account":      strings.Replace(testMT940, ":25:", ":21:", 1),
+		"no decimal mark": strings.Replace(testMT940, "D100,21", "D100", 1),
+	} {
+		if _, err := ParseMT940(message); err == nil {
+			t.Errorf("expected an error for %v", name)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package tlsutil
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// ClientOptions describe how the service authenticates a server it connects
+// to, such as mongo, and the certificate it presents to it.
+type ClientOptions struct {
+	CAFile     string // CA bundle trusted instead of the system roots
+	CertFile   string // optional client certificate
+	KeyFile    string
+	ServerName string // overrides the name verified in the server certificate
+	MinVersion string
+	// PinnedSHA256 are hex SHA-256 fingerprints of certificates; when set the
+	// server chain must contain one of them.
+	PinnedSHA256 []string
+}
+
+// ClientConfig builds the TLS configuration used to dial a server.
+func ClientConfig(opts ClientOptions) (*tls.Config, error) {
+	minVersion, err := ParseVersion(opts.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		MinVersion: minVersion,
+		ServerName: opts.ServerName,
+	}
+	if opts.CAFile != "" {
+		pem, err := ioutil.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %v", opts.CAFile)
+		}
+	}
+	if opts.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if len(opts.PinnedSHA256) > 0 {
+		pins, err := ParsePins(opts.PinnedSHA256)
+		if err != nil {
+			return nil, err
+		}
+		config.VerifyPeerCertificate = verifyPins(pins)
+	}
+	return config, nil
+}
+
+// ParsePins normalises hex SHA-256 fingerprints, accepting colon separators.
+func ParsePins(fingerprints []string) (map[string]bool, error) {
+	pins := map[string]bool{}
+	for _, f := range fingerprints {
+		pin := strings.ToLower(strings.Replace(strings.TrimSpace(f), ":", "", -1))
+		if b, err := hex.DecodeString(pin); err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("invalid SHA-256 fingerprint %q", f)
+		}
+		pins[pin] = true
+	}
+	return pins, nil
+}
+
+// verifyPins runs after the usual chain verification and additionally
+// requires one of the verified certificates to match a pin.
+func verifyPins(pins map[string]bool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		for _, chain := range verifiedChains {
+			for _, cert := range chain {
+				sum := sha256.Sum256(cert.Raw)
+				if pins[hex.EncodeToString(sum[:])] {
+					return nil
+				}
+			}
+		}
+		return errors.New("server certificate does not match any pinned fingerprint")
+	}
+}
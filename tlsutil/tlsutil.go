@@ -0,0 +1,241 @@
+// Package tlsutil builds the TLS configuration of the REST API and reloads
+// certificates when their files change.
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/form3/logging"
+)
+
+// versionTLS13 is tls.VersionTLS13, spelled out for older toolchains.
+const versionTLS13 = 0x0304
+
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": versionTLS13,
+}
+
+var cipherSuites = map[string]uint16{
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256":       tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384":       tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305":        tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305":          tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256":       tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256":         tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+	"TLS_RSA_WITH_AES_128_GCM_SHA256":               tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384":               tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256": tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256":   tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":        tls.NoClientCert,
+	"none":    tls.NoClientCert,
+	"request": tls.VerifyClientCertIfGiven,
+	"require": tls.RequireAndVerifyClientCert,
+}
+
+// ParseVersion converts a TLS version such as "1.2" into its tls constant.
+func ParseVersion(s string) (uint16, error) {
+	if s == "" {
+		return tls.VersionTLS12, nil
+	}
+	v, ok := versions[s]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q", s)
+	}
+	return v, nil
+}
+
+// ParseCipherSuites converts cipher suite names into their tls constants.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	var ids []uint16
+	for _, name := range names {
+		id, ok := cipherSuites[strings.ToUpper(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// ParseClientAuth converts none, request or require into a tls.ClientAuthType.
+func ParseClientAuth(s string) (tls.ClientAuthType, error) {
+	auth, ok := clientAuthTypes[strings.ToLower(s)]
+	if !ok {
+		return tls.NoClientCert, fmt.Errorf("unknown client auth %q, expected none, request or require", s)
+	}
+	return auth, nil
+}
+
+// Options describe the TLS setup of the server.
+type Options struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string // CA bundle used to verify client certificates
+	ClientAuth   string // none, request or require
+	MinVersion   string
+	CipherSuites []string
+}
+
+// Reloader serves the certificate and client CA bundle currently on disk,
+// reloading them when their files change.
+type Reloader struct {
+	opts         Options
+	minVersion   uint16
+	cipherSuites []uint16
+	clientAuth   tls.ClientAuthType
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	modTimes map[string]time.Time
+}
+
+// New loads the certificates described by opts.
+func New(opts Options) (*Reloader, error) {
+	r := &Reloader{opts: opts}
+	var err error
+	if r.minVersion, err = ParseVersion(opts.MinVersion); err != nil {
+		return nil, err
+	}
+	if r.cipherSuites, err = ParseCipherSuites(opts.CipherSuites); err != nil {
+		return nil, err
+	}
+	if r.clientAuth, err = ParseClientAuth(opts.ClientAuth); err != nil {
+		return nil, err
+	}
+	if r.clientAuth != tls.NoClientCert && opts.ClientCAFile == "" {
+		return nil, errors.New("a client CA bundle is required to verify client certificates")
+	}
+	if err = r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the certificate, key and client CA bundle from disk. The
+// previous ones are kept if any of the files cannot be loaded.
+func (r *Reloader) Reload() error {
+	modTimes := map[string]time.Time{}
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.opts.CertFile, r.opts.KeyFile)
+	if err != nil {
+		return err
+	}
+	var pool *x509.CertPool
+	if r.opts.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(r.opts.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in %v", r.opts.ClientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert, r.clientCA, r.modTimes = &cert, pool, modTimes
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *Reloader) files() []string {
+	files := []string{r.opts.CertFile, r.opts.KeyFile}
+	if r.opts.ClientCAFile != "" {
+		files = append(files, r.opts.ClientCAFile)
+	}
+	return files
+}
+
+// changed reports if any of the files was modified since the last reload.
+func (r *Reloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		if !info.ModTime().Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch polls the files every interval and reloads them when they change,
+// until done is closed.
+func (r *Reloader) Watch(done <-chan struct{}, interval time.Duration) {
+	logger := logging.Default()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				logger.Error("Could not reload TLS certificates", logging.Fields{"error": err})
+			} else {
+				logger.Info("Reloaded TLS certificates", logging.Fields{"cert": r.opts.CertFile})
+			}
+		}
+	}
+}
+
+// Certificate returns the certificate currently served.
+func (r *Reloader) Certificate() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+// TLSConfig returns a server configuration always using the latest
+// certificate and client CA bundle.
+func (r *Reloader) TLSConfig() *tls.Config {
+	base := &tls.Config{
+		MinVersion:               r.minVersion,
+		CipherSuites:             r.cipherSuites,
+		PreferServerCipherSuites: true,
+		ClientAuth:               r.clientAuth,
+	}
+	config := base.Clone()
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := base.Clone()
+		r.mu.RLock()
+		c.Certificates = []tls.Certificate{*r.cert}
+		c.ClientCAs = r.clientCA
+		r.mu.RUnlock()
+		return c, nil
+	}
+	config.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return r.Certificate(), nil
+	}
+	return config
+}
@@ -0,0 +1,252 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newCert(t *testing.T, cn string, parent *testCert, isCA bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func write(t *testing.T, path string, content []byte) {
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	if v, err := ParseVersion("1.3"); err != nil || v != versionTLS13 {
+		t.Errorf("unexpected version %v %v", v, err)
+	}
+	if _, err := ParseVersion("2.0"); err == nil {
+		t.Errorf("expected error for unknown version")
+	}
+	suites, err := ParseCipherSuites([]string{"tls_ecdhe_rsa_with_aes_128_gcm_sha256"})
+	if err != nil || len(suites) != 1 || suites[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("unexpected suites %v %v", suites, err)
+	}
+	if _, err := ParseCipherSuites([]string{"TLS_RSA_WITH_RC4_128_SHA"}); err == nil {
+		t.Errorf("expected error for unsupported suite")
+	}
+	if _, err := ParseClientAuth("sometimes"); err == nil {
+		t.Errorf("expected error for unknown client auth")
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "tlsutil")
+	defer os.RemoveAll(dir)
+
+	ca := newCert(t, "test ca", nil, true)
+	server := newCert(t, "localhost", ca, false)
+	client := newCert(t, "payroll", ca, false)
+	write(t, filepath.Join(dir, "ca.pem"), ca.certPEM)
+	write(t, filepath.Join(dir, "server.pem"), server.certPEM)
+	write(t, filepath.Join(dir, "server.key"), server.keyPEM)
+
+	certs, err := New(Options{
+		CertFile:     filepath.Join(dir, "server.pem"),
+		KeyFile:      filepath.Join(dir, "server.key"),
+		ClientCAFile: filepath.Join(dir, "ca.pem"),
+		ClientAuth:   "require",
+		MinVersion:   "1.2",
+	})
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+
+	var subject string
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject = r.TLS.VerifiedChains[0][0].Subject.CommonName
+	}))
+	ts.TLS = certs.TLSConfig()
+	ts.StartTLS()
+	defer ts.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientCert, _ := tls.X509KeyPair(client.certPEM, client.keyPEM)
+
+	withCert := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{clientCert},
+	}}}
+	if _, err := withCert.Get(ts.URL); err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	if subject != "payroll" {
+		t.Errorf("expected client subject payroll, got %q", subject)
+	}
+
+	withoutCert := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	if _, err := withoutCert.Get(ts.URL); err == nil {
+		t.Errorf("expected handshake to fail without a client certificate")
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "tlsutil")
+	defer os.RemoveAll(dir)
+
+	first := newCert(t, "first", nil, false)
+	certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key")
+	write(t, certFile, first.certPEM)
+	write(t, keyFile, first.keyPEM)
+
+	certs, err := New(Options{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go certs.Watch(done, 5*time.Millisecond)
+
+	second := newCert(t, "second", nil, false)
+	write(t, certFile, second.certPEM)
+	write(t, keyFile, second.keyPEM)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		leaf, _ := x509.ParseCertificate(certs.Certificate().Certificate[0])
+		if leaf.Subject.CommonName == "second" {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Errorf("certificate was not reloaded")
+}
+
+func TestReloadKeepsCertificateOnError(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "tlsutil")
+	defer os.RemoveAll(dir)
+
+	cert := newCert(t, "first", nil, false)
+	certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key")
+	write(t, certFile, cert.certPEM)
+	write(t, keyFile, cert.keyPEM)
+
+	certs, err := New(Options{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	write(t, keyFile, []byte("not a key"))
+	if err := certs.Reload(); err == nil {
+		t.Errorf("expected error reloading a broken key")
+	}
+	if certs.Certificate() == nil {
+		t.Errorf("expected the previous certificate to be kept")
+	}
+}
+
+func TestClientConfigPinning(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "tlsutil")
+	defer os.RemoveAll(dir)
+
+	ca := newCert(t, "test ca", nil, true)
+	server := newCert(t, "localhost", ca, false)
+	other := newCert(t, "other ca", nil, true)
+	write(t, filepath.Join(dir, "ca.pem"), ca.certPEM)
+	write(t, filepath.Join(dir, "server.pem"), server.certPEM)
+	write(t, filepath.Join(dir, "server.key"), server.keyPEM)
+
+	certs, err := New(Options{CertFile: filepath.Join(dir, "server.pem"), KeyFile: filepath.Join(dir, "server.key")})
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = certs.TLSConfig()
+	ts.StartTLS()
+	defer ts.Close()
+
+	get := func(pin *testCert) error {
+		sum := sha256.Sum256(pin.cert.Raw)
+		config, err := ClientConfig(ClientOptions{
+			CAFile:       filepath.Join(dir, "ca.pem"),
+			PinnedSHA256: []string{hex.EncodeToString(sum[:])},
+		})
+		if err != nil {
+			t.Fatalf("Didn't expect error %v", err)
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		_, err = client.Get(ts.URL)
+		return err
+	}
+
+	if err := get(ca); err != nil {
+		t.Errorf("expected pinned CA to be accepted, got %v", err)
+	}
+	if err := get(other); err == nil {
+		t.Errorf("expected connection to fail with another pin")
+	}
+}
+
+func TestParsePins(t *testing.T) {
+	pin := "AB:" + strings.Repeat("cd", 31)
+	pins, err := ParsePins([]string{pin})
+	if err != nil || !pins["ab"+strings.Repeat("cd", 31)] {
+		t.Errorf("unexpected pins %v %v", pins, err)
+	}
+	if _, err := ParsePins([]string{"abcd"}); err == nil {
+		t.Errorf("expected error for short fingerprint")
+	}
+}
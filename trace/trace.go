@@ -0,0 +1,118 @@
+// Package trace carries the request correlation ID and the W3C trace context
+// of a request through context.Context.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Info identifies a request and its position in a distributed trace.
+type Info struct {
+	RequestID string
+	TraceID   string // 32 lower case hex characters
+	SpanID    string // 16 lower case hex characters, the span of this service
+	ParentID  string // span of the caller, empty if the trace started here
+	Flags     string // 2 hex characters
+}
+
+var errInvalidTraceparent = errors.New("invalid traceparent")
+
+// ParseTraceparent parses a version 00 traceparent header.
+func ParseTraceparent(header string) (traceID, parentID, flags string, err error) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || parts[0] == "ff" || !isHex(parts[0], 2) {
+		return "", "", "", errInvalidTraceparent
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return "", "", "", errInvalidTraceparent
+	}
+	traceID, parentID, flags = parts[1], parts[2], parts[3]
+	if !isHex(traceID, 32) || !isHex(parentID, 16) || !isHex(flags, 2) {
+		return "", "", "", errInvalidTraceparent
+	}
+	if traceID == strings.Repeat("0", 32) || parentID == strings.Repeat("0", 16) {
+		return "", "", "", errInvalidTraceparent
+	}
+	return traceID, parentID, flags, nil
+}
+
+// Traceparent renders the header to send to the next hop, with this
+// service's span as the parent.
+func (i Info) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%s", i.TraceID, i.SpanID, i.Flags)
+}
+
+// New builds the trace information of an incoming request. The request ID is
+// kept if valid, the trace is continued if traceparent is valid, otherwise
+// new identifiers are generated.
+func New(requestID, traceparent string) Info {
+	info := Info{RequestID: requestID, SpanID: randomHex(8), Flags: "01"}
+	if !validRequestID(requestID) {
+		info.RequestID = randomHex(16)
+	}
+	if traceID, parentID, flags, err := ParseTraceparent(traceparent); err == nil {
+		info.TraceID, info.ParentID, info.Flags = traceID, parentID, flags
+	} else {
+		info.TraceID = randomHex(16)
+	}
+	return info
+}
+
+// validRequestID accepts client supplied IDs of printable ASCII up to 128
+// characters, so they can be safely echoed in headers and logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying info.
+func NewContext(ctx context.Context, info Info) context.Context {
+	return context.WithValue(ctx, contextKey{}, info)
+}
+
+// FromContext returns the trace information stored in ctx.
+func FromContext(ctx context.Context) (Info, bool) {
+	if ctx == nil {
+		return Info{}, false
+	}
+	info, ok := ctx.Value(contextKey{}).(Info)
+	return info, ok
+}
+
+// RequestID returns the request ID stored in ctx, or an empty string.
+func RequestID(ctx context.Context) string {
+	info, _ := FromContext(ctx)
+	return info.RequestID
+}
@@ -0,0 +1,69 @@
+package trace
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	traceID, parentID, flags, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	if traceID != "4bf92f3577b34da6a3ce929d0e0e4736" || parentID != "00f067aa0ba902b7" || flags != "01" {
+		t.Errorf("unexpected parse result %v %v %v", traceID, parentID, flags)
+	}
+
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	}
+	for _, header := range invalid {
+		if _, _, _, err := ParseTraceparent(header); err == nil {
+			t.Errorf("expected error parsing %q", header)
+		}
+	}
+}
+
+func TestNewContinuesTrace(t *testing.T) {
+	info := New("customer-ref-1", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	if info.RequestID != "customer-ref-1" {
+		t.Errorf("expected request ID to be kept, got %v", info.RequestID)
+	}
+	if info.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || info.ParentID != "00f067aa0ba902b7" {
+		t.Errorf("expected trace to be continued, got %+v", info)
+	}
+	if !isHex(info.SpanID, 16) || info.SpanID == info.ParentID {
+		t.Errorf("expected a new span, got %v", info.SpanID)
+	}
+	if info.Traceparent() != "00-4bf92f3577b34da6a3ce929d0e0e4736-"+info.SpanID+"-01" {
+		t.Errorf("unexpected traceparent %v", info.Traceparent())
+	}
+}
+
+func TestNewGeneratesIDs(t *testing.T) {
+	info := New("bad id\n", "garbage")
+
+	if !isHex(info.RequestID, 32) {
+		t.Errorf("expected generated request ID, got %q", info.RequestID)
+	}
+	if !isHex(info.TraceID, 32) || info.ParentID != "" {
+		t.Errorf("expected new trace, got %+v", info)
+	}
+}
+
+func TestContext(t *testing.T) {
+	if RequestID(context.Background()) != "" {
+		t.Errorf("expected no request ID")
+	}
+	ctx := NewContext(context.Background(), Info{RequestID: "abc"})
+	if RequestID(ctx) != "abc" {
+		t.Errorf("expected request ID from context")
+	}
+}
@@ -0,0 +1,51 @@
+// Package worker runs background goroutines that must be stopped and waited
+// for when the service shuts down.
+package worker
+
+import (
+	"context"
+	"sync"
+)
+
+// Group tracks background workers. Workers watch the channel returned by
+// Done and return once it is closed.
+type Group struct {
+	wg       sync.WaitGroup
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewGroup() *Group {
+	return &Group{done: make(chan struct{})}
+}
+
+// Go runs fn in a new goroutine tracked by the group.
+func (g *Group) Go(fn func(done <-chan struct{})) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		fn(g.done)
+	}()
+}
+
+// Done is closed when the group is asked to stop.
+func (g *Group) Done() <-chan struct{} {
+	return g.done
+}
+
+// Shutdown asks every worker to stop and waits for them to return or for
+// ctx to expire, whichever happens first.
+func (g *Group) Shutdown(ctx context.Context) error {
+	g.stopOnce.Do(func() { close(g.done) })
+	finished := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
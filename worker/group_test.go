@@ -0,0 +1,39 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestShutdownWaitsForWorkers(t *testing.T) {
+	g := NewGroup()
+	finished := make(chan struct{})
+	g.Go(func(done <-chan struct{}) {
+		<-done
+		time.Sleep(10 * time.Millisecond)
+		close(finished)
+	})
+
+	if err := g.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Didn't expect error %v", err)
+	}
+	select {
+	case <-finished:
+	default:
+		t.Errorf("Shutdown returned before the worker finished")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	g := NewGroup()
+	block := make(chan struct{})
+	defer close(block)
+	g.Go(func(done <-chan struct{}) { <-block })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := g.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}